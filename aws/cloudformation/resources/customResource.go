@@ -111,10 +111,22 @@ type CloudFormationLambdaEvent struct {
 	ResourceType          string
 	StackID               string `json:"StackId"`
 	LogicalResourceID     string `json:"LogicalResourceId"`
+	PhysicalResourceID    string `json:"PhysicalResourceId,omitempty"`
 	ResourceProperties    json.RawMessage
 	OldResourceProperties json.RawMessage
 }
 
+// physicalResourceID returns the PhysicalResourceId for the response. Update
+// and Delete requests return the ID supplied by CloudFormation, since a
+// different ID signals a replacement and triggers a Delete request for the
+// previous resource. Create requests return a new ID.
+func physicalResourceID(event *CloudFormationLambdaEvent, logStreamName string) string {
+	if event.PhysicalResourceID != "" {
+		return event.PhysicalResourceID
+	}
+	return fmt.Sprintf("LogStreamName: %s", logStreamName)
+}
+
 // SendCloudFormationResponse sends the given response
 // to the CloudFormation URL that was submitted together
 // with this event
@@ -153,11 +165,10 @@ func SendCloudFormationResponse(lambdaCtx *awsLambdaCtx.LambdaContext,
 	// and can be up to 1 Kb in size. The value must be a non-empty string and
 	// must be identical for all responses for the same resource.
 	// Ref: https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/crpg-ref-requesttypes-create.html
-	physicalResourceID := fmt.Sprintf("LogStreamName: %s", logStreamName)
 	responseData := map[string]interface{}{
 		"Status":             status,
 		"Reason":             reasonText,
-		"PhysicalResourceId": physicalResourceID,
+		"PhysicalResourceId": physicalResourceID(event, logStreamName),
 		"StackId":            event.StackID,
 		"RequestId":          event.RequestID,
 		"LogicalResourceId":  event.LogicalResourceID,
@@ -0,0 +1,27 @@
+package resources
+
+import (
+	"testing"
+)
+
+func TestPhysicalResourceIDStability(t *testing.T) {
+	createEvent := &CloudFormationLambdaEvent{
+		RequestType:       CreateOperation,
+		LogicalResourceID: "logicalID",
+	}
+	createID := physicalResourceID(createEvent, "stream1")
+	if createID == "" {
+		t.Fatalf("Expected non-empty PhysicalResourceId for Create")
+	}
+	for _, eachRequestType := range []string{UpdateOperation, DeleteOperation} {
+		event := &CloudFormationLambdaEvent{
+			RequestType:        eachRequestType,
+			LogicalResourceID:  "logicalID",
+			PhysicalResourceID: createID,
+		}
+		// A different log stream must not change the ID
+		if physicalResourceID(event, "stream2") != createID {
+			t.Errorf("Expected stable PhysicalResourceId for %s request", eachRequestType)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package cloudformation
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
@@ -9,7 +10,9 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net/url"
 	"os"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
@@ -19,6 +22,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/lambda"
@@ -169,10 +173,72 @@ func (converter *templateConverter) results() (*gocf.StringExpr, error) {
 
 // END - templateConverter
 
+// CloudFormationAPI is the subset of the CloudFormation client used to
+// converge stack state. The *cloudformation.CloudFormation client satisfies
+// it, and tests can supply a fake implementation.
+type CloudFormationAPI interface {
+	CreateChangeSet(*cloudformation.CreateChangeSetInput) (*cloudformation.CreateChangeSetOutput, error)
+	CreateStack(*cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error)
+	DeleteChangeSet(*cloudformation.DeleteChangeSetInput) (*cloudformation.DeleteChangeSetOutput, error)
+	DeleteStack(*cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error)
+	DescribeChangeSet(*cloudformation.DescribeChangeSetInput) (*cloudformation.DescribeChangeSetOutput, error)
+	DescribeStackEvents(*cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error)
+	DescribeStackEventsWithContext(aws.Context, *cloudformation.DescribeStackEventsInput, ...request.Option) (*cloudformation.DescribeStackEventsOutput, error)
+	DescribeStacks(*cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error)
+	DescribeStacksWithContext(aws.Context, *cloudformation.DescribeStacksInput, ...request.Option) (*cloudformation.DescribeStacksOutput, error)
+	ExecuteChangeSet(*cloudformation.ExecuteChangeSetInput) (*cloudformation.ExecuteChangeSetOutput, error)
+	GetTemplate(*cloudformation.GetTemplateInput) (*cloudformation.GetTemplateOutput, error)
+	ListChangeSets(*cloudformation.ListChangeSetsInput) (*cloudformation.ListChangeSetsOutput, error)
+	ListExports(*cloudformation.ListExportsInput) (*cloudformation.ListExportsOutput, error)
+	ListImports(*cloudformation.ListImportsInput) (*cloudformation.ListImportsOutput, error)
+	ListStackResourcesPages(*cloudformation.ListStackResourcesInput, func(*cloudformation.ListStackResourcesOutput, bool) bool) error
+	ValidateTemplate(*cloudformation.ValidateTemplateInput) (*cloudformation.ValidateTemplateOutput, error)
+	GetStackPolicyWithContext(aws.Context, *cloudformation.GetStackPolicyInput, ...request.Option) (*cloudformation.GetStackPolicyOutput, error)
+	SetStackPolicyWithContext(aws.Context, *cloudformation.SetStackPolicyInput, ...request.Option) (*cloudformation.SetStackPolicyOutput, error)
+}
+
 func cloudformationPollingDelay() time.Duration {
 	return time.Duration(3+rand.Int31n(5)) * time.Second
 }
 
+// StackPollInterval is the delay between DescribeStacks calls while
+// waiting for a stack operation to complete. Each delay is the Interval
+// plus a random duration in [0, Jitter).
+type StackPollInterval struct {
+	Interval time.Duration
+	Jitter   time.Duration
+}
+
+// delay returns the next polling delay. A nil pollInterval uses the
+// default 11-24 second delay.
+func (pollInterval *StackPollInterval) delay() time.Duration {
+	if pollInterval == nil {
+		return time.Duration(11+rand.Int31n(13)) * time.Second
+	}
+	delay := pollInterval.Interval
+	if pollInterval.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(pollInterval.Jitter)))
+	}
+	return delay
+}
+
+// StackProgressFunc is called with the current stack state after each
+// DescribeStacks poll made while waiting for a stack operation to complete
+type StackProgressFunc func(stack *cloudformation.Stack)
+
+// stackProgressContextKey is the context key for the optional
+// StackProgressFunc
+type stackProgressContextKey struct{}
+
+// WithStackProgress returns a copy of ctx that reports each stack
+// operation poll to progressFunc. A nil progressFunc returns ctx unchanged.
+func WithStackProgress(ctx context.Context, progressFunc StackProgressFunc) context.Context {
+	if progressFunc == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, stackProgressContextKey{}, progressFunc)
+}
+
 func existingStackTemplate(serviceName string,
 	session *session.Session,
 	logger *logrus.Logger) (*gocf.Template, error) {
@@ -217,21 +283,27 @@ func updateStackViaChangeSet(serviceName string,
 	cfTemplate *gocf.Template,
 	cfTemplateURL string,
 	awsTags []*cloudformation.Tag,
-	awsCloudFormation *cloudformation.CloudFormation,
+	notificationARNs []string,
+	disableRollback bool,
+	awsCloudFormation CloudFormationAPI,
 	logger *logrus.Logger) error {
 
 	// Create a change set name...
 	changeSetRequestName := CloudFormationResourceName(fmt.Sprintf("%sChangeSet", serviceName))
-	_, changesErr := CreateStackChangeSet(changeSetRequestName,
+	changes, changesErr := createStackChangeSet(changeSetRequestName,
 		serviceName,
 		cfTemplate,
 		cfTemplateURL,
 		awsTags,
+		notificationARNs,
 		awsCloudFormation,
 		logger)
 	if nil != changesErr {
 		return changesErr
 	}
+	if nil != changes {
+		LogChangeSetChanges(changes, logger)
+	}
 
 	//////////////////////////////////////////////////////////////////////////////
 	// Apply the change
@@ -239,6 +311,9 @@ func updateStackViaChangeSet(serviceName string,
 		ChangeSetName: aws.String(changeSetRequestName),
 		StackName:     aws.String(serviceName),
 	}
+	if disableRollback {
+		executeChangeSetInput.DisableRollback = aws.Bool(true)
+	}
 	executeChangeSetOutput, executeChangeSetError := awsCloudFormation.ExecuteChangeSet(&executeChangeSetInput)
 
 	logger.WithFields(logrus.Fields{
@@ -346,20 +421,60 @@ func parseFnJoinExpr(data map[string]interface{}) (*gocf.StringExpr, error) {
 	return nil, fmt.Errorf("Unsupported AWS Function detected: %#v", data)
 }
 
+// iamResourceNameProperties maps the IAM resource types that require the
+// CAPABILITY_IAM capability to the property that provides a custom name.
+// Custom named resources also require CAPABILITY_NAMED_IAM. See
+// https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/using-iam-template.html#using-iam-capabilities
+var iamResourceNameProperties = map[string]string{
+	"AWS::IAM::AccessKey":           "",
+	"AWS::IAM::Group":               "GroupName",
+	"AWS::IAM::InstanceProfile":     "InstanceProfileName",
+	"AWS::IAM::ManagedPolicy":       "ManagedPolicyName",
+	"AWS::IAM::Policy":              "",
+	"AWS::IAM::Role":                "RoleName",
+	"AWS::IAM::User":                "UserName",
+	"AWS::IAM::UserToGroupAddition": "",
+}
+
+// isNamedIAMResource returns true if the resource properties include a
+// value for the nameProperty
+func isNamedIAMResource(properties gocf.ResourceProperties, nameProperty string) bool {
+	if nameProperty == "" {
+		return false
+	}
+	propertiesJSON, propertiesJSONErr := json.Marshal(properties)
+	if propertiesJSONErr != nil {
+		return false
+	}
+	var propertiesMap map[string]interface{}
+	if json.Unmarshal(propertiesJSON, &propertiesMap) != nil {
+		return false
+	}
+	nameValue, nameValueExists := propertiesMap[nameProperty]
+	return nameValueExists && nameValue != nil && nameValue != ""
+}
+
 func stackCapabilities(template *gocf.Template) []*string {
 	// Only require IAM capability if the definition requires it.
-	capabilities := make([]*string, 0)
+	requiresIAM := false
+	requiresNamedIAM := false
 	for _, eachResource := range template.Resources {
-		if eachResource.Properties.CfnResourceType() == "AWS::IAM::Role" {
-			found := false
-			for _, eachElement := range capabilities {
-				found = (found || (*eachElement == "CAPABILITY_IAM"))
-			}
-			if !found {
-				capabilities = append(capabilities, aws.String("CAPABILITY_IAM"))
-			}
+		nameProperty, isIAMResource := iamResourceNameProperties[eachResource.Properties.CfnResourceType()]
+		if !isIAMResource {
+			continue
+		}
+		requiresIAM = true
+		if !requiresNamedIAM && isNamedIAMResource(eachResource.Properties, nameProperty) {
+			requiresNamedIAM = true
 		}
 	}
+	capabilities := make([]*string, 0)
+	if requiresIAM {
+		capabilities = append(capabilities, aws.String(cloudformation.CapabilityCapabilityIam))
+	}
+	if requiresNamedIAM {
+		capabilities = append(capabilities, aws.String(cloudformation.CapabilityCapabilityNamedIam))
+	}
 	return capabilities
 }
 
@@ -555,12 +670,179 @@ func AddAutoIncrementingLambdaVersionResource(serviceName string,
 	return &autoIncrementingLambdaVersionInfo, nil
 }
 
+// nestedStackOutputs returns the Outputs of the stack and its nested
+// stacks. Output keys are prefixed with keyPrefix.
+func nestedStackOutputs(stackName string,
+	keyPrefix string,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) (map[string]string, error) {
+
+	describeStacksOutput, describeStacksErr := awsCloudFormation.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if describeStacksErr != nil {
+		return nil, errors.Wrapf(describeStacksErr, "Failed to describe stack: %s", stackName)
+	}
+	if len(describeStacksOutput.Stacks) <= 0 {
+		return nil, errors.Errorf("Failed to enumerate stack info: %s", stackName)
+	}
+	outputs := make(map[string]string)
+	for _, eachOutput := range describeStacksOutput.Stacks[0].Outputs {
+		outputs[keyPrefix+aws.StringValue(eachOutput.OutputKey)] = aws.StringValue(eachOutput.OutputValue)
+	}
+
+	// Find the nested stacks...
+	nestedStacks := make(map[string]string)
+	listResourcesErr := awsCloudFormation.ListStackResourcesPages(&cloudformation.ListStackResourcesInput{
+		StackName: aws.String(stackName),
+	}, func(page *cloudformation.ListStackResourcesOutput, lastPage bool) bool {
+		for _, eachSummary := range page.StackResourceSummaries {
+			if aws.StringValue(eachSummary.ResourceType) == "AWS::CloudFormation::Stack" &&
+				aws.StringValue(eachSummary.PhysicalResourceId) != "" {
+				nestedStacks[aws.StringValue(eachSummary.LogicalResourceId)] = aws.StringValue(eachSummary.PhysicalResourceId)
+			}
+		}
+		return true
+	})
+	if listResourcesErr != nil {
+		return nil, errors.Wrapf(listResourcesErr, "Failed to list stack resources: %s", stackName)
+	}
+	logger.WithFields(logrus.Fields{
+		"StackName":    stackName,
+		"NestedStacks": nestedStacks,
+	}).Debug("Fetching nested stack outputs")
+
+	// ...and fetch them concurrently
+	var wg sync.WaitGroup
+	var outputsMutex sync.Mutex
+	var fetchErrors []error
+	for eachLogicalName, eachStackID := range nestedStacks {
+		wg.Add(1)
+		go func(logicalName string, stackID string) {
+			defer wg.Done()
+			childOutputs, childOutputsErr := nestedStackOutputs(stackID,
+				fmt.Sprintf("%s%s.", keyPrefix, logicalName),
+				awsCloudFormation,
+				logger)
+			outputsMutex.Lock()
+			defer outputsMutex.Unlock()
+			if childOutputsErr != nil {
+				fetchErrors = append(fetchErrors, childOutputsErr)
+				return
+			}
+			for eachKey, eachValue := range childOutputs {
+				outputs[eachKey] = eachValue
+			}
+		}(eachLogicalName, eachStackID)
+	}
+	wg.Wait()
+	if len(fetchErrors) != 0 {
+		return nil, errors.Errorf("Failed to fetch nested stack outputs: %v", fetchErrors)
+	}
+	return outputs, nil
+}
+
+// StackOutputs returns the unified Outputs of stackName and all of its
+// nested AWS::CloudFormation::Stack resources. Nested stack Outputs are
+// fetched concurrently and keyed by the dotted path of the nested stack
+// logical resource names (eg, `NestedStackResource.OutputKey`).
+func StackOutputs(stackName string,
+	awsSession *session.Session,
+	logger *logrus.Logger) (map[string]string, error) {
+	return nestedStackOutputs(stackName,
+		"",
+		cloudformation.New(awsSession),
+		logger)
+}
+
+// reCascadingFailureReason matches the ResourceStatusReason values of
+// failures that are caused by the failure of another resource
+var reCascadingFailureReason = regexp.MustCompile(`(?i)(resource (creation|update|deletion) cancelled)|` +
+	`(the following resource\(s\) failed to)`)
+
+// stackConsoleURL returns the CloudFormation console URL for the stackID
+func stackConsoleURL(region string, stackID string) string {
+	return fmt.Sprintf("https://%s.console.aws.amazon.com/cloudformation/home?region=%s#/stack/detail?stackId=%s",
+		region,
+		region,
+		url.QueryEscape(stackID))
+}
+
+// stackFailureMessage returns the log message for a failed stack event
+func stackFailureMessage(event *cloudformation.StackEvent) string {
+	return fmt.Sprintf("%s (%s): %s",
+		aws.StringValue(event.ResourceType),
+		aws.StringValue(event.LogicalResourceId),
+		aws.StringValue(event.ResourceStatusReason))
+}
+
+// StackFailureError is returned when a stack operation fails. It identifies
+// the earliest initiating resource failure, since later failures are often
+// cascading.
+type StackFailureError struct {
+	// StackName is the name of the failed stack
+	StackName string
+	// StackStatus is the terminal stack status (eg, UPDATE_ROLLBACK_COMPLETE)
+	StackStatus string
+	// ResourceType is the type of the root cause resource (eg, AWS::IAM::Role)
+	ResourceType string
+	// LogicalResourceID is the logical ID of the root cause resource
+	LogicalResourceID string
+	// ResourceStatusReason is the reason that the root cause resource failed
+	ResourceStatusReason string
+	// AdditionalFailures is the number of other failed resource events
+	AdditionalFailures int
+}
+
+// Error returns the root cause failure message
+func (stackErr *StackFailureError) Error() string {
+	return fmt.Sprintf("Failed to provision: %s (%s). Root cause: %s (%s): %s (%d additional failures)",
+		stackErr.StackName,
+		stackErr.StackStatus,
+		stackErr.ResourceType,
+		stackErr.LogicalResourceID,
+		stackErr.ResourceStatusReason,
+		stackErr.AdditionalFailures)
+}
+
+// classifyStackFailureEvents partitions the failed events into the
+// initiating failures and the failures that cascaded from them. The
+// initiating failures are returned in chronological order. If every failure
+// looks like a cascade, all of them are returned as initiating failures.
+func classifyStackFailureEvents(failedEvents []*cloudformation.StackEvent) ([]*cloudformation.StackEvent,
+	[]*cloudformation.StackEvent) {
+	rootCauses := []*cloudformation.StackEvent{}
+	cascade := []*cloudformation.StackEvent{}
+	for _, eachEvent := range failedEvents {
+		if reCascadingFailureReason.MatchString(aws.StringValue(eachEvent.ResourceStatusReason)) {
+			cascade = append(cascade, eachEvent)
+		} else {
+			rootCauses = append(rootCauses, eachEvent)
+		}
+	}
+	if len(rootCauses) == 0 {
+		rootCauses, cascade = cascade, rootCauses
+	}
+	sort.SliceStable(rootCauses, func(i, j int) bool {
+		return aws.TimeValue(rootCauses[i].Timestamp).Before(aws.TimeValue(rootCauses[j].Timestamp))
+	})
+	return rootCauses, cascade
+}
+
 // StackEvents returns the slice of cloudformation.StackEvents for the given stackID or stackName
 func StackEvents(stackID string,
 	eventFilterLowerBoundInclusive time.Time,
 	awsSession *session.Session) ([]*cloudformation.StackEvent, error) {
+	return stackEvents(stackID,
+		eventFilterLowerBoundInclusive,
+		cloudformation.New(awsSession))
+}
 
-	cfService := cloudformation.New(awsSession)
+// stackEvents returns the stack events at or after the
+// eventFilterLowerBoundInclusive time
+func stackEvents(stackID string,
+	eventFilterLowerBoundInclusive time.Time,
+	cfService CloudFormationAPI) ([]*cloudformation.StackEvent, error) {
 	var events []*cloudformation.StackEvent
 
 	nextToken := ""
@@ -591,6 +873,59 @@ func StackEvents(stackID string,
 	return events, nil
 }
 
+// stackOperationStartTime returns the time that CloudFormation started
+// the stack's current operation
+func stackOperationStartTime(stack *cloudformation.Stack) time.Time {
+	if stack.DeletionTime != nil {
+		return *stack.DeletionTime
+	}
+	if stack.LastUpdatedTime != nil {
+		return *stack.LastUpdatedTime
+	}
+	return aws.TimeValue(stack.CreationTime)
+}
+
+// newStackEvents returns the stackID events that occurred at or after since
+// and which aren't in seenEvents, in chronological order. The returned
+// events are added to seenEvents.
+func newStackEvents(ctx context.Context,
+	stackID string,
+	since time.Time,
+	seenEvents map[string]bool,
+	awsCloudFormation CloudFormationAPI) ([]*cloudformation.StackEvent, error) {
+
+	var events []*cloudformation.StackEvent
+	params := &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(stackID),
+	}
+	for {
+		resp, err := awsCloudFormation.DescribeStackEventsWithContext(ctx, params)
+		if nil != err {
+			return nil, err
+		}
+		// Events are returned newest first, so stop at the first one
+		// that's already been seen
+		done := resp.NextToken == nil
+		for _, eachEvent := range resp.StackEvents {
+			eventID := aws.StringValue(eachEvent.EventId)
+			if seenEvents[eventID] || aws.TimeValue(eachEvent.Timestamp).Before(since) {
+				done = true
+				break
+			}
+			seenEvents[eventID] = true
+			events = append(events, eachEvent)
+		}
+		if done {
+			break
+		}
+		params.NextToken = resp.NextToken
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
 // WaitForStackOperationCompleteResult encapsulates the stackInfo
 // following a WaitForStackOperationComplete call
 type WaitForStackOperationCompleteResult struct {
@@ -603,7 +938,26 @@ type WaitForStackOperationCompleteResult struct {
 // to determine if an operation is complete
 func WaitForStackOperationComplete(stackID string,
 	pollingMessage string,
-	awsCloudFormation *cloudformation.CloudFormation,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) (*WaitForStackOperationCompleteResult, error) {
+	return WaitForStackOperationCompleteWithContext(context.Background(),
+		stackID,
+		pollingMessage,
+		nil,
+		awsCloudFormation,
+		logger)
+}
+
+// WaitForStackOperationCompleteWithContext is the same as
+// WaitForStackOperationComplete, but stops polling and returns the context
+// error when the ctx is canceled or its deadline expires. The in-progress
+// stack operation isn't canceled. The optional pollInterval sets the delay
+// between DescribeStacks calls. New stack events are logged on each poll.
+func WaitForStackOperationCompleteWithContext(ctx context.Context,
+	stackID string,
+	pollingMessage string,
+	pollInterval *StackPollInterval,
+	awsCloudFormation CloudFormationAPI,
 	logger *logrus.Logger) (*WaitForStackOperationCompleteResult, error) {
 
 	result := &WaitForStackOperationCompleteResult{}
@@ -619,6 +973,8 @@ func WaitForStackOperationComplete(stackID string,
 	describeStacksInput := &cloudformation.DescribeStacksInput{
 		StackName: aws.String(stackID),
 	}
+	// The IDs of the stack events that have been logged
+	seenEvents := make(map[string]bool)
 	for waitComplete := false; !waitComplete; {
 		// Startup the spinner if needed...
 		switch logger.Formatter.(type) {
@@ -639,18 +995,56 @@ func WaitForStackOperationComplete(stackID string,
 		}
 
 		// Then sleep and figure out if things are done...
-		sleepDuration := time.Duration(11+rand.Int31n(13)) * time.Second
-		time.Sleep(sleepDuration)
+		sleepDuration := pollInterval.delay()
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(),
+				"Stopped waiting for stack operation to complete: %s",
+				stackID)
+		case <-time.After(sleepDuration):
+		}
 
-		describeStacksOutput, err := awsCloudFormation.DescribeStacks(describeStacksInput)
+		// Throttled requests are retried by the session's retryer
+		describeStacksOutput, err := awsCloudFormation.DescribeStacksWithContext(ctx, describeStacksInput)
 		if nil != err {
-			// TODO - add retry iff we're RateExceeded due to collective access
 			return nil, err
 		}
 		if len(describeStacksOutput.Stacks) <= 0 {
 			return nil, fmt.Errorf("Failed to enumerate stack info: %v", *describeStacksInput.StackName)
 		}
 		result.stackInfo = describeStacksOutput.Stacks[0]
+		if progressFunc, ok := ctx.Value(stackProgressContextKey{}).(StackProgressFunc); ok {
+			progressFunc(result.stackInfo)
+		}
+
+		// Log the events since the last poll
+		events, eventsErr := newStackEvents(ctx,
+			stackID,
+			stackOperationStartTime(result.stackInfo),
+			seenEvents,
+			awsCloudFormation)
+		if nil != eventsErr {
+			logger.WithFields(logrus.Fields{
+				"Error": eventsErr,
+			}).Debug("Failed to fetch stack events")
+		}
+		if len(events) != 0 && cliSpinnerStarted {
+			cliSpinner.Stop()
+		}
+		for _, eachEvent := range events {
+			eventFields := logrus.Fields{
+				"ResourceType": aws.StringValue(eachEvent.ResourceType),
+				"Resource":     aws.StringValue(eachEvent.LogicalResourceId),
+				"Status":       aws.StringValue(eachEvent.ResourceStatus),
+			}
+			if eachEvent.ResourceStatusReason != nil {
+				eventFields["Reason"] = aws.StringValue(eachEvent.ResourceStatusReason)
+			}
+			logger.WithFields(eventFields).Info("Stack event")
+		}
+		if len(events) != 0 && cliSpinnerStarted {
+			cliSpinner.Start()
+		}
 		switch *(result.stackInfo).StackStatus {
 		case cloudformation.StackStatusCreateComplete,
 			cloudformation.StackStatusUpdateComplete:
@@ -663,7 +1057,10 @@ func WaitForStackOperationComplete(stackID string,
 			cloudformation.StackStatusDeleteFailed,
 			cloudformation.StackStatusRollbackFailed,
 			cloudformation.StackStatusRollbackComplete,
-			cloudformation.StackStatusUpdateRollbackComplete:
+			cloudformation.StackStatusUpdateRollbackComplete,
+			cloudformation.StackStatusUpdateRollbackFailed,
+			// Updates that fail with rollback disabled
+			cloudformation.StackStatusUpdateFailed:
 			result.operationSuccessful = false
 			waitComplete = true
 		default:
@@ -674,6 +1071,61 @@ func WaitForStackOperationComplete(stackID string,
 	return result, nil
 }
 
+// WaitForStackDeleteComplete is a blocking call that waits for the stackID
+// delete operation to complete. The stackID should be the stack ID rather
+// than the stack name, since deleted stacks can only be described by ID.
+// If the delete fails, the returned error includes the failed resource
+// events.
+func WaitForStackDeleteComplete(stackID string,
+	startTime time.Time,
+	awsSession *session.Session,
+	logger *logrus.Logger) error {
+	return WaitForStackDeleteCompleteWithClient(stackID,
+		startTime,
+		nil,
+		cloudformation.New(awsSession),
+		logger)
+}
+
+// WaitForStackDeleteCompleteWithClient is the same as
+// WaitForStackDeleteComplete, but uses the awsCloudFormation client and
+// polls the stack with the optional pollInterval
+func WaitForStackDeleteCompleteWithClient(stackID string,
+	startTime time.Time,
+	pollInterval *StackPollInterval,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) error {
+
+	deleteResult, deleteErr := WaitForStackOperationCompleteWithContext(context.Background(),
+		stackID,
+		"Waiting for CloudFormation stack delete to complete",
+		pollInterval,
+		awsCloudFormation,
+		logger)
+	if nil != deleteErr {
+		return deleteErr
+	}
+	if aws.StringValue(deleteResult.stackInfo.StackStatus) == cloudformation.StackStatusDeleteComplete {
+		return nil
+	}
+	events, eventsErr := stackEvents(stackID, startTime, awsCloudFormation)
+	if nil != eventsErr {
+		return errors.Wrapf(eventsErr, "Failed to retrieve stack events")
+	}
+	failureMessages := []string{}
+	for _, eachEvent := range events {
+		if aws.StringValue(eachEvent.ResourceStatus) == cloudformation.ResourceStatusDeleteFailed {
+			failureMessage := stackFailureMessage(eachEvent)
+			logger.Error("\tDelete failure: " + failureMessage)
+			failureMessages = append(failureMessages, failureMessage)
+		}
+	}
+	return errors.Errorf("Failed to delete stack: %s (status: %s). %s",
+		stackID,
+		aws.StringValue(deleteResult.stackInfo.StackStatus),
+		strings.Join(failureMessages, "; "))
+}
+
 // CloudFormationResourceName returns a name suitable as a logical
 // CloudFormation resource value.  See http://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/resources-section-structure.html
 // for more information.  The `prefix` value should provide a hint as to the
@@ -705,6 +1157,418 @@ func CloudFormationResourceName(prefix string, parts ...string) string {
 	return reCloudFormationInvalidChars.ReplaceAllString(resourceName, "x")
 }
 
+// MaxTemplateSize is the maximum size in bytes of a template that's
+// provided to CloudFormation via an S3 URL
+const MaxTemplateSize = 1024 * 1024
+
+// MaxTemplateBodySize is the maximum size in bytes of a template that's
+// provided to CloudFormation inline via the TemplateBody parameter
+const MaxTemplateBodySize = 51200
+
+// reSubReference matches the resource references in an Fn::Sub string
+var reSubReference = regexp.MustCompile(`\$\{([A-Za-z0-9]+)(\.[A-Za-z0-9.]+)?\}`)
+
+// TemplateResourceCluster is a set of template resources that are connected
+// via Ref, Fn::GetAtt, Fn::Sub, or DependsOn references
+type TemplateResourceCluster struct {
+	// Resources are the sorted logical resource names in the cluster
+	Resources []string
+	// Size is the serialized size in bytes of the cluster's resources
+	Size int
+}
+
+// templateReferences appends the names referenced by the generic
+// template value to refs
+func templateReferences(value interface{}, refs []string) []string {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for eachKey, eachValue := range typedValue {
+			switch eachKey {
+			case "Ref", "DependsOn":
+				if refName, refNameOk := eachValue.(string); refNameOk {
+					refs = append(refs, refName)
+					continue
+				}
+				if eachKey == "DependsOn" {
+					if dependsOn, dependsOnOk := eachValue.([]interface{}); dependsOnOk {
+						for _, eachDependency := range dependsOn {
+							if dependencyName, dependencyNameOk := eachDependency.(string); dependencyNameOk {
+								refs = append(refs, dependencyName)
+							}
+						}
+						continue
+					}
+				}
+			case "Fn::GetAtt":
+				switch getAtt := eachValue.(type) {
+				case []interface{}:
+					if len(getAtt) != 0 {
+						if resourceName, resourceNameOk := getAtt[0].(string); resourceNameOk {
+							refs = append(refs, resourceName)
+						}
+					}
+					continue
+				case string:
+					refs = append(refs, strings.Split(getAtt, ".")[0])
+					continue
+				}
+			case "Fn::Sub":
+				subString, subStringOk := eachValue.(string)
+				if subSlice, subSliceOk := eachValue.([]interface{}); subSliceOk && len(subSlice) != 0 {
+					subString, subStringOk = subSlice[0].(string)
+				}
+				if subStringOk {
+					for _, eachMatch := range reSubReference.FindAllStringSubmatch(subString, -1) {
+						refs = append(refs, eachMatch[1])
+					}
+				}
+			}
+			refs = templateReferences(eachValue, refs)
+		}
+	case []interface{}:
+		for _, eachValue := range typedValue {
+			refs = templateReferences(eachValue, refs)
+		}
+	}
+	return refs
+}
+
+// TemplateReferences returns the sorted, unique logical names referenced
+// by the generic template value via Ref, Fn::GetAtt, Fn::Sub, and
+// DependsOn entries
+func TemplateReferences(value interface{}) []string {
+	uniqueRefs := make(map[string]bool)
+	for _, eachRef := range templateReferences(value, nil) {
+		uniqueRefs[eachRef] = true
+	}
+	refs := make([]string, 0, len(uniqueRefs))
+	for eachRef := range uniqueRefs {
+		refs = append(refs, eachRef)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// TemplateResourceClusters partitions the resources in templateBody into
+// clusters of resources that reference each other. Clusters are returned
+// in order of decreasing serialized size. Since clusters don't reference
+// each other, each is a candidate for extraction into a separate stack.
+func TemplateResourceClusters(templateBody []byte) ([]*TemplateResourceCluster, error) {
+	resources, resourcesErr := templateResources(templateBody)
+	if resourcesErr != nil {
+		return nil, resourcesErr
+	}
+	// Union-find over the logical resource names
+	parents := make(map[string]string, len(resources))
+	var find func(name string) string
+	find = func(name string) string {
+		if parents[name] != name {
+			parents[name] = find(parents[name])
+		}
+		return parents[name]
+	}
+	for eachName := range resources {
+		parents[eachName] = eachName
+	}
+	for eachName, eachResource := range resources {
+		for _, eachRef := range templateReferences(eachResource, nil) {
+			if _, isResource := resources[eachRef]; isResource {
+				parents[find(eachRef)] = find(eachName)
+			}
+		}
+	}
+	clusterMap := make(map[string]*TemplateResourceCluster)
+	for eachName, eachResource := range resources {
+		resourceJSON, resourceJSONErr := json.Marshal(eachResource)
+		if resourceJSONErr != nil {
+			return nil, errors.Wrapf(resourceJSONErr, "Failed to marshal resource: %s", eachName)
+		}
+		root := find(eachName)
+		cluster, clusterExists := clusterMap[root]
+		if !clusterExists {
+			cluster = &TemplateResourceCluster{}
+			clusterMap[root] = cluster
+		}
+		cluster.Resources = append(cluster.Resources, eachName)
+		cluster.Size += len(resourceJSON)
+	}
+	clusters := make([]*TemplateResourceCluster, 0, len(clusterMap))
+	for _, eachCluster := range clusterMap {
+		sort.Strings(eachCluster.Resources)
+		clusters = append(clusters, eachCluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Size != clusters[j].Size {
+			return clusters[i].Size > clusters[j].Size
+		}
+		return clusters[i].Resources[0] < clusters[j].Resources[0]
+	})
+	return clusters, nil
+}
+
+// CanonicalTemplateJSON returns the canonical JSON representation of
+// templateJSON. Object keys are sorted and insignificant whitespace is
+// removed so that semantically identical templates produce identical
+// bytes, and therefore identical content digests.
+func CanonicalTemplateJSON(templateJSON []byte) ([]byte, error) {
+	var genericTemplate interface{}
+	decoder := json.NewDecoder(bytes.NewReader(templateJSON))
+	// Preserve numeric values exactly as written
+	decoder.UseNumber()
+	decodeErr := decoder.Decode(&genericTemplate)
+	if decodeErr != nil {
+		return nil, errors.Wrapf(decodeErr, "Failed to parse template JSON")
+	}
+	canonicalJSON, canonicalJSONErr := json.Marshal(genericTemplate)
+	if canonicalJSONErr != nil {
+		return nil, errors.Wrapf(canonicalJSONErr, "Failed to marshal canonical template JSON")
+	}
+	return canonicalJSON, nil
+}
+
+// templateNode is a JSON template value that preserves the object key order
+type templateNode struct {
+	// keys and values are the ordered entries of an object
+	keys   []string
+	values []*templateNode
+	// items are the entries of an array
+	items []*templateNode
+	// scalar is the YAML text of a string, number, boolean, or null
+	scalar string
+	// text is the value of a string
+	text     string
+	isString bool
+	isObject bool
+	isArray  bool
+}
+
+// reYAMLPlainScalar matches strings that are safe to emit as plain YAML
+// scalars. Everything else is emitted as a double quoted JSON string,
+// which is also a valid YAML scalar.
+var reYAMLPlainScalar = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./:@-]*$`)
+
+// yamlReservedScalars are plain scalars that YAML resolves to non-string
+// values
+var yamlReservedScalars = map[string]bool{
+	"true":  true,
+	"false": true,
+	"yes":   true,
+	"no":    true,
+	"on":    true,
+	"off":   true,
+	"y":     true,
+	"n":     true,
+	"null":  true,
+}
+
+func yamlString(value string) string {
+	if reYAMLPlainScalar.MatchString(value) &&
+		!strings.HasSuffix(value, ":") &&
+		!yamlReservedScalars[strings.ToLower(value)] {
+		return value
+	}
+	quoted, _ := json.Marshal(value)
+	return string(quoted)
+}
+
+// decodeTemplateNode decodes the next JSON value from the decoder
+func decodeTemplateNode(decoder *json.Decoder) (*templateNode, error) {
+	token, tokenErr := decoder.Token()
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+	node := &templateNode{}
+	switch typedToken := token.(type) {
+	case json.Delim:
+		switch typedToken {
+		case '{':
+			node.isObject = true
+			for decoder.More() {
+				keyToken, keyTokenErr := decoder.Token()
+				if keyTokenErr != nil {
+					return nil, keyTokenErr
+				}
+				value, valueErr := decodeTemplateNode(decoder)
+				if valueErr != nil {
+					return nil, valueErr
+				}
+				node.keys = append(node.keys, keyToken.(string))
+				node.values = append(node.values, value)
+			}
+		case '[':
+			node.isArray = true
+			for decoder.More() {
+				item, itemErr := decodeTemplateNode(decoder)
+				if itemErr != nil {
+					return nil, itemErr
+				}
+				node.items = append(node.items, item)
+			}
+		default:
+			return nil, errors.Errorf("Unexpected JSON delimiter: %s", typedToken)
+		}
+		// Consume the closing delimiter
+		_, closeErr := decoder.Token()
+		if closeErr != nil {
+			return nil, closeErr
+		}
+	case string:
+		node.scalar = yamlString(typedToken)
+		node.text = typedToken
+		node.isString = true
+	case json.Number:
+		node.scalar = typedToken.String()
+	case bool:
+		node.scalar = strconv.FormatBool(typedToken)
+	case nil:
+		node.scalar = "null"
+	}
+	return node, nil
+}
+
+// shortForm returns the YAML short form tag and value of an intrinsic
+// function object (eg, `!Ref`). The value is nil for intrinsic functions
+// whose short form is a single scalar. A node can only have a single YAML
+// tag, so an intrinsic function whose argument is itself a short form
+// intrinsic function stays in the long form.
+func (node *templateNode) shortForm() (string, *templateNode, bool) {
+	if !node.isObject || len(node.keys) != 1 {
+		return "", nil, false
+	}
+	key := node.keys[0]
+	value := node.values[0]
+	switch {
+	case key == "Ref":
+		if !value.isString {
+			return "", nil, false
+		}
+		return "!Ref " + value.scalar, nil, true
+	case key == "Fn::GetAtt":
+		// The dotted short form requires literal names
+		if !value.isArray ||
+			len(value.items) != 2 ||
+			!value.items[0].isString ||
+			!value.items[1].isString ||
+			strings.Contains(value.items[0].text, ".") {
+			return "", nil, false
+		}
+		return "!GetAtt " + yamlString(value.items[0].text+"."+value.items[1].text), nil, true
+	case strings.HasPrefix(key, "Fn::"):
+		// Tagged scalars are strings
+		if !value.isObject && !value.isArray && !value.isString {
+			return "", nil, false
+		}
+		if _, _, valueShortForm := value.shortForm(); valueShortForm {
+			return "", nil, false
+		}
+		return "!" + strings.TrimPrefix(key, "Fn::"), value, true
+	}
+	return "", nil, false
+}
+
+// inlineYAML returns the single line YAML representation of the node, if
+// it has one
+func (node *templateNode) inlineYAML() (string, bool) {
+	if tag, value, isShortForm := node.shortForm(); isShortForm {
+		if value == nil {
+			return tag, true
+		}
+		inlineValue, inlineValueOk := value.inlineYAML()
+		if !inlineValueOk {
+			return "", false
+		}
+		return tag + " " + inlineValue, true
+	}
+	switch {
+	case node.isObject && len(node.keys) == 0:
+		return "{}", true
+	case node.isArray && len(node.items) == 0:
+		return "[]", true
+	case node.isObject || node.isArray:
+		return "", false
+	}
+	return node.scalar, true
+}
+
+// blockYAML returns the optional short form tag and the unindented block
+// lines of a node that doesn't have an inline representation
+func (node *templateNode) blockYAML() (string, []string) {
+	if tag, value, isShortForm := node.shortForm(); isShortForm {
+		_, lines := value.blockYAML()
+		return tag, lines
+	}
+	var lines []string
+	if node.isObject {
+		for index, eachKey := range node.keys {
+			key := yamlString(eachKey)
+			if inlineValue, isInline := node.values[index].inlineYAML(); isInline {
+				lines = append(lines, key+": "+inlineValue)
+				continue
+			}
+			tag, valueLines := node.values[index].blockYAML()
+			if tag != "" {
+				key += ": " + tag
+			} else {
+				key += ":"
+			}
+			lines = append(lines, key)
+			for _, eachLine := range valueLines {
+				lines = append(lines, "  "+eachLine)
+			}
+		}
+		return "", lines
+	}
+	for _, eachItem := range node.items {
+		if inlineValue, isInline := eachItem.inlineYAML(); isInline {
+			lines = append(lines, "- "+inlineValue)
+			continue
+		}
+		tag, itemLines := eachItem.blockYAML()
+		if tag != "" {
+			lines = append(lines, "- "+tag)
+			for _, eachLine := range itemLines {
+				lines = append(lines, "  "+eachLine)
+			}
+			continue
+		}
+		for lineIndex, eachLine := range itemLines {
+			if lineIndex == 0 {
+				lines = append(lines, "- "+eachLine)
+			} else {
+				lines = append(lines, "  "+eachLine)
+			}
+		}
+	}
+	return "", lines
+}
+
+// TemplateYAML returns the YAML representation of templateJSON. Object keys
+// keep their JSON order and intrinsic functions use the YAML short form
+// (eg, `!Ref`, `!GetAtt`, `!Sub`) where the YAML syntax allows it.
+func TemplateYAML(templateJSON []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(templateJSON))
+	decoder.UseNumber()
+	rootNode, rootNodeErr := decodeTemplateNode(decoder)
+	if rootNodeErr != nil {
+		return nil, errors.Wrapf(rootNodeErr, "Failed to parse template JSON")
+	}
+	var lines []string
+	if inlineValue, isInline := rootNode.inlineYAML(); isInline {
+		lines = []string{inlineValue}
+	} else {
+		tag, blockLines := rootNode.blockYAML()
+		if tag != "" {
+			lines = append(lines, tag)
+			for _, eachLine := range blockLines {
+				lines = append(lines, "  "+eachLine)
+			}
+		} else {
+			lines = blockLines
+		}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
 // UploadTemplate marshals the given cfTemplate and uploads it to the
 // supplied bucket using the given KeyName
 func UploadTemplate(serviceName string,
@@ -751,8 +1615,19 @@ func UploadTemplate(serviceName string,
 
 // StackExists returns whether the given stackName or stackID currently exists
 func StackExists(stackNameOrID string, awsSession *session.Session, logger *logrus.Logger) (bool, error) {
-	cf := cloudformation.New(awsSession)
+	return stackExists(stackNameOrID, cloudformation.New(awsSession), logger)
+}
 
+// StackExistsWithClient is the same as StackExists, but uses the
+// awsCloudFormation client
+func StackExistsWithClient(stackNameOrID string,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) (bool, error) {
+	return stackExists(stackNameOrID, awsCloudFormation, logger)
+}
+
+// stackExists returns whether the stackNameOrID stack exists
+func stackExists(stackNameOrID string, cf CloudFormationAPI, logger *logrus.Logger) (bool, error) {
 	describeStacksInput := &cloudformation.DescribeStacksInput{
 		StackName: aws.String(stackNameOrID),
 	}
@@ -779,14 +1654,392 @@ func StackExists(stackNameOrID string, awsSession *session.Session, logger *logr
 	return exists, nil
 }
 
+// TemplateDiff is the resource-level difference between the template
+// of a deployed stack and a proposed template. Each slice contains the
+// sorted logical resource names.
+type TemplateDiff struct {
+	// Does the stack currently exist?
+	StackExists bool
+	// Resources in the proposed template that aren't deployed
+	Added []string
+	// Deployed resources that aren't in the proposed template
+	Removed []string
+	// Resources whose definitions differ
+	Modified []string
+}
+
+// Empty returns true if the templates define the same set of resources
+func (diff *TemplateDiff) Empty() bool {
+	return len(diff.Added) == 0 &&
+		len(diff.Removed) == 0 &&
+		len(diff.Modified) == 0
+}
+
+// templateResources returns the generic representation of the
+// Resources map in the JSON template body
+func templateResources(templateBody []byte) (map[string]interface{}, error) {
+	genericTemplate := struct {
+		Resources map[string]interface{}
+	}{}
+	unmarshalErr := json.Unmarshal(templateBody, &genericTemplate)
+	if unmarshalErr != nil {
+		return nil, errors.Wrapf(unmarshalErr, "Failed to parse template body")
+	}
+	return genericTemplate.Resources, nil
+}
+
+// diffTemplateResources compares the two resource maps
+func diffTemplateResources(deployed map[string]interface{},
+	proposed map[string]interface{}) *TemplateDiff {
+	diff := &TemplateDiff{
+		Added:    []string{},
+		Removed:  []string{},
+		Modified: []string{},
+	}
+	for eachName, eachResource := range proposed {
+		deployedResource, deployedExists := deployed[eachName]
+		if !deployedExists {
+			diff.Added = append(diff.Added, eachName)
+		} else if !reflect.DeepEqual(deployedResource, eachResource) {
+			diff.Modified = append(diff.Modified, eachName)
+		}
+	}
+	for eachName := range deployed {
+		if _, proposedExists := proposed[eachName]; !proposedExists {
+			diff.Removed = append(diff.Removed, eachName)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+	return diff
+}
+
+// DiffStackTemplate returns the resource-level differences between the
+// template of the existing stackName stack and the proposed template.
+// If the stack doesn't exist, all proposed resources are reported as Added.
+func DiffStackTemplate(stackName string,
+	template *gocf.Template,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) (*TemplateDiff, error) {
+
+	proposedBody, proposedBodyErr := json.Marshal(template)
+	if proposedBodyErr != nil {
+		return nil, errors.Wrapf(proposedBodyErr, "Failed to marshal proposed template")
+	}
+	proposedResources, proposedResourcesErr := templateResources(proposedBody)
+	if proposedResourcesErr != nil {
+		return nil, proposedResourcesErr
+	}
+	exists, existsErr := stackExists(stackName, awsCloudFormation, logger)
+	if existsErr != nil {
+		return nil, existsErr
+	}
+	deployedResources := map[string]interface{}{}
+	if exists {
+		resources, resourcesErr := StackTemplateResources(stackName, awsCloudFormation)
+		if resourcesErr != nil {
+			return nil, resourcesErr
+		}
+		deployedResources = resources
+	}
+	diff := diffTemplateResources(deployedResources, proposedResources)
+	diff.StackExists = exists
+	return diff, nil
+}
+
+// StackTemplateResources returns the generic `Resources` map of the
+// template that's currently deployed for stackName, keyed by logical
+// resource name
+func StackTemplateResources(stackName string,
+	awsCloudFormation CloudFormationAPI) (map[string]interface{}, error) {
+	getTemplateOutput, getTemplateErr := awsCloudFormation.GetTemplate(&cloudformation.GetTemplateInput{
+		StackName: aws.String(stackName),
+	})
+	if getTemplateErr != nil {
+		return nil, errors.Wrapf(getTemplateErr, "Failed to get template for stack: %s", stackName)
+	}
+	deployedBody := []byte(aws.StringValue(getTemplateOutput.TemplateBody))
+	return templateResources(deployedBody)
+}
+
+// stackImports returns the names of the stacks that import exportName
+func stackImports(exportName string,
+	awsCloudFormation CloudFormationAPI) ([]string, error) {
+	importers := []string{}
+	listImportsInput := &cloudformation.ListImportsInput{
+		ExportName: aws.String(exportName),
+	}
+	for {
+		listImportsOutput, listImportsErr := awsCloudFormation.ListImports(listImportsInput)
+		if listImportsErr != nil {
+			// Unused exports are reported as a ValidationError
+			if strings.Contains(listImportsErr.Error(), "is not imported by any stack") {
+				return importers, nil
+			}
+			return nil, errors.Wrapf(listImportsErr, "Failed to list imports for: %s", exportName)
+		}
+		importers = append(importers, aws.StringValueSlice(listImportsOutput.Imports)...)
+		if listImportsOutput.NextToken == nil {
+			break
+		}
+		listImportsInput.NextToken = listImportsOutput.NextToken
+	}
+	return importers, nil
+}
+
+// capabilityCovered returns true if the capability is in capabilities.
+// CAPABILITY_NAMED_IAM also acknowledges unnamed IAM resources, so it
+// covers CAPABILITY_IAM.
+func capabilityCovered(capability string, capabilities []*string) bool {
+	for _, eachCapability := range capabilities {
+		switch aws.StringValue(eachCapability) {
+		case capability:
+			return true
+		case cloudformation.CapabilityCapabilityNamedIam:
+			if capability == cloudformation.CapabilityCapabilityIam {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isIAMCapability returns true for the IAM acknowledgement capabilities
+func isIAMCapability(capability string) bool {
+	return capability == cloudformation.CapabilityCapabilityIam ||
+		capability == cloudformation.CapabilityCapabilityNamedIam
+}
+
+// capabilityDifferences returns the capabilities that CloudFormation
+// declared for a template that aren't in the computed set, and the computed
+// capabilities that CloudFormation didn't declare. A computed IAM
+// capability isn't reported as extra if any IAM capability was declared.
+func capabilityDifferences(declared []*string, computed []*string) ([]string, []string) {
+	declaresIAM := false
+	missing := []string{}
+	for _, eachCapability := range declared {
+		capability := aws.StringValue(eachCapability)
+		declaresIAM = declaresIAM || isIAMCapability(capability)
+		if !capabilityCovered(capability, computed) {
+			missing = append(missing, capability)
+		}
+	}
+	extra := []string{}
+	for _, eachCapability := range computed {
+		capability := aws.StringValue(eachCapability)
+		if isIAMCapability(capability) && declaresIAM {
+			continue
+		}
+		if !capabilityCovered(capability, declared) {
+			extra = append(extra, capability)
+		}
+	}
+	return missing, extra
+}
+
+// ValidateTemplate validates the template with the CloudFormation
+// ValidateTemplate API so that syntax errors are reported with the exact
+// reason before any stack operation. The templateBody is the marshaled
+// template and is sent inline if it's at most MaxTemplateBodySize bytes.
+// Otherwise templateURL, the S3 URL of the uploaded template, is required.
+// A warning is logged if the capabilities CloudFormation declares for the
+// template differ from those that are requested for the stack operation.
+func ValidateTemplate(template *gocf.Template,
+	templateBody []byte,
+	templateURL string,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) error {
+
+	validateInput := &cloudformation.ValidateTemplateInput{}
+	switch {
+	case len(templateBody) != 0 && len(templateBody) <= MaxTemplateBodySize:
+		validateInput.TemplateBody = aws.String(string(templateBody))
+	case templateURL != "":
+		validateInput.TemplateURL = aws.String(templateURL)
+	default:
+		return errors.Errorf("Template validation requires a template URL for templates larger than %d bytes",
+			MaxTemplateBodySize)
+	}
+	validateOutput, validateErr := awsCloudFormation.ValidateTemplate(validateInput)
+	if validateErr != nil {
+		return errors.Wrapf(validateErr, "CloudFormation template validation failed")
+	}
+	missing, extra := capabilityDifferences(validateOutput.Capabilities,
+		stackCapabilities(template))
+	if len(missing) != 0 || len(extra) != 0 {
+		logger.WithFields(logrus.Fields{
+			"Missing": missing,
+			"Extra":   extra,
+			"Reason":  aws.StringValue(validateOutput.CapabilitiesReason),
+		}).Warn("Template capabilities differ from the requested stack capabilities")
+	}
+	logger.Debug("Validated CloudFormation template")
+	return nil
+}
+
+// ValidateStackExports ensures that the proposed template doesn't remove
+// or rename any exported Output of the existing stackName stack that's
+// imported by another stack. CloudFormation rejects these updates late
+// in the stack operation, so this allows the caller to fail fast with the
+// list of importing stacks. Proposed export names that are
+// intrinsic functions can't be resolved and are assumed to be unchanged.
+func ValidateStackExports(stackName string,
+	template *gocf.Template,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) error {
+
+	describeStacksOutput, describeStacksErr := awsCloudFormation.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if describeStacksErr != nil {
+		if strings.Contains(describeStacksErr.Error(), "does not exist") {
+			return nil
+		}
+		return errors.Wrapf(describeStacksErr, "Failed to describe stack: %s", stackName)
+	}
+	if len(describeStacksOutput.Stacks) != 1 {
+		return nil
+	}
+	// Use the JSON representation of the proposed outputs
+	proposedBody, proposedBodyErr := json.Marshal(template)
+	if proposedBodyErr != nil {
+		return errors.Wrapf(proposedBodyErr, "Failed to marshal proposed template")
+	}
+	proposedTemplate := struct {
+		Outputs map[string]struct {
+			Export *struct {
+				Name interface{}
+			}
+		}
+	}{}
+	unmarshalErr := json.Unmarshal(proposedBody, &proposedTemplate)
+	if unmarshalErr != nil {
+		return errors.Wrapf(unmarshalErr, "Failed to parse proposed template outputs")
+	}
+
+	exportErrors := []string{}
+	for _, eachOutput := range describeStacksOutput.Stacks[0].Outputs {
+		exportName := aws.StringValue(eachOutput.ExportName)
+		if exportName == "" {
+			continue
+		}
+		outputKey := aws.StringValue(eachOutput.OutputKey)
+		proposedOutput, proposedOutputExists := proposedTemplate.Outputs[outputKey]
+		if proposedOutputExists && proposedOutput.Export != nil {
+			proposedName, proposedNameOk := proposedOutput.Export.Name.(string)
+			if !proposedNameOk || proposedName == exportName {
+				continue
+			}
+		}
+		importers, importersErr := stackImports(exportName, awsCloudFormation)
+		if importersErr != nil {
+			return importersErr
+		}
+		logger.WithFields(logrus.Fields{
+			"Output":    outputKey,
+			"Export":    exportName,
+			"Importers": importers,
+		}).Debug("Export removed or renamed")
+		if len(importers) != 0 {
+			exportErrors = append(exportErrors,
+				fmt.Sprintf("%s (Output: %s) is imported by: %s",
+					exportName,
+					outputKey,
+					strings.Join(importers, ", ")))
+		}
+	}
+	if len(exportErrors) != 0 {
+		return errors.Errorf("Exports cannot be removed or renamed while in use. Remove the Fn::ImportValue references first. %s",
+			strings.Join(exportErrors, "; "))
+	}
+	return nil
+}
+
+// ValidateExportNames ensures that none of the exportNames are already
+// exported by a stack other than stackName. CloudFormation export names
+// must be unique within a region.
+func ValidateExportNames(stackName string,
+	exportNames []string,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) error {
+	if len(exportNames) == 0 {
+		return nil
+	}
+	requestedNames := make(map[string]bool)
+	for _, eachName := range exportNames {
+		requestedNames[eachName] = true
+	}
+	collisions := []string{}
+	listExportsInput := &cloudformation.ListExportsInput{}
+	for {
+		listExportsOutput, listExportsErr := awsCloudFormation.ListExports(listExportsInput)
+		if listExportsErr != nil {
+			return errors.Wrapf(listExportsErr, "Failed to list CloudFormation exports")
+		}
+		for _, eachExport := range listExportsOutput.Exports {
+			exportName := aws.StringValue(eachExport.Name)
+			if !requestedNames[exportName] {
+				continue
+			}
+			// arn:aws:cloudformation:region:account:stack/stackName/uuid
+			exportingStackID := aws.StringValue(eachExport.ExportingStackId)
+			exportingStackName := exportingStackID
+			stackIDParts := strings.Split(exportingStackID, "/")
+			if len(stackIDParts) >= 2 {
+				exportingStackName = stackIDParts[1]
+			}
+			if exportingStackName != stackName {
+				collisions = append(collisions,
+					fmt.Sprintf("%s (exported by: %s)", exportName, exportingStackName))
+			}
+		}
+		if listExportsOutput.NextToken == nil {
+			break
+		}
+		listExportsInput.NextToken = listExportsOutput.NextToken
+	}
+	logger.WithFields(logrus.Fields{
+		"ExportNames": exportNames,
+		"Collisions":  collisions,
+	}).Debug("Validated export names")
+	if len(collisions) != 0 {
+		return errors.Errorf("Export names must be unique per region. Existing exports: %s",
+			strings.Join(collisions, ", "))
+	}
+	return nil
+}
+
 // CreateStackChangeSet returns the DescribeChangeSetOutput
-// for a given stack transformation
+// for a given stack transformation. If the templateURL is empty, the
+// cfTemplate is provided inline as the change set TemplateBody. A nil
+// DescribeChangeSetOutput is returned if there are no changes.
 func CreateStackChangeSet(changeSetRequestName string,
 	serviceName string,
 	cfTemplate *gocf.Template,
 	templateURL string,
 	awsTags []*cloudformation.Tag,
-	awsCloudFormation *cloudformation.CloudFormation,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) (*cloudformation.DescribeChangeSetOutput, error) {
+	return createStackChangeSet(changeSetRequestName,
+		serviceName,
+		cfTemplate,
+		templateURL,
+		awsTags,
+		nil,
+		awsCloudFormation,
+		logger)
+}
+
+// createStackChangeSet is the same as CreateStackChangeSet, but also sets
+// the optional SNS topic ARNs that receive the stack events
+func createStackChangeSet(changeSetRequestName string,
+	serviceName string,
+	cfTemplate *gocf.Template,
+	templateURL string,
+	awsTags []*cloudformation.Tag,
+	notificationARNs []string,
+	awsCloudFormation CloudFormationAPI,
 	logger *logrus.Logger) (*cloudformation.DescribeChangeSetOutput, error) {
 
 	capabilities := stackCapabilities(cfTemplate)
@@ -794,13 +2047,29 @@ func CreateStackChangeSet(changeSetRequestName string,
 		Capabilities:  capabilities,
 		ChangeSetName: aws.String(changeSetRequestName),
 		ClientToken:   aws.String(changeSetRequestName),
-		Description:   aws.String(fmt.Sprintf("Change set for service: %s", serviceName)),
+		Description:   aws.String(changeSetDescription(serviceName)),
 		StackName:     aws.String(serviceName),
-		TemplateURL:   aws.String(templateURL),
+	}
+	if templateURL != "" {
+		changeSetInput.TemplateURL = aws.String(templateURL)
+	} else {
+		templateBody, templateBodyErr := json.Marshal(cfTemplate)
+		if nil != templateBodyErr {
+			return nil, errors.Wrapf(templateBodyErr, "Failed to marshal change set template")
+		}
+		if len(templateBody) > MaxTemplateBodySize {
+			return nil, errors.Errorf("Template size (%d bytes) exceeds the inline TemplateBody limit (%d bytes)",
+				len(templateBody),
+				MaxTemplateBodySize)
+		}
+		changeSetInput.TemplateBody = aws.String(string(templateBody))
 	}
 	if len(awsTags) != 0 {
 		changeSetInput.Tags = awsTags
 	}
+	if len(notificationARNs) != 0 {
+		changeSetInput.NotificationARNs = aws.StringSlice(notificationARNs)
+	}
 	_, changeSetError := awsCloudFormation.CreateChangeSet(changeSetInput)
 	if nil != changeSetError {
 		return nil, changeSetError
@@ -843,6 +2112,14 @@ func CreateStackChangeSet(changeSetRequestName string,
 			case "CREATE_COMPLETE":
 				changeSetStabilized = true
 			case "FAILED":
+				_, deleteChangeSetResultErr := DeleteChangeSet(serviceName,
+					changeSetRequestName,
+					awsCloudFormation)
+				if nil != deleteChangeSetResultErr {
+					logger.WithFields(logrus.Fields{
+						"Error": deleteChangeSetResultErr,
+					}).Warn("Failed to delete failed ChangeSet")
+				}
 				return nil, fmt.Errorf("Failed to create ChangeSet: %#v", *describeChangeSetOutput)
 			}
 		}
@@ -868,12 +2145,83 @@ func CreateStackChangeSet(changeSetRequestName string,
 	return describeChangeSetOutput, nil
 }
 
+// changeSetDescription returns the Description of the change sets that
+// are created for serviceName
+func changeSetDescription(serviceName string) string {
+	return fmt.Sprintf("Change set for service: %s", serviceName)
+}
+
+// LogChangeSetChanges logs the resource changes defined by a change set,
+// including whether a modified resource will be replaced
+func LogChangeSetChanges(changeSet *cloudformation.DescribeChangeSetOutput,
+	logger *logrus.Logger) {
+	for _, eachChange := range changeSet.Changes {
+		resourceChange := eachChange.ResourceChange
+		if resourceChange == nil {
+			continue
+		}
+		changeFields := logrus.Fields{
+			"Action":       aws.StringValue(resourceChange.Action),
+			"Resource":     aws.StringValue(resourceChange.LogicalResourceId),
+			"ResourceType": aws.StringValue(resourceChange.ResourceType),
+		}
+		if resourceChange.Replacement != nil {
+			changeFields["Replacement"] = aws.StringValue(resourceChange.Replacement)
+		}
+		logger.WithFields(changeFields).Info("ChangeSet resource change")
+	}
+}
+
+// DeleteAbandonedChangeSets deletes the change sets that were created for
+// serviceName by CreateStackChangeSet, but which haven't been executed.
+// Change sets created by other tools are left as is.
+func DeleteAbandonedChangeSets(serviceName string,
+	awsCloudFormation CloudFormationAPI,
+	logger *logrus.Logger) error {
+
+	description := changeSetDescription(serviceName)
+	listChangeSetsInput := &cloudformation.ListChangeSetsInput{
+		StackName: aws.String(serviceName),
+	}
+	for {
+		listChangeSetsOutput, listChangeSetsErr := awsCloudFormation.ListChangeSets(listChangeSetsInput)
+		if nil != listChangeSetsErr {
+			if strings.Contains(listChangeSetsErr.Error(), "does not exist") {
+				return nil
+			}
+			return listChangeSetsErr
+		}
+		for _, eachSummary := range listChangeSetsOutput.Summaries {
+			if aws.StringValue(eachSummary.Description) != description {
+				continue
+			}
+			switch aws.StringValue(eachSummary.ExecutionStatus) {
+			case cloudformation.ExecutionStatusAvailable,
+				cloudformation.ExecutionStatusUnavailable:
+				logger.WithFields(logrus.Fields{
+					"ChangeSetName": aws.StringValue(eachSummary.ChangeSetName),
+				}).Info("Deleting abandoned ChangeSet")
+				_, deleteErr := DeleteChangeSet(serviceName,
+					aws.StringValue(eachSummary.ChangeSetName),
+					awsCloudFormation)
+				if nil != deleteErr {
+					return deleteErr
+				}
+			}
+		}
+		if listChangeSetsOutput.NextToken == nil {
+			return nil
+		}
+		listChangeSetsInput.NextToken = listChangeSetsOutput.NextToken
+	}
+}
+
 // DeleteChangeSet is a utility function that attempts to delete
 // an existing CloudFormation change set, with a bit of retry
 // logic in case of EC
 func DeleteChangeSet(stackName string,
 	changeSetRequestName string,
-	awsCloudFormation *cloudformation.CloudFormation) (*cloudformation.DeleteChangeSetOutput, error) {
+	awsCloudFormation CloudFormationAPI) (*cloudformation.DeleteChangeSetOutput, error) {
 
 	// Delete request...
 	deleteChangeSetInput := cloudformation.DeleteChangeSetInput{
@@ -900,6 +2248,79 @@ func DeleteChangeSet(stackName string,
 	}
 }
 
+// defaultStackPolicyBody is the policy that allows all updates, which is
+// the effective policy of a stack without an explicit stack policy
+const defaultStackPolicyBody = `{"Statement":[{"Effect":"Allow","Action":"Update:*","Principal":"*","Resource":"*"}]}`
+
+// StackPolicy is the optional set of stack policy documents applied during
+// ConvergeStackStateWithContext. See
+// https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/protect-stack-resources.html
+type StackPolicy struct {
+	// Body is the stack policy that protects the stack resources
+	Body string
+	// DuringUpdateBody temporarily overrides the stack policy while the
+	// stack is updated
+	DuringUpdateBody string
+}
+
+// stackTags transforms the tag map into CloudFormation stack Tags, sorted
+// by key
+func stackTags(tags map[string]string) []*cloudformation.Tag {
+	tagKeys := make([]string, 0, len(tags))
+	for eachKey := range tags {
+		tagKeys = append(tagKeys, eachKey)
+	}
+	sort.Strings(tagKeys)
+	awsTags := make([]*cloudformation.Tag, 0, len(tagKeys))
+	for _, eachKey := range tagKeys {
+		awsTags = append(awsTags,
+			&cloudformation.Tag{
+				Key:   aws.String(eachKey),
+				Value: aws.String(tags[eachKey]),
+			})
+	}
+	return awsTags
+}
+
+// newCreateStackInput returns the CreateStack request for a new service
+// stack. An empty onFailure deletes the stack if the create fails. CreateStack
+// doesn't accept both OnFailure and DisableRollback, so onFailure is ignored
+// if disableRollback is true.
+func newCreateStackInput(serviceName string,
+	cfTemplate *gocf.Template,
+	templateURL string,
+	awsTags []*cloudformation.Tag,
+	notificationARNs []string,
+	stackPolicy *StackPolicy,
+	onFailure string,
+	disableRollback bool) *cloudformation.CreateStackInput {
+
+	createStackInput := &cloudformation.CreateStackInput{
+		StackName:        aws.String(serviceName),
+		TemplateURL:      aws.String(templateURL),
+		TimeoutInMinutes: aws.Int64(20),
+		Capabilities:     stackCapabilities(cfTemplate),
+	}
+	if disableRollback {
+		createStackInput.DisableRollback = aws.Bool(true)
+	} else {
+		if onFailure == "" {
+			onFailure = cloudformation.OnFailureDelete
+		}
+		createStackInput.OnFailure = aws.String(onFailure)
+	}
+	if len(awsTags) != 0 {
+		createStackInput.Tags = awsTags
+	}
+	if len(notificationARNs) != 0 {
+		createStackInput.NotificationARNs = aws.StringSlice(notificationARNs)
+	}
+	if stackPolicy != nil && stackPolicy.Body != "" {
+		createStackInput.StackPolicyBody = aws.String(stackPolicy.Body)
+	}
+	return createStackInput
+}
+
 // ConvergeStackState ensures that the serviceName converges to the template
 // state defined by cfTemplate. This function establishes a polling loop to determine
 // when the stack operation has completed.
@@ -912,48 +2333,163 @@ func ConvergeStackState(serviceName string,
 	outputsDividerChar string,
 	dividerWidth int,
 	logger *logrus.Logger) (*cloudformation.Stack, error) {
+	return ConvergeStackStateWithContext(context.Background(),
+		serviceName,
+		cfTemplate,
+		templateURL,
+		tags,
+		nil,
+		nil,
+		nil,
+		"",
+		false,
+		startTime,
+		awsSession,
+		outputsDividerChar,
+		dividerWidth,
+		logger)
+}
+
+// ConvergeStackStateWithContext is the same as ConvergeStackState, but
+// stops waiting for the stack operation to complete when the ctx is canceled
+// or its deadline expires. The optional notificationARNs are the SNS topics
+// that receive the stack events. The optional stackPolicy is applied to the
+// stack. Change sets don't support stack policies, so an update applies the
+// DuringUpdateBody override directly to the stack and then restores the
+// stack policy once the update completes. The optional pollInterval sets
+// the delay between DescribeStacks calls. The onFailure value is the
+// CreateStack OnFailure behavior and defaults to DELETE if empty. If
+// disableRollback is true, a failed create or update isn't rolled back so
+// that the failed resources can be inspected. The stack is left in the
+// CREATE_FAILED or UPDATE_FAILED state and onFailure is ignored.
+func ConvergeStackStateWithContext(ctx context.Context,
+	serviceName string,
+	cfTemplate *gocf.Template,
+	templateURL string,
+	tags map[string]string,
+	notificationARNs []string,
+	stackPolicy *StackPolicy,
+	pollInterval *StackPollInterval,
+	onFailure string,
+	disableRollback bool,
+	startTime time.Time,
+	awsSession *session.Session,
+	outputsDividerChar string,
+	dividerWidth int,
+	logger *logrus.Logger) (*cloudformation.Stack, error) {
+	return ConvergeStackStateWithClient(ctx,
+		serviceName,
+		cfTemplate,
+		templateURL,
+		tags,
+		notificationARNs,
+		stackPolicy,
+		pollInterval,
+		onFailure,
+		disableRollback,
+		startTime,
+		cloudformation.New(awsSession),
+		aws.StringValue(awsSession.Config.Region),
+		outputsDividerChar,
+		dividerWidth,
+		logger)
+}
+
+// ConvergeStackStateWithClient is the same as ConvergeStackStateWithContext,
+// but makes the CloudFormation requests with the awsCloudFormation client.
+// The region is used to log the console URL of a failed stack.
+func ConvergeStackStateWithClient(ctx context.Context,
+	serviceName string,
+	cfTemplate *gocf.Template,
+	templateURL string,
+	tags map[string]string,
+	notificationARNs []string,
+	stackPolicy *StackPolicy,
+	pollInterval *StackPollInterval,
+	onFailure string,
+	disableRollback bool,
+	startTime time.Time,
+	awsCloudFormation CloudFormationAPI,
+	region string,
+	outputsDividerChar string,
+	dividerWidth int,
+	logger *logrus.Logger) (*cloudformation.Stack, error) {
 
-	awsCloudFormation := cloudformation.New(awsSession)
 	// Update the tags
-	awsTags := make([]*cloudformation.Tag, 0)
-	if nil != tags {
-		for eachKey, eachValue := range tags {
-			awsTags = append(awsTags,
-				&cloudformation.Tag{
-					Key:   aws.String(eachKey),
-					Value: aws.String(eachValue),
-				})
-		}
-	}
-	exists, existsErr := StackExists(serviceName, awsSession, logger)
+	awsTags := stackTags(tags)
+	exists, existsErr := stackExists(serviceName, awsCloudFormation, logger)
 	if nil != existsErr {
 		return nil, existsErr
 	}
+	setStackPolicy := func(policyBody string, description string) error {
+		logger.WithFields(logrus.Fields{
+			"StackName": serviceName,
+		}).Info(description)
+		_, setPolicyErr := awsCloudFormation.SetStackPolicyWithContext(ctx,
+			&cloudformation.SetStackPolicyInput{
+				StackName:       aws.String(serviceName),
+				StackPolicyBody: aws.String(policyBody),
+			})
+		return errors.Wrapf(setPolicyErr, "Failed to set stack policy")
+	}
+
 	stackID := ""
+	restorePolicyBody := ""
 	if exists {
+		if stackPolicy != nil && stackPolicy.DuringUpdateBody != "" {
+			restorePolicyBody = stackPolicy.Body
+			if restorePolicyBody == "" {
+				getPolicyOutput, getPolicyErr := awsCloudFormation.GetStackPolicyWithContext(ctx,
+					&cloudformation.GetStackPolicyInput{
+						StackName: aws.String(serviceName),
+					})
+				if nil != getPolicyErr {
+					return nil, errors.Wrapf(getPolicyErr, "Failed to get stack policy")
+				}
+				restorePolicyBody = aws.StringValue(getPolicyOutput.StackPolicyBody)
+			}
+			if restorePolicyBody == "" {
+				restorePolicyBody = defaultStackPolicyBody
+			}
+			overrideErr := setStackPolicy(stackPolicy.DuringUpdateBody,
+				"Applying stack policy override for update")
+			if nil != overrideErr {
+				return nil, overrideErr
+			}
+		} else if stackPolicy != nil && stackPolicy.Body != "" {
+			restorePolicyBody = stackPolicy.Body
+		}
 		updateErr := updateStackViaChangeSet(serviceName,
 			cfTemplate,
 			templateURL,
 			awsTags,
+			notificationARNs,
+			disableRollback,
 			awsCloudFormation,
 			logger)
 
 		if nil != updateErr {
+			if restorePolicyBody != "" {
+				restoreErr := setStackPolicy(restorePolicyBody, "Restoring stack policy")
+				if nil != restoreErr {
+					logger.WithFields(logrus.Fields{
+						"Error": restoreErr,
+					}).Warn("Failed to restore stack policy")
+				}
+			}
 			return nil, updateErr
 		}
 		stackID = serviceName
 	} else {
 		// Create stack
-		createStackInput := &cloudformation.CreateStackInput{
-			StackName:        aws.String(serviceName),
-			TemplateURL:      aws.String(templateURL),
-			TimeoutInMinutes: aws.Int64(20),
-			OnFailure:        aws.String(cloudformation.OnFailureDelete),
-			Capabilities:     stackCapabilities(cfTemplate),
-		}
-		if len(awsTags) != 0 {
-			createStackInput.Tags = awsTags
-		}
+		createStackInput := newCreateStackInput(serviceName,
+			cfTemplate,
+			templateURL,
+			awsTags,
+			notificationARNs,
+			stackPolicy,
+			onFailure,
+			disableRollback)
 		createStackResponse, createStackResponseErr := awsCloudFormation.CreateStack(createStackInput)
 		if nil != createStackResponseErr {
 			return nil, createStackResponseErr
@@ -966,18 +2502,31 @@ func ConvergeStackState(serviceName string,
 	}
 	// Wait for the operation to succeed
 	pollingMessage := "Waiting for CloudFormation operation to complete"
-	convergeResult, convergeErr := WaitForStackOperationComplete(stackID,
+	convergeResult, convergeErr := WaitForStackOperationCompleteWithContext(ctx,
+		stackID,
 		pollingMessage,
+		pollInterval,
 		awsCloudFormation,
 		logger)
+	if restorePolicyBody != "" {
+		restoreErr := setStackPolicy(restorePolicyBody, "Applying stack policy")
+		if nil != restoreErr {
+			if nil == convergeErr {
+				return nil, restoreErr
+			}
+			logger.WithFields(logrus.Fields{
+				"Error": restoreErr,
+			}).Warn("Failed to restore stack policy")
+		}
+	}
 	if nil != convergeErr {
 		return nil, convergeErr
 	}
 	// Get the events and assemble them into either errors to output
 	// or summary information
 	resourceMetrics := make(map[string]*resourceProvisionMetrics)
-	errorMessages := []string{}
-	events, err := StackEvents(stackID, startTime, awsSession)
+	failedEvents := []*cloudformation.StackEvent{}
+	events, err := stackEvents(stackID, startTime, awsCloudFormation)
 	if nil != err {
 		return nil, fmt.Errorf("Failed to retrieve stack events: %s", err.Error())
 	}
@@ -987,11 +2536,7 @@ func ConvergeStackState(serviceName string,
 		case cloudformation.ResourceStatusCreateFailed,
 			cloudformation.ResourceStatusDeleteFailed,
 			cloudformation.ResourceStatusUpdateFailed:
-			errMsg := fmt.Sprintf("\tError ensuring %s (%s): %s",
-				aws.StringValue(eachEvent.ResourceType),
-				aws.StringValue(eachEvent.LogicalResourceId),
-				aws.StringValue(eachEvent.ResourceStatusReason))
-			errorMessages = append(errorMessages, errMsg)
+			failedEvents = append(failedEvents, eachEvent)
 		case cloudformation.ResourceStatusCreateInProgress,
 			cloudformation.ResourceStatusUpdateInProgress:
 			existingMetric, existingMetricExists := resourceMetrics[*eachEvent.LogicalResourceId]
@@ -1018,11 +2563,43 @@ func ConvergeStackState(serviceName string,
 
 	// If it didn't work, then output some failure information
 	if !convergeResult.operationSuccessful {
-		logger.Error("Stack provisioning error")
-		for _, eachError := range errorMessages {
-			logger.Error(eachError)
+		stackStatus := aws.StringValue(convergeResult.stackInfo.StackStatus)
+		logger.WithFields(logrus.Fields{
+			"StackStatus": stackStatus,
+		}).Error("Stack provisioning error")
+		rootCauses, cascade := classifyStackFailureEvents(failedEvents)
+		for _, eachEvent := range rootCauses {
+			logger.Error("\tRoot cause: " + stackFailureMessage(eachEvent))
+		}
+		for _, eachEvent := range cascade {
+			logger.Error("\tCascading failure: " + stackFailureMessage(eachEvent))
+		}
+		// Point to the failed stack if it wasn't deleted
+		if stackStatus != cloudformation.StackStatusDeleteComplete {
+			logger.WithFields(logrus.Fields{
+				"URL": stackConsoleURL(region,
+					aws.StringValue(convergeResult.stackInfo.StackId)),
+			}).Error("Inspect the failed stack in the CloudFormation console")
+		}
+		if disableRollback &&
+			(stackStatus == cloudformation.StackStatusCreateFailed ||
+				stackStatus == cloudformation.StackStatusUpdateFailed) {
+			logger.WithFields(logrus.Fields{
+				"StackStatus": stackStatus,
+			}).Warn("Rollback is disabled and the failed resources were retained. Roll back or delete the stack before the next update")
+		}
+		if len(rootCauses) == 0 {
+			return nil, fmt.Errorf("Failed to provision: %s (%s)", serviceName, stackStatus)
+		}
+		// The root causes are sorted, so the first one is the earliest
+		return nil, &StackFailureError{
+			StackName:            serviceName,
+			StackStatus:          stackStatus,
+			ResourceType:         aws.StringValue(rootCauses[0].ResourceType),
+			LogicalResourceID:    aws.StringValue(rootCauses[0].LogicalResourceId),
+			ResourceStatusReason: aws.StringValue(rootCauses[0].ResourceStatusReason),
+			AdditionalFailures:   len(failedEvents) - 1,
 		}
-		return nil, fmt.Errorf("Failed to provision: %s", serviceName)
 	}
 
 	// Rip through the events so that we can output exactly how long it took to
@@ -1,9 +1,20 @@
 package cloudformation
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 var conversionParams = map[string]interface{}{
@@ -143,3 +154,746 @@ func TestExpand(t *testing.T) {
 		}
 	}
 }
+
+func TestDiffTemplateResources(t *testing.T) {
+	deployed, deployedErr := templateResources([]byte(`{
+		"Resources": {
+			"Unchanged": {"Type": "AWS::SNS::Topic"},
+			"Changed": {"Type": "AWS::SQS::Queue", "Properties": {"DelaySeconds": 1}},
+			"Deleted": {"Type": "AWS::SNS::Topic"}
+		}
+	}`))
+	if deployedErr != nil {
+		t.Fatal(deployedErr)
+	}
+	proposed, proposedErr := templateResources([]byte(`{
+		"Resources": {
+			"Unchanged": {"Type": "AWS::SNS::Topic"},
+			"Changed": {"Type": "AWS::SQS::Queue", "Properties": {"DelaySeconds": 2}},
+			"Created": {"Type": "AWS::SNS::Topic"}
+		}
+	}`))
+	if proposedErr != nil {
+		t.Fatal(proposedErr)
+	}
+	diff := diffTemplateResources(deployed, proposed)
+	if diff.Empty() {
+		t.Fatalf("Expected non-empty diff")
+	}
+	if len(diff.Added) != 1 || diff.Added[0] != "Created" {
+		t.Errorf("Unexpected Added resources: %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "Deleted" {
+		t.Errorf("Unexpected Removed resources: %v", diff.Removed)
+	}
+	if len(diff.Modified) != 1 || diff.Modified[0] != "Changed" {
+		t.Errorf("Unexpected Modified resources: %v", diff.Modified)
+	}
+	if !diffTemplateResources(deployed, deployed).Empty() {
+		t.Errorf("Expected empty diff for identical templates")
+	}
+}
+
+func TestCanonicalTemplateJSON(t *testing.T) {
+	first, firstErr := CanonicalTemplateJSON([]byte(`{
+		"Resources": {"B": {"Type": "AWS::SNS::Topic"}, "A": {"Type": "AWS::SQS::Queue"}},
+		"AWSTemplateFormatVersion": "2010-09-09"
+	}`))
+	if firstErr != nil {
+		t.Fatal(firstErr)
+	}
+	second, secondErr := CanonicalTemplateJSON([]byte(`{"AWSTemplateFormatVersion":"2010-09-09",` +
+		`"Resources":{"A":{"Type":"AWS::SQS::Queue"},"B":{"Type":"AWS::SNS::Topic"}}}`))
+	if secondErr != nil {
+		t.Fatal(secondErr)
+	}
+	if string(first) != string(second) {
+		t.Fatalf("Canonical templates differ:\n%s\n%s", string(first), string(second))
+	}
+}
+
+func TestTemplateResourceClusters(t *testing.T) {
+	clusters, clustersErr := TemplateResourceClusters([]byte(`{
+		"Resources": {
+			"Function": {"Type": "AWS::Lambda::Function", "Properties": {"Role": {"Fn::GetAtt": ["Role", "Arn"]}}},
+			"Role": {"Type": "AWS::IAM::Role"},
+			"Permission": {"Type": "AWS::Lambda::Permission", "Properties": {"FunctionName": {"Ref": "Function"}}},
+			"Topic": {"Type": "AWS::SNS::Topic"},
+			"Policy": {"Type": "AWS::SNS::TopicPolicy", "Properties": {"PolicyDocument": {"Fn::Sub": "${Topic.TopicName}"}}},
+			"Queue": {"Type": "AWS::SQS::Queue", "DependsOn": ["AWS::NoValue"]}
+		}
+	}`))
+	if clustersErr != nil {
+		t.Fatal(clustersErr)
+	}
+	if len(clusters) != 3 {
+		t.Fatalf("Expected 3 clusters, got %d", len(clusters))
+	}
+	expected := []string{"Function,Permission,Role", "Policy,Topic", "Queue"}
+	for eachIndex, eachCluster := range clusters {
+		if strings.Join(eachCluster.Resources, ",") != expected[eachIndex] {
+			t.Errorf("Unexpected cluster %d: %v", eachIndex, eachCluster.Resources)
+		}
+	}
+}
+
+func TestClassifyStackFailureEvents(t *testing.T) {
+	now := time.Now()
+	failedEvent := func(logicalID string, reason string, offset time.Duration) *cloudformation.StackEvent {
+		return &cloudformation.StackEvent{
+			LogicalResourceId:    aws.String(logicalID),
+			ResourceStatusReason: aws.String(reason),
+			Timestamp:            aws.Time(now.Add(offset)),
+		}
+	}
+	// Events are reported in reverse chronological order
+	rootCauses, cascade := classifyStackFailureEvents([]*cloudformation.StackEvent{
+		failedEvent("Stack", "The following resource(s) failed to create: [Role, Queue]. ", 3*time.Second),
+		failedEvent("Queue", "Resource creation cancelled", 2*time.Second),
+		failedEvent("Role", "Policy document is malformed", 1*time.Second),
+		failedEvent("Bucket", "Bucket already exists", 0),
+	})
+	if len(rootCauses) != 2 ||
+		aws.StringValue(rootCauses[0].LogicalResourceId) != "Bucket" ||
+		aws.StringValue(rootCauses[1].LogicalResourceId) != "Role" {
+		t.Fatalf("Unexpected root causes: %v", rootCauses)
+	}
+	if len(cascade) != 2 {
+		t.Fatalf("Unexpected cascading failures: %v", cascade)
+	}
+	rootCauses, cascade = classifyStackFailureEvents([]*cloudformation.StackEvent{
+		failedEvent("Queue", "Resource creation cancelled", 0),
+	})
+	if len(rootCauses) != 1 || len(cascade) != 0 {
+		t.Fatalf("Expected cascading failure to be reported as root cause")
+	}
+}
+
+func TestStackPollIntervalDelay(t *testing.T) {
+	pollInterval := &StackPollInterval{
+		Interval: 2 * time.Second,
+		Jitter:   time.Second,
+	}
+	for i := 0; i < 100; i++ {
+		delay := pollInterval.delay()
+		if delay < pollInterval.Interval || delay >= pollInterval.Interval+pollInterval.Jitter {
+			t.Fatalf("Unexpected poll delay: %s", delay)
+		}
+	}
+	var defaultInterval *StackPollInterval
+	delay := defaultInterval.delay()
+	if delay < 11*time.Second || delay > 23*time.Second {
+		t.Fatalf("Unexpected default poll delay: %s", delay)
+	}
+}
+
+func TestStackCapabilities(t *testing.T) {
+	template := gocf.NewTemplate()
+	template.AddResource("Profile", &gocf.IAMInstanceProfile{})
+	capabilities := aws.StringValueSlice(stackCapabilities(template))
+	if strings.Join(capabilities, ",") != "CAPABILITY_IAM" {
+		t.Fatalf("Unexpected capabilities for unnamed resources: %v", capabilities)
+	}
+	// A custom path doesn't name the role
+	template.AddResource("PathRole", &gocf.IAMRole{
+		Path: gocf.String("/service-roles/"),
+	})
+	capabilities = aws.StringValueSlice(stackCapabilities(template))
+	if strings.Join(capabilities, ",") != "CAPABILITY_IAM" {
+		t.Fatalf("Unexpected capabilities for unnamed resources with a path: %v", capabilities)
+	}
+	template.AddResource("Role", &gocf.IAMRole{
+		Path:     gocf.String("/service-roles/"),
+		RoleName: gocf.String("MyRole"),
+	})
+	capabilities = aws.StringValueSlice(stackCapabilities(template))
+	if strings.Join(capabilities, ",") != "CAPABILITY_IAM,CAPABILITY_NAMED_IAM" {
+		t.Fatalf("Unexpected capabilities for named resources: %v", capabilities)
+	}
+}
+
+func TestCreateStackInput(t *testing.T) {
+	createStackInput := newCreateStackInput("MyService",
+		gocf.NewTemplate(),
+		"https://example.s3.amazonaws.com/MyService/template.json",
+		stackTags(map[string]string{
+			"Team":        "Platform",
+			"Environment": "prod",
+		}),
+		[]string{"arn:aws:sns:us-west-2:123456789012:StackEvents"},
+		nil,
+		"",
+		false)
+	tags := []string{}
+	for _, eachTag := range createStackInput.Tags {
+		tags = append(tags, aws.StringValue(eachTag.Key)+"="+aws.StringValue(eachTag.Value))
+	}
+	if strings.Join(tags, ",") != "Environment=prod,Team=Platform" {
+		t.Fatalf("Unexpected CreateStackInput tags: %v", tags)
+	}
+	notificationARNs := aws.StringValueSlice(createStackInput.NotificationARNs)
+	if strings.Join(notificationARNs, ",") != "arn:aws:sns:us-west-2:123456789012:StackEvents" {
+		t.Fatalf("Unexpected CreateStackInput NotificationARNs: %v", notificationARNs)
+	}
+	if aws.StringValue(createStackInput.OnFailure) != cloudformation.OnFailureDelete {
+		t.Fatalf("Unexpected default OnFailure: %s", aws.StringValue(createStackInput.OnFailure))
+	}
+	createStackInput = newCreateStackInput("MyService",
+		gocf.NewTemplate(),
+		"https://example.s3.amazonaws.com/MyService/template.json",
+		nil,
+		nil,
+		nil,
+		cloudformation.OnFailureDelete,
+		true)
+	if !aws.BoolValue(createStackInput.DisableRollback) || createStackInput.OnFailure != nil {
+		t.Fatalf("Unexpected DisableRollback CreateStackInput: %v", createStackInput)
+	}
+}
+
+func TestCapabilityDifferences(t *testing.T) {
+	iam := aws.String(cloudformation.CapabilityCapabilityIam)
+	namedIAM := aws.String(cloudformation.CapabilityCapabilityNamedIam)
+	autoExpand := aws.String(cloudformation.CapabilityCapabilityAutoExpand)
+
+	missing, extra := capabilityDifferences([]*string{iam}, []*string{iam, namedIAM})
+	if len(missing) != 0 || len(extra) != 0 {
+		t.Errorf("Unexpected differences for NAMED_IAM superset. Missing: %v, Extra: %v", missing, extra)
+	}
+	missing, extra = capabilityDifferences([]*string{namedIAM, autoExpand}, []*string{iam})
+	if strings.Join(missing, ",") != "CAPABILITY_NAMED_IAM,CAPABILITY_AUTO_EXPAND" || len(extra) != 0 {
+		t.Errorf("Unexpected differences for undeclared capabilities. Missing: %v, Extra: %v", missing, extra)
+	}
+	missing, extra = capabilityDifferences(nil, []*string{iam})
+	if len(missing) != 0 || strings.Join(extra, ",") != "CAPABILITY_IAM" {
+		t.Errorf("Unexpected differences for extra capabilities. Missing: %v, Extra: %v", missing, extra)
+	}
+}
+
+func TestTemplateYAML(t *testing.T) {
+	templateJSON := `{
+		"AWSTemplateFormatVersion": "2010-09-09",
+		"Resources": {
+			"Function": {
+				"Type": "AWS::Lambda::Function",
+				"Properties": {
+					"Role": {"Fn::GetAtt": ["Role", "Arn"]},
+					"MemorySize": 128,
+					"Environment": {
+						"Variables": {
+							"BUCKET": {"Ref": "Bucket"},
+							"URL": {"Fn::Sub": "https://${AWS::Region}.example.com"},
+							"ENABLED": "true",
+							"ENCODED": {"Fn::Base64": {"Ref": "Bucket"}}
+						}
+					},
+					"Layers": [{"Fn::Join": [":", ["arn", {"Ref": "AWS::Partition"}]]}]
+				}
+			}
+		}
+	}`
+	expected := `AWSTemplateFormatVersion: "2010-09-09"
+Resources:
+  Function:
+    Type: AWS::Lambda::Function
+    Properties:
+      Role: !GetAtt Role.Arn
+      MemorySize: 128
+      Environment:
+        Variables:
+          BUCKET: !Ref Bucket
+          URL: !Sub "https://${AWS::Region}.example.com"
+          ENABLED: "true"
+          ENCODED:
+            Fn::Base64: !Ref Bucket
+      Layers:
+        - !Join
+          - ":"
+          - - arn
+            - !Ref AWS::Partition
+`
+	templateYAML, templateYAMLErr := TemplateYAML([]byte(templateJSON))
+	if templateYAMLErr != nil {
+		t.Fatal(templateYAMLErr)
+	}
+	if string(templateYAML) != expected {
+		t.Fatalf("Unexpected template YAML. Expected:\n%s\nActual:\n%s", expected, templateYAML)
+	}
+	if _, invalidErr := TemplateYAML([]byte(`{"Resources":`)); invalidErr == nil {
+		t.Fatal("Failed to reject invalid template JSON")
+	}
+}
+
+// fakeCloudFormationAPI creates a new stack that reports the stackStatus
+type fakeCloudFormationAPI struct {
+	CloudFormationAPI
+	stackStatus string
+	stackEvents []*cloudformation.StackEvent
+}
+
+func (fake *fakeCloudFormationAPI) DescribeStacks(input *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	return nil, errors.Errorf("Stack with id %s does not exist", aws.StringValue(input.StackName))
+}
+
+func (fake *fakeCloudFormationAPI) CreateStack(input *cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error) {
+	return &cloudformation.CreateStackOutput{
+		StackId: aws.String("arn:aws:cloudformation:us-west-2:123412341234:stack/MyStack/1"),
+	}, nil
+}
+
+func (fake *fakeCloudFormationAPI) DescribeStacksWithContext(ctx aws.Context,
+	input *cloudformation.DescribeStacksInput,
+	options ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+	return &cloudformation.DescribeStacksOutput{
+		Stacks: []*cloudformation.Stack{
+			{
+				StackId:      input.StackName,
+				StackName:    aws.String("MyStack"),
+				StackStatus:  aws.String(fake.stackStatus),
+				CreationTime: aws.Time(time.Now()),
+			},
+		},
+	}, nil
+}
+
+func (fake *fakeCloudFormationAPI) DescribeStackEventsWithContext(ctx aws.Context,
+	input *cloudformation.DescribeStackEventsInput,
+	options ...request.Option) (*cloudformation.DescribeStackEventsOutput, error) {
+	return &cloudformation.DescribeStackEventsOutput{}, nil
+}
+
+func (fake *fakeCloudFormationAPI) DescribeStackEvents(input *cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error) {
+	return &cloudformation.DescribeStackEventsOutput{
+		StackEvents: fake.stackEvents,
+	}, nil
+}
+
+func TestConvergeStackStateWithClient(t *testing.T) {
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+	logger.Level = logrus.FatalLevel
+
+	testCases := []struct {
+		stackStatus string
+		expectError bool
+	}{
+		{cloudformation.StackStatusCreateComplete, false},
+		{cloudformation.StackStatusUpdateComplete, false},
+		{cloudformation.StackStatusDeleteComplete, true},
+		{cloudformation.StackStatusCreateFailed, true},
+		{cloudformation.StackStatusDeleteFailed, true},
+		{cloudformation.StackStatusRollbackFailed, true},
+		{cloudformation.StackStatusRollbackComplete, true},
+		{cloudformation.StackStatusUpdateRollbackComplete, true},
+		{cloudformation.StackStatusUpdateRollbackFailed, true},
+		{cloudformation.StackStatusUpdateFailed, true},
+	}
+	for _, eachTestCase := range testCases {
+		stack, convergeErr := ConvergeStackStateWithClient(context.Background(),
+			"MyStack",
+			gocf.NewTemplate(),
+			"https://bucket.s3.amazonaws.com/MyStack-cftemplate.json",
+			nil,
+			nil,
+			nil,
+			&StackPollInterval{Interval: time.Millisecond},
+			"",
+			false,
+			time.Now(),
+			&fakeCloudFormationAPI{stackStatus: eachTestCase.stackStatus},
+			"us-west-2",
+			"-",
+			80,
+			logger)
+		if eachTestCase.expectError {
+			if convergeErr == nil {
+				t.Errorf("Failed to report error for status: %s", eachTestCase.stackStatus)
+			}
+			continue
+		}
+		if convergeErr != nil {
+			t.Errorf("Unexpected error for status %s: %s", eachTestCase.stackStatus, convergeErr)
+		} else if aws.StringValue(stack.StackStatus) != eachTestCase.stackStatus {
+			t.Errorf("Unexpected stack status: %s", aws.StringValue(stack.StackStatus))
+		}
+	}
+}
+
+func TestConvergeStackStateRootCause(t *testing.T) {
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+	logger.Level = logrus.FatalLevel
+
+	startTime := time.Now()
+	failedEvent := func(resourceType string,
+		logicalID string,
+		reason string,
+		offset time.Duration) *cloudformation.StackEvent {
+		return &cloudformation.StackEvent{
+			ResourceType:         aws.String(resourceType),
+			LogicalResourceId:    aws.String(logicalID),
+			ResourceStatus:       aws.String(cloudformation.ResourceStatusCreateFailed),
+			ResourceStatusReason: aws.String(reason),
+			Timestamp:            aws.Time(startTime.Add(offset)),
+		}
+	}
+	_, convergeErr := ConvergeStackStateWithClient(context.Background(),
+		"MyStack",
+		gocf.NewTemplate(),
+		"https://bucket.s3.amazonaws.com/MyStack-cftemplate.json",
+		nil,
+		nil,
+		nil,
+		&StackPollInterval{Interval: time.Millisecond},
+		"",
+		false,
+		startTime,
+		&fakeCloudFormationAPI{
+			stackStatus: cloudformation.StackStatusRollbackComplete,
+			stackEvents: []*cloudformation.StackEvent{
+				failedEvent("AWS::SQS::Queue", "Queue", "Resource creation cancelled", 3*time.Second),
+				failedEvent("AWS::Lambda::Function", "Function", "Role is invalid", 2*time.Second),
+				failedEvent("AWS::IAM::Role", "Role", "Policy document is malformed", time.Second),
+			},
+		},
+		"us-west-2",
+		"-",
+		80,
+		logger)
+	stackErr, ok := convergeErr.(*StackFailureError)
+	if !ok {
+		t.Fatalf("Unexpected error: %#v", convergeErr)
+	}
+	if stackErr.ResourceType != "AWS::IAM::Role" ||
+		stackErr.LogicalResourceID != "Role" ||
+		stackErr.ResourceStatusReason != "Policy document is malformed" ||
+		stackErr.AdditionalFailures != 2 {
+		t.Errorf("Unexpected root cause: %#v", stackErr)
+	}
+	if !strings.Contains(stackErr.Error(), "Policy document is malformed") {
+		t.Errorf("Failed to include root cause reason: %s", stackErr.Error())
+	}
+}
+
+func TestWithStackProgress(t *testing.T) {
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+	logger.Level = logrus.FatalLevel
+
+	if WithStackProgress(context.Background(), nil) != context.Background() {
+		t.Errorf("Failed to ignore nil StackProgressFunc")
+	}
+	var polledStatuses []string
+	progressContext := WithStackProgress(context.Background(),
+		func(stack *cloudformation.Stack) {
+			polledStatuses = append(polledStatuses, aws.StringValue(stack.StackStatus))
+		})
+	_, waitErr := WaitForStackOperationCompleteWithContext(progressContext,
+		"MyStack",
+		"Waiting for stack",
+		&StackPollInterval{Interval: time.Millisecond},
+		&fakeCloudFormationAPI{stackStatus: cloudformation.StackStatusUpdateComplete},
+		logger)
+	if waitErr != nil {
+		t.Fatalf("Unexpected error: %s", waitErr)
+	}
+	if len(polledStatuses) != 1 ||
+		polledStatuses[0] != cloudformation.StackStatusUpdateComplete {
+		t.Errorf("Unexpected polled statuses: %v", polledStatuses)
+	}
+}
+
+// fakeExportsAPI reports a stack with the exported outputs, and the
+// stacks that import each export
+type fakeExportsAPI struct {
+	CloudFormationAPI
+	outputs         []*cloudformation.Output
+	importers       map[string][]string
+	importsRequests []string
+}
+
+func (fake *fakeExportsAPI) DescribeStacks(input *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	return &cloudformation.DescribeStacksOutput{
+		Stacks: []*cloudformation.Stack{
+			{
+				StackName: input.StackName,
+				Outputs:   fake.outputs,
+			},
+		},
+	}, nil
+}
+
+func (fake *fakeExportsAPI) ListImports(input *cloudformation.ListImportsInput) (*cloudformation.ListImportsOutput, error) {
+	exportName := aws.StringValue(input.ExportName)
+	fake.importsRequests = append(fake.importsRequests, exportName)
+	importers := fake.importers[exportName]
+	if len(importers) == 0 {
+		return nil, errors.Errorf("ValidationError: Export '%s' is not imported by any stack.", exportName)
+	}
+	return &cloudformation.ListImportsOutput{
+		Imports: aws.StringSlice(importers),
+	}, nil
+}
+
+func TestValidateStackExports(t *testing.T) {
+	exportedOutput := func(outputKey string) *cloudformation.Output {
+		return &cloudformation.Output{
+			OutputKey:   aws.String(outputKey),
+			OutputValue: aws.String("value"),
+			ExportName:  aws.String("MyStack-" + outputKey),
+		}
+	}
+	fakeCF := &fakeExportsAPI{
+		outputs: []*cloudformation.Output{
+			exportedOutput("RemovedImported"),
+			exportedOutput("RemovedUnused"),
+			exportedOutput("Kept"),
+			{
+				OutputKey:   aws.String("NotExported"),
+				OutputValue: aws.String("value"),
+			},
+		},
+		importers: map[string][]string{
+			"MyStack-RemovedImported": {"ConsumerStack"},
+			"MyStack-Kept":            {"ConsumerStack"},
+		},
+	}
+	template := gocf.NewTemplate()
+	template.Outputs = map[string]*gocf.Output{
+		"Kept": {
+			Value: gocf.String("value"),
+			Export: &gocf.OutputExport{
+				Name: gocf.String("MyStack-Kept"),
+			},
+		},
+	}
+	logger := logrus.New()
+	validateErr := ValidateStackExports("MyStack", template, fakeCF, logger)
+	if validateErr == nil ||
+		!strings.Contains(validateErr.Error(), "MyStack-RemovedImported (Output: RemovedImported) is imported by: ConsumerStack") {
+		t.Fatalf("Failed to reject removal of imported export: %v", validateErr)
+	}
+	if strings.Contains(validateErr.Error(), "MyStack-RemovedUnused") ||
+		strings.Contains(validateErr.Error(), "MyStack-Kept") {
+		t.Errorf("Unexpected export in error: %s", validateErr)
+	}
+	// Unchanged exports don't need to be checked
+	expectedRequests := "MyStack-RemovedImported,MyStack-RemovedUnused"
+	if strings.Join(fakeCF.importsRequests, ",") != expectedRequests {
+		t.Errorf("Unexpected ListImports requests. Expected: %s, Actual: %v",
+			expectedRequests,
+			fakeCF.importsRequests)
+	}
+
+	// Removing only unused exports is allowed
+	delete(fakeCF.importers, "MyStack-RemovedImported")
+	validateErr = ValidateStackExports("MyStack", template, fakeCF, logger)
+	if validateErr != nil {
+		t.Errorf("Failed to allow removal of unused exports: %s", validateErr)
+	}
+}
+
+// fakeNestedStacksAPI reports the outputs and nested stack resources
+// of each stack, keyed by stack name
+type fakeNestedStacksAPI struct {
+	CloudFormationAPI
+	outputs      map[string]map[string]string
+	nestedStacks map[string]map[string]string
+	mutex        sync.Mutex
+	described    []string
+}
+
+func (fake *fakeNestedStacksAPI) DescribeStacks(input *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	stackName := aws.StringValue(input.StackName)
+	fake.mutex.Lock()
+	fake.described = append(fake.described, stackName)
+	fake.mutex.Unlock()
+
+	stackOutputs, exists := fake.outputs[stackName]
+	if !exists {
+		return nil, errors.Errorf("Stack with id %s does not exist", stackName)
+	}
+	stack := &cloudformation.Stack{
+		StackName: input.StackName,
+	}
+	for eachKey, eachValue := range stackOutputs {
+		stack.Outputs = append(stack.Outputs, &cloudformation.Output{
+			OutputKey:   aws.String(eachKey),
+			OutputValue: aws.String(eachValue),
+		})
+	}
+	return &cloudformation.DescribeStacksOutput{
+		Stacks: []*cloudformation.Stack{stack},
+	}, nil
+}
+
+func (fake *fakeNestedStacksAPI) ListStackResourcesPages(input *cloudformation.ListStackResourcesInput,
+	fn func(*cloudformation.ListStackResourcesOutput, bool) bool) error {
+	page := &cloudformation.ListStackResourcesOutput{
+		StackResourceSummaries: []*cloudformation.StackResourceSummary{
+			{
+				LogicalResourceId:  aws.String("Bucket"),
+				PhysicalResourceId: aws.String("my-bucket"),
+				ResourceType:       aws.String("AWS::S3::Bucket"),
+			},
+		},
+	}
+	for eachLogicalName, eachStackID := range fake.nestedStacks[aws.StringValue(input.StackName)] {
+		page.StackResourceSummaries = append(page.StackResourceSummaries,
+			&cloudformation.StackResourceSummary{
+				LogicalResourceId:  aws.String(eachLogicalName),
+				PhysicalResourceId: aws.String(eachStackID),
+				ResourceType:       aws.String("AWS::CloudFormation::Stack"),
+			})
+	}
+	fn(page, true)
+	return nil
+}
+
+func TestNestedStackOutputs(t *testing.T) {
+	fakeCF := &fakeNestedStacksAPI{
+		outputs: map[string]map[string]string{
+			"MyStack":    {"RootURL": "https://example.com"},
+			"ChildStack": {"ChildARN": "arn:child"},
+			"LeafStack":  {"LeafARN": "arn:leaf"},
+			"OtherStack": {"OtherARN": "arn:other"},
+		},
+		nestedStacks: map[string]map[string]string{
+			"MyStack":    {"Child": "ChildStack", "Other": "OtherStack"},
+			"ChildStack": {"Leaf": "LeafStack"},
+		},
+	}
+	logger := logrus.New()
+	outputs, outputsErr := nestedStackOutputs("MyStack", "", fakeCF, logger)
+	if outputsErr != nil {
+		t.Fatalf("Failed to fetch nested stack outputs: %s", outputsErr)
+	}
+	expectedOutputs := map[string]string{
+		"RootURL":            "https://example.com",
+		"Child.ChildARN":     "arn:child",
+		"Child.Leaf.LeafARN": "arn:leaf",
+		"Other.OtherARN":     "arn:other",
+	}
+	if len(outputs) != len(expectedOutputs) {
+		t.Fatalf("Unexpected outputs. Expected: %v, Actual: %v", expectedOutputs, outputs)
+	}
+	for eachKey, eachValue := range expectedOutputs {
+		if outputs[eachKey] != eachValue {
+			t.Errorf("Unexpected value for output %s. Expected: %s, Actual: %s",
+				eachKey,
+				eachValue,
+				outputs[eachKey])
+		}
+	}
+	if len(fakeCF.described) != 4 {
+		t.Errorf("Unexpected DescribeStacks requests: %v", fakeCF.described)
+	}
+
+	// A failed nested stack fetch fails the whole request
+	delete(fakeCF.outputs, "LeafStack")
+	_, outputsErr = nestedStackOutputs("MyStack", "", fakeCF, logger)
+	if outputsErr == nil || !strings.Contains(outputsErr.Error(), "LeafStack") {
+		t.Errorf("Failed to report nested stack fetch error: %v", outputsErr)
+	}
+}
+
+// fakeChangeSetsAPI pages through the change set summaries and records
+// the deleted change set names
+type fakeChangeSetsAPI struct {
+	CloudFormationAPI
+	pages             [][]*cloudformation.ChangeSetSummary
+	deletedChangeSets []string
+}
+
+func (fake *fakeChangeSetsAPI) ListChangeSets(input *cloudformation.ListChangeSetsInput) (*cloudformation.ListChangeSetsOutput, error) {
+	pageIndex := 0
+	if input.NextToken != nil {
+		pageIndex = len(aws.StringValue(input.NextToken))
+	}
+	output := &cloudformation.ListChangeSetsOutput{
+		Summaries: fake.pages[pageIndex],
+	}
+	if pageIndex+1 < len(fake.pages) {
+		output.NextToken = aws.String(strings.Repeat("n", pageIndex+1))
+	}
+	return output, nil
+}
+
+func (fake *fakeChangeSetsAPI) DeleteChangeSet(input *cloudformation.DeleteChangeSetInput) (*cloudformation.DeleteChangeSetOutput, error) {
+	fake.deletedChangeSets = append(fake.deletedChangeSets, aws.StringValue(input.ChangeSetName))
+	return &cloudformation.DeleteChangeSetOutput{}, nil
+}
+
+func TestDeleteAbandonedChangeSets(t *testing.T) {
+	changeSetSummary := func(name string, description string, status string) *cloudformation.ChangeSetSummary {
+		return &cloudformation.ChangeSetSummary{
+			ChangeSetName:   aws.String(name),
+			Description:     aws.String(description),
+			ExecutionStatus: aws.String(status),
+		}
+	}
+	spartaDescription := changeSetDescription("MyService")
+	fakeCF := &fakeChangeSetsAPI{
+		pages: [][]*cloudformation.ChangeSetSummary{
+			{
+				changeSetSummary("Available", spartaDescription, cloudformation.ExecutionStatusAvailable),
+				changeSetSummary("Executed", spartaDescription, cloudformation.ExecutionStatusExecuteComplete),
+				changeSetSummary("OtherTool", "Created by another tool", cloudformation.ExecutionStatusAvailable),
+			},
+			{
+				changeSetSummary("Unavailable", spartaDescription, cloudformation.ExecutionStatusUnavailable),
+			},
+		},
+	}
+	deleteErr := DeleteAbandonedChangeSets("MyService", fakeCF, logrus.New())
+	if deleteErr != nil {
+		t.Fatalf("Failed to delete abandoned change sets: %s", deleteErr)
+	}
+	if strings.Join(fakeCF.deletedChangeSets, ",") != "Available,Unavailable" {
+		t.Errorf("Unexpected deleted change sets: %v", fakeCF.deletedChangeSets)
+	}
+}
+
+func TestLogChangeSetChanges(t *testing.T) {
+	var logOutput bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &logOutput
+	LogChangeSetChanges(&cloudformation.DescribeChangeSetOutput{
+		Changes: []*cloudformation.Change{
+			{
+				ResourceChange: &cloudformation.ResourceChange{
+					Action:            aws.String(cloudformation.ChangeActionModify),
+					LogicalResourceId: aws.String("MyFunction"),
+					ResourceType:      aws.String("AWS::Lambda::Function"),
+					Replacement:       aws.String(cloudformation.ReplacementTrue),
+				},
+			},
+			{},
+		},
+	}, logger)
+	logLines := strings.Split(strings.TrimSpace(logOutput.String()), "\n")
+	if len(logLines) != 1 {
+		t.Fatalf("Unexpected change log lines: %v", logLines)
+	}
+	for _, eachValue := range []string{"Action=Modify", "Resource=MyFunction", "Replacement=True"} {
+		if !strings.Contains(logLines[0], eachValue) {
+			t.Errorf("Failed to find %q in change log: %s", eachValue, logLines[0])
+		}
+	}
+}
+
+func TestCreateStackChangeSetTemplateBodyLimit(t *testing.T) {
+	template := gocf.NewTemplate()
+	template.Description = strings.Repeat("d", MaxTemplateBodySize)
+	// The fake panics if the change set is requested
+	_, changeSetErr := CreateStackChangeSet("MyServiceChangeSet",
+		"MyService",
+		template,
+		"",
+		nil,
+		&fakeChangeSetsAPI{},
+		logrus.New())
+	if changeSetErr == nil || !strings.Contains(changeSetErr.Error(), "inline TemplateBody limit") {
+		t.Fatalf("Failed to reject oversized inline template: %v", changeSetErr)
+	}
+}
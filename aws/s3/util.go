@@ -1,6 +1,7 @@
 package s3
 
 import (
+	"context"
 	"fmt"
 	"mime"
 	"net/url"
@@ -9,8 +10,12 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
@@ -24,6 +29,30 @@ type RollbackFunction func(logger *logrus.Logger) error
 // uploaded item. Note that s3ArtifactURL may include a `versionId` query arg
 // to denote the specific version to delete.
 func CreateS3RollbackFunc(awsSession *session.Session, s3ArtifactURL string) RollbackFunction {
+	return func(logger *logrus.Logger) error {
+		artifactURLParts, artifactURLPartsErr := url.Parse(s3ArtifactURL)
+		if nil != artifactURLPartsErr {
+			return artifactURLPartsErr
+		}
+		// Bucket is the first component
+		s3Bucket := strings.Split(artifactURLParts.Host, ".")[0]
+		rollback := CreateS3RollbackFuncWithClient(s3.New(awsSession),
+			s3Bucket,
+			artifactURLParts.Path,
+			s3ArtifactURL)
+		return rollback(logger)
+	}
+}
+
+// CreateS3RollbackFuncWithClient is the same as CreateS3RollbackFunc, but
+// deletes the S3KeyName object from S3Bucket with the s3Client. The
+// s3ArtifactURL is only used for its optional `versionId` query arg, so
+// both virtual hosted-style and path-style (eg, LocalStack) URLs are
+// supported.
+func CreateS3RollbackFuncWithClient(s3Client s3iface.S3API,
+	S3Bucket string,
+	S3KeyName string,
+	s3ArtifactURL string) RollbackFunction {
 	return func(logger *logrus.Logger) error {
 		logger.WithFields(logrus.Fields{
 			"URL": s3ArtifactURL,
@@ -32,12 +61,9 @@ func CreateS3RollbackFunc(awsSession *session.Session, s3ArtifactURL string) Rol
 		if nil != artifactURLPartsErr {
 			return artifactURLPartsErr
 		}
-		// Bucket is the first component
-		s3Bucket := strings.Split(artifactURLParts.Host, ".")[0]
-		s3Client := s3.New(awsSession)
 		params := &s3.DeleteObjectInput{
-			Bucket: aws.String(s3Bucket),
-			Key:    aws.String(artifactURLParts.Path),
+			Bucket: aws.String(S3Bucket),
+			Key:    aws.String(S3KeyName),
 		}
 		versionID := artifactURLParts.Query().Get("versionId")
 		if "" != versionID {
@@ -61,6 +87,240 @@ func UploadLocalFileToS3(localPath string,
 	S3Bucket string,
 	S3KeyName string,
 	logger *logrus.Logger) (string, error) {
+	return UploadLocalFileToS3WithMetadata(context.Background(),
+		localPath,
+		awsSession,
+		S3Bucket,
+		S3KeyName,
+		nil,
+		logger)
+}
+
+// ExpectedBucketOwner returns a request.Option that sets the
+// `x-amz-expected-bucket-owner` header so that S3 rejects the request
+// if the bucket isn't owned by the accountID
+func ExpectedBucketOwner(accountID string) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("x-amz-expected-bucket-owner", accountID)
+	}
+}
+
+// ServerSideEncryption returns a request.Option that requests server-side
+// encryption of objects created by PutObject or a multipart upload. The
+// algorithm is s3.ServerSideEncryptionAes256 (SSE-S3) or
+// s3.ServerSideEncryptionAwsKms (SSE-KMS). The optional kmsKeyID selects
+// the SSE-KMS key, otherwise the AWS managed key is used. Other requests
+// are unchanged.
+func ServerSideEncryption(algorithm string, kmsKeyID string) request.Option {
+	return func(r *request.Request) {
+		switch params := r.Params.(type) {
+		case *s3.PutObjectInput:
+			params.ServerSideEncryption = aws.String(algorithm)
+			if kmsKeyID != "" {
+				params.SSEKMSKeyId = aws.String(kmsKeyID)
+			}
+		case *s3.CreateMultipartUploadInput:
+			params.ServerSideEncryption = aws.String(algorithm)
+			if kmsKeyID != "" {
+				params.SSEKMSKeyId = aws.String(kmsKeyID)
+			}
+		}
+	}
+}
+
+// VerifyBucketOwner returns an error if the S3Bucket isn't owned by
+// the accountID
+func VerifyBucketOwner(ctx context.Context,
+	s3Client s3iface.S3API,
+	S3Bucket string,
+	accountID string,
+	logger *logrus.Logger) error {
+	_, headBucketErr := s3Client.HeadBucketWithContext(ctx,
+		&s3.HeadBucketInput{
+			Bucket: aws.String(S3Bucket),
+		},
+		ExpectedBucketOwner(accountID))
+	if headBucketErr != nil {
+		return errors.Wrapf(headBucketErr,
+			"Failed to verify that bucket %s is owned by account %s",
+			S3Bucket,
+			accountID)
+	}
+	logger.WithFields(logrus.Fields{
+		"Bucket":  S3Bucket,
+		"Account": accountID,
+	}).Info("Checking S3 bucket owner")
+	return nil
+}
+
+// VerifyBucketAccess returns an error if the S3Bucket doesn't exist or the
+// current credentials can't access it. HeadBucket only confirms read access,
+// so write permissions are verified by the subsequent upload. The optional
+// requestOptions are applied to the HeadBucket request.
+func VerifyBucketAccess(ctx context.Context,
+	s3Client s3iface.S3API,
+	S3Bucket string,
+	requestOptions ...request.Option) error {
+
+	_, headBucketErr := s3Client.HeadBucketWithContext(ctx,
+		&s3.HeadBucketInput{
+			Bucket: aws.String(S3Bucket),
+		},
+		requestOptions...)
+	if headBucketErr == nil {
+		return nil
+	}
+	if requestErr, requestErrOk := headBucketErr.(awserr.RequestFailure); requestErrOk {
+		switch requestErr.StatusCode() {
+		case 404:
+			return errors.Errorf("S3 bucket %s does not exist", S3Bucket)
+		case 403:
+			return errors.Errorf("Access denied to S3 bucket %s", S3Bucket)
+		}
+	}
+	return errors.Wrapf(headBucketErr, "Failed to access S3 bucket %s", S3Bucket)
+}
+
+// BucketLocation returns the region of the S3Bucket from its
+// GetBucketLocation constraint. GetBucketLocation can be called from any
+// region, so the s3Client doesn't need to be in the bucket's region. The
+// optional requestOptions are applied to the GetBucketLocation request.
+func BucketLocation(ctx context.Context,
+	s3Client s3iface.S3API,
+	S3Bucket string,
+	requestOptions ...request.Option) (string, error) {
+
+	locationOutput, locationErr := s3Client.GetBucketLocationWithContext(ctx,
+		&s3.GetBucketLocationInput{
+			Bucket: aws.String(S3Bucket),
+		},
+		requestOptions...)
+	if locationErr != nil {
+		if requestErr, requestErrOk := locationErr.(awserr.RequestFailure); requestErrOk {
+			switch requestErr.StatusCode() {
+			case 404:
+				return "", errors.Errorf("S3 bucket %s does not exist", S3Bucket)
+			case 403:
+				return "", errors.Errorf("Access denied to the location of S3 bucket %s", S3Bucket)
+			}
+		}
+		return "", errors.Wrapf(locationErr, "Failed to locate S3 bucket %s", S3Bucket)
+	}
+	// An empty constraint is us-east-1 and EU is eu-west-1
+	return s3.NormalizeBucketLocation(aws.StringValue(locationOutput.LocationConstraint)), nil
+}
+
+// ObjectURL returns the URL of the S3KeyName object as addressed by a client
+// created with awsConfig. A custom awsConfig Endpoint (eg, LocalStack) is
+// used if set, otherwise the regional S3 endpoint for the awsConfig Region is
+// resolved. The global endpoint is used if neither is available. The URL is
+// path-style if S3ForcePathStyle is set and virtual hosted-style otherwise.
+func ObjectURL(awsConfig *aws.Config, S3Bucket string, S3KeyName string) string {
+	if awsConfig == nil {
+		awsConfig = &aws.Config{}
+	}
+	disableSSL := aws.BoolValue(awsConfig.DisableSSL)
+	s3Endpoint := endpoints.AddScheme("s3.amazonaws.com", disableSSL)
+	if customEndpoint := aws.StringValue(awsConfig.Endpoint); customEndpoint != "" {
+		s3Endpoint = endpoints.AddScheme(customEndpoint, disableSSL)
+	} else {
+		resolvedEndpoint, resolvedEndpointErr := endpoints.DefaultResolver().EndpointFor(s3.EndpointsID,
+			aws.StringValue(awsConfig.Region),
+			func(resolveOptions *endpoints.Options) {
+				resolveOptions.DisableSSL = disableSSL
+			})
+		if resolvedEndpointErr == nil {
+			s3Endpoint = resolvedEndpoint.URL
+		}
+	}
+	endpointURL, endpointURLErr := url.Parse(s3Endpoint)
+	if endpointURLErr != nil || endpointURL.Host == "" {
+		endpointURL, _ = url.Parse(endpoints.AddScheme("s3.amazonaws.com", disableSSL))
+	}
+	if aws.BoolValue(awsConfig.S3ForcePathStyle) {
+		return fmt.Sprintf("%s://%s/%s/%s",
+			endpointURL.Scheme,
+			endpointURL.Host,
+			S3Bucket,
+			S3KeyName)
+	}
+	return fmt.Sprintf("%s://%s.%s/%s",
+		endpointURL.Scheme,
+		S3Bucket,
+		endpointURL.Host,
+		S3KeyName)
+}
+
+// ObjectLocation returns the URL of an existing S3 object as addressed by a
+// client created with awsConfig. See ObjectURL. If the bucket is versioned, the URL
+// includes the `versionId` query arg of the latest version. An empty string
+// is returned if the object doesn't exist. The optional requestOptions are
+// applied to the HeadObject request.
+func ObjectLocation(ctx context.Context,
+	s3Client s3iface.S3API,
+	awsConfig *aws.Config,
+	S3Bucket string,
+	S3KeyName string,
+	requestOptions ...request.Option) (string, error) {
+
+	headObjectOutput, headObjectErr := s3Client.HeadObjectWithContext(ctx,
+		&s3.HeadObjectInput{
+			Bucket: aws.String(S3Bucket),
+			Key:    aws.String(S3KeyName),
+		},
+		requestOptions...)
+	if headObjectErr != nil {
+		if requestErr, requestErrOk := headObjectErr.(awserr.RequestFailure); requestErrOk &&
+			requestErr.StatusCode() == 404 {
+			return "", nil
+		}
+		return "", errors.Wrapf(headObjectErr, "Failed to get S3 object: s3://%s/%s", S3Bucket, S3KeyName)
+	}
+	locationURL := ObjectURL(awsConfig, S3Bucket, S3KeyName)
+	if headObjectOutput.VersionId != nil {
+		locationURL = fmt.Sprintf("%s?versionId=%s", locationURL, aws.StringValue(headObjectOutput.VersionId))
+	}
+	return locationURL, nil
+}
+
+// UploadLocalFileToS3WithMetadata uploads the content at localPath to the
+// given S3Bucket and S3KeyName, and attaches the optional user-defined
+// metadata to the S3 object. The upload is aborted if the ctx is
+// canceled or its deadline expires. The optional requestOptions are
+// applied to each S3 request (eg, ExpectedBucketOwner).
+func UploadLocalFileToS3WithMetadata(ctx context.Context,
+	localPath string,
+	awsSession *session.Session,
+	S3Bucket string,
+	S3KeyName string,
+	metadata map[string]string,
+	logger *logrus.Logger,
+	requestOptions ...request.Option) (string, error) {
+	return UploadLocalFileToS3WithClient(ctx,
+		localPath,
+		s3.New(awsSession),
+		S3Bucket,
+		S3KeyName,
+		metadata,
+		nil,
+		logger,
+		requestOptions...)
+}
+
+// UploadLocalFileToS3WithClient is the same as
+// UploadLocalFileToS3WithMetadata, but uploads the content with the
+// s3Client. The optional uploaderOptions customize the s3manager.Uploader,
+// such as its PartSize and Concurrency. Unset values use the s3manager
+// defaults of DefaultUploadPartSize (5MB) and DefaultUploadConcurrency (5).
+func UploadLocalFileToS3WithClient(ctx context.Context,
+	localPath string,
+	s3Client s3iface.S3API,
+	S3Bucket string,
+	S3KeyName string,
+	metadata map[string]string,
+	uploaderOptions []func(*s3manager.Uploader),
+	logger *logrus.Logger,
+	requestOptions ...request.Option) (string, error) {
 
 	// Then do the actual work
 	/* #nosec */
@@ -74,6 +334,9 @@ func UploadLocalFileToS3(localPath string,
 		ContentType: aws.String(mime.TypeByExtension(path.Ext(localPath))),
 		Body:        reader,
 	}
+	if len(metadata) != 0 {
+		uploadInput.Metadata = aws.StringMap(metadata)
+	}
 	// If we can get the current working directory, let's try and strip
 	// it from the path just to keep the log statement a bit shorter
 	logPath := localPath
@@ -96,8 +359,11 @@ func UploadLocalFileToS3(localPath string,
 		"Size":   humanize.Bytes(uint64(stat.Size())),
 	}).Info("Uploading local file to S3")
 
-	uploader := s3manager.NewUploader(awsSession)
-	result, err := uploader.Upload(uploadInput)
+	options := append([]func(*s3manager.Uploader){
+		s3manager.WithUploaderRequestOptions(requestOptions...),
+	}, uploaderOptions...)
+	uploader := s3manager.NewUploaderWithClient(s3Client, options...)
+	result, err := uploader.UploadWithContext(ctx, uploadInput)
 	if nil != err {
 		return "", errors.Wrapf(err, "Failed to upload object to S3")
 	}
@@ -121,16 +387,15 @@ func UploadLocalFileToS3(localPath string,
 
 // BucketVersioningEnabled determines if a given S3 bucket has object
 // versioning enabled.
-func BucketVersioningEnabled(awsSession *session.Session,
+func BucketVersioningEnabled(s3Client s3iface.S3API,
 	S3Bucket string,
 	logger *logrus.Logger) (bool, error) {
 
-	s3Svc := s3.New(awsSession)
 	params := &s3.GetBucketVersioningInput{
 		Bucket: aws.String(S3Bucket), // Required
 	}
 	versioningEnabled := false
-	resp, err := s3Svc.GetBucketVersioning(params)
+	resp, err := s3Client.GetBucketVersioning(params)
 	if err == nil && resp != nil && resp.Status != nil {
 		// What's the versioning policy?
 		logger.WithFields(logrus.Fields{
@@ -142,6 +407,123 @@ func BucketVersioningEnabled(awsSession *session.Session,
 	return versioningEnabled, err
 }
 
+// bucketLifecycleRules returns the bucket's lifecycle rules. A bucket without
+// a lifecycle configuration has no rules.
+func bucketLifecycleRules(s3Client s3iface.S3API, S3Bucket string) ([]*s3.LifecycleRule, error) {
+	lifecycleOutput, lifecycleErr := s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(S3Bucket),
+	})
+	if lifecycleErr != nil {
+		if awsErr, awsErrOk := lifecycleErr.(awserr.Error); awsErrOk &&
+			awsErr.Code() == "NoSuchLifecycleConfiguration" {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(lifecycleErr, "Failed to get lifecycle configuration for bucket: %s", S3Bucket)
+	}
+	return lifecycleOutput.Rules, nil
+}
+
+// lifecycleRulePrefix returns the key prefix the rule applies to and
+// whether the rule applies to every key with that prefix
+func lifecycleRulePrefix(rule *s3.LifecycleRule) (string, bool) {
+	if rule.Filter == nil {
+		return aws.StringValue(rule.Prefix), true
+	}
+	if rule.Filter.Tag != nil {
+		return "", false
+	}
+	if rule.Filter.And != nil {
+		if len(rule.Filter.And.Tags) != 0 {
+			return "", false
+		}
+		return aws.StringValue(rule.Filter.And.Prefix), true
+	}
+	return aws.StringValue(rule.Filter.Prefix), true
+}
+
+// PrefixExpirationRule returns the enabled lifecycle rule that expires every
+// key with the given keyPrefix, or nil if the prefix isn't covered by an
+// expiration rule
+func PrefixExpirationRule(s3Client s3iface.S3API,
+	S3Bucket string,
+	keyPrefix string,
+	logger *logrus.Logger) (*s3.LifecycleRule, error) {
+
+	rules, rulesErr := bucketLifecycleRules(s3Client, S3Bucket)
+	if rulesErr != nil {
+		return nil, rulesErr
+	}
+	for _, eachRule := range rules {
+		rulePrefix, appliesToAll := lifecycleRulePrefix(eachRule)
+		if aws.StringValue(eachRule.Status) != s3.ExpirationStatusEnabled ||
+			!appliesToAll ||
+			!strings.HasPrefix(keyPrefix, rulePrefix) ||
+			eachRule.Expiration == nil ||
+			aws.Int64Value(eachRule.Expiration.Days) <= 0 {
+			continue
+		}
+		logger.WithFields(logrus.Fields{
+			"Bucket": S3Bucket,
+			"Prefix": keyPrefix,
+			"RuleID": aws.StringValue(eachRule.ID),
+		}).Debug("Found lifecycle expiration rule")
+		return eachRule, nil
+	}
+	return nil, nil
+}
+
+// EnsurePrefixExpirationRule creates or updates the ruleID lifecycle rule
+// that expires the current and noncurrent versions of every key with the
+// given keyPrefix after expirationDays. Other lifecycle rules are preserved.
+func EnsurePrefixExpirationRule(s3Client s3iface.S3API,
+	S3Bucket string,
+	keyPrefix string,
+	ruleID string,
+	expirationDays int64,
+	logger *logrus.Logger) error {
+
+	rules, rulesErr := bucketLifecycleRules(s3Client, S3Bucket)
+	if rulesErr != nil {
+		return rulesErr
+	}
+	updatedRules := []*s3.LifecycleRule{}
+	for _, eachRule := range rules {
+		if aws.StringValue(eachRule.ID) != ruleID {
+			updatedRules = append(updatedRules, eachRule)
+		}
+	}
+	updatedRules = append(updatedRules, &s3.LifecycleRule{
+		ID:     aws.String(ruleID),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{
+			Prefix: aws.String(keyPrefix),
+		},
+		Expiration: &s3.LifecycleExpiration{
+			Days: aws.Int64(expirationDays),
+		},
+		NoncurrentVersionExpiration: &s3.NoncurrentVersionExpiration{
+			NoncurrentDays: aws.Int64(expirationDays),
+		},
+	})
+	logger.WithFields(logrus.Fields{
+		"Bucket":         S3Bucket,
+		"Prefix":         keyPrefix,
+		"RuleID":         ruleID,
+		"ExpirationDays": expirationDays,
+	}).Info("Updating bucket lifecycle expiration rule")
+
+	_, putErr := s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(S3Bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: updatedRules,
+		},
+	})
+	if putErr != nil {
+		return errors.Wrapf(putErr, "Failed to update lifecycle configuration for bucket: %s", S3Bucket)
+	}
+	return nil
+}
+
 // BucketRegion returns the AWS region that hosts the bucket
 func BucketRegion(awsSession *session.Session,
 	S3Bucket string,
@@ -0,0 +1,318 @@
+package s3
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/sirupsen/logrus"
+)
+
+func TestServerSideEncryption(t *testing.T) {
+	awsSession := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String("us-west-2"),
+	}))
+	s3Svc := s3.New(awsSession)
+	kmsKeyID := "arn:aws:kms:us-west-2:123456789012:key/sparta-test"
+
+	putObjectInput := &s3.PutObjectInput{
+		Bucket: aws.String("weagle"),
+		Key:    aws.String("MyService/code.zip"),
+	}
+	putObjectRequest, _ := s3Svc.PutObjectRequest(putObjectInput)
+	putObjectRequest.ApplyOptions(ServerSideEncryption(s3.ServerSideEncryptionAwsKms, kmsKeyID))
+	if aws.StringValue(putObjectInput.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms ||
+		aws.StringValue(putObjectInput.SSEKMSKeyId) != kmsKeyID {
+		t.Fatalf("Unexpected PutObject encryption: %s, %s",
+			aws.StringValue(putObjectInput.ServerSideEncryption),
+			aws.StringValue(putObjectInput.SSEKMSKeyId))
+	}
+
+	multipartInput := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String("weagle"),
+		Key:    aws.String("MyService/code.zip"),
+	}
+	multipartRequest, _ := s3Svc.CreateMultipartUploadRequest(multipartInput)
+	multipartRequest.ApplyOptions(ServerSideEncryption(s3.ServerSideEncryptionAes256, ""))
+	if aws.StringValue(multipartInput.ServerSideEncryption) != s3.ServerSideEncryptionAes256 ||
+		multipartInput.SSEKMSKeyId != nil {
+		t.Fatalf("Unexpected CreateMultipartUpload encryption: %s, %s",
+			aws.StringValue(multipartInput.ServerSideEncryption),
+			aws.StringValue(multipartInput.SSEKMSKeyId))
+	}
+}
+
+type fakeBucketLocationAPI struct {
+	s3iface.S3API
+	locationConstraint *string
+	err                error
+}
+
+func (api *fakeBucketLocationAPI) GetBucketLocationWithContext(ctx aws.Context,
+	input *s3.GetBucketLocationInput,
+	opts ...request.Option) (*s3.GetBucketLocationOutput, error) {
+	if api.err != nil {
+		return nil, api.err
+	}
+	return &s3.GetBucketLocationOutput{
+		LocationConstraint: api.locationConstraint,
+	}, nil
+}
+
+func TestBucketLocation(t *testing.T) {
+	expectedRegions := map[string]*string{
+		"us-east-1": nil,
+		"eu-west-1": aws.String("EU"),
+		"us-west-2": aws.String("us-west-2"),
+	}
+	for eachRegion, eachConstraint := range expectedRegions {
+		region, regionErr := BucketLocation(context.Background(),
+			&fakeBucketLocationAPI{locationConstraint: eachConstraint},
+			"weagle")
+		if regionErr != nil {
+			t.Fatalf("Failed to locate bucket: %s", regionErr)
+		}
+		if region != eachRegion {
+			t.Errorf("Unexpected bucket region. Expected: %s, Actual: %s", eachRegion, region)
+		}
+	}
+	missingErr := awserr.NewRequestFailure(awserr.New("NoSuchBucket", "missing", nil), 404, "")
+	_, regionErr := BucketLocation(context.Background(),
+		&fakeBucketLocationAPI{err: missingErr},
+		"weagle")
+	if regionErr == nil || !strings.Contains(regionErr.Error(), "does not exist") {
+		t.Errorf("Unexpected missing bucket error: %v", regionErr)
+	}
+}
+
+func TestObjectURL(t *testing.T) {
+	objectURL := ObjectURL(&aws.Config{Region: aws.String("us-west-2")},
+		"weagle",
+		"MyService/cftemplate.json")
+	if !strings.HasPrefix(objectURL, "https://weagle.s3") ||
+		!strings.Contains(objectURL, "us-west-2") ||
+		!strings.HasSuffix(objectURL, ".amazonaws.com/MyService/cftemplate.json") {
+		t.Errorf("Unexpected regional object URL: %s", objectURL)
+	}
+	chinaURL := ObjectURL(&aws.Config{Region: aws.String("cn-north-1")},
+		"weagle",
+		"MyService/cftemplate.json")
+	if !strings.HasSuffix(chinaURL, ".amazonaws.com.cn/MyService/cftemplate.json") {
+		t.Errorf("Unexpected China object URL: %s", chinaURL)
+	}
+
+	// Custom endpoints (eg, LocalStack) and path-style addressing
+	testCases := []struct {
+		awsConfig   *aws.Config
+		expectedURL string
+	}{
+		{
+			&aws.Config{
+				Region:           aws.String("us-east-1"),
+				Endpoint:         aws.String("http://localhost:4566"),
+				S3ForcePathStyle: aws.Bool(true),
+			},
+			"http://localhost:4566/weagle/MyService/cftemplate.json",
+		},
+		{
+			&aws.Config{
+				Region:   aws.String("us-east-1"),
+				Endpoint: aws.String("s3.example.com"),
+			},
+			"https://weagle.s3.example.com/MyService/cftemplate.json",
+		},
+		{
+			&aws.Config{
+				Region:           aws.String("us-east-1"),
+				S3ForcePathStyle: aws.Bool(true),
+			},
+			"https://s3.amazonaws.com/weagle/MyService/cftemplate.json",
+		},
+	}
+	for _, eachTestCase := range testCases {
+		actualURL := ObjectURL(eachTestCase.awsConfig, "weagle", "MyService/cftemplate.json")
+		if actualURL != eachTestCase.expectedURL {
+			t.Errorf("Unexpected object URL. Expected: %s, Actual: %s",
+				eachTestCase.expectedURL,
+				actualURL)
+		}
+	}
+}
+
+type fakeDeleteObjectAPI struct {
+	s3iface.S3API
+	deleted []*s3.DeleteObjectInput
+}
+
+func (api *fakeDeleteObjectAPI) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	api.deleted = append(api.deleted, input)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func TestCreateS3RollbackFuncWithClient(t *testing.T) {
+	// LocalStack (path-style) upload location
+	fakeAPI := &fakeDeleteObjectAPI{}
+	rollback := CreateS3RollbackFuncWithClient(fakeAPI,
+		"weagle",
+		"MyService/code.zip",
+		"http://localhost:4566/weagle/MyService/code.zip?versionId=v1")
+	rollbackErr := rollback(logrus.New())
+	if rollbackErr != nil {
+		t.Fatalf("Failed to rollback S3 object: %s", rollbackErr)
+	}
+	if len(fakeAPI.deleted) != 1 {
+		t.Fatalf("Unexpected DeleteObject count: %d", len(fakeAPI.deleted))
+	}
+	deleteInput := fakeAPI.deleted[0]
+	if aws.StringValue(deleteInput.Bucket) != "weagle" ||
+		aws.StringValue(deleteInput.Key) != "MyService/code.zip" ||
+		aws.StringValue(deleteInput.VersionId) != "v1" {
+		t.Errorf("Unexpected DeleteObject input: %s", deleteInput)
+	}
+
+	// Unversioned upload location
+	fakeAPI = &fakeDeleteObjectAPI{}
+	rollback = CreateS3RollbackFuncWithClient(fakeAPI,
+		"weagle",
+		"MyService/code.zip",
+		"http://localhost:4566/weagle/MyService/code.zip")
+	rollbackErr = rollback(logrus.New())
+	if rollbackErr != nil {
+		t.Fatalf("Failed to rollback S3 object: %s", rollbackErr)
+	}
+	if len(fakeAPI.deleted) != 1 || fakeAPI.deleted[0].VersionId != nil {
+		t.Errorf("Unexpected DeleteObject input: %v", fakeAPI.deleted)
+	}
+}
+
+type fakeLifecycleAPI struct {
+	s3iface.S3API
+	rules []*s3.LifecycleRule
+}
+
+func (api *fakeLifecycleAPI) GetBucketLifecycleConfiguration(input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	if api.rules == nil {
+		return nil, awserr.New("NoSuchLifecycleConfiguration", "missing", nil)
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{
+		Rules: api.rules,
+	}, nil
+}
+
+func TestPrefixExpirationRule(t *testing.T) {
+	expiringRule := func(ruleID string, filter *s3.LifecycleRuleFilter) *s3.LifecycleRule {
+		return &s3.LifecycleRule{
+			ID:     aws.String(ruleID),
+			Status: aws.String(s3.ExpirationStatusEnabled),
+			Filter: filter,
+			Expiration: &s3.LifecycleExpiration{
+				Days: aws.Int64(30),
+			},
+		}
+	}
+	tag := &s3.Tag{Key: aws.String("Retention"), Value: aws.String("short")}
+	testCases := []struct {
+		name           string
+		rule           *s3.LifecycleRule
+		expectedPrefix string
+		expectedAll    bool
+		expectedMatch  bool
+	}{
+		{"FilterPrefix",
+			expiringRule("filter", &s3.LifecycleRuleFilter{Prefix: aws.String("MyService/")}),
+			"MyService/",
+			true,
+			true},
+		{"FilterParentPrefix",
+			expiringRule("parent", &s3.LifecycleRuleFilter{Prefix: aws.String("")}),
+			"",
+			true,
+			true},
+		{"FilterOtherPrefix",
+			expiringRule("other", &s3.LifecycleRuleFilter{Prefix: aws.String("OtherService/")}),
+			"OtherService/",
+			true,
+			false},
+		{"AndPrefix",
+			expiringRule("and", &s3.LifecycleRuleFilter{
+				And: &s3.LifecycleRuleAndOperator{Prefix: aws.String("MyService/")},
+			}),
+			"MyService/",
+			true,
+			true},
+		{"AndPrefixTags",
+			expiringRule("andTags", &s3.LifecycleRuleFilter{
+				And: &s3.LifecycleRuleAndOperator{
+					Prefix: aws.String("MyService/"),
+					Tags:   []*s3.Tag{tag},
+				},
+			}),
+			"",
+			false,
+			false},
+		{"Tag",
+			expiringRule("tag", &s3.LifecycleRuleFilter{Tag: tag}),
+			"",
+			false,
+			false},
+		{"LegacyPrefix",
+			&s3.LifecycleRule{
+				ID:     aws.String("legacy"),
+				Status: aws.String(s3.ExpirationStatusEnabled),
+				Prefix: aws.String("MyService/"),
+				Expiration: &s3.LifecycleExpiration{
+					Days: aws.Int64(30),
+				},
+			},
+			"MyService/",
+			true,
+			true},
+		{"Disabled",
+			&s3.LifecycleRule{
+				ID:     aws.String("disabled"),
+				Status: aws.String(s3.ExpirationStatusDisabled),
+				Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("MyService/")},
+				Expiration: &s3.LifecycleExpiration{
+					Days: aws.Int64(30),
+				},
+			},
+			"MyService/",
+			true,
+			false},
+	}
+	logger := logrus.New()
+	for _, eachTestCase := range testCases {
+		rulePrefix, appliesToAll := lifecycleRulePrefix(eachTestCase.rule)
+		if rulePrefix != eachTestCase.expectedPrefix || appliesToAll != eachTestCase.expectedAll {
+			t.Errorf("%s: Unexpected rule prefix. Expected: (%q, %t), Actual: (%q, %t)",
+				eachTestCase.name,
+				eachTestCase.expectedPrefix,
+				eachTestCase.expectedAll,
+				rulePrefix,
+				appliesToAll)
+		}
+		rule, ruleErr := PrefixExpirationRule(&fakeLifecycleAPI{rules: []*s3.LifecycleRule{eachTestCase.rule}},
+			"weagle",
+			"MyService/",
+			logger)
+		if ruleErr != nil {
+			t.Fatalf("%s: Failed to get expiration rule: %s", eachTestCase.name, ruleErr)
+		}
+		if (rule != nil) != eachTestCase.expectedMatch {
+			t.Errorf("%s: Unexpected expiration rule match: %#v", eachTestCase.name, rule)
+		}
+	}
+	// A bucket without a lifecycle configuration has no expiration rule
+	rule, ruleErr := PrefixExpirationRule(&fakeLifecycleAPI{}, "weagle", "MyService/", logger)
+	if rule != nil || ruleErr != nil {
+		t.Errorf("Unexpected expiration rule for bucket without lifecycle configuration: %#v, %v",
+			rule,
+			ruleErr)
+	}
+}
@@ -1,7 +1,10 @@
 package aws
 
 import (
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/sirupsen/logrus"
@@ -15,6 +18,33 @@ func (proxy *logrusProxy) Log(args ...interface{}) {
 	proxy.logger.Info(args...)
 }
 
+// DefaultMaxRetries is the number of times a retryable AWS request, such
+// as a throttled request, is retried when the aws.Config doesn't specify
+// MaxRetries. The SDK default of 3 is too low for accounts where several
+// services are provisioned concurrently.
+const DefaultMaxRetries = 8
+
+// loggingRetryer is the client.DefaultRetryer, which retries throttled
+// requests with exponential backoff and jitter, and logs each retry
+type loggingRetryer struct {
+	client.DefaultRetryer
+	logger *logrus.Logger
+}
+
+// RetryRules returns the delay before the request is retried
+func (retryer loggingRetryer) RetryRules(r *request.Request) time.Duration {
+	delay := retryer.DefaultRetryer.RetryRules(r)
+	retryer.logger.WithFields(logrus.Fields{
+		"Service":    r.ClientInfo.ServiceName,
+		"Operation":  r.Operation.Name,
+		"RetryCount": r.RetryCount + 1,
+		"MaxRetries": retryer.MaxRetries(),
+		"Delay":      delay.String(),
+		"Error":      r.Error,
+	}).Debug("Retrying AWS request")
+	return delay
+}
+
 // NewSessionWithConfig returns an awsSession that includes the user supplied
 // configuration information
 func NewSessionWithConfig(awsConfig *aws.Config, logger *logrus.Logger) *session.Session {
@@ -43,6 +73,31 @@ func NewSessionWithLevel(level aws.LogLevelType, logger *logrus.Logger) *session
 func NewSessionWithConfigLevel(awsConfig *aws.Config,
 	level aws.LogLevelType,
 	logger *logrus.Logger) *session.Session {
+	sess := session.New(loggingConfig(awsConfig, level, logger))
+	return withRequestLogging(sess, logger)
+}
+
+// NewSessionWithProfile returns an AWS Session that resolves credentials and
+// configuration from the named shared config profile (eg, ~/.aws/config and
+// ~/.aws/credentials), in addition to the user supplied configuration.
+func NewSessionWithProfile(awsConfig *aws.Config,
+	profile string,
+	logger *logrus.Logger) (*session.Session, error) {
+	sess, sessErr := session.NewSessionWithOptions(session.Options{
+		Config:            *loggingConfig(awsConfig, aws.LogDebugWithRequestErrors, logger),
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if sessErr != nil {
+		return nil, sessErr
+	}
+	return withRequestLogging(sess, logger), nil
+}
+
+// loggingConfig returns the awsConfig with the logger and retryer attached
+func loggingConfig(awsConfig *aws.Config,
+	level aws.LogLevelType,
+	logger *logrus.Logger) *aws.Config {
 	if nil == awsConfig {
 		awsConfig = &aws.Config{
 			CredentialsChainVerboseErrors: aws.Bool(true),
@@ -55,7 +110,21 @@ func NewSessionWithConfigLevel(awsConfig *aws.Config,
 		awsConfig.LogLevel = aws.LogLevel(level)
 	}
 	awsConfig.Logger = &logrusProxy{logger}
-	sess := session.New(awsConfig)
+	if nil == awsConfig.Retryer {
+		maxRetries := DefaultMaxRetries
+		if nil != awsConfig.MaxRetries && *awsConfig.MaxRetries != aws.UseServiceDefaultRetries {
+			maxRetries = *awsConfig.MaxRetries
+		}
+		awsConfig.Retryer = loggingRetryer{
+			DefaultRetryer: client.DefaultRetryer{NumMaxRetries: maxRetries},
+			logger:         logger,
+		}
+	}
+	return awsConfig
+}
+
+// withRequestLogging attaches a debug level request handler to the session
+func withRequestLogging(sess *session.Session, logger *logrus.Logger) *session.Session {
 	sess.Handlers.Send.PushFront(func(r *request.Request) {
 		logger.WithFields(logrus.Fields{
 			"Service":   r.ClientInfo.ServiceName,
@@ -0,0 +1,66 @@
+package aws
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLoggingConfigRetryer(t *testing.T) {
+	logger := logrus.New()
+	defaultConfig := loggingConfig(nil, aws.LogDebug, logger)
+	if defaultConfig.Retryer == nil ||
+		defaultConfig.Retryer.(request.Retryer).MaxRetries() != DefaultMaxRetries {
+		t.Fatalf("Unexpected default retryer: %#v", defaultConfig.Retryer)
+	}
+	userConfig := loggingConfig(&aws.Config{MaxRetries: aws.Int(2)}, aws.LogDebug, logger)
+	if userConfig.Retryer.(request.Retryer).MaxRetries() != 2 {
+		t.Errorf("Failed to apply user MaxRetries: %#v", userConfig.Retryer)
+	}
+	userRetryer := client.DefaultRetryer{NumMaxRetries: 1}
+	retryerConfig := loggingConfig(&aws.Config{Retryer: userRetryer}, aws.LogDebug, logger)
+	if retryerConfig.Retryer != userRetryer {
+		t.Errorf("Unexpected replacement of user retryer: %#v", retryerConfig.Retryer)
+	}
+}
+
+func TestLoggingRetryerRetryRules(t *testing.T) {
+	var logOutput bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &logOutput
+	logger.Level = logrus.DebugLevel
+
+	retryer := loggingRetryer{
+		DefaultRetryer: client.DefaultRetryer{NumMaxRetries: DefaultMaxRetries},
+		logger:         logger,
+	}
+	throttledRequest := &request.Request{
+		ClientInfo: metadata.ClientInfo{ServiceName: "cloudformation"},
+		Operation:  &request.Operation{Name: "DescribeStacks"},
+		Error:      awserr.New("Throttling", "Rate exceeded", nil),
+		HTTPResponse: &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Header:     http.Header{},
+		},
+	}
+	if delay := retryer.RetryRules(throttledRequest); delay <= 0 {
+		t.Errorf("Unexpected retry delay: %s", delay)
+	}
+	for _, eachValue := range []string{"Retrying AWS request",
+		"Service=cloudformation",
+		"Operation=DescribeStacks",
+		"RetryCount=1",
+		"MaxRetries=8"} {
+		if !strings.Contains(logOutput.String(), eachValue) {
+			t.Errorf("Failed to find %q in retry log: %s", eachValue, logOutput.String())
+		}
+	}
+}
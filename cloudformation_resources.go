@@ -3,10 +3,13 @@ package sparta
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 	"text/template"
 
+	gocc "github.com/mweagle/go-cloudcondenser"
 	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,7 +21,8 @@ func resourceOutputs(resourceName string,
 
 	outputProps := []string{}
 	switch typedResource := resource.(type) {
-	case gocf.IAMRole:
+	case gocf.IAMRole,
+		iamRoleResource:
 		// NOP
 	case *gocf.DynamoDBTable:
 		if typedResource.StreamSpecification != nil {
@@ -132,3 +136,40 @@ func safeMetadataInsert(resource *gocf.Resource, key string, value interface{})
 	}
 	resource.Metadata[key] = value
 }
+
+// safeMergeTemplates merges the sourceTemplate into the destTemplate.
+// Resource, Output, and Parameter logical names in the sourceTemplate that
+// are already defined by the destTemplate are returned as an error and
+// the destTemplate is left unchanged.
+func safeMergeTemplates(sourceTemplate *gocf.Template, destTemplate *gocf.Template) error {
+	collisions := []string{}
+	for eachName := range sourceTemplate.Resources {
+		if _, exists := destTemplate.Resources[eachName]; exists {
+			collisions = append(collisions, fmt.Sprintf("Resource %s", eachName))
+		}
+	}
+	for eachName := range sourceTemplate.Outputs {
+		if _, exists := destTemplate.Outputs[eachName]; exists {
+			collisions = append(collisions, fmt.Sprintf("Output %s", eachName))
+		}
+	}
+	for eachName := range sourceTemplate.Parameters {
+		if _, exists := destTemplate.Parameters[eachName]; exists {
+			collisions = append(collisions, fmt.Sprintf("Parameter %s", eachName))
+		}
+	}
+	if len(collisions) != 0 {
+		sort.Strings(collisions)
+		return errors.Errorf("Template merge failed. Logical names are already defined: %s",
+			strings.Join(collisions, ", "))
+	}
+	safeMergeErrs := gocc.SafeMerge(sourceTemplate, destTemplate)
+	if len(safeMergeErrs) != 0 {
+		errorText := make([]string, len(safeMergeErrs))
+		for index, eachError := range safeMergeErrs {
+			errorText[index] = eachError.Error()
+		}
+		return errors.Errorf("Template merge failed: %s", strings.Join(errorText, ", "))
+	}
+	return nil
+}
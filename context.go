@@ -20,4 +20,8 @@ const (
 	// ContextKeyLambdaVersions is the key in the context that stores the map
 	// of autoincrementing versions
 	ContextKeyLambdaVersions = "spartaLambdaVersions"
+	// ContextKeyRollbackFunctions is the key in the context that stores the
+	// slice of user-defined RollbackFunction values. See
+	// RegisterRollbackFunction
+	ContextKeyRollbackFunctions = "spartaRollbackFunctions"
 )
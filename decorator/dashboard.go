@@ -2,9 +2,11 @@ package decorator
 
 import (
 	"bytes"
+	"encoding/json"
 	"text/template"
 
 	"regexp"
+	"sort"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	sparta "github.com/mweagle/Sparta"
@@ -45,11 +47,21 @@ type LambdaTemplateData struct {
 	ResourceName  string
 }
 
+// APIGatewayTemplateData is the API Gateway RestApi defined in the
+// template together with the widget index of its metrics
+type APIGatewayTemplateData struct {
+	ResourceName string
+	APIName      string
+	WidgetIndex  int
+}
+
 // DashboardTemplateData is the object supplied to the dashboard template
 // to generate the resulting dashboard
 type DashboardTemplateData struct {
 	// The list of lambda functions
 	LambdaFunctions []*LambdaTemplateData
+	// The list of API Gateway RestApis
+	APIGateways []*APIGatewayTemplateData
 	// SpartaVersion is the Sparta library used to provision this service
 	SpartaVersion string
 	// SpartaGitHash is the commit hash of this version of the library
@@ -102,12 +114,32 @@ var dashboardTemplate = `
         "metrics": [
             [ "AWS/Lambda", "Invocations", "FunctionName", "{ "Ref" : "<< $eachLambda.ResourceName >>" }", { "stat": "Sum" }],
 						[ ".", "Errors", ".", ".", { "stat": "Sum" }],
-						[ ".", "Throttles", ".", ".", { "stat": "Sum" } ]
+						[ ".", "Throttles", ".", ".", { "stat": "Sum" } ],
+						[ ".", "Duration", ".", ".", { "stat": "Average", "yAxis": "right" } ]
         ],
         "region": "{ "Ref" : "AWS::Region" }",
         "period": << $.TimeSeriesPeriod >>,
         "title": "λ: { "Ref" : "<< $eachLambda.ResourceName >>" }"
       }
+    }<<end>><<range $index, $eachAPI := .APIGateways>>,
+    {
+      "type": "metric",
+      "x": <<widgetX $eachAPI.WidgetIndex >>,
+      "y": <<widgetY $eachAPI.WidgetIndex >>,
+      "width": << $.Extents.MetricWidthUnits >>,
+      "height": << $.Extents.MetricHeightUnits >>,
+      "properties": {
+        "view": "timeSeries",
+        "stacked": false,
+        "metrics": [
+            [ "AWS/ApiGateway", "Count", "ApiName", "<< jsonEscape $eachAPI.APIName >>", { "stat": "Sum" }],
+						[ ".", "4XXError", ".", ".", { "stat": "Sum" }],
+						[ ".", "5XXError", ".", ".", { "stat": "Sum" } ]
+        ],
+        "region": "{ "Ref" : "AWS::Region" }",
+        "period": << $.TimeSeriesPeriod >>,
+        "title": "API Gateway: << jsonEscape $eachAPI.APIName >>"
+      }
     }<<end>>
   ]
 }
@@ -120,14 +152,27 @@ var templateFuncMap = template.FuncMap{
 	},
 	"widgetY": func(lambdaIndex int) int {
 		xRow := 1
-		xRow += (int)((float64)(lambdaIndex % metricsPerRow))
+		xRow += lambdaIndex / metricsPerRow
 		// That's the row
 		return headerHeightUnits + (xRow * metricHeightUnits)
 	},
+	// jsonEscape escapes a user supplied value, such as an API name, for
+	// inclusion in a JSON string
+	"jsonEscape": func(value string) (string, error) {
+		escaped, escapedErr := json.Marshal(value)
+		if escapedErr != nil {
+			return "", escapedErr
+		}
+		// Trim the enclosing quotes
+		return string(escaped[1 : len(escaped)-1]), nil
+	},
 }
 
 // DashboardDecorator returns a ServiceDecoratorHook function that
-// can be attached the workflow to create a dashboard
+// can be attached the workflow to create a dashboard. The dashboard
+// includes a widget with the invocation, error, throttle, and duration
+// metrics for each lambda function and, if the service defines an API,
+// a widget with the API Gateway request count, 4XX, and 5XX metrics.
 func DashboardDecorator(lambdaAWSInfo []*sparta.LambdaAWSInfo,
 	timeSeriesPeriod int) sparta.ServiceDecoratorHookFunc {
 	return func(context map[string]interface{},
@@ -146,10 +191,35 @@ func DashboardDecorator(lambdaAWSInfo []*sparta.LambdaAWSInfo,
 				ResourceName:  eachLambda.LogicalResourceName(),
 			}
 		}
+		// Any API Gateway instances? Sort them by resource name
+		// so that the dashboard is deterministic
+		apiGatewayNames := []string{}
+		for eachName, eachResource := range cfTemplate.Resources {
+			if eachResource.Properties.CfnResourceType() == "AWS::ApiGateway::RestApi" {
+				apiGatewayNames = append(apiGatewayNames, eachName)
+			}
+		}
+		sort.Strings(apiGatewayNames)
+		apiGateways := []*APIGatewayTemplateData{}
+		for _, eachName := range apiGatewayNames {
+			restAPI, restAPIOk := cfTemplate.Resources[eachName].Properties.(*gocf.APIGatewayRestAPI)
+			if !restAPIOk || restAPI.Name == nil || restAPI.Name.Literal == "" {
+				logger.WithFields(logrus.Fields{
+					"Resource": eachName,
+				}).Debug("Skipping dashboard widget for API Gateway without a literal name")
+				continue
+			}
+			apiGateways = append(apiGateways, &APIGatewayTemplateData{
+				ResourceName: eachName,
+				APIName:      restAPI.Name.Literal,
+				WidgetIndex:  len(lambdaFunctions) + len(apiGateways),
+			})
+		}
 		dashboardTemplateData := &DashboardTemplateData{
 			SpartaVersion:    sparta.SpartaVersion,
 			SpartaGitHash:    sparta.SpartaGitHash,
 			LambdaFunctions:  lambdaFunctions,
+			APIGateways:      apiGateways,
 			TimeSeriesPeriod: timeSeriesPeriod,
 			Extents: widgetExtents{
 				HeaderWidthUnits:  headerWidthUnits,
@@ -0,0 +1,133 @@
+package decorator
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	sparta "github.com/mweagle/Sparta"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+func dashboardTestLambda(ctx context.Context) (string, error) {
+	return "Hello World", nil
+}
+
+// resolveDashboardBody joins the Fn::Join dashboard body, replacing each
+// intrinsic function with a placeholder value
+func resolveDashboardBody(t *testing.T, dashboardBody *gocf.StringExpr) string {
+	bodyJSON, bodyJSONErr := json.Marshal(dashboardBody)
+	if bodyJSONErr != nil {
+		t.Fatal(bodyJSONErr)
+	}
+	var joinExpr struct {
+		Join []interface{} `json:"Fn::Join"`
+	}
+	if unmarshalErr := json.Unmarshal(bodyJSON, &joinExpr); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	if len(joinExpr.Join) != 2 {
+		t.Fatalf("Unexpected dashboard body: %s", string(bodyJSON))
+	}
+	parts, _ := joinExpr.Join[1].([]interface{})
+	var resolved strings.Builder
+	for _, eachPart := range parts {
+		switch typedPart := eachPart.(type) {
+		case string:
+			resolved.WriteString(typedPart)
+		default:
+			resolved.WriteString("Intrinsic")
+		}
+	}
+	return resolved.String()
+}
+
+func TestDashboardDecorator(t *testing.T) {
+	var lambdaFunctions []*sparta.LambdaAWSInfo
+	for i := 0; i != 4; i++ {
+		lambdaFn := sparta.HandleAWSLambda(sparta.LambdaName(dashboardTestLambda),
+			dashboardTestLambda,
+			sparta.IAMRoleDefinition{})
+		lambdaFn.Options.SpartaOptions = &sparta.SpartaOptions{
+			Name: strings.Repeat("Dashboard", i+1),
+		}
+		lambdaFunctions = append(lambdaFunctions, lambdaFn)
+	}
+	apiName := `My "quoted" API`
+	cfTemplate := gocf.NewTemplate()
+	cfTemplate.AddResource("MyRestAPI", &gocf.APIGatewayRestAPI{
+		Name: gocf.String(apiName),
+	})
+	decorator := DashboardDecorator(lambdaFunctions, 60)
+	decoratorErr := decorator(map[string]interface{}{},
+		"DashboardService",
+		cfTemplate,
+		"weagle",
+		"buildID",
+		nil,
+		true,
+		logrus.New())
+	if decoratorErr != nil {
+		t.Fatalf("Failed to decorate dashboard: %s", decoratorErr)
+	}
+	var dashboard *gocf.CloudWatchDashboard
+	for _, eachResource := range cfTemplate.Resources {
+		if typedDashboard, isDashboard := eachResource.Properties.(*gocf.CloudWatchDashboard); isDashboard {
+			dashboard = typedDashboard
+		}
+	}
+	if dashboard == nil {
+		t.Fatal("Failed to add dashboard resource")
+	}
+	if _, outputExists := cfTemplate.Outputs[OutputDashboardURL]; !outputExists {
+		t.Errorf("Missing %s Output", OutputDashboardURL)
+	}
+
+	dashboardBody := resolveDashboardBody(t, dashboard.DashboardBody)
+	var dashboardJSON struct {
+		Widgets []struct {
+			Type       string
+			X          int
+			Y          int
+			Properties struct {
+				Title   string
+				Metrics [][]interface{}
+			}
+		}
+	}
+	if unmarshalErr := json.Unmarshal([]byte(dashboardBody), &dashboardJSON); unmarshalErr != nil {
+		t.Fatalf("Invalid dashboard JSON: %s\n%s", unmarshalErr, dashboardBody)
+	}
+	// Two header widgets, one per function, and one per API
+	if len(dashboardJSON.Widgets) != 2+len(lambdaFunctions)+1 {
+		t.Fatalf("Unexpected widget count: %d", len(dashboardJSON.Widgets))
+	}
+	metricWidgets := dashboardJSON.Widgets[2:]
+	for index, eachWidget := range metricWidgets {
+		expectedX := metricWidthUnits * (index % metricsPerRow)
+		expectedY := headerHeightUnits + (1+index/metricsPerRow)*metricHeightUnits
+		if eachWidget.X != expectedX || eachWidget.Y != expectedY {
+			t.Errorf("Unexpected widget %d position. Expected: (%d, %d), Actual: (%d, %d)",
+				index,
+				expectedX,
+				expectedY,
+				eachWidget.X,
+				eachWidget.Y)
+		}
+	}
+	lambdaMetrics := metricWidgets[0].Properties.Metrics
+	if len(lambdaMetrics) != 4 || lambdaMetrics[3][1] != "Duration" {
+		t.Errorf("Missing Duration metric: %v", lambdaMetrics)
+	}
+	apiWidget := metricWidgets[len(metricWidgets)-1]
+	if apiWidget.Properties.Title != "API Gateway: "+apiName {
+		t.Errorf("Unexpected API widget title: %s", apiWidget.Properties.Title)
+	}
+	if len(apiWidget.Properties.Metrics) != 3 ||
+		apiWidget.Properties.Metrics[0][0] != "AWS/ApiGateway" ||
+		apiWidget.Properties.Metrics[0][3] != apiName {
+		t.Errorf("Unexpected API widget metrics: %v", apiWidget.Properties.Metrics)
+	}
+}
@@ -3,21 +3,120 @@
 package sparta
 
 import (
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
 	"github.com/sirupsen/logrus"
 
 	spartaAWS "github.com/mweagle/Sparta/aws"
 )
 
+// stackArtifact is an S3 object referenced by a deployed stack
+type stackArtifact struct {
+	bucket  string
+	key     string
+	version string
+}
+
+// stackCodeArtifacts returns the S3 code artifacts referenced by the
+// functions in the deployed serviceName template. Only literal
+// bucket and key values are returned.
+func stackCodeArtifacts(serviceName string,
+	cfSvc spartaCF.CloudFormationAPI) ([]*stackArtifact, error) {
+	deployedResources, deployedResourcesErr := spartaCF.StackTemplateResources(serviceName,
+		cfSvc)
+	if nil != deployedResourcesErr {
+		return nil, deployedResourcesErr
+	}
+	artifacts := []*stackArtifact{}
+	seenArtifacts := make(map[stackArtifact]bool)
+	for _, eachResource := range deployedResources {
+		resource, _ := eachResource.(map[string]interface{})
+		if resource == nil || resource["Type"] != "AWS::Lambda::Function" {
+			continue
+		}
+		properties, _ := resource["Properties"].(map[string]interface{})
+		if properties == nil {
+			continue
+		}
+		code, _ := properties["Code"].(map[string]interface{})
+		if code == nil {
+			continue
+		}
+		bucket, _ := code["S3Bucket"].(string)
+		key, _ := code["S3Key"].(string)
+		version, _ := code["S3ObjectVersion"].(string)
+		if bucket == "" || key == "" {
+			continue
+		}
+		artifact := stackArtifact{
+			bucket:  bucket,
+			key:     key,
+			version: version,
+		}
+		if !seenArtifacts[artifact] {
+			seenArtifacts[artifact] = true
+			artifacts = append(artifacts, &artifact)
+		}
+	}
+	return artifacts, nil
+}
+
+// deleteStackArtifacts deletes the S3 artifacts. Failures are logged, but
+// don't fail the delete since the stack is already gone
+func deleteStackArtifacts(artifacts []*stackArtifact,
+	s3Svc s3iface.S3API,
+	logger *logrus.Logger) {
+	for _, eachArtifact := range artifacts {
+		deleteObjectInput := &s3.DeleteObjectInput{
+			Bucket: aws.String(eachArtifact.bucket),
+			Key:    aws.String(eachArtifact.key),
+		}
+		if eachArtifact.version != "" {
+			deleteObjectInput.VersionId = aws.String(eachArtifact.version)
+		}
+		_, deleteErr := s3Svc.DeleteObject(deleteObjectInput)
+		logEntry := logger.WithFields(logrus.Fields{
+			"Bucket":  eachArtifact.bucket,
+			"Key":     eachArtifact.key,
+			"Version": eachArtifact.version,
+		})
+		if nil != deleteErr {
+			logEntry.WithField("Error", deleteErr).Warn("Failed to delete service artifact")
+		} else {
+			logEntry.Info("Deleted service artifact")
+		}
+	}
+}
+
 // Delete the provided serviceName.  Failing to delete a non-existent
-// service is not considered an error.  Note that the delete does
+// service is not considered an error. Delete waits for the stack delete
+// to complete and then deletes the function code artifacts that the
+// stack referenced. Other uploaded artifacts, such as the template,
+// aren't discoverable from the stack and aren't deleted.
 func Delete(serviceName string, logger *logrus.Logger) error {
 	session := spartaAWS.NewSession(logger)
-	awsCloudFormation := cloudformation.New(session)
+	return deleteService(serviceName,
+		cloudformation.New(session),
+		s3.New(session),
+		nil,
+		logger)
+}
+
+// deleteService deletes the serviceName stack and its code artifacts with
+// the cfSvc and s3Svc clients. The optional pollInterval sets the delay
+// between the stack delete status checks.
+func deleteService(serviceName string,
+	cfSvc spartaCF.CloudFormationAPI,
+	s3Svc s3iface.S3API,
+	pollInterval *spartaCF.StackPollInterval,
+	logger *logrus.Logger) error {
 
-	exists, err := spartaCF.StackExists(serviceName, session, logger)
+	exists, err := spartaCF.StackExistsWithClient(serviceName, cfSvc, logger)
 	if nil != err {
 		return err
 	}
@@ -27,17 +126,54 @@ func Delete(serviceName string, logger *logrus.Logger) error {
 	}).Info("Stack existence check")
 
 	if exists {
+		// Deleted stacks can only be described by ID
+		describeStacksOutput, describeStacksErr := cfSvc.DescribeStacks(&cloudformation.DescribeStacksInput{
+			StackName: aws.String(serviceName),
+		})
+		if nil != describeStacksErr {
+			return describeStacksErr
+		}
+		if len(describeStacksOutput.Stacks) <= 0 {
+			logger.Info("Stack does not exist")
+			return nil
+		}
+		stackID := aws.StringValue(describeStacksOutput.Stacks[0].StackId)
+
+		// Find the artifacts before the template is gone
+		artifacts, artifactsErr := stackCodeArtifacts(serviceName, cfSvc)
+		if nil != artifactsErr {
+			logger.WithFields(logrus.Fields{
+				"Error": artifactsErr,
+			}).Warn("Failed to discover service artifacts")
+		}
 
+		startTime := time.Now()
 		params := &cloudformation.DeleteStackInput{
 			StackName: aws.String(serviceName),
 		}
-		resp, err := awsCloudFormation.DeleteStack(params)
+		resp, err := cfSvc.DeleteStack(params)
 		if nil != resp {
 			logger.WithFields(logrus.Fields{
 				"Response": resp,
 			}).Info("Delete request submitted")
 		}
-		return err
+		if nil != err {
+			return err
+		}
+		waitErr := spartaCF.WaitForStackDeleteCompleteWithClient(stackID,
+			startTime,
+			pollInterval,
+			cfSvc,
+			logger)
+		if nil != waitErr {
+			return waitErr
+		}
+		logger.WithFields(logrus.Fields{
+			"Name":     serviceName,
+			"Duration": time.Since(startTime).String(),
+		}).Info("Stack deleted")
+		deleteStackArtifacts(artifacts, s3Svc, logger)
+		return nil
 	}
 	logger.Info("Stack does not exist")
 	return nil
@@ -0,0 +1,93 @@
+package sparta
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+func TestDeleteService(t *testing.T) {
+	templateBody, templateBodyErr := json.Marshal(map[string]interface{}{
+		"Resources": map[string]interface{}{
+			"FunctionOne": map[string]interface{}{
+				"Type": "AWS::Lambda::Function",
+				"Properties": map[string]interface{}{
+					"Code": map[string]interface{}{
+						"S3Bucket":        "weagle",
+						"S3Key":           "MyService/code.zip",
+						"S3ObjectVersion": "v1",
+					},
+				},
+			},
+			// Functions that share the archive are only deleted once
+			"FunctionTwo": map[string]interface{}{
+				"Type": "AWS::Lambda::Function",
+				"Properties": map[string]interface{}{
+					"Code": map[string]interface{}{
+						"S3Bucket":        "weagle",
+						"S3Key":           "MyService/code.zip",
+						"S3ObjectVersion": "v1",
+					},
+				},
+			},
+			"Queue": map[string]interface{}{
+				"Type": "AWS::SQS::Queue",
+			},
+		},
+	})
+	if templateBodyErr != nil {
+		t.Fatal(templateBodyErr)
+	}
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+	pollInterval := &spartaCF.StackPollInterval{Interval: time.Millisecond}
+
+	fakeCF := &fakeCFAPI{
+		templateBody: string(templateBody),
+		deleteStatus: cloudformation.StackStatusDeleteComplete,
+	}
+	fakeS3 := &fakeS3API{}
+	deleteErr := deleteService("MyService", fakeCF, fakeS3, pollInterval, logger)
+	if deleteErr != nil {
+		t.Fatalf("Failed to delete service: %s", deleteErr)
+	}
+	if fakeCF.deleteStackCount != 1 {
+		t.Errorf("Unexpected DeleteStack count: %d", fakeCF.deleteStackCount)
+	}
+	if len(fakeS3.deletedObjects) != 1 {
+		t.Fatalf("Unexpected deleted artifacts: %v", fakeS3.deletedObjects)
+	}
+	deletedObject := fakeS3.deletedObjects[0]
+	if aws.StringValue(deletedObject.Bucket) != "weagle" ||
+		aws.StringValue(deletedObject.Key) != "MyService/code.zip" ||
+		aws.StringValue(deletedObject.VersionId) != "v1" {
+		t.Errorf("Unexpected deleted artifact: %s", deletedObject)
+	}
+
+	// A failed delete reports the failed resources and keeps the artifacts
+	fakeCF = &fakeCFAPI{
+		templateBody: string(templateBody),
+		deleteStatus: cloudformation.StackStatusDeleteFailed,
+	}
+	fakeS3 = &fakeS3API{}
+	deleteErr = deleteService("MyService", fakeCF, fakeS3, pollInterval, logger)
+	if deleteErr == nil || !strings.Contains(deleteErr.Error(), "ArtifactBucket") {
+		t.Fatalf("Unexpected failed delete error: %v", deleteErr)
+	}
+	if len(fakeS3.deletedObjects) != 0 {
+		t.Errorf("Unexpected artifact delete after failed stack delete: %v", fakeS3.deletedObjects)
+	}
+
+	// Services that don't exist aren't deleted
+	fakeCF = &fakeCFAPI{}
+	deleteErr = deleteService("MyService", fakeCF, &fakeS3API{}, pollInterval, logger)
+	if deleteErr != nil || fakeCF.deleteStackCount != 0 {
+		t.Errorf("Unexpected delete of missing service: %v, %d", deleteErr, fakeCF.deleteStackCount)
+	}
+}
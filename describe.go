@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -22,9 +24,98 @@ const (
 	nodeColorEventSource = "#FBBB06"
 	nodeColorLambda      = "#F58206"
 	nodeColorAPIGateway  = "#06B5F5"
+	nodeColorIAMRole     = "#759C3E"
+	nodeColorResource    = "#D9D9D9"
+	nodeColorOutput      = "#FFFFFF"
 	nodeNameAPIGateway   = "API Gateway"
 )
 
+// describedTemplate is the subset of the provisioned CloudFormation
+// template used to render resource relationships
+type describedTemplate struct {
+	Resources map[string]*describedResource
+	Outputs   map[string]*describedOutput
+}
+
+// describedResource is a single template resource
+type describedResource struct {
+	Type       string
+	DependsOn  interface{}
+	Properties map[string]interface{}
+}
+
+// dependsOn returns the resource's DependsOn names, which may be
+// expressed as either a single name or a list of names
+func (resource *describedResource) dependsOn() []string {
+	switch typedDependsOn := resource.DependsOn.(type) {
+	case string:
+		return []string{typedDependsOn}
+	case []interface{}:
+		dependencies := make([]string, 0, len(typedDependsOn))
+		for _, eachDependency := range typedDependsOn {
+			if dependencyName, dependencyNameOk := eachDependency.(string); dependencyNameOk {
+				dependencies = append(dependencies, dependencyName)
+			}
+		}
+		return dependencies
+	}
+	return nil
+}
+
+// describedOutput is a single template output
+type describedOutput struct {
+	Value interface{}
+}
+
+// parseDescribedTemplate unmarshals the template body produced by the
+// noop provisioning step
+func parseDescribedTemplate(templateBody []byte) (*describedTemplate, error) {
+	parsedTemplate := &describedTemplate{}
+	unmarshalErr := json.Unmarshal(templateBody, parsedTemplate)
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("Failed to parse CloudFormation template: %s", unmarshalErr)
+	}
+	return parsedTemplate, nil
+}
+
+// sortedResourceNames returns the sorted resource names so that the output
+// is stable across runs
+func (parsedTemplate *describedTemplate) sortedResourceNames() []string {
+	resourceNames := make([]string, 0, len(parsedTemplate.Resources))
+	for eachName := range parsedTemplate.Resources {
+		resourceNames = append(resourceNames, eachName)
+	}
+	sort.Strings(resourceNames)
+	return resourceNames
+}
+
+// sortedOutputNames returns the sorted output names
+func (parsedTemplate *describedTemplate) sortedOutputNames() []string {
+	outputNames := make([]string, 0, len(parsedTemplate.Outputs))
+	for eachName := range parsedTemplate.Outputs {
+		outputNames = append(outputNames, eachName)
+	}
+	sort.Strings(outputNames)
+	return outputNames
+}
+
+// resourceNodeColor returns the node color for the CloudFormation
+// resource type
+func resourceNodeColor(resourceType string) string {
+	switch {
+	case resourceType == "AWS::Lambda::Function":
+		return nodeColorLambda
+	case resourceType == "AWS::IAM::Role":
+		return nodeColorIAMRole
+	case resourceType == "AWS::Lambda::Permission",
+		resourceType == "AWS::Lambda::EventSourceMapping":
+		return nodeColorEventSource
+	case strings.HasPrefix(resourceType, "AWS::ApiGateway::"):
+		return nodeColorAPIGateway
+	}
+	return nodeColorResource
+}
+
 type templateResource struct {
 	KeyName string
 	Data    string
@@ -137,10 +228,114 @@ func templateImageMap(logger *logrus.Logger) map[string]string {
 	return imageMap
 }
 
-// Describe produces a graphical representation of a service's Lambda and data sources.  Typically
-// automatically called as part of a compiled golang binary via the `describe` command
-// line option.
-func Describe(serviceName string,
+// writeTemplateRelationships adds the IAM roles, DependsOn edges, and
+// Outputs wiring from the provisioned template to the Mermaid graph.
+func writeTemplateRelationships(writer io.Writer,
+	parsedTemplate *describedTemplate,
+	lambdaAWSInfos []*LambdaAWSInfo) {
+
+	// Lambda functions are already in the graph under their function names
+	nodeNames := make(map[string]string)
+	for _, eachLambda := range lambdaAWSInfos {
+		nodeNames[eachLambda.LogicalResourceName()] = eachLambda.lambdaFunctionName()
+	}
+	writtenNodes := make(map[string]bool)
+	resourceNode := func(logicalName string, nodeColor string) string {
+		nodeName, nodeNameExists := nodeNames[logicalName]
+		if nodeNameExists {
+			return nodeName
+		}
+		if !writtenNodes[logicalName] {
+			writeNode(writer, logicalName, nodeColor, "border-style:dashed")
+			writtenNodes[logicalName] = true
+		}
+		return logicalName
+	}
+
+	for _, eachName := range parsedTemplate.sortedResourceNames() {
+		lambdaName, isLambda := nodeNames[eachName]
+		if !isLambda {
+			continue
+		}
+		eachResource := parsedTemplate.Resources[eachName]
+		roleNames := spartaCF.TemplateReferences(eachResource.Properties["Role"])
+		for _, eachRole := range roleNames {
+			if _, roleExists := parsedTemplate.Resources[eachRole]; roleExists {
+				writeLink(writer, resourceNode(eachRole, nodeColorIAMRole), lambdaName, "Role")
+			}
+		}
+		for _, eachDependency := range eachResource.dependsOn() {
+			dependency, dependencyExists := parsedTemplate.Resources[eachDependency]
+			if !dependencyExists || dependency.Type == "AWS::IAM::Role" {
+				continue
+			}
+			writeLink(writer,
+				lambdaName,
+				resourceNode(eachDependency, resourceNodeColor(dependency.Type)),
+				"DependsOn")
+		}
+	}
+	for _, eachName := range parsedTemplate.sortedOutputNames() {
+		outputNode := fmt.Sprintf("Output: %s", eachName)
+		writeNode(writer, outputNode, nodeColorOutput, "")
+		for _, eachRef := range spartaCF.TemplateReferences(parsedTemplate.Outputs[eachName].Value) {
+			if resource, resourceExists := parsedTemplate.Resources[eachRef]; resourceExists {
+				writeLink(writer, resourceNode(eachRef, resourceNodeColor(resource.Type)), outputNode, "")
+			}
+		}
+	}
+}
+
+// writeGraphviz writes the Graphviz DOT representation of the template
+// resources. Solid edges are Ref and Fn::GetAtt references, dashed edges
+// are DependsOn relationships, and dotted edges are Outputs wiring.
+func writeGraphviz(writer io.Writer, serviceName string, parsedTemplate *describedTemplate) {
+	fmt.Fprintf(writer, "digraph %s {\n", strconv.Quote(serviceName))
+	fmt.Fprintf(writer, "  rankdir=LR;\n")
+	fmt.Fprintf(writer, "  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	for _, eachName := range parsedTemplate.sortedResourceNames() {
+		eachResource := parsedTemplate.Resources[eachName]
+		fmt.Fprintf(writer, "  %s [label=%s, fillcolor=%s];\n",
+			strconv.Quote(eachName),
+			strconv.Quote(fmt.Sprintf("%s\n%s", eachName, eachResource.Type)),
+			strconv.Quote(resourceNodeColor(eachResource.Type)))
+	}
+	for _, eachName := range parsedTemplate.sortedResourceNames() {
+		eachResource := parsedTemplate.Resources[eachName]
+		for _, eachRef := range spartaCF.TemplateReferences(eachResource.Properties) {
+			if _, refExists := parsedTemplate.Resources[eachRef]; refExists {
+				fmt.Fprintf(writer, "  %s -> %s;\n",
+					strconv.Quote(eachName),
+					strconv.Quote(eachRef))
+			}
+		}
+		for _, eachDependency := range eachResource.dependsOn() {
+			if _, dependencyExists := parsedTemplate.Resources[eachDependency]; dependencyExists {
+				fmt.Fprintf(writer, "  %s -> %s [style=dashed, label=\"DependsOn\"];\n",
+					strconv.Quote(eachName),
+					strconv.Quote(eachDependency))
+			}
+		}
+	}
+	for _, eachName := range parsedTemplate.sortedOutputNames() {
+		outputNode := fmt.Sprintf("Output: %s", eachName)
+		fmt.Fprintf(writer, "  %s [shape=note, fillcolor=%s];\n",
+			strconv.Quote(outputNode),
+			strconv.Quote(nodeColorOutput))
+		for _, eachRef := range spartaCF.TemplateReferences(parsedTemplate.Outputs[eachName].Value) {
+			if _, refExists := parsedTemplate.Resources[eachRef]; refExists {
+				fmt.Fprintf(writer, "  %s -> %s [style=dotted];\n",
+					strconv.Quote(eachRef),
+					strconv.Quote(outputNode))
+			}
+		}
+	}
+	fmt.Fprintf(writer, "}\n")
+}
+
+// describeServiceTemplate returns the CloudFormation template that would be
+// provisioned for the service. No AWS resources are created.
+func describeServiceTemplate(serviceName string,
 	serviceDescription string,
 	lambdaAWSInfos []*LambdaAWSInfo,
 	api *API,
@@ -148,13 +343,12 @@ func Describe(serviceName string,
 	s3BucketName string,
 	buildTags string,
 	linkFlags string,
-	outputWriter io.Writer,
 	workflowHooks *WorkflowHooks,
-	logger *logrus.Logger) error {
+	logger *logrus.Logger) ([]byte, error) {
 
 	validationErr := validateSpartaPreconditions(lambdaAWSInfos, logger)
 	if validationErr != nil {
-		return validationErr
+		return nil, validationErr
 	}
 
 	var cloudFormationTemplate bytes.Buffer
@@ -174,6 +368,83 @@ func Describe(serviceName string,
 		&cloudFormationTemplate,
 		workflowHooks,
 		logger)
+	if nil != err {
+		return nil, err
+	}
+	return cloudFormationTemplate.Bytes(), nil
+}
+
+// DescribeGraphviz writes a Graphviz DOT representation of the service's
+// CloudFormation resources, their DependsOn relationships, and Outputs to
+// outputWriter. The template is built as in `provision --noop`. Render the
+// output with the Graphviz `dot` tool (eg, `dot -Tsvg`).
+func DescribeGraphviz(serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api *API,
+	s3Site *S3Site,
+	s3BucketName string,
+	buildTags string,
+	linkFlags string,
+	outputWriter io.Writer,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) error {
+
+	cloudFormationTemplate, err := describeServiceTemplate(serviceName,
+		serviceDescription,
+		lambdaAWSInfos,
+		api,
+		s3Site,
+		s3BucketName,
+		buildTags,
+		linkFlags,
+		workflowHooks,
+		logger)
+	if nil != err {
+		return err
+	}
+	parsedTemplate, err := parseDescribedTemplate(cloudFormationTemplate)
+	if nil != err {
+		return err
+	}
+	writeGraphviz(outputWriter, serviceName, parsedTemplate)
+	return nil
+}
+
+// Describe produces a graphical representation of a service's Lambda and data sources.  Typically
+// automatically called as part of a compiled golang binary via the `describe` command
+// line option.
+func Describe(serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api *API,
+	s3Site *S3Site,
+	s3BucketName string,
+	buildTags string,
+	linkFlags string,
+	outputWriter io.Writer,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) error {
+
+	cloudFormationTemplate, err := describeServiceTemplate(serviceName,
+		serviceDescription,
+		lambdaAWSInfos,
+		api,
+		s3Site,
+		s3BucketName,
+		buildTags,
+		linkFlags,
+		workflowHooks,
+		logger)
+	if nil != err {
+		return err
+	}
+	parsedTemplate, err := parseDescribedTemplate(cloudFormationTemplate)
+	if nil != err {
+		return err
+	}
+	// The HTML template parses the template from a JSON string literal
+	templateLiteral, err := json.Marshal(string(cloudFormationTemplate))
 	if nil != err {
 		return err
 	}
@@ -245,6 +516,8 @@ func Describe(serviceName string,
 			}
 		}
 	}
+	// IAM roles, dependencies, and outputs from the template
+	writeTemplateRelationships(&b, parsedTemplate, lambdaAWSInfos)
 
 	params := struct {
 		SpartaVersion          string
@@ -259,7 +532,7 @@ func Describe(serviceName string,
 		SpartaGitHash[0:8],
 		serviceName,
 		serviceDescription,
-		cloudFormationTemplate.String(),
+		string(templateLiteral),
 		templateCSSFiles(logger),
 		templateJSFiles(logger),
 		templateImageMap(logger),
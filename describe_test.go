@@ -1,7 +1,9 @@
 package sparta
 
 import (
+	"bytes"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -28,3 +30,44 @@ func TestDescribe(t *testing.T) {
 		t.Errorf("Failed to describe: %s", err)
 	}
 }
+
+func TestDescribeTemplateRelationships(t *testing.T) {
+	templateBody := []byte(`{
+		"Resources": {
+			"HelloLambda": {
+				"Type": "AWS::Lambda::Function",
+				"DependsOn": ["HelloRole", "HelloQueue"],
+				"Properties": {
+					"Role": {"Fn::GetAtt": ["HelloRole", "Arn"]}
+				}
+			},
+			"HelloRole": {
+				"Type": "AWS::IAM::Role"
+			},
+			"HelloQueue": {
+				"Type": "AWS::SQS::Queue"
+			}
+		},
+		"Outputs": {
+			"FunctionARN": {
+				"Value": {"Fn::GetAtt": ["HelloLambda", "Arn"]}
+			}
+		}
+	}`)
+	parsedTemplate, parsedTemplateErr := parseDescribedTemplate(templateBody)
+	if parsedTemplateErr != nil {
+		t.Fatalf("Failed to parse template: %s", parsedTemplateErr)
+	}
+	var graphviz bytes.Buffer
+	writeGraphviz(&graphviz, "SampleService", parsedTemplate)
+	expectedLines := []string{
+		`"HelloLambda" -> "HelloRole";`,
+		`"HelloLambda" -> "HelloQueue" [style=dashed, label="DependsOn"];`,
+		`"HelloLambda" -> "Output: FunctionARN" [style=dotted];`,
+	}
+	for _, eachLine := range expectedLines {
+		if !strings.Contains(graphviz.String(), eachLine) {
+			t.Errorf("Graphviz output missing edge: %s\n%s", eachLine, graphviz.String())
+		}
+	}
+}
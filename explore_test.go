@@ -0,0 +1,42 @@
+package sparta
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCheckPayloadSize(t *testing.T) {
+	var logOutput bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &logOutput
+
+	if sizeErr := checkPayloadSize("Request", []byte("{}"), logger); sizeErr != nil {
+		t.Fatalf("Failed to accept small payload: %s", sizeErr)
+	}
+	if logOutput.Len() != 0 {
+		t.Errorf("Unexpected warning for small payload: %s", logOutput.String())
+	}
+
+	// Payloads approaching the limit are logged
+	nearLimit := bytes.Repeat([]byte("a"), lambdaSyncPayloadLimit)
+	if sizeErr := checkPayloadSize("Request", nearLimit, logger); sizeErr != nil {
+		t.Fatalf("Failed to accept payload at the limit: %s", sizeErr)
+	}
+	if !strings.Contains(logOutput.String(), "approaching the synchronous Lambda payload limit") {
+		t.Errorf("Missing payload size warning: %s", logOutput.String())
+	}
+
+	// Payloads over the limit are rejected
+	overLimit := bytes.Repeat([]byte("a"), lambdaSyncPayloadLimit+1)
+	sizeErr := checkPayloadSize("Response", overLimit, logger)
+	if sizeErr == nil {
+		t.Fatal("Failed to reject payload over the limit")
+	}
+	if !strings.Contains(sizeErr.Error(), "Response payload size") ||
+		!strings.Contains(sizeErr.Error(), "exceeds the synchronous Lambda payload limit") {
+		t.Errorf("Unexpected payload size error: %s", sizeErr)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	broadcast "github.com/dustin/go-broadcast"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/gdamore/tcell"
 	"github.com/hokaccha/go-prettyjson"
 	spartaCWLogs "github.com/mweagle/Sparta/aws/cloudwatchlogs"
@@ -43,6 +44,35 @@ const (
 	settingSelectedEvent = "selectedEvent"
 )
 
+const (
+	// lambdaSyncPayloadLimit is the maximum size of a synchronous
+	// Lambda request or response payload
+	lambdaSyncPayloadLimit = 6 * 1024 * 1024
+	// lambdaSyncPayloadWarningRatio is the fraction of the payload limit
+	// that triggers a warning
+	lambdaSyncPayloadWarningRatio = 0.8
+)
+
+// checkPayloadSize logs a warning if the payload is approaching the
+// synchronous Lambda payload limit and returns an error if it exceeds it
+func checkPayloadSize(payloadType string, payload []byte, logger *logrus.Logger) error {
+	payloadSize := humanize.Bytes(uint64(len(payload)))
+	payloadLimit := humanize.Bytes(lambdaSyncPayloadLimit)
+	if len(payload) > lambdaSyncPayloadLimit {
+		return errors.Errorf("%s payload size (%s) exceeds the synchronous Lambda payload limit (%s)",
+			payloadType,
+			payloadSize,
+			payloadLimit)
+	}
+	if float64(len(payload)) >= lambdaSyncPayloadWarningRatio*lambdaSyncPayloadLimit {
+		logger.WithFields(logrus.Fields{
+			"Size":  payloadSize,
+			"Limit": payloadLimit,
+		}).Warn(payloadType + " payload size is approaching the synchronous Lambda payload limit")
+	}
+	return nil
+}
+
 func settingsFile() string {
 	return filepath.Join(ScratchDirectory, "explore-settings.json")
 }
@@ -209,6 +239,26 @@ func newEventInputSelector(awsSession *session.Session,
 		logger.WithError(walkErr).Error("Failed to find JSON files in directory: " + curDir)
 		return nil, nil
 	}
+	// Then add the named test event fixtures
+	fixtureData := make(map[string][]byte)
+	for _, eachLambda := range lambdaAWSInfos {
+		fixtures, fixturesErr := eachLambda.TestEventFixtures()
+		if fixturesErr != nil {
+			logger.WithError(fixturesErr).Error("Failed to load test event fixtures")
+			continue
+		}
+		fixtureNames := []string{}
+		for eachName := range fixtures {
+			fixtureNames = append(fixtureNames, eachName)
+		}
+		sort.Strings(fixtureNames)
+		for _, eachName := range fixtureNames {
+			fixtureLabel := fmt.Sprintf("λ %s: %s", eachLambda.lambdaFunctionName(), eachName)
+			fixtureData[fixtureLabel] = fixtures[eachName]
+			jsonFiles = append(jsonFiles, fixtureLabel)
+			logger.WithField("Fixture", fixtureLabel).Debug("Event fixture found")
+		}
+	}
 	// Create all the views...
 	var selectedJSONData []byte
 	selectedInput := 0
@@ -234,9 +284,13 @@ func newEventInputSelector(awsSession *session.Session,
 		eventDataView.Clear()
 		// Save it...
 		saveSetting(settingSelectedEvent, value)
-		fullPath := curDir + value
-		/* #nosec */
-		jsonFile, jsonFileErr := ioutil.ReadFile(fullPath)
+		jsonFile, jsonFileExists := fixtureData[value]
+		var jsonFileErr error
+		if !jsonFileExists {
+			fullPath := curDir + value
+			/* #nosec */
+			jsonFile, jsonFileErr = ioutil.ReadFile(fullPath)
+		}
 		if jsonFileErr != nil {
 			writePrettyString(eventDataView, jsonFileErr.Error())
 		} else {
@@ -257,6 +311,11 @@ func newEventInputSelector(awsSession *session.Session,
 		}
 		// Submit it to lambda
 		if activeFunction != "" {
+			requestSizeErr := checkPayloadSize("Request", selectedJSONData, logger)
+			if requestSizeErr != nil {
+				logger.WithError(requestSizeErr).Error("Failed to invoke Lambda function")
+				return
+			}
 			lambdaInput := &lambda.InvokeInput{
 				FunctionName: aws.String(activeFunction),
 				Payload:      selectedJSONData,
@@ -271,6 +330,10 @@ func newEventInputSelector(awsSession *session.Session,
 					"Error": invokeOutput.FunctionError,
 				}).Error("Lambda function produced an error")
 			} else {
+				responseSizeErr := checkPayloadSize("Response", invokeOutput.Payload, logger)
+				if responseSizeErr != nil {
+					logger.WithError(responseSizeErr).Warn("Lambda function response exceeds payload limit")
+				}
 				var m interface{}
 
 				jsonErr := json.Unmarshal(invokeOutput.Payload, &m)
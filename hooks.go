@@ -83,6 +83,17 @@ type TemplateDecoratorHandler interface {
 		logger *logrus.Logger) error
 }
 
+// LambdaResourceDecorator mutates the AWS::Lambda::Function resource that
+// Sparta generates for a LambdaAWSInfo. Unlike a TemplateDecorator, changes
+// to the function properties (eg, MemorySize, Timeout, VpcConfig) are
+// preserved. The resource param provides the resource attributes
+// (eg, DependsOn, DeletionPolicy). Its Properties value is managed by Sparta
+// and must not be replaced. The function Code is also managed by Sparta.
+type LambdaResourceDecorator func(function *gocf.LambdaFunction,
+	resource *gocf.Resource,
+	template *gocf.Template,
+	logger *logrus.Logger) error
+
 ////////////////////////////////////////////////////////////////////////////////
 // WorkflowHandler
 
@@ -283,3 +294,22 @@ type RollbackHookHandler interface {
 		noop bool,
 		logger *logrus.Logger) error
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// RollbackFunction
+
+// RollbackFunction is a user-defined function that's called if the
+// provision operation fails. It's intended to cleanup any out-of-band
+// AWS state created by a decorator or hook (eg, a pre-created ECR repository).
+type RollbackFunction func(logger *logrus.Logger) error
+
+// RegisterRollbackFunction registers a RollbackFunction in the workflow
+// context map provided to TemplateDecorators, ServiceDecorators, and
+// WorkflowHooks. Registered functions are called concurrently with the
+// built-in rollback functions if the provision operation fails. Errors
+// are logged and don't stop the other rollback functions. They're returned
+// together with the provision error as a RollbackError.
+func RegisterRollbackFunction(context map[string]interface{}, rollback RollbackFunction) {
+	rollbackFunctions, _ := context[ContextKeyRollbackFunctions].([]RollbackFunction)
+	context[ContextKeyRollbackFunctions] = append(rollbackFunctions, rollback)
+}
@@ -21,11 +21,12 @@ TODO - Simplify this as part of https://trello.com/c/aOULlJcz/14-port-nodejs-cus
 Adding a new permission type?
   1. Add the principal name value to sparta.go constants
   2. Define the new struct and satisfy LambdaPermissionExporter
-  3. Update provision_utils.go's `PushSourceConfigurationActions` map with the new principal's permissions
-  4. Update `PROXIED_MODULES` in resources/index.js to include the first principal component name( eg, 'events')
-  5. Update `customResourceScripts` in provision.go to ensure the embedded JS file is included in the deployed archive.
-  6. Implement the custom type defined in 2
-  7. Implement the service configuration logic referred to in 4.
+  3. Update provision.go's `PushSourceConfigurationActions` map with the new principal's permissions
+  4. Define the push source CustomResourceCommand in aws/cloudformation/resources
+     and add its typename to `customTypeProvider`. The custom resources are
+     compiled into the service binary, so there are no scripts to embed in the archive.
+  5. Implement the custom type defined in 2
+  6. Implement the service configuration logic referred to in 4.
 */
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -223,7 +223,7 @@ func annotateEventSourceMappings(lambdaAWSInfos []*LambdaAWSInfo,
 		if !cfResourceOk {
 			return errors.Errorf("Unable to locate lambda function for annotation")
 		}
-		lambdaResource, lambdaResourceOk := cfResource.Properties.(gocf.LambdaFunction)
+		lambdaResource, lambdaResourceOk := cfResource.Properties.(LambdaFunctionResource)
 		if !lambdaResourceOk {
 			return errors.Errorf("CloudFormation resource exists, but is incorrect type: %s (%v)",
 				cfResource.Properties.CfnResourceType(),
@@ -245,8 +245,13 @@ func annotateEventSourceMappings(lambdaAWSInfos []*LambdaAWSInfo,
 				return errors.Errorf("IAM role not found: %s", resourceRef.ResourceName)
 			}
 			// Coerce to the IAMRole and update the statements
-			typedIAMRole, typedIAMRoleOk := iamRole.Properties.(gocf.IAMRole)
-			if !typedIAMRoleOk {
+			var typedIAMRole gocf.IAMRole
+			switch typedProperties := iamRole.Properties.(type) {
+			case iamRoleResource:
+				typedIAMRole = typedProperties.IAMRole
+			case gocf.IAMRole:
+				typedIAMRole = typedProperties
+			default:
 				return errors.Errorf("Failed to type convert iamRole to proper IAMRole resource")
 			}
 			policyList := typedIAMRole.Policies
@@ -5,7 +5,15 @@ package sparta
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
@@ -13,28 +21,39 @@ import (
 	"go/parser"
 	"go/token"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
 	humanize "github.com/dustin/go-humanize"
 	spartaAWS "github.com/mweagle/Sparta/aws"
 	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
 	spartaS3 "github.com/mweagle/Sparta/aws/s3"
 	spartaZip "github.com/mweagle/Sparta/zip"
-	gocc "github.com/mweagle/go-cloudcondenser"
 	gocf "github.com/mweagle/go-cloudformation"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -58,6 +77,48 @@ var (
 	SpartaTagBuildTagsKey = spartaTagName("buildTags")
 )
 
+const (
+	// OutputResourceCount is the keyname of the Output that stores the
+	// number of resources in the service's CloudFormation template.
+	// See WithStackResourceCountOutput
+	OutputResourceCount = "ResourceCount"
+	// OutputResourceTypeCounts is the keyname of the Output that stores the
+	// JSON encoded map of resource type to count in the service's CloudFormation
+	// template. See WithStackResourceCountOutput
+	OutputResourceTypeCounts = "ResourceTypeCounts"
+	// OutputSpartaBuildID is the keyname of the Output that stores the
+	// user-supplied or automatically generated BuildID of the provision
+	OutputSpartaBuildID = "SpartaBuildID"
+	// OutputSpartaGitCommit is the keyname of the Output that stores the
+	// git commit SHA of the provisioned source. The Output is omitted if
+	// the SHA can't be determined.
+	OutputSpartaGitCommit = "SpartaGitCommit"
+	// OutputSpartaBuildTime is the keyname of the Output that stores the
+	// RFC3339 UTC timestamp of the provision
+	OutputSpartaBuildTime = "SpartaBuildTime"
+	// maxStackResourceCount is the CloudFormation per-stack resource limit
+	maxStackResourceCount = 500
+	// defaultAccountConcurrencyLimit is the default Lambda concurrent
+	// executions limit of an account. See WithConcurrencyLimitHint
+	defaultAccountConcurrencyLimit = 1000
+	// minUnreservedConcurrency is the number of concurrent executions
+	// that Lambda requires to remain unreserved
+	minUnreservedConcurrency = 100
+	// deploymentHistoryKeyName is the basename of the deployment history
+	// object in the artifact bucket
+	deploymentHistoryKeyName = "deployment-history.jsonl"
+	// deploymentHistoryKeyPrefix is the key prefix of the deployment history
+	// objects. Stack names can't include an underscore, so it never matches
+	// a service's artifact prefix or artifact expiration rule.
+	deploymentHistoryKeyPrefix = "_sparta/"
+	// minimumGoMajorVersion and minimumGoMinorVersion define the oldest
+	// Go toolchain supported for building the Lambda binary
+	minimumGoMajorVersion = 1
+	minimumGoMinorVersion = 10
+)
+
+var reGoVersion = regexp.MustCompile(`go(\d+)\.(\d+)`)
+
 // finalizerFunction is the type of function pushed onto the cleanup stack
 type finalizerFunction func(logger *logrus.Logger)
 
@@ -145,6 +206,37 @@ type userdata struct {
 	s3SiteContext *s3SiteContext
 	// The user-supplied S3 bucket where service artifacts should be posted.
 	s3Bucket string
+	// Service-wide options from the user-supplied ProvisionOption values
+	options *provisionOptions
+}
+
+// cfAPI is the subset of the CloudFormation client used by the workflow
+// steps
+type cfAPI interface {
+	spartaCF.CloudFormationAPI
+	DescribeStackResourceWithContext(aws.Context, *cloudformation.DescribeStackResourceInput, ...request.Option) (*cloudformation.DescribeStackResourceOutput, error)
+}
+
+// iamAPI is the subset of the IAM client used by the workflow steps
+type iamAPI interface {
+	GetRole(*iam.GetRoleInput) (*iam.GetRoleOutput, error)
+}
+
+// s3API is the S3 client used by the workflow steps. The full client API
+// is required by the s3manager uploader.
+type s3API interface {
+	s3iface.S3API
+}
+
+// lambdaAPI is the Lambda client used by the in-place updates and the cold
+// start benchmarks
+type lambdaAPI interface {
+	lambdaiface.LambdaAPI
+}
+
+// stsAPI is the subset of the STS client used by the workflow steps
+type stsAPI interface {
+	GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error)
 }
 
 // context is data that is mutated during the provisioning workflow
@@ -154,6 +246,13 @@ type provisionContext struct {
 	// AWS Session to be used for all API calls made in the process of provisioning
 	// this service.
 	awsSession *session.Session
+	// Clients created from the awsSession. Tests may replace them with
+	// fakes.
+	cfSvc     cfAPI
+	iamSvc    iamAPI
+	lambdaSvc lambdaAPI
+	s3Svc     s3API
+	stsSvc    stsAPI
 	// Cached IAM role name map.  Used to support dynamic and static IAM role
 	// names.  Static ARN role names are checked for existence via AWS APIs
 	// prior to CloudFormation provisioning.
@@ -164,10 +263,27 @@ type provisionContext struct {
 	cfTemplate *gocf.Template
 	// Is versioning enabled for s3 Bucket?
 	s3BucketVersioningEnabled bool
+	// Region of the s3 Bucket
+	s3BucketRegion string
 	// name of the binary inside the ZIP archive
 	binaryName string
 	// Context to pass between workflow operations
 	workflowHooksContext map[string]interface{}
+	// Detached signatures of the uploaded artifacts, keyed by S3 key.
+	// Uploads run concurrently, so access is guarded by the mutex
+	artifactSignatures      map[string]*ArtifactSignature
+	artifactSignaturesMutex sync.Mutex
+	// Hex encoded SHA256 digest of the compiled binary
+	binaryDigest string
+	// Export names added to the template Outputs
+	exportNames []string
+	// Options applied to every artifact S3 request
+	s3RequestOptions []request.Option
+	// Cold start Init Duration (ms) per function name. See
+	// WithColdStartBenchmark
+	coldStartDurations map[string]float64
+	// The provisioned stack
+	stack *cloudformation.Stack
 }
 
 // similar to context, transaction scopes values that span the entire
@@ -180,6 +296,9 @@ type transaction struct {
 	// Optional finalizer functions that are unconditionally executed following
 	// workflow completion, success or failure
 	finalizerFunctions []finalizerFunction
+	// Guards the rollback and finalizer slices, since the upload tasks
+	// register functions concurrently
+	functionsMutex sync.Mutex
 	// Timings that measure how long things actually took
 	stepDurations []*workflowStepDuration
 }
@@ -198,6 +317,39 @@ type workflowContext struct {
 	transaction transaction
 	// Preconfigured logger
 	logger *logrus.Logger
+	// Context for the currently executing workflowStep. It carries the
+	// deadline of the step's phase, if one was configured
+	stepContext context.Context
+}
+
+// phaseTimeoutStep wraps the step invocation in a context.WithTimeout scoped
+// to the phase's timeout. Commands and AWS calls that accept the
+// ctx.stepContext are canceled when the deadline expires and the
+// resulting error is reported as a phase timeout.
+func phaseTimeoutStep(phase ProvisionPhase, step workflowStep) workflowStep {
+	return func(ctx *workflowContext) (workflowStep, error) {
+		timeout := ctx.userdata.options.phaseTimeouts[phase]
+		if timeout <= 0 {
+			return step(ctx)
+		}
+		stepContext, cancel := context.WithTimeout(ctx.stepContext, timeout)
+		defer cancel()
+		parentContext := ctx.stepContext
+		ctx.stepContext = stepContext
+		defer func() {
+			ctx.stepContext = parentContext
+		}()
+		ctx.logger.WithFields(logrus.Fields{
+			"Phase":   phase,
+			"Timeout": timeout,
+		}).Debug("Applying phase timeout")
+
+		next, err := step(ctx)
+		if err != nil && stepContext.Err() == context.DeadlineExceeded {
+			return nil, errors.Wrapf(err, "%s phase exceeded timeout of %s", phase, timeout)
+		}
+		return next, err
+	}
 }
 
 // recordDuration is a utility function to record how long
@@ -213,6 +365,8 @@ func recordDuration(start time.Time, name string, ctx *workflowContext) {
 // Register a rollback function in the event that the provisioning
 // function failed.
 func (ctx *workflowContext) registerRollback(userFunction spartaS3.RollbackFunction) {
+	ctx.transaction.functionsMutex.Lock()
+	defer ctx.transaction.functionsMutex.Unlock()
 	if nil == ctx.transaction.rollbackFunctions || len(ctx.transaction.rollbackFunctions) <= 0 {
 		ctx.transaction.rollbackFunctions = make([]spartaS3.RollbackFunction, 0)
 	}
@@ -222,15 +376,32 @@ func (ctx *workflowContext) registerRollback(userFunction spartaS3.RollbackFunct
 // Register a rollback function in the event that the provisioning
 // function failed.
 func (ctx *workflowContext) registerFinalizer(userFunction finalizerFunction) {
+	ctx.transaction.functionsMutex.Lock()
+	defer ctx.transaction.functionsMutex.Unlock()
 	if nil == ctx.transaction.finalizerFunctions || len(ctx.transaction.finalizerFunctions) <= 0 {
 		ctx.transaction.finalizerFunctions = make([]finalizerFunction, 0)
 	}
 	ctx.transaction.finalizerFunctions = append(ctx.transaction.finalizerFunctions, userFunction)
 }
 
+// preserveArtifact returns true and logs the localPath if local build
+// artifacts are retained. See WithPreserveArtifacts
+func preserveArtifact(ctx *workflowContext, localPath string) bool {
+	if ctx.userdata.options == nil || !ctx.userdata.options.preserveArtifacts {
+		return false
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"Path": relativePath(localPath),
+	}).Info("Preserving build artifact")
+	return true
+}
+
 // Register a finalizer that cleans up local artifacts
 func (ctx *workflowContext) registerFileCleanupFinalizer(localPath string) {
 	cleanup := func(logger *logrus.Logger) {
+		if preserveArtifact(ctx, localPath) {
+			return
+		}
 		errRemove := os.Remove(localPath)
 		if nil != errRemove {
 			logger.WithFields(logrus.Fields{
@@ -246,23 +417,84 @@ func (ctx *workflowContext) registerFileCleanupFinalizer(localPath string) {
 	ctx.registerFinalizer(cleanup)
 }
 
-// Run any provided rollback functions
-func (ctx *workflowContext) rollback() {
+// Register a rollback function that closes and removes a partially written
+// local artifact if provisioning fails
+func (ctx *workflowContext) registerFileCleanupRollback(localFile *os.File) {
+	ctx.registerRollback(func(logger *logrus.Logger) error {
+		// The file is already closed if it was completely written
+		_ = localFile.Close()
+		if preserveArtifact(ctx, localFile.Name()) {
+			return nil
+		}
+		errRemove := os.Remove(localFile.Name())
+		if nil != errRemove && !os.IsNotExist(errRemove) {
+			return errRemove
+		}
+		logger.WithFields(logrus.Fields{
+			"Path": relativePath(localFile.Name()),
+		}).Debug("Partial build artifact deleted")
+		return nil
+	})
+}
+
+// RollbackError is returned by Provision when the workflow fails and one or
+// more of the rollback functions also fail. The failed rollback functions may
+// have left behind resources, such as S3 objects, that must be cleaned up
+// manually.
+type RollbackError struct {
+	// Err is the workflow error that triggered the rollback
+	Err error
+	// RollbackErr describes each rollback function failure
+	RollbackErr error
+}
+
+// Error returns the workflow error and the rollback error messages
+func (rollbackErr *RollbackError) Error() string {
+	return fmt.Sprintf("%s. Rollback also failed: %s",
+		rollbackErr.Err,
+		rollbackErr.RollbackErr)
+}
+
+// Cause returns the workflow error that triggered the rollback
+func (rollbackErr *RollbackError) Cause() error {
+	return rollbackErr.Err
+}
+
+// withRollbackError attaches the optional rollbackErr to the workflow err
+func withRollbackError(err error, rollbackErr error) error {
+	if rollbackErr == nil {
+		return err
+	}
+	return &RollbackError{
+		Err:         err,
+		RollbackErr: rollbackErr,
+	}
+}
+
+// Run any provided rollback functions and return a single error that
+// includes the message of each failed function
+func (ctx *workflowContext) rollback() error {
 	defer recordDuration(time.Now(), "Rollback", ctx)
 
-	// Run each cleanup function concurrently.  If there's an error
-	// all we're going to do is log it as a warning, since at this
-	// point there's nothing to do...
+	// Run each cleanup function concurrently. The failures are logged
+	// as they happen and returned together once every function completes
 	ctx.logger.Info("Invoking rollback functions")
-	var wg sync.WaitGroup
-	wg.Add(len(ctx.transaction.rollbackFunctions))
-	rollbackErr := callRollbackHook(ctx, &wg)
-	if rollbackErr != nil {
-		ctx.logger.WithFields(logrus.Fields{
-			"Error": rollbackErr,
-		}).Warning("Rollback Hook failed to execute")
+	rollbackFunctions := ctx.transaction.rollbackFunctions
+	userRollbacks, _ := ctx.context.workflowHooksContext[ContextKeyRollbackFunctions].([]RollbackFunction)
+	for _, eachUserRollback := range userRollbacks {
+		rollbackFunctions = append(rollbackFunctions, spartaS3.RollbackFunction(eachUserRollback))
+	}
+	var rollbackErrors []error
+	var rollbackErrorsMutex sync.Mutex
+	recordRollbackError := func(rollbackErr error) {
+		rollbackErrorsMutex.Lock()
+		defer rollbackErrorsMutex.Unlock()
+		rollbackErrors = append(rollbackErrors, rollbackErr)
 	}
-	for _, eachCleanup := range ctx.transaction.rollbackFunctions {
+	var wg sync.WaitGroup
+	wg.Add(len(rollbackFunctions))
+	callRollbackHook(ctx, &wg, recordRollbackError)
+	for _, eachCleanup := range rollbackFunctions {
 		go func(cleanupFunc spartaS3.RollbackFunction, goLogger *logrus.Logger) {
 			// Decrement the counter when the goroutine completes.
 			defer wg.Done()
@@ -272,10 +504,22 @@ func (ctx *workflowContext) rollback() {
 				ctx.logger.WithFields(logrus.Fields{
 					"Error": err,
 				}).Warning("Failed to cleanup resource")
+				recordRollbackError(err)
 			}
 		}(eachCleanup, ctx.logger)
 	}
 	wg.Wait()
+
+	if len(rollbackErrors) == 0 {
+		return nil
+	}
+	errorText := make([]string, len(rollbackErrors))
+	for index, eachError := range rollbackErrors {
+		errorText[index] = eachError.Error()
+	}
+	return errors.Errorf("Encountered %d error(s) during rollback:\n\t%s",
+		len(rollbackErrors),
+		strings.Join(errorText, "\n\t"))
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -294,9 +538,11 @@ func logFilesize(message string, filePath string, logger *logrus.Logger) {
 }
 
 // Encapsulate calling the rollback hooks
-func callRollbackHook(ctx *workflowContext, wg *sync.WaitGroup) error {
+func callRollbackHook(ctx *workflowContext,
+	wg *sync.WaitGroup,
+	recordRollbackError func(error)) {
 	if ctx.userdata.workflowHooks == nil {
-		return nil
+		return
 	}
 	rollbackHooks := ctx.userdata.workflowHooks.Rollbacks
 	if ctx.userdata.workflowHooks.Rollback != nil {
@@ -318,9 +564,12 @@ func callRollbackHook(ctx *workflowContext, wg *sync.WaitGroup) error {
 				awsSession,
 				noop,
 				logger)
-			logger.WithFields(logrus.Fields{
-				"Error": rollbackErr,
-			}).Warn("Rollback function failed to complete")
+			if rollbackErr != nil {
+				logger.WithFields(logrus.Fields{
+					"Error": rollbackErr,
+				}).Warn("Rollback function failed to complete")
+				recordRollbackError(rollbackErr)
+			}
 		}(eachRollbackHook,
 			ctx.context.workflowHooksContext,
 			ctx.userdata.serviceName,
@@ -328,7 +577,6 @@ func callRollbackHook(ctx *workflowContext, wg *sync.WaitGroup) error {
 			ctx.userdata.noop,
 			ctx.logger)
 	}
-	return nil
 }
 
 // Encapsulate calling the service decorator hooks
@@ -367,10 +615,27 @@ func callServiceDecoratorHook(ctx *workflowContext) error {
 		if nil != decoratorError {
 			return decoratorError
 		}
-		safeMergeErrs := gocc.SafeMerge(serviceTemplate, ctx.context.cfTemplate)
-		if len(safeMergeErrs) != 0 {
-			return errors.Errorf("Failed to merge templates: %#v", safeMergeErrs)
+		mergeErr := safeMergeTemplates(serviceTemplate, ctx.context.cfTemplate)
+		if nil != mergeErr {
+			return errors.Wrapf(mergeErr, "Failed to merge ServiceDecoratorHook (%s) template", hookName)
+		}
+	}
+	return nil
+}
+
+// mergeAdditionalTemplates merges the WithAdditionalTemplate templates
+// into the service template. Logical names that are already defined are
+// reported as errors rather than overwritten.
+func mergeAdditionalTemplates(ctx *workflowContext) error {
+	for eachIndex, eachTemplate := range ctx.userdata.options.additionalTemplates {
+		mergeErr := safeMergeTemplates(eachTemplate, ctx.context.cfTemplate)
+		if nil != mergeErr {
+			return errors.Wrapf(mergeErr, "Failed to merge additional template %d", eachIndex)
 		}
+		ctx.logger.WithFields(logrus.Fields{
+			"Resources": len(eachTemplate.Resources),
+			"Outputs":   len(eachTemplate.Outputs),
+		}).Info("Merged additional template")
 	}
 	return nil
 }
@@ -439,6 +704,28 @@ func callWorkflowHook(hookPhase string,
 	return nil
 }
 
+// artifactKeyPrefix returns the S3 key prefix of the service's artifacts,
+// including the trailing slash. The optional WithS3KeyPrefix value is
+// prepended to the `<serviceName>/` prefix.
+func artifactKeyPrefix(ctx *workflowContext) string {
+	keyPrefix := fmt.Sprintf("%s/", ctx.userdata.serviceName)
+	if ctx.userdata.options != nil && ctx.userdata.options.s3KeyPrefix != "" {
+		keyPrefix = fmt.Sprintf("%s/%s", ctx.userdata.options.s3KeyPrefix, keyPrefix)
+	}
+	return keyPrefix
+}
+
+// deploymentHistoryKey returns the S3 key of the service's deployment
+// history. It's stored outside of the artifactKeyPrefix so that the
+// WithArtifactBucketKeyExpiry rule doesn't expire it.
+func deploymentHistoryKey(ctx *workflowContext) string {
+	keyPrefix := deploymentHistoryKeyPrefix
+	if ctx.userdata.options != nil && ctx.userdata.options.s3KeyPrefix != "" {
+		keyPrefix = fmt.Sprintf("%s/%s", ctx.userdata.options.s3KeyPrefix, keyPrefix)
+	}
+	return fmt.Sprintf("%s%s/%s", keyPrefix, ctx.userdata.serviceName, deploymentHistoryKeyName)
+}
+
 // versionAwareS3KeyName returns a keyname that provides the correct cache
 // invalidation semantics based on whether the target bucket
 // has versioning enabled
@@ -469,6 +756,87 @@ func versionAwareS3KeyName(s3DefaultKey string, s3VersioningEnabled bool, logger
 	return versionKeyName, nil
 }
 
+// metadata returns the S3 object metadata that carries the signature
+func (signature *ArtifactSignature) metadata() map[string]string {
+	return map[string]string{
+		"sparta-sha256":    signature.SHA256,
+		"sparta-signature": signature.Signature,
+	}
+}
+
+// fileSHA256 returns the hex encoded SHA256 digest of the
+// content at localPath
+func fileSHA256(localPath string) (string, error) {
+	/* #nosec */
+	reader, readerErr := os.Open(localPath)
+	if readerErr != nil {
+		return "", errors.Wrapf(readerErr, "Failed to open file: %s", localPath)
+	}
+	defer reader.Close()
+	hash := sha256.New()
+	_, copyErr := io.Copy(hash, reader)
+	if copyErr != nil {
+		return "", errors.Wrapf(copyErr, "Failed to compute digest: %s", localPath)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// signArtifact returns the signature of the SHA256 digest of the
+// content at localPath
+func signArtifact(localPath string, signer crypto.Signer) (*ArtifactSignature, error) {
+	/* #nosec */
+	reader, readerErr := os.Open(localPath)
+	if readerErr != nil {
+		return nil, errors.Wrapf(readerErr, "Failed to open artifact for signing")
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	_, copyErr := io.Copy(hash, reader)
+	if copyErr != nil {
+		return nil, errors.Wrapf(copyErr, "Failed to compute artifact digest")
+	}
+	digest := hash.Sum(nil)
+	signature, signatureErr := signer.Sign(rand.Reader, digest, crypto.SHA256)
+	if signatureErr != nil {
+		return nil, errors.Wrapf(signatureErr, "Failed to sign artifact: %s", localPath)
+	}
+	return &ArtifactSignature{
+		SHA256:    hex.EncodeToString(digest),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}, nil
+}
+
+// s3UploaderOptions returns the s3manager.Uploader options that apply the
+// optional multipart upload tuning
+func s3UploaderOptions(options *provisionOptions) []func(*s3manager.Uploader) {
+	var uploaderOptions []func(*s3manager.Uploader)
+	if options.s3UploadPartSize != 0 {
+		uploaderOptions = append(uploaderOptions, func(uploader *s3manager.Uploader) {
+			uploader.PartSize = options.s3UploadPartSize
+		})
+	}
+	if options.s3UploadConcurrency != 0 {
+		uploaderOptions = append(uploaderOptions, func(uploader *s3manager.Uploader) {
+			uploader.Concurrency = options.s3UploadConcurrency
+		})
+	}
+	return uploaderOptions
+}
+
+// s3BucketConfig returns the session configuration, including any custom
+// endpoint and path-style addressing, scoped to the S3 bucket's region.
+// It's used to build the URLs of the objects in the bucket.
+func s3BucketConfig(ctx *workflowContext) *aws.Config {
+	bucketConfig := &aws.Config{
+		Region: aws.String(ctx.context.s3BucketRegion),
+	}
+	if ctx.context.awsSession == nil {
+		return bucketConfig
+	}
+	return ctx.context.awsSession.Config.Copy(bucketConfig)
+}
+
 // Upload a local file to S3.  Returns the full S3 URL to the file that was
 // uploaded. If the target bucket does not have versioning enabled,
 // this function will automatically make a new key to ensure uniqueness
@@ -478,7 +846,7 @@ func uploadLocalFileToS3(localPath string, s3ObjectKey string, ctx *workflowCont
 	// that's dynamically created. By default assume that the bucket is
 	// enabled for versioning
 	if "" == s3ObjectKey {
-		defaultS3KeyName := fmt.Sprintf("%s/%s", ctx.userdata.serviceName, filepath.Base(localPath))
+		defaultS3KeyName := artifactKeyPrefix(ctx) + filepath.Base(localPath)
 		s3KeyName, s3KeyNameErr := versionAwareS3KeyName(defaultS3KeyName,
 			ctx.context.s3BucketVersioningEnabled,
 			ctx.logger)
@@ -488,6 +856,19 @@ func uploadLocalFileToS3(localPath string, s3ObjectKey string, ctx *workflowCont
 		s3ObjectKey = s3KeyName
 	}
 
+	// Sign it?
+	var s3Metadata map[string]string
+	if nil != ctx.userdata.options.artifactSigner {
+		signature, signatureErr := signArtifact(localPath, ctx.userdata.options.artifactSigner)
+		if nil != signatureErr {
+			return "", signatureErr
+		}
+		ctx.context.artifactSignaturesMutex.Lock()
+		ctx.context.artifactSignatures[s3ObjectKey] = signature
+		ctx.context.artifactSignaturesMutex.Unlock()
+		s3Metadata = signature.metadata()
+	}
+
 	s3URL := ""
 	if ctx.userdata.noop {
 
@@ -503,23 +884,30 @@ func uploadLocalFileToS3(localPath string, s3ObjectKey string, ctx *workflowCont
 			"File":   filepath.Base(localPath),
 			"Size":   humanize.Bytes(uint64(filesize)),
 		}).Info(noopMessage("S3 upload"))
-		s3URL = fmt.Sprintf("https://%s-s3.amazonaws.com/%s",
+		s3URL = spartaS3.ObjectURL(s3BucketConfig(ctx),
 			ctx.userdata.s3Bucket,
 			s3ObjectKey)
 	} else {
 		// Make sure we mark things for cleanup in case there's a problem
 		ctx.registerFileCleanupFinalizer(localPath)
 		// Then upload it
-		uploadLocation, uploadURLErr := spartaS3.UploadLocalFileToS3(localPath,
-			ctx.context.awsSession,
+		uploadLocation, uploadURLErr := spartaS3.UploadLocalFileToS3WithClient(ctx.stepContext,
+			localPath,
+			ctx.context.s3Svc,
 			ctx.userdata.s3Bucket,
 			s3ObjectKey,
-			ctx.logger)
+			s3Metadata,
+			s3UploaderOptions(ctx.userdata.options),
+			ctx.logger,
+			ctx.context.s3RequestOptions...)
 		if nil != uploadURLErr {
 			return "", errors.Wrapf(uploadURLErr, "Failed to upload local file to S3")
 		}
 		s3URL = uploadLocation
-		ctx.registerRollback(spartaS3.CreateS3RollbackFunc(ctx.context.awsSession, uploadLocation))
+		ctx.registerRollback(spartaS3.CreateS3RollbackFuncWithClient(ctx.context.s3Svc,
+			ctx.userdata.s3Bucket,
+			s3ObjectKey,
+			uploadLocation))
 	}
 	return s3URL, nil
 }
@@ -531,16 +919,216 @@ func uploadLocalFileToS3(localPath string, s3ObjectKey string, ctx *workflowCont
 // Workflow steps
 ////////////////////////////////////////////////////////////////////////////////
 
+// goVersionSupported returns true if the `go version` output reports a
+// release at or after minimumGoVersion.
+func goVersionSupported(versionOutput string) bool {
+	matches := reGoVersion.FindStringSubmatch(versionOutput)
+	if len(matches) != 3 {
+		// Development builds (`go version devel ...`) are assumed current
+		return strings.Contains(versionOutput, "devel")
+	}
+	major, majorErr := strconv.Atoi(matches[1])
+	minor, minorErr := strconv.Atoi(matches[2])
+	if majorErr != nil || minorErr != nil {
+		return false
+	}
+	if major != minimumGoMajorVersion {
+		return major > minimumGoMajorVersion
+	}
+	return minor >= minimumGoMinorVersion
+}
+
+// verifyGoToolchain returns an error if the `go` binary isn't on the PATH
+// or reports a version older than the minimum supported release
+func verifyGoToolchain(cmdContext context.Context) error {
+	goPath, lookPathErr := exec.LookPath("go")
+	if lookPathErr != nil {
+		return errors.Wrapf(lookPathErr, "Failed to find `go` on the PATH")
+	}
+	/* #nosec */
+	versionOutput, versionErr := exec.CommandContext(cmdContext, goPath, "version").CombinedOutput()
+	if versionErr != nil {
+		return errors.Wrapf(versionErr, "Failed to run `%s version`", goPath)
+	}
+	if !goVersionSupported(string(versionOutput)) {
+		return errors.Errorf("Unsupported Go toolchain %q. Go %d.%d or newer is required",
+			strings.TrimSpace(string(versionOutput)),
+			minimumGoMajorVersion,
+			minimumGoMinorVersion)
+	}
+	return nil
+}
+
+// Verify the local toolchain, service name, and S3 bucket before doing any
+// work. All failures are reported together.
+func verifyPreflightConditions(ctx *workflowContext) (workflowStep, error) {
+	defer recordDuration(time.Now(), "Verifying preflight conditions", ctx)
+
+	var errorText []string
+	serviceNameErr := validateServiceName(ctx.userdata.serviceName)
+	if serviceNameErr != nil {
+		errorText = append(errorText, serviceNameErr.Error())
+	}
+	goToolchainErr := verifyGoToolchain(ctx.stepContext)
+	if goToolchainErr != nil {
+		errorText = append(errorText, goToolchainErr.Error())
+	}
+	if ctx.userdata.useCGO {
+		_, dockerErr := exec.LookPath("docker")
+		if dockerErr != nil {
+			errorText = append(errorText,
+				errors.Wrapf(dockerErr, "Failed to find `docker` on the PATH for CGO build").Error())
+		}
+	}
+	if ctx.userdata.noop {
+		ctx.logger.WithFields(logrus.Fields{
+			"Bucket": ctx.userdata.s3Bucket,
+		}).Info(noopMessage("S3 bucket access check"))
+	} else {
+		bucketErr := spartaS3.VerifyBucketAccess(ctx.stepContext,
+			ctx.context.s3Svc,
+			ctx.userdata.s3Bucket,
+			ctx.context.s3RequestOptions...)
+		if bucketErr != nil {
+			errorText = append(errorText, bucketErr.Error())
+		}
+	}
+	if len(errorText) != 0 {
+		return nil, errors.Errorf("Preflight checks failed:\n%s", strings.Join(errorText, "\n"))
+	}
+	return verifyIAMRoles, nil
+}
+
+// iamRoleVerificationConcurrency is the maximum number of concurrent
+// GetRole requests made to verify static role names
+const iamRoleVerificationConcurrency = 8
+
+// staticRoleARN is the ARN of a verified static role name
+type staticRoleARN struct {
+	roleName string
+	arn      string
+}
+
+// verifyStaticRoleName returns the ARN of the existing roleName. The
+// roleName may include a path prefix (eg, `service-role/MyRole`), which
+// must match the role's path.
+func verifyStaticRoleName(svc iamAPI, roleName string) (*staticRoleARN, error) {
+	rolePath := "/"
+	baseName := roleName
+	if pathSeparator := strings.LastIndex(roleName, "/"); pathSeparator >= 0 {
+		rolePath = fmt.Sprintf("/%s/", strings.Trim(roleName[:pathSeparator], "/"))
+		baseName = roleName[pathSeparator+1:]
+	}
+	resp, err := svc.GetRole(&iam.GetRoleInput{
+		RoleName: aws.String(baseName),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get IAM role: %s", roleName)
+	}
+	if rolePath != "/" && aws.StringValue(resp.Role.Path) != rolePath {
+		return nil, errors.Errorf("IAM role %s has path %s, not %s",
+			baseName,
+			aws.StringValue(resp.Role.Path),
+			rolePath)
+	}
+	return &staticRoleARN{
+		roleName: roleName,
+		arn:      aws.StringValue(resp.Role.Arn),
+	}, nil
+}
+
+// verifyStaticRoleNames caches the ARN of every static role name. Role
+// ARNs are used as is. The remaining names are deduplicated and verified
+// concurrently, and all missing roles are reported together.
+func verifyStaticRoleNames(ctx *workflowContext, roleNames []string) error {
+	uniqueRoleNames := make(map[string]bool)
+	for _, eachRoleName := range roleNames {
+		if _, exists := ctx.context.lambdaIAMRoleNameMap[eachRoleName]; exists {
+			continue
+		}
+		if reIAMRoleARN.MatchString(eachRoleName) {
+			ctx.context.lambdaIAMRoleNameMap[eachRoleName] = gocf.String(eachRoleName)
+			continue
+		}
+		uniqueRoleNames[eachRoleName] = true
+	}
+	if len(uniqueRoleNames) == 0 {
+		return nil
+	}
+	verifyTaskMaker := func(roleName string) taskFunc {
+		return func() workResult {
+			ctx.logger.Debug("Checking IAM RoleName: ", roleName)
+			return newTaskResult(verifyStaticRoleName(ctx.context.iamSvc, roleName))
+		}
+	}
+	var verifyTasks []*workTask
+	for eachRoleName := range uniqueRoleNames {
+		verifyTasks = append(verifyTasks, newWorkTask(verifyTaskMaker(eachRoleName)))
+	}
+	concurrency := iamRoleVerificationConcurrency
+	if len(verifyTasks) < concurrency {
+		concurrency = len(verifyTasks)
+	}
+	p := newWorkerPool(verifyTasks, concurrency)
+	results, verifyErrors := p.Run()
+	if len(verifyErrors) != 0 {
+		errorText := make([]string, len(verifyErrors))
+		for index, eachError := range verifyErrors {
+			errorText[index] = eachError.Error()
+		}
+		sort.Strings(errorText)
+		return errors.Errorf("Failed to verify IAM roles:\n%s", strings.Join(errorText, "\n"))
+	}
+	// Cache them - the CloudFormation template needs the execution Arn
+	// (not role)
+	for _, eachResult := range results {
+		roleARN := eachResult.(*staticRoleARN)
+		ctx.context.lambdaIAMRoleNameMap[roleARN.roleName] = gocf.String(roleARN.arn)
+	}
+	return nil
+}
+
 // Verify & cache the IAM rolename to ARN mapping
 func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 	defer recordDuration(time.Now(), "Verifying IAM roles", ctx)
+	reportProgress(ctx, ProgressStepVerifyIAMRoles, 10, nil)
 
 	// The map is either a literal Arn from a pre-existing role name
 	// or a gocf.RefFunc() value.
 	// Don't verify them, just create them...
 	ctx.logger.Info("Verifying IAM Lambda execution roles")
 	ctx.context.lambdaIAMRoleNameMap = make(map[string]*gocf.StringExpr)
-	svc := iam.New(ctx.context.awsSession)
+	// If content deduplication is enabled, semantically identical
+	// IAMRoleDefinitions share the logical name of the first instance
+	roleDigestNames := make(map[string]string)
+	dedupRoleDefinition := func(roleDefinition *IAMRoleDefinition,
+		eventSourceMappings []*EventSourceMapping,
+		options *LambdaFunctionOptions,
+		targetLambdaFnName string) error {
+		if !ctx.userdata.options.iamRoleContentDedup {
+			return nil
+		}
+		digest, digestErr := roleDefinition.contentDigest(eventSourceMappings,
+			options,
+			ctx.logger)
+		if digestErr != nil {
+			return errors.Wrapf(digestErr,
+				"Failed to compute IAM role digest for: %s",
+				targetLambdaFnName)
+		}
+		existingName, existingNameOk := roleDigestNames[digest]
+		if !existingNameOk {
+			roleDigestNames[digest] = roleDefinition.logicalName(ctx.userdata.serviceName,
+				targetLambdaFnName)
+			return nil
+		}
+		ctx.logger.WithFields(logrus.Fields{
+			"Function": targetLambdaFnName,
+			"IAMRole":  existingName,
+		}).Debug("Sharing identical IAM role definition")
+		roleDefinition.cachedLogicalName = existingName
+		return nil
+	}
 
 	// Assemble all the RoleNames and validate the inline IAMRoleDefinitions
 	var allRoleNames []string
@@ -567,6 +1155,13 @@ func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 
 		// Validate the IAMRoleDefinitions associated
 		if nil != eachLambdaInfo.RoleDefinition {
+			dedupErr := dedupRoleDefinition(eachLambdaInfo.RoleDefinition,
+				eachLambdaInfo.EventSourceMappings,
+				eachLambdaInfo.Options,
+				eachLambdaInfo.lambdaFunctionName())
+			if dedupErr != nil {
+				return nil, dedupErr
+			}
 			logicalName := eachLambdaInfo.RoleDefinition.logicalName(ctx.userdata.serviceName, eachLambdaInfo.lambdaFunctionName())
 			_, exists := ctx.context.lambdaIAMRoleNameMap[logicalName]
 			if !exists {
@@ -584,6 +1179,13 @@ func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 		// And the custom resource IAMRoles as well...
 		for _, eachCustomResource := range eachLambdaInfo.customResources {
 			if nil != eachCustomResource.roleDefinition {
+				dedupErr := dedupRoleDefinition(eachCustomResource.roleDefinition,
+					nil,
+					eachCustomResource.options,
+					eachCustomResource.userFunctionName)
+				if dedupErr != nil {
+					return nil, dedupErr
+				}
 				customResourceLogicalName := eachCustomResource.roleDefinition.logicalName(ctx.userdata.serviceName,
 					eachCustomResource.userFunctionName)
 
@@ -600,23 +1202,9 @@ func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 	}
 
 	// Then check all the RoleName literals
-	for _, eachRoleName := range allRoleNames {
-		_, exists := ctx.context.lambdaIAMRoleNameMap[eachRoleName]
-		if !exists {
-			// Check the role
-			params := &iam.GetRoleInput{
-				RoleName: aws.String(eachRoleName),
-			}
-			ctx.logger.Debug("Checking IAM RoleName: ", eachRoleName)
-			resp, err := svc.GetRole(params)
-			if err != nil {
-				ctx.logger.Error(err.Error())
-				return nil, err
-			}
-			// Cache it - we'll need it later when we create the
-			// CloudFormation template which needs the execution Arn (not role)
-			ctx.context.lambdaIAMRoleNameMap[eachRoleName] = gocf.String(*resp.Role.Arn)
-		}
+	verifyErr := verifyStaticRoleNames(ctx, allRoleNames)
+	if verifyErr != nil {
+		return nil, verifyErr
 	}
 	ctx.logger.WithFields(logrus.Fields{
 		"Count": len(ctx.context.lambdaIAMRoleNameMap),
@@ -639,7 +1227,7 @@ func verifyAWSPreconditions(ctx *workflowContext) (workflowStep, error) {
 	} else {
 		// Bucket versioning
 		// Get the S3 bucket and see if it has versioning enabled
-		isEnabled, versioningPolicyErr := spartaS3.BucketVersioningEnabled(ctx.context.awsSession, ctx.userdata.s3Bucket, ctx.logger)
+		isEnabled, versioningPolicyErr := spartaS3.BucketVersioningEnabled(ctx.context.s3Svc, ctx.userdata.s3Bucket, ctx.logger)
 		if nil != versioningPolicyErr {
 			return nil, versioningPolicyErr
 		}
@@ -655,29 +1243,46 @@ func verifyAWSPreconditions(ctx *workflowContext) (workflowStep, error) {
 		/*
 			The name of the Amazon S3 bucket where the .zip file that contains your deployment package is stored. This bucket must reside in the same AWS Region that you're creating the Lambda function in. You can specify a bucket from another AWS account as long as the Lambda function and the bucket are in the same region.
 		*/
-
-		bucketRegion, bucketRegionErr := spartaS3.BucketRegion(ctx.context.awsSession,
+		// GetBucketLocation works from any region, so a bucket in another
+		// region is reported by name rather than as an opaque redirect
+		// from a later upload
+		bucketRegion, bucketRegionErr := spartaS3.BucketLocation(ctx.stepContext,
+			ctx.context.s3Svc,
 			ctx.userdata.s3Bucket,
-			ctx.logger)
-
+			ctx.context.s3RequestOptions...)
 		if bucketRegionErr != nil {
-			return nil, fmt.Errorf("Failed to determine region for %s. Error: %s",
-				ctx.userdata.s3Bucket,
-				bucketRegionErr)
+			return nil, errors.Wrapf(bucketRegionErr,
+				"Failed to determine the region of the S3 artifact bucket")
 		}
 		ctx.logger.WithFields(logrus.Fields{
 			"Bucket": ctx.userdata.s3Bucket,
 			"Region": bucketRegion,
 		}).Info("Checking S3 region")
 		if bucketRegion != *ctx.context.awsSession.Config.Region {
-			return nil, fmt.Errorf("Target region (%s) does not match bucket region (%s)",
-				*ctx.context.awsSession.Config.Region,
-				bucketRegion)
+			return nil, fmt.Errorf("S3 bucket %s is in region %s, but the target region is %s. "+
+				"Lambda requires the deployment bucket to be in the same region as the functions",
+				ctx.userdata.s3Bucket,
+				bucketRegion,
+				*ctx.context.awsSession.Config.Region)
 		}
+		ctx.context.s3BucketRegion = bucketRegion
 		// Nothing else to do...
 		ctx.logger.WithFields(logrus.Fields{
 			"Region": bucketRegion,
 		}).Debug("Confirmed S3 region match")
+
+		if ctx.userdata.options.bucketOwnershipCheck {
+			ownerErr := ensureBucketOwner(ctx)
+			if nil != ownerErr {
+				return nil, ownerErr
+			}
+		}
+		if ctx.userdata.options.artifactExpirationDays > 0 {
+			expirationErr := ensureArtifactExpiration(ctx)
+			if nil != expirationErr {
+				return nil, expirationErr
+			}
+		}
 	}
 
 	// If there are codePipeline environments defined, warn if they don't include
@@ -722,7 +1327,79 @@ func verifyAWSPreconditions(ctx *workflowContext) (workflowStep, error) {
 		}
 	}
 
-	return createPackageStep(), nil
+	return phaseTimeoutStep(ProvisionPhaseBuild, createPackageStep()), nil
+}
+
+// ensureBucketOwner verifies that the artifact bucket is owned by the
+// caller's account and ensures that subsequent artifact S3 requests
+// are rejected by S3 if the bucket owner differs
+func ensureBucketOwner(ctx *workflowContext) error {
+	callerIdentity, callerIdentityErr := ctx.context.stsSvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if nil != callerIdentityErr {
+		return errors.Wrapf(callerIdentityErr, "Failed to get caller identity")
+	}
+	accountID := aws.StringValue(callerIdentity.Account)
+	verifyErr := spartaS3.VerifyBucketOwner(ctx.stepContext,
+		ctx.context.s3Svc,
+		ctx.userdata.s3Bucket,
+		accountID,
+		ctx.logger)
+	if nil != verifyErr {
+		return verifyErr
+	}
+	ctx.context.s3RequestOptions = append(ctx.context.s3RequestOptions,
+		spartaS3.ExpectedBucketOwner(accountID))
+	return nil
+}
+
+// ensureArtifactExpiration verifies that the service's artifact key prefix is
+// covered by a lifecycle expiration rule, optionally creating a service
+// scoped rule
+func ensureArtifactExpiration(ctx *workflowContext) error {
+	keyPrefix := artifactKeyPrefix(ctx)
+	expirationDays := ctx.userdata.options.artifactExpirationDays
+	ruleID := fmt.Sprintf("sparta-%s-expiration", sanitizedName(strings.TrimSuffix(keyPrefix, "/")))
+
+	rule, ruleErr := spartaS3.PrefixExpirationRule(ctx.context.s3Svc,
+		ctx.userdata.s3Bucket,
+		keyPrefix,
+		ctx.logger)
+	if nil != ruleErr {
+		return ruleErr
+	}
+	if rule != nil && aws.Int64Value(rule.Expiration.Days) == expirationDays {
+		ctx.logger.WithFields(logrus.Fields{
+			"Bucket":         ctx.userdata.s3Bucket,
+			"Prefix":         keyPrefix,
+			"RuleID":         aws.StringValue(rule.ID),
+			"ExpirationDays": expirationDays,
+		}).Info("Checking S3 artifact expiration")
+		return nil
+	}
+	if rule == nil && !ctx.userdata.options.artifactExpirationCreateRule {
+		return errors.Errorf("Bucket (%s) doesn't have a lifecycle expiration rule for service prefix: %s",
+			ctx.userdata.s3Bucket,
+			keyPrefix)
+	}
+	// Only Sparta's own rule is updated. An externally managed rule with a
+	// different retention is left alone.
+	if rule != nil &&
+		(aws.StringValue(rule.ID) != ruleID || !ctx.userdata.options.artifactExpirationCreateRule) {
+		ctx.logger.WithFields(logrus.Fields{
+			"Bucket":                 ctx.userdata.s3Bucket,
+			"Prefix":                 keyPrefix,
+			"RuleID":                 aws.StringValue(rule.ID),
+			"ExpirationDays":         aws.Int64Value(rule.Expiration.Days),
+			"ExpectedExpirationDays": expirationDays,
+		}).Warn("Artifact expiration rule retention differs from requested retention")
+		return nil
+	}
+	return spartaS3.EnsurePrefixExpirationRule(ctx.context.s3Svc,
+		ctx.userdata.s3Bucket,
+		keyPrefix,
+		ruleID,
+		expirationDays,
+		ctx.logger)
 }
 
 func ensureMainEntrypoint(logger *logrus.Logger) error {
@@ -755,13 +1432,225 @@ func ensureMainEntrypoint(logger *logrus.Logger) error {
 	return nil
 }
 
-func buildGoBinary(serviceName string,
+// verifyGoModuleConsistency ensures that the module dependencies in the
+// current working directory match the declarations in go.mod/go.sum
+// before they're compiled into the binary.
+func verifyGoModuleConsistency(cmdContext context.Context, logger *logrus.Logger) error {
+	_, goModErr := os.Stat("go.mod")
+	if goModErr != nil {
+		logger.Warn("go.mod not found in working directory. Skipping module consistency check.")
+		return nil
+	}
+	moduleCheck := func(description string, args ...string) error {
+		cmd := exec.CommandContext(cmdContext, "go", args...)
+		cmd.Env = os.Environ()
+		logger.WithFields(logrus.Fields{
+			"Args": args,
+		}).Info(description)
+		cmdErr := runOSCommand(cmd, logger)
+		if cmdErr != nil {
+			return errors.Wrapf(cmdErr,
+				"Module dependencies are inconsistent with go.mod/go.sum (`go %s` failed)",
+				strings.Join(args, " "))
+		}
+		return nil
+	}
+	verifyErr := moduleCheck("Verifying module dependencies", "mod", "verify")
+	if verifyErr != nil {
+		return verifyErr
+	}
+	// `go mod tidy -diff` is only available in newer versions of go
+	helpOutput, helpOutputErr := exec.CommandContext(cmdContext, "go", "help", "mod", "tidy").CombinedOutput()
+	if helpOutputErr == nil && strings.Contains(string(helpOutput), "-diff") {
+		tidyErr := moduleCheck("Verifying go.mod is tidy", "mod", "tidy", "-diff")
+		if tidyErr != nil {
+			return tidyErr
+		}
+	} else {
+		logger.Debug("`go mod tidy -diff` not supported by installed go version")
+	}
+	// If there's a vendor directory, the go tool reports any
+	// inconsistency with vendor/modules.txt
+	vendorInfo, vendorInfoErr := os.Stat("vendor")
+	if vendorInfoErr == nil && vendorInfo.IsDir() {
+		vendorErr := moduleCheck("Verifying vendor directory", "list", "-mod=vendor", "./...")
+		if vendorErr != nil {
+			return vendorErr
+		}
+	}
+	return nil
+}
+
+// userBuildEnvironment returns the sorted KEY=VALUE pairs of the user
+// supplied build environment. GOOS and GOARCH are ignored so that the
+// binary always targets the AWS Lambda environment.
+func userBuildEnvironment(env map[string]string, logger *logrus.Logger) []string {
+	envKeys := make([]string, 0, len(env))
+	for eachKey := range env {
+		switch eachKey {
+		case "GOOS", "GOARCH":
+			logger.WithFields(logrus.Fields{
+				"Name":  eachKey,
+				"Value": env[eachKey],
+			}).Warn("Ignoring user supplied build environment variable")
+		default:
+			envKeys = append(envKeys, eachKey)
+		}
+	}
+	sort.Strings(envKeys)
+	buildEnv := make([]string, len(envKeys))
+	for eachIndex, eachKey := range envKeys {
+		buildEnv[eachIndex] = fmt.Sprintf("%s=%s", eachKey, env[eachKey])
+	}
+	return buildEnv
+}
+
+// buildConcurrencySettings returns the `go build` flags and environment
+// variables that limit the build to buildConcurrency cores. A
+// non-positive value doesn't limit the build.
+func buildConcurrencySettings(buildConcurrency int) ([]string, []string) {
+	if buildConcurrency <= 0 {
+		return nil, nil
+	}
+	return []string{"-p", strconv.Itoa(buildConcurrency)},
+		[]string{fmt.Sprintf("GOMAXPROCS=%d", buildConcurrency)}
+}
+
+// buildCacheDirectory is the scratch directory that stores previously
+// built binaries
+func buildCacheDirectory() string {
+	return filepath.Join(ScratchDirectory, "build-cache")
+}
+
+// buildCachePath returns the path of the cached binary for the build
+// inputs digest
+func buildCachePath(serviceName string, buildDigest string, goArch string) string {
+	return filepath.Join(buildCacheDirectory(),
+		fmt.Sprintf("%s-%s.lambda.%s", sanitizedName(serviceName), buildDigest, goArch))
+}
+
+// buildInputsDigest returns the SHA256 digest of everything that affects
+// the compiled binary: the Go toolchain version, the build flags and
+// environment, and the content of every non-hidden file under the working
+// directory. Dependencies outside the working directory are covered by
+// go.sum or a vendor directory.
+func buildInputsDigest(cmdContext context.Context,
+	executableOutput string,
+	useCGO bool,
+	goArch string,
+	buildFlags []string,
+	buildEnv []string) (string, error) {
+	hash := sha256.New()
+
+	/* #nosec */
+	versionOutput, versionErr := exec.CommandContext(cmdContext, "go", "version").CombinedOutput()
+	if versionErr != nil {
+		return "", errors.Wrapf(versionErr, "Failed to run `go version`")
+	}
+	fmt.Fprintf(hash, "version:%s\n", strings.TrimSpace(string(versionOutput)))
+	fmt.Fprintf(hash, "cgo:%t\narch:%s\n", useCGO, goArch)
+	for _, eachFlag := range buildFlags {
+		fmt.Fprintf(hash, "flag:%s\n", eachFlag)
+	}
+	buildEnvironment := append([]string{}, buildEnv...)
+	for _, eachPair := range os.Environ() {
+		if strings.HasPrefix(eachPair, "GO") ||
+			strings.HasPrefix(eachPair, "CGO_") ||
+			strings.HasPrefix(eachPair, "SPARTA_") {
+			buildEnvironment = append(buildEnvironment, eachPair)
+		}
+	}
+	sort.Strings(buildEnvironment)
+	for _, eachPair := range buildEnvironment {
+		fmt.Fprintf(hash, "env:%s\n", eachPair)
+	}
+
+	// filepath.Walk visits the files in lexical order
+	walkErr := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != "." && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() ||
+			filepath.Clean(path) == filepath.Clean(executableOutput) {
+			return nil
+		}
+		/* #nosec */
+		file, fileErr := os.Open(path)
+		if fileErr != nil {
+			return fileErr
+		}
+		defer file.Close()
+		fmt.Fprintf(hash, "file:%s:%d\n", filepath.ToSlash(path), info.Size())
+		_, copyErr := io.Copy(hash, file)
+		return copyErr
+	})
+	if walkErr != nil {
+		return "", errors.Wrapf(walkErr, "Failed to hash build inputs")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyLocalFile copies the sourcePath file to targetPath with executable
+// permissions
+func copyLocalFile(sourcePath string, targetPath string) error {
+	/* #nosec */
+	source, sourceErr := os.Open(sourcePath)
+	if sourceErr != nil {
+		return sourceErr
+	}
+	defer source.Close()
+	/* #nosec */
+	target, targetErr := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if targetErr != nil {
+		return targetErr
+	}
+	_, copyErr := io.Copy(target, source)
+	closeErr := target.Close()
+	if copyErr != nil {
+		return copyErr
+	}
+	return closeErr
+}
+
+// cacheBuiltBinary copies the built binary into the build cache and
+// removes the service's previously cached binaries
+func cacheBuiltBinary(serviceName string,
+	executableOutput string,
+	cachedBinaryPath string) error {
+	mkdirErr := os.MkdirAll(buildCacheDirectory(), os.ModePerm)
+	if mkdirErr != nil {
+		return mkdirErr
+	}
+	// Match the hex encoded SHA256 digest exactly so that services whose
+	// names share a prefix don't match
+	staleBinaries, _ := filepath.Glob(filepath.Join(buildCacheDirectory(),
+		fmt.Sprintf("%s-%s.lambda.*", sanitizedName(serviceName), strings.Repeat("?", sha256.Size*2))))
+	for _, eachBinary := range staleBinaries {
+		if eachBinary != cachedBinaryPath {
+			os.Remove(eachBinary)
+		}
+	}
+	return copyLocalFile(executableOutput, cachedBinaryPath)
+}
+
+func buildGoBinary(cmdContext context.Context,
+	serviceName string,
 	executableOutput string,
 	useCGO bool,
 	buildID string,
 	buildTags string,
 	linkFlags string,
 	noop bool,
+	buildConcurrency int,
+	buildOptions *BuildOptions,
+	goArch string,
+	forceBuild bool,
 	logger *logrus.Logger) error {
 
 	// Before we do anything, let's make sure there's a `main` package in this directory.
@@ -770,9 +1659,9 @@ func buildGoBinary(serviceName string,
 		return ensureMainPackageErr
 	}
 	// Go generate
-	cmd := exec.Command("go", "generate")
+	cmd := exec.CommandContext(cmdContext, "go", "generate")
 	if logger.Level == logrus.DebugLevel {
-		cmd = exec.Command("go", "generate", "-v", "-x")
+		cmd = exec.CommandContext(cmdContext, "go", "generate", "-v", "-x")
 	}
 	cmd.Env = os.Environ()
 	commandString := fmt.Sprintf("%s", cmd.Args)
@@ -787,9 +1676,23 @@ func buildGoBinary(serviceName string,
 	if noop {
 		noopTag = "noop "
 	}
+	buildEnv := []string{}
+	if nil != buildOptions {
+		buildTags = strings.TrimSpace(fmt.Sprintf("%s %s",
+			buildTags,
+			strings.Join(buildOptions.Tags, " ")))
+		linkFlags = strings.TrimSpace(fmt.Sprintf("%s %s",
+			linkFlags,
+			buildOptions.LinkerFlags))
+		buildEnv = append(buildEnv, userBuildEnvironment(buildOptions.Env, logger)...)
+	}
 
 	userBuildFlags := []string{"-tags",
 		fmt.Sprintf("lambdabinary %s%s", noopTag, buildTags)}
+	// Limit the build parallelism?
+	concurrencyFlags, concurrencyEnv := buildConcurrencySettings(buildConcurrency)
+	userBuildFlags = append(userBuildFlags, concurrencyFlags...)
+	buildEnv = append(buildEnv, concurrencyEnv...)
 
 	// Append all the linker flags
 	// Stamp the service name into the binary
@@ -804,16 +1707,54 @@ func buildGoBinary(serviceName string,
 		"StampedServiceName": serviceName,
 		"StampedBuildID":     buildID,
 	}
-	for eachFlag, eachValue := range linkerFlags {
+	// Sorted so that the build flags, and therefore the build cache digest,
+	// are stable
+	linkerFlagNames := make([]string, 0, len(linkerFlags))
+	for eachFlag := range linkerFlags {
+		linkerFlagNames = append(linkerFlagNames, eachFlag)
+	}
+	sort.Strings(linkerFlagNames)
+	for _, eachFlag := range linkerFlagNames {
 		linkFlags = fmt.Sprintf("%s -s -w -X github.com/mweagle/Sparta.%s=%s",
 			linkFlags,
 			eachFlag,
-			eachValue)
+			linkerFlags[eachFlag])
 	}
 	linkFlags = strings.TrimSpace(linkFlags)
 	if len(linkFlags) != 0 {
 		userBuildFlags = append(userBuildFlags, "-ldflags", linkFlags)
 	}
+
+	// Reuse the previously built binary if none of the build inputs changed
+	cachedBinaryPath := ""
+	if !forceBuild {
+		buildDigest, buildDigestErr := buildInputsDigest(cmdContext,
+			executableOutput,
+			useCGO,
+			goArch,
+			userBuildFlags,
+			buildEnv)
+		if buildDigestErr != nil {
+			logger.WithFields(logrus.Fields{
+				"Error": buildDigestErr,
+			}).Warn("Failed to compute build inputs digest. Skipping build cache")
+		} else {
+			cachedBinaryPath = buildCachePath(serviceName, buildDigest, goArch)
+			if _, statErr := os.Stat(cachedBinaryPath); statErr == nil {
+				copyErr := copyLocalFile(cachedBinaryPath, executableOutput)
+				if copyErr == nil {
+					logger.WithFields(logrus.Fields{
+						"Name":   executableOutput,
+						"Cached": relativePath(cachedBinaryPath),
+					}).Info("Reusing cached binary. Build inputs are unchanged. Use --forceBuild to rebuild")
+					return nil
+				}
+				logger.WithFields(logrus.Fields{
+					"Error": copyErr,
+				}).Warn("Failed to reuse cached binary")
+			}
+		}
+	}
 	// If this is CGO, do the Docker build if we're doing an actual
 	// provision. Otherwise use the "normal" build to keep things
 	// a bit faster.
@@ -842,9 +1783,9 @@ func buildGoBinary(serviceName string,
 		if goosTarget == "" {
 			goosTarget = "linux"
 		}
-		goArch := os.Getenv("SPARTA_GOARCH")
-		if goArch == "" {
-			goArch = "amd64"
+		goArchTarget := os.Getenv("SPARTA_GOARCH")
+		if goArchTarget == "" {
+			goArchTarget = goArch
 		}
 		spartaEnvVars := []string{
 			"-e",
@@ -852,7 +1793,10 @@ func buildGoBinary(serviceName string,
 			"-e",
 			fmt.Sprintf("GOOS=%s", goosTarget),
 			"-e",
-			fmt.Sprintf("GOARCH=%s", goArch),
+			fmt.Sprintf("GOARCH=%s", goArchTarget),
+		}
+		for _, eachPair := range buildEnv {
+			spartaEnvVars = append(spartaEnvVars, "-e", eachPair)
 		}
 		// User vars
 		for _, eachPair := range os.Environ() {
@@ -880,7 +1824,7 @@ func buildGoBinary(serviceName string,
 			"-buildmode=c-shared",
 		)
 		dockerBuildArgs = append(dockerBuildArgs, userBuildFlags...)
-		cmd = exec.Command("docker", dockerBuildArgs...)
+		cmd = exec.CommandContext(cmdContext, "docker", dockerBuildArgs...)
 		cmd.Env = os.Environ()
 		logger.WithFields(logrus.Fields{
 			"Name": executableOutput,
@@ -922,21 +1866,188 @@ func buildGoBinary(serviceName string,
 		}
 		buildArgs = append(buildArgs, userBuildFlags...)
 		buildArgs = append(buildArgs, ".")
-		cmd = exec.Command("go", buildArgs...)
+		cmd = exec.CommandContext(cmdContext, "go", buildArgs...)
 		cmd.Env = os.Environ()
-		cmd.Env = append(cmd.Env, "GOOS=linux", "GOARCH=amd64")
+		cmd.Env = append(cmd.Env, "GOOS=linux", fmt.Sprintf("GOARCH=%s", goArch))
+		cmd.Env = append(cmd.Env, buildEnv...)
 		logger.WithFields(logrus.Fields{
 			"Name": executableOutput,
 		}).Info("Compiling binary")
 		cmdError = runOSCommand(cmd, logger)
 	}
+	if cmdError == nil && cachedBinaryPath != "" {
+		cacheErr := cacheBuiltBinary(serviceName, executableOutput, cachedBinaryPath)
+		if cacheErr != nil {
+			logger.WithFields(logrus.Fields{
+				"Error": cacheErr,
+			}).Warn("Failed to cache binary")
+		}
+	}
 	return cmdError
 }
 
+// binaryTargetDescription returns the os/arch pair the binary at binaryPath
+// was compiled for, as best as it can be determined from the file header.
+func binaryTargetDescription(binaryPath string) string {
+	machoArchs := map[macho.Cpu]string{
+		macho.CpuAmd64: "amd64",
+		macho.CpuArm64: "arm64",
+		macho.Cpu386:   "386",
+	}
+	peArchs := map[uint16]string{
+		pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+		pe.IMAGE_FILE_MACHINE_I386:  "386",
+		0xaa64:                      "arm64",
+	}
+	if machoFile, machoFileErr := macho.Open(binaryPath); machoFileErr == nil {
+		defer machoFile.Close()
+		arch, archOk := machoArchs[machoFile.Cpu]
+		if !archOk {
+			arch = machoFile.Cpu.String()
+		}
+		return fmt.Sprintf("darwin/%s", arch)
+	}
+	if peFile, peFileErr := pe.Open(binaryPath); peFileErr == nil {
+		defer peFile.Close()
+		arch, archOk := peArchs[peFile.Machine]
+		if !archOk {
+			arch = fmt.Sprintf("0x%x", peFile.Machine)
+		}
+		return fmt.Sprintf("windows/%s", arch)
+	}
+	return "an unknown platform"
+}
+
+// verifyLambdaBinary ensures that the compiled binary is a 64-bit Linux ELF
+// file for the target architecture. This catches builds where GOOS/GOARCH
+// were overridden by the environment before they fail at Lambda runtime.
+func verifyLambdaBinary(binaryPath string, targetArch string, logger *logrus.Logger) error {
+	elfMachines := map[string]elf.Machine{
+		"amd64": elf.EM_X86_64,
+		"arm64": elf.EM_AARCH64,
+	}
+	lambdaTarget := fmt.Sprintf("linux/%s", targetArch)
+	expectedMachine, expectedMachineOk := elfMachines[targetArch]
+	if !expectedMachineOk {
+		return errors.Errorf("Unsupported Lambda architecture: %s", targetArch)
+	}
+	mismatchErr := func(builtFor string) error {
+		return errors.Errorf("Binary %s was built for %s but Lambda needs %s",
+			binaryPath,
+			builtFor,
+			lambdaTarget)
+	}
+	elfFile, elfFileErr := elf.Open(binaryPath)
+	if elfFileErr != nil {
+		return mismatchErr(binaryTargetDescription(binaryPath))
+	}
+	defer elfFile.Close()
+
+	if elfFile.Class != elf.ELFCLASS64 {
+		return mismatchErr(fmt.Sprintf("a %s %s target", elfFile.Class, elfFile.Machine))
+	}
+	if elfFile.OSABI != elf.ELFOSABI_NONE && elfFile.OSABI != elf.ELFOSABI_LINUX {
+		return mismatchErr(fmt.Sprintf("%s/%s", elfFile.OSABI, elfFile.Machine))
+	}
+	if elfFile.Type != elf.ET_EXEC && elfFile.Type != elf.ET_DYN {
+		return errors.Errorf("Binary %s is not an executable (ELF type: %s)",
+			binaryPath,
+			elfFile.Type)
+	}
+	if elfFile.Machine != expectedMachine {
+		builtArch := elfFile.Machine.String()
+		for eachArch, eachMachine := range elfMachines {
+			if eachMachine == elfFile.Machine {
+				builtArch = eachArch
+			}
+		}
+		return mismatchErr(fmt.Sprintf("linux/%s", builtArch))
+	}
+	logger.WithFields(logrus.Fields{
+		"Path":    binaryPath,
+		"Class":   elfFile.Class,
+		"Machine": elfFile.Machine,
+	}).Debug("Verified binary target")
+	return nil
+}
+
+// compressBinary compresses the binary in place with `upx`. Compression is
+// skipped with a warning if `upx` isn't available or can't compress the
+// binary. The compressed binary is verified with `upx -t`, since the Lambda
+// binary generally can't be run on the build host.
+func compressBinary(cmdContext context.Context, binaryPath string, logger *logrus.Logger) error {
+	upxPath, lookPathErr := exec.LookPath("upx")
+	if lookPathErr != nil {
+		logger.Warn("UPX compression requested, but `upx` isn't on the PATH. Skipping compression")
+		return nil
+	}
+	originalStat, originalStatErr := os.Stat(binaryPath)
+	if originalStatErr != nil {
+		return errors.Wrapf(originalStatErr, "Failed to stat binary: %s", binaryPath)
+	}
+	/* #nosec */
+	compressCmd := exec.CommandContext(cmdContext, upxPath, "-q", binaryPath)
+	compressErr := runOSCommand(compressCmd, logger)
+	if compressErr != nil {
+		// upx leaves the binary unchanged if it fails
+		logger.WithFields(logrus.Fields{
+			"Error": compressErr,
+		}).Warn("Failed to compress binary with `upx`. Skipping compression")
+		return nil
+	}
+	/* #nosec */
+	testCmd := exec.CommandContext(cmdContext, upxPath, "-q", "-t", binaryPath)
+	testErr := runOSCommand(testCmd, logger)
+	if testErr != nil {
+		return errors.Wrapf(testErr, "Failed to verify `upx` compressed binary: %s", binaryPath)
+	}
+	compressedStat, compressedStatErr := os.Stat(binaryPath)
+	if compressedStatErr != nil {
+		return errors.Wrapf(compressedStatErr, "Failed to stat binary: %s", binaryPath)
+	}
+	logger.WithFields(logrus.Fields{
+		"OriginalSize":   humanize.Bytes(uint64(originalStat.Size())),
+		"CompressedSize": humanize.Bytes(uint64(compressedStat.Size())),
+		"Ratio":          fmt.Sprintf("%.1f%%", 100*float64(compressedStat.Size())/float64(originalStat.Size())),
+	}).Info("Compressed binary with `upx`")
+	return nil
+}
+
+// reportProgress notifies the optional ProgressFunc of the workflow
+// progress. The StackName is always included in the detail.
+func reportProgress(ctx *workflowContext,
+	step string,
+	pct int,
+	detail map[string]interface{}) {
+	if ctx.userdata.options == nil || ctx.userdata.options.progressFunc == nil {
+		return
+	}
+	if detail == nil {
+		detail = make(map[string]interface{})
+	}
+	detail["StackName"] = ctx.userdata.serviceName
+	ctx.userdata.options.progressFunc(step, pct, detail)
+}
+
+// stackProgressFunc returns the spartaCF.StackProgressFunc that reports
+// each converge poll to the optional ProgressFunc, or nil if there is none
+func stackProgressFunc(ctx *workflowContext) spartaCF.StackProgressFunc {
+	if ctx.userdata.options == nil || ctx.userdata.options.progressFunc == nil {
+		return nil
+	}
+	return func(stack *cloudformation.Stack) {
+		reportProgress(ctx, ProgressStepEnsureCloudFormationStack, 90, map[string]interface{}{
+			"StackId":     aws.StringValue(stack.StackId),
+			"StackStatus": aws.StringValue(stack.StackStatus),
+		})
+	}
+}
+
 // Build and package the application
 func createPackageStep() workflowStep {
 	return func(ctx *workflowContext) (workflowStep, error) {
 		defer recordDuration(time.Now(), "Creating code bundle", ctx)
+		reportProgress(ctx, ProgressStepCreatePackage, 25, nil)
 
 		// PreBuild Hook
 		if ctx.userdata.workflowHooks != nil {
@@ -949,27 +2060,62 @@ func createPackageStep() workflowStep {
 			}
 		}
 		sanitizedServiceName := sanitizedName(ctx.userdata.serviceName)
-		buildErr := buildGoBinary(ctx.userdata.serviceName,
+		if ctx.userdata.options.goModuleCheck {
+			moduleErr := verifyGoModuleConsistency(ctx.stepContext, ctx.logger)
+			if nil != moduleErr {
+				return nil, moduleErr
+			}
+		}
+		// Cleanup the temporary binary, including a partial copy from the
+		// build cache
+		defer func() {
+			if preserveArtifact(ctx, ctx.context.binaryName) {
+				return
+			}
+			errRemove := os.Remove(ctx.context.binaryName)
+			if nil != errRemove && !os.IsNotExist(errRemove) {
+				ctx.logger.WithFields(logrus.Fields{
+					"File":  ctx.context.binaryName,
+					"Error": errRemove,
+				}).Warn("Failed to delete binary")
+			}
+		}()
+		buildErr := buildGoBinary(ctx.stepContext,
+			ctx.userdata.serviceName,
 			ctx.context.binaryName,
 			ctx.userdata.useCGO,
 			ctx.userdata.buildID,
 			ctx.userdata.buildTags,
 			ctx.userdata.linkFlags,
 			ctx.userdata.noop,
+			ctx.userdata.options.buildConcurrency,
+			ctx.userdata.options.buildOptions,
+			lambdaGoArch(ctx.userdata.options.lambdaArchitecture),
+			ctx.userdata.options.forceBuild,
 			ctx.logger)
 		if nil != buildErr {
 			return nil, buildErr
 		}
-		// Cleanup the temporary binary
-		defer func() {
-			errRemove := os.Remove(ctx.context.binaryName)
-			if nil != errRemove {
-				ctx.logger.WithFields(logrus.Fields{
-					"File":  ctx.context.binaryName,
-					"Error": errRemove,
-				}).Warn("Failed to delete binary")
+		// Make sure we actually built something Lambda can run
+		verifyErr := verifyLambdaBinary(ctx.context.binaryName,
+			lambdaGoArch(ctx.userdata.options.lambdaArchitecture),
+			ctx.logger)
+		if nil != verifyErr {
+			return nil, verifyErr
+		}
+		if ctx.userdata.options.upxCompression {
+			compressErr := compressBinary(ctx.stepContext, ctx.context.binaryName, ctx.logger)
+			if nil != compressErr {
+				return nil, compressErr
 			}
-		}()
+		}
+		if ctx.userdata.options.deploymentHistory {
+			binaryDigest, binaryDigestErr := fileSHA256(ctx.context.binaryName)
+			if nil != binaryDigestErr {
+				return nil, binaryDigestErr
+			}
+			ctx.context.binaryDigest = binaryDigest
+		}
 
 		// PostBuild Hook
 		if ctx.userdata.workflowHooks != nil {
@@ -985,6 +2131,7 @@ func createPackageStep() workflowStep {
 		if err != nil {
 			return nil, err
 		}
+		ctx.registerFileCleanupRollback(tmpFile)
 		// Strip the local directory in case it's in there...
 		ctx.logger.WithFields(logrus.Fields{
 			"TempName": relativePath(tmpFile.Name()),
@@ -997,14 +2144,17 @@ func createPackageStep() workflowStep {
 			return nil, archiveErr
 		}
 		// Issue: https://github.com/mweagle/Sparta/issues/103. If the executable
-		// bit isn't set, then AWS Lambda won't be able to fork the binary
-		var fileHeaderAnnotator spartaZip.FileHeaderAnnotator
-		if runtime.GOOS == "windows" {
-			fileHeaderAnnotator = func(header *zip.FileHeader) (*zip.FileHeader, error) {
-				// Make the binary executable
-				header.ExternalAttrs = 0777 << 16
-				return header, nil
-			}
+		// bit isn't set, then AWS Lambda won't be able to fork the binary. The
+		// mode is set explicitly so that it doesn't depend on the host
+		// filesystem (eg, Windows or a noexec mount)
+		// The OS-only provided runtimes require the executable to be named
+		// `bootstrap`
+		handlerName := lambdaHandlerName(ctx.userdata.options.lambdaRuntime,
+			ctx.context.binaryName)
+		fileHeaderAnnotator := func(header *zip.FileHeader) (*zip.FileHeader, error) {
+			header.Name = handlerName
+			header.SetMode(0755)
+			return header, nil
 		}
 		// File info for the binary executable
 		readerErr := spartaZip.AnnotateAddToZip(lambdaArchive,
@@ -1023,8 +2173,20 @@ func createPackageStep() workflowStep {
 		if nil != tempfileCloseErr {
 			return nil, tempfileCloseErr
 		}
-		return createUploadStep(tmpFile.Name()), nil
+		return phaseTimeoutStep(ProvisionPhaseUpload, createUploadStep(tmpFile.Name())), nil
+	}
+}
+
+// uploadErrorsError returns a single error that includes the message of
+// each upload error
+func uploadErrorsError(uploadErrors []error) error {
+	errorText := make([]string, len(uploadErrors))
+	for index, eachError := range uploadErrors {
+		errorText[index] = eachError.Error()
 	}
+	return errors.Errorf("Encountered %d error(s) during upload:\n\t%s",
+		len(uploadErrors),
+		strings.Join(errorText, "\n\t"))
 }
 
 // Given the zipped binary in packagePath, upload the primary code bundle
@@ -1032,16 +2194,53 @@ func createPackageStep() workflowStep {
 func createUploadStep(packagePath string) workflowStep {
 	return func(ctx *workflowContext) (workflowStep, error) {
 		defer recordDuration(time.Now(), "Uploading code", ctx)
+		reportProgress(ctx, ProgressStepCreateUpload, 50, map[string]interface{}{
+			"PackagePath": packagePath,
+		})
 
 		var uploadTasks []*workTask
 		// We always upload the primary binary...
 		uploadBinaryTask := func() workResult {
 			logFilesize("Lambda code archive size", packagePath, ctx.logger)
 
-			// Create the S3 key...
-			zipS3URL, zipS3URLErr := uploadLocalFileToS3(packagePath, "", ctx)
-			if nil != zipS3URLErr {
-				return newTaskResult(nil, zipS3URLErr)
+			// The archive is deterministic, so the S3 key is content addressable
+			// and an existing object with the same content can be reused
+			packageDigest, packageDigestErr := fileSHA256(packagePath)
+			if nil != packageDigestErr {
+				return newTaskResult(nil, packageDigestErr)
+			}
+			zipS3Key := fmt.Sprintf("%s%s-code-%s.zip",
+				artifactKeyPrefix(ctx),
+				sanitizedName(ctx.userdata.serviceName),
+				packageDigest)
+			zipS3URL := ""
+			if !ctx.userdata.noop {
+				existingURL, existingURLErr := spartaS3.ObjectLocation(ctx.stepContext,
+					ctx.context.s3Svc,
+					s3BucketConfig(ctx),
+					ctx.userdata.s3Bucket,
+					zipS3Key,
+					ctx.context.s3RequestOptions...)
+				if nil != existingURLErr {
+					return newTaskResult(nil, existingURLErr)
+				}
+				if existingURL != "" {
+					ctx.logger.WithFields(logrus.Fields{
+						"Bucket": ctx.userdata.s3Bucket,
+						"Key":    zipS3Key,
+					}).Info("Code archive is unchanged. Skipping upload")
+					// The existing object isn't registered for rollback since
+					// the deployed stack may reference it
+					ctx.registerFileCleanupFinalizer(packagePath)
+					zipS3URL = existingURL
+				}
+			}
+			if zipS3URL == "" {
+				uploadURL, uploadURLErr := uploadLocalFileToS3(packagePath, zipS3Key, ctx)
+				if nil != uploadURLErr {
+					return newTaskResult(nil, uploadURLErr)
+				}
+				zipS3URL = uploadURL
 			}
 			ctx.context.s3CodeZipURL = newS3UploadURL(zipS3URL)
 			return newTaskResult(ctx.context.s3CodeZipURL, nil)
@@ -1057,6 +2256,7 @@ func createUploadStep(packagePath string) workflowStep {
 					return newTaskResult(nil,
 						errors.Wrapf(err, "Failed to create temporary S3 site archive file"))
 				}
+				ctx.registerFileCleanupRollback(tmpFile)
 
 				// Add the contents to the Zip file
 				zipArchive := zip.NewWriter(tmpFile)
@@ -1070,7 +2270,14 @@ func createUploadStep(packagePath string) workflowStep {
 					"SourcePath": absResourcePath,
 				}).Info("Creating S3Site archive")
 
-				err = spartaZip.AddToZip(zipArchive, absResourcePath, absResourcePath, ctx.logger)
+				err = spartaZip.AddToZipWithOptions(zipArchive,
+					absResourcePath,
+					absResourcePath,
+					spartaZip.ArchiveOptions{
+						ExcludePatterns: ctx.userdata.s3SiteContext.s3Site.ExcludePatterns,
+						Symlinks:        ctx.userdata.s3SiteContext.s3Site.Symlinks,
+					},
+					ctx.logger)
 				if nil != err {
 					return newTaskResult(nil, err)
 				}
@@ -1097,7 +2304,7 @@ func createUploadStep(packagePath string) workflowStep {
 		_, uploadErrors := p.Run()
 
 		if len(uploadErrors) > 0 {
-			return nil, errors.Errorf("Encountered multiple errors during upload: %#v", uploadErrors)
+			return nil, uploadErrorsError(uploadErrors)
 		}
 		return validateSpartaPostconditions(), nil
 	}
@@ -1140,7 +2347,14 @@ func createCodePipelineTriggerPackage(cfTemplateJSON []byte, ctx *workflowContex
 	// If there is a codePipelineEnvironments defined, then we'll need to get all the
 	// maps, marshal them to JSON, then add the JSON to the ZIP archive.
 	if nil != codePipelineEnvironments {
-		for eachEnvironment, eachMap := range codePipelineEnvironments {
+		// Sort the environments so that the archive entry order is stable
+		environmentNames := []string{}
+		for eachEnvironment := range codePipelineEnvironments {
+			environmentNames = append(environmentNames, eachEnvironment)
+		}
+		sort.Strings(environmentNames)
+		for _, eachEnvironment := range environmentNames {
+			eachMap := codePipelineEnvironments[eachEnvironment]
 			codePipelineParameters := map[string]interface{}{
 				"Parameters": eachMap,
 			}
@@ -1178,12 +2392,31 @@ func createCodePipelineTriggerPackage(cfTemplateJSON []byte, ctx *workflowContex
 	return tmpFile.Name(), nil
 }
 
+// isSelectedFunctionResource returns true if the function with the given
+// logical resource name should be updated
+func isSelectedFunctionResource(ctx *workflowContext, logicalResourceName string) bool {
+	if ctx.userdata.options.selectedFunctions == nil {
+		return true
+	}
+	for _, eachLambda := range ctx.userdata.lambdaAWSInfos {
+		if eachLambda.LogicalResourceName() != logicalResourceName {
+			continue
+		}
+		for _, eachName := range ctx.userdata.options.selectedFunctions {
+			if eachName == eachLambda.lambdaFunctionName() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // If the only detected changes to a stack are Lambda code updates,
 // then update use the LAmbda API to update the code directly
 // rather than waiting for CloudFormation
 func applyInPlaceFunctionUpdates(ctx *workflowContext, templateURL string) (*cloudformation.Stack, error) {
 	// Get the updates...
-	awsCloudFormation := cloudformation.New(ctx.context.awsSession)
+	awsCloudFormation := ctx.context.cfSvc
 	changeSetRequestName := CloudFormationResourceName(fmt.Sprintf("%sInPlaceChangeSet", ctx.userdata.serviceName))
 	changes, changesErr := spartaCF.CreateStackChangeSet(changeSetRequestName,
 		ctx.userdata.serviceName,
@@ -1203,6 +2436,12 @@ func applyInPlaceFunctionUpdates(ctx *workflowContext, templateURL string) (*clo
 	for _, eachChange := range changes.Changes {
 		resourceChange := eachChange.ResourceChange
 		if *resourceChange.Action == "Modify" && *resourceChange.ResourceType == "AWS::Lambda::Function" {
+			if !isSelectedFunctionResource(ctx, *resourceChange.LogicalResourceId) {
+				ctx.logger.WithFields(logrus.Fields{
+					"Resource": *resourceChange.LogicalResourceId,
+				}).Debug("Skipping in-place update for unselected function")
+				continue
+			}
 			updateCodeRequest := &lambda.UpdateFunctionCodeInput{
 				FunctionName: resourceChange.PhysicalResourceId,
 				S3Bucket:     aws.String(ctx.userdata.s3Bucket),
@@ -1232,16 +2471,15 @@ func applyInPlaceFunctionUpdates(ctx *workflowContext, templateURL string) (*clo
 	}).Debug("Update requests")
 
 	// TODO - migrate to the worker pool
-	updateTaskMaker := func(lambdaSvc *lambda.Lambda, request *lambda.UpdateFunctionCodeInput) taskFunc {
+	updateTaskMaker := func(lambdaSvc lambdaAPI, request *lambda.UpdateFunctionCodeInput) taskFunc {
 		return func() workResult {
 			_, updateResultErr := lambdaSvc.UpdateFunctionCode(request)
 			return newTaskResult("", updateResultErr)
 		}
 	}
 	var inPlaceUpdateTasks []*workTask
-	awsLambda := lambda.New(ctx.context.awsSession)
 	for _, eachUpdateCodeRequest := range updateCodeRequests {
-		updateTask := updateTaskMaker(awsLambda, eachUpdateCodeRequest)
+		updateTask := updateTaskMaker(ctx.context.lambdaSvc, eachUpdateCodeRequest)
 		inPlaceUpdateTasks = append(inPlaceUpdateTasks, newWorkTask(updateTask))
 	}
 
@@ -1271,17 +2509,33 @@ func applyInPlaceFunctionUpdates(ctx *workflowContext, templateURL string) (*clo
 	return describeStackOutput.Stacks[0], nil
 }
 
+// serviceStackTags returns the user-supplied and Sparta tags for the
+// service's CloudFormation stack. Reserved `aws:` keys are rejected by
+// CloudFormation, so they're logged and ignored.
+func serviceStackTags(ctx *workflowContext) map[string]string {
+	stackTags := make(map[string]string)
+	for eachKey, eachValue := range ctx.userdata.options.stackTags {
+		if strings.HasPrefix(strings.ToLower(eachKey), "aws:") {
+			ctx.logger.WithFields(logrus.Fields{
+				"Key": eachKey,
+			}).Warn("Ignoring stack tag with reserved `aws:` prefix")
+			continue
+		}
+		stackTags[eachKey] = eachValue
+	}
+	stackTags[SpartaTagBuildIDKey] = ctx.userdata.buildID
+	if len(ctx.userdata.buildTags) != 0 {
+		stackTags[SpartaTagBuildTagsKey] = ctx.userdata.buildTags
+	}
+	return stackTags
+}
+
 // applyCloudFormationOperation is responsible for taking the current template
 // and applying that operation to the stack. It's where the in-place
 // branch is applied, because at this point all the template
 // mutations have been accumulated
 func applyCloudFormationOperation(ctx *workflowContext) (workflowStep, error) {
-	stackTags := map[string]string{
-		SpartaTagBuildIDKey: ctx.userdata.buildID,
-	}
-	if len(ctx.userdata.buildTags) != 0 {
-		stackTags[SpartaTagBuildTagsKey] = ctx.userdata.buildTags
-	}
+	stackTags := serviceStackTags(ctx)
 	// Generate the CF template...
 	cfTemplate, err := json.Marshal(ctx.context.cfTemplate)
 	if err != nil {
@@ -1292,6 +2546,27 @@ func applyCloudFormationOperation(ctx *workflowContext) (workflowStep, error) {
 	// Consistent naming of template
 	sanitizedServiceName := sanitizedName(ctx.userdata.serviceName)
 	templateName := fmt.Sprintf("%s-cftemplate.json", sanitizedServiceName)
+
+	// If the template is canonicalized, the S3 key is content addressable
+	templateS3Key := ""
+	if ctx.userdata.options.canonicalTemplate {
+		canonicalTemplate, canonicalTemplateErr := spartaCF.CanonicalTemplateJSON(cfTemplate)
+		if nil != canonicalTemplateErr {
+			return nil, canonicalTemplateErr
+		}
+		cfTemplate = canonicalTemplate
+		templateDigest := sha256.Sum256(cfTemplate)
+		templateS3Key = fmt.Sprintf("%s%s-cftemplate-%s.json",
+			artifactKeyPrefix(ctx),
+			sanitizedServiceName,
+			hex.EncodeToString(templateDigest[:]))
+		ctx.logger.WithFields(logrus.Fields{
+			"Key": templateS3Key,
+		}).Debug("Canonical template key")
+	}
+	if len(cfTemplate) > spartaCF.MaxTemplateSize {
+		return nil, templateTooLargeError(cfTemplate, ctx.logger)
+	}
 	templateFile, templateFileErr := temporaryFile(templateName)
 	if nil != templateFileErr {
 		return nil, templateFileErr
@@ -1305,22 +2580,46 @@ func applyCloudFormationOperation(ctx *workflowContext) (workflowStep, error) {
 		return nil, errClose
 	}
 	// Log the template if needed
-	if nil != ctx.context.templateWriter || ctx.logger.Level <= logrus.DebugLevel {
-		templateBody := string(cfTemplate)
-		formatted, formattedErr := json.MarshalIndent(templateBody, "", " ")
+	templateOutputPath := ctx.userdata.options.templateOutputPath
+	if nil != ctx.context.templateWriter ||
+		templateOutputPath != "" ||
+		ctx.logger.Level <= logrus.DebugLevel {
+		var formatted bytes.Buffer
+		formattedErr := json.Indent(&formatted, cfTemplate, "", " ")
 		if nil != formattedErr {
 			return nil, formattedErr
 		}
+		// The uploaded template is always JSON
+		if ctx.userdata.options.templateFormat == TemplateFormatYAML {
+			yamlTemplate, yamlTemplateErr := spartaCF.TemplateYAML(cfTemplate)
+			if nil != yamlTemplateErr {
+				return nil, yamlTemplateErr
+			}
+			formatted.Reset()
+			formatted.Write(yamlTemplate)
+		}
 		ctx.logger.WithFields(logrus.Fields{
-			"Body": string(formatted),
+			"Body": formatted.String(),
 		}).Debug("CloudFormation template body")
 		if nil != ctx.context.templateWriter {
-			_, writeErr := io.WriteString(ctx.context.templateWriter,
-				string(formatted))
+			_, writeErr := ctx.context.templateWriter.Write(formatted.Bytes())
 			if writeErr != nil {
 				return nil, errors.Wrapf(writeErr, "Failed to write template")
 			}
 		}
+		if templateOutputPath != "" {
+			mkdirErr := os.MkdirAll(filepath.Dir(templateOutputPath), os.ModePerm)
+			if nil != mkdirErr {
+				return nil, errors.Wrapf(mkdirErr, "Failed to create template output directory")
+			}
+			writeErr := ioutil.WriteFile(templateOutputPath, formatted.Bytes(), 0644)
+			if writeErr != nil {
+				return nil, errors.Wrapf(writeErr, "Failed to write template to %s", templateOutputPath)
+			}
+			ctx.logger.WithFields(logrus.Fields{
+				"Path": relativePath(templateOutputPath),
+			}).Info("Wrote CloudFormation template")
+		}
 	}
 
 	// If this isn't a codePipelineTrigger, then do that
@@ -1330,13 +2629,80 @@ func applyCloudFormationOperation(ctx *workflowContext) (workflowStep, error) {
 				"Bucket":       ctx.userdata.s3Bucket,
 				"TemplateName": templateName,
 			}).Info(noopMessage("Stack creation"))
+			if ctx.userdata.options.dryRunDiff {
+				diffErr := logStackTemplateDiff(ctx)
+				if nil != diffErr {
+					return nil, diffErr
+				}
+			}
+			if ctx.userdata.options.changeSetReview {
+				reviewErr := logStackChangeSet(ctx)
+				if nil != reviewErr {
+					return nil, reviewErr
+				}
+			}
 		} else {
+			// Fail fast if we'd remove an export that's still in use
+			exportsErr := spartaCF.ValidateStackExports(ctx.userdata.serviceName,
+				ctx.context.cfTemplate,
+				ctx.context.cfSvc,
+				ctx.logger)
+			if nil != exportsErr {
+				return nil, exportsErr
+			}
+			// Or if we'd add an export that another stack owns
+			exportNamesErr := spartaCF.ValidateExportNames(ctx.userdata.serviceName,
+				ctx.context.exportNames,
+				ctx.context.cfSvc,
+				ctx.logger)
+			if nil != exportNamesErr {
+				return nil, exportNamesErr
+			}
+			// Surface template errors before any stack operation. Templates
+			// that fit inline are validated before they're uploaded
+			inlineValidation := len(cfTemplate) <= spartaCF.MaxTemplateBodySize
+			if inlineValidation {
+				validateErr := spartaCF.ValidateTemplate(ctx.context.cfTemplate,
+					cfTemplate,
+					"",
+					ctx.context.cfSvc,
+					ctx.logger)
+				if nil != validateErr {
+					return nil, validateErr
+				}
+			}
 			// Dump the template to a file, then upload it...
-			uploadURL, uploadURLErr := uploadLocalFileToS3(templateFile.Name(), "", ctx)
+			uploadURL, uploadURLErr := uploadLocalFileToS3(templateFile.Name(), templateS3Key, ctx)
 			if nil != uploadURLErr {
 				return nil, uploadURLErr
 			}
+			if !inlineValidation {
+				validateErr := spartaCF.ValidateTemplate(ctx.context.cfTemplate,
+					nil,
+					uploadURL,
+					ctx.context.cfSvc,
+					ctx.logger)
+				if nil != validateErr {
+					return nil, validateErr
+				}
+			}
+			// Don't leave behind any change sets if the update fails
+			ctx.registerRollback(func(logger *logrus.Logger) error {
+				return spartaCF.DeleteAbandonedChangeSets(ctx.userdata.serviceName,
+					ctx.context.cfSvc,
+					logger)
+			})
 
+			// PreProvision Hook
+			if ctx.userdata.workflowHooks != nil {
+				preProvisionErr := callWorkflowHook("PreProvision",
+					nil,
+					ctx.userdata.workflowHooks.PreProvisions,
+					ctx)
+				if nil != preProvisionErr {
+					return nil, preProvisionErr
+				}
+			}
 			// If we're supposed to be inplace, then go ahead and try that
 			var stack *cloudformation.Stack
 			var stackErr error
@@ -1344,12 +2710,24 @@ func applyCloudFormationOperation(ctx *workflowContext) (workflowStep, error) {
 				stack, stackErr = applyInPlaceFunctionUpdates(ctx, uploadURL)
 			} else {
 				// Regular update, go ahead with the CloudFormation changes
-				stack, stackErr = spartaCF.ConvergeStackState(ctx.userdata.serviceName,
+				convergeContext := spartaCF.WithStackProgress(ctx.stepContext,
+					stackProgressFunc(ctx))
+				stack, stackErr = spartaCF.ConvergeStackStateWithClient(convergeContext,
+					ctx.userdata.serviceName,
 					ctx.context.cfTemplate,
 					uploadURL,
 					stackTags,
+					ctx.userdata.options.stackNotificationARNs,
+					&spartaCF.StackPolicy{
+						Body:             ctx.userdata.options.stackPolicyBody,
+						DuringUpdateBody: ctx.userdata.options.stackPolicyDuringUpdateBody,
+					},
+					ctx.userdata.options.stackPollInterval,
+					ctx.userdata.options.stackOnFailure,
+					ctx.userdata.options.disableRollback,
 					ctx.transaction.startTime,
-					ctx.context.awsSession,
+					ctx.context.cfSvc,
+					aws.StringValue(ctx.context.awsSession.Config.Region),
 					"▬",
 					dividerLength,
 					ctx.logger)
@@ -1362,6 +2740,29 @@ func applyCloudFormationOperation(ctx *workflowContext) (workflowStep, error) {
 				"StackId":      *stack.StackId,
 				"CreationTime": *stack.CreationTime,
 			}).Info("Stack provisioned")
+			ctx.context.stack = stack
+			// PostProvision Hook
+			if ctx.userdata.workflowHooks != nil {
+				postProvisionErr := callWorkflowHook("PostProvision",
+					nil,
+					ctx.userdata.workflowHooks.PostProvisions,
+					ctx)
+				if nil != postProvisionErr {
+					return nil, postProvisionErr
+				}
+			}
+			if ctx.userdata.options.coldStartBenchmark {
+				benchmarkErr := benchmarkColdStarts(ctx)
+				if nil != benchmarkErr {
+					return nil, benchmarkErr
+				}
+			}
+			if ctx.userdata.options.deploymentHistory {
+				historyErr := appendDeploymentHistory(ctx, stack, cfTemplate)
+				if nil != historyErr {
+					return nil, historyErr
+				}
+			}
 		}
 	} else {
 		ctx.logger.Info("Creating pipeline package")
@@ -1375,6 +2776,979 @@ func applyCloudFormationOperation(ctx *workflowContext) (workflowStep, error) {
 	return nil, nil
 }
 
+// reInitDuration matches the cold start duration in the Lambda REPORT log line
+var reInitDuration = regexp.MustCompile(`Init Duration: ([0-9.]+) ms`)
+
+// coldStartProbeEnvVar is the environment variable that's temporarily
+// updated to force a cold start
+const coldStartProbeEnvVar = "SPARTA_COLD_START_PROBE"
+
+// functionUpdatePollInterval is the delay between the
+// GetFunctionConfiguration calls that wait for a configuration update
+const functionUpdatePollInterval = time.Second
+
+// waitForFunctionRevision polls the function configuration until it reports
+// the revisionID returned by an UpdateFunctionConfiguration request
+func waitForFunctionRevision(ctx context.Context,
+	lambdaSvc lambdaAPI,
+	functionName string,
+	revisionID string) error {
+	configInput := &lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String(functionName),
+	}
+	for {
+		configOutput, configOutputErr := lambdaSvc.GetFunctionConfigurationWithContext(ctx, configInput)
+		if configOutputErr != nil {
+			return errors.Wrapf(configOutputErr, "Failed to get function configuration: %s", functionName)
+		}
+		if aws.StringValue(configOutput.RevisionId) == revisionID {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(),
+				"Stopped waiting for function configuration update: %s",
+				functionName)
+		case <-time.After(functionUpdatePollInterval):
+		}
+	}
+}
+
+// benchmarkColdStart forces a cold start of the function by temporarily
+// updating an environment variable, invokes it, and returns the Init Duration
+// reported in the invocation log tail. Each configuration update is awaited
+// before the function is invoked or the probe returns, and the original
+// function configuration is restored before returning.
+func benchmarkColdStart(ctx context.Context,
+	lambdaSvc lambdaAPI,
+	functionName string,
+	logger *logrus.Logger) (float64, error) {
+
+	configInput := &lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String(functionName),
+	}
+	configOutput, configOutputErr := lambdaSvc.GetFunctionConfigurationWithContext(ctx, configInput)
+	if configOutputErr != nil {
+		return 0, errors.Wrapf(configOutputErr, "Failed to get function configuration: %s", functionName)
+	}
+	originalVariables := map[string]*string{}
+	if configOutput.Environment != nil && configOutput.Environment.Variables != nil {
+		originalVariables = configOutput.Environment.Variables
+	}
+	probeVariables := map[string]*string{}
+	for eachKey, eachValue := range originalVariables {
+		probeVariables[eachKey] = eachValue
+	}
+	probeVariables[coldStartProbeEnvVar] = aws.String(time.Now().UTC().Format(time.RFC3339Nano))
+	updateEnvironment := func(variables map[string]*string) error {
+		updateOutput, updateErr := lambdaSvc.UpdateFunctionConfigurationWithContext(ctx,
+			&lambda.UpdateFunctionConfigurationInput{
+				FunctionName: aws.String(functionName),
+				Environment: &lambda.Environment{
+					Variables: variables,
+				},
+			})
+		if updateErr != nil {
+			return errors.Wrapf(updateErr, "Failed to update function configuration: %s", functionName)
+		}
+		// Wait for the update so that the next request isn't rejected
+		// with a ResourceConflictException
+		return waitForFunctionRevision(ctx,
+			lambdaSvc,
+			functionName,
+			aws.StringValue(updateOutput.RevisionId))
+	}
+	probeErr := updateEnvironment(probeVariables)
+	if probeErr != nil {
+		return 0, probeErr
+	}
+	defer func() {
+		restoreErr := updateEnvironment(originalVariables)
+		if restoreErr != nil {
+			logger.WithFields(logrus.Fields{
+				"Function": functionName,
+				"Error":    restoreErr,
+			}).Warn("Failed to restore function configuration after cold start probe")
+		}
+	}()
+
+	invokeOutput, invokeOutputErr := lambdaSvc.InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName: aws.String(functionName),
+		LogType:      aws.String(lambda.LogTypeTail),
+		Payload:      []byte("{}"),
+	})
+	if invokeOutputErr != nil {
+		return 0, errors.Wrapf(invokeOutputErr, "Failed to invoke function: %s", functionName)
+	}
+	logTail, logTailErr := base64.StdEncoding.DecodeString(aws.StringValue(invokeOutput.LogResult))
+	if logTailErr != nil {
+		return 0, errors.Wrapf(logTailErr, "Failed to decode log tail for function: %s", functionName)
+	}
+	matches := reInitDuration.FindStringSubmatch(string(logTail))
+	if len(matches) != 2 {
+		return 0, errors.Errorf("Failed to find Init Duration in log tail for function: %s", functionName)
+	}
+	return strconv.ParseFloat(matches[1], 64)
+}
+
+// benchmarkColdStarts records the cold start duration of each function
+// in the provisioned stack, including ImageURI functions
+func benchmarkColdStarts(ctx *workflowContext) error {
+	defer recordDuration(time.Now(), "Benchmarking cold starts", ctx)
+
+	cfSvc := ctx.context.cfSvc
+	lambdaSvc := ctx.context.lambdaSvc
+	ctx.context.coldStartDurations = make(map[string]float64)
+	for _, eachLambda := range ctx.userdata.lambdaAWSInfos {
+		describeOutput, describeErr := cfSvc.DescribeStackResourceWithContext(ctx.stepContext,
+			&cloudformation.DescribeStackResourceInput{
+				StackName:         aws.String(ctx.userdata.serviceName),
+				LogicalResourceId: aws.String(eachLambda.LogicalResourceName()),
+			})
+		if describeErr != nil {
+			return errors.Wrapf(describeErr, "Failed to describe function resource: %s",
+				eachLambda.lambdaFunctionName())
+		}
+		physicalName := aws.StringValue(describeOutput.StackResourceDetail.PhysicalResourceId)
+		initDuration, initDurationErr := benchmarkColdStart(ctx.stepContext,
+			lambdaSvc,
+			physicalName,
+			ctx.logger)
+		if initDurationErr != nil {
+			ctx.logger.WithFields(logrus.Fields{
+				"Function": eachLambda.lambdaFunctionName(),
+				"Error":    initDurationErr,
+			}).Warn("Failed to benchmark cold start")
+			continue
+		}
+		ctx.context.coldStartDurations[eachLambda.lambdaFunctionName()] = initDuration
+		ctx.logger.WithFields(logrus.Fields{
+			"Function":          eachLambda.lambdaFunctionName(),
+			"InitDuration (ms)": initDuration,
+		}).Info("Cold start benchmark")
+	}
+	return nil
+}
+
+// deploymentHistoryRecord is a single entry in the deployment history
+// object. See WithDeploymentHistory
+type deploymentHistoryRecord struct {
+	Timestamp      string `json:"timestamp"`
+	StackID        string `json:"stackId"`
+	CallerARN      string `json:"callerArn"`
+	CallerAccount  string `json:"callerAccount"`
+	BuildID        string `json:"buildId"`
+	TemplateSHA256 string `json:"templateSHA256"`
+	BinarySHA256   string `json:"binarySHA256,omitempty"`
+	// Cold start Init Duration (ms) per function name
+	ColdStartInitMS map[string]float64 `json:"coldStartInitMs,omitempty"`
+}
+
+// appendDeploymentHistory appends a deploymentHistoryRecord to the JSON-lines
+// deployment history object in the artifact bucket
+func appendDeploymentHistory(ctx *workflowContext,
+	stack *cloudformation.Stack,
+	cfTemplate []byte) error {
+
+	callerIdentity, callerIdentityErr := ctx.context.stsSvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if callerIdentityErr != nil {
+		return errors.Wrapf(callerIdentityErr, "Failed to get caller identity for deployment history")
+	}
+	templateDigest := sha256.Sum256(cfTemplate)
+	record := deploymentHistoryRecord{
+		Timestamp:       time.Now().UTC().Format(time.RFC3339),
+		StackID:         aws.StringValue(stack.StackId),
+		CallerARN:       aws.StringValue(callerIdentity.Arn),
+		CallerAccount:   aws.StringValue(callerIdentity.Account),
+		BuildID:         ctx.userdata.buildID,
+		TemplateSHA256:  hex.EncodeToString(templateDigest[:]),
+		BinarySHA256:    ctx.context.binaryDigest,
+		ColdStartInitMS: ctx.context.coldStartDurations,
+	}
+	recordJSON, recordJSONErr := json.Marshal(record)
+	if recordJSONErr != nil {
+		return errors.Wrapf(recordJSONErr, "Failed to marshal deployment history record")
+	}
+	// S3 doesn't support appends, so fetch the existing history first
+	historyKey := deploymentHistoryKey(ctx)
+	s3Svc := ctx.context.s3Svc
+	var history bytes.Buffer
+	getObjectOutput, getObjectErr := s3Svc.GetObjectWithContext(ctx.stepContext,
+		&s3.GetObjectInput{
+			Bucket: aws.String(ctx.userdata.s3Bucket),
+			Key:    aws.String(historyKey),
+		},
+		ctx.context.s3RequestOptions...)
+	if getObjectErr == nil {
+		_, readErr := history.ReadFrom(getObjectOutput.Body)
+		closeErr := getObjectOutput.Body.Close()
+		if readErr != nil {
+			return errors.Wrapf(readErr, "Failed to read deployment history")
+		}
+		if closeErr != nil {
+			return errors.Wrapf(closeErr, "Failed to close deployment history")
+		}
+	} else if awsErr, awsErrOk := getObjectErr.(awserr.Error); !awsErrOk ||
+		awsErr.Code() != s3.ErrCodeNoSuchKey {
+		return errors.Wrapf(getObjectErr, "Failed to get deployment history")
+	}
+	_, writeErr := history.Write(append(recordJSON, '\n'))
+	if writeErr != nil {
+		return errors.Wrapf(writeErr, "Failed to append deployment history record")
+	}
+	_, putObjectErr := s3Svc.PutObjectWithContext(ctx.stepContext,
+		&s3.PutObjectInput{
+			Bucket:      aws.String(ctx.userdata.s3Bucket),
+			Key:         aws.String(historyKey),
+			ContentType: aws.String("application/x-ndjson"),
+			Body:        bytes.NewReader(history.Bytes()),
+		},
+		ctx.context.s3RequestOptions...)
+	if putObjectErr != nil {
+		return errors.Wrapf(putObjectErr, "Failed to update deployment history")
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"Bucket": ctx.userdata.s3Bucket,
+		"Key":    historyKey,
+		"Caller": record.CallerARN,
+	}).Info("Deployment history updated")
+	return nil
+}
+
+// logStackTemplateDiff logs the resource-level differences between
+// the generated template and the template of the deployed stack
+func logStackTemplateDiff(ctx *workflowContext) error {
+	diff, diffErr := spartaCF.DiffStackTemplate(ctx.userdata.serviceName,
+		ctx.context.cfTemplate,
+		ctx.context.cfSvc,
+		ctx.logger)
+	if nil != diffErr {
+		return errors.Wrapf(diffErr, "Failed to diff template against existing stack")
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"StackName":   ctx.userdata.serviceName,
+		"StackExists": diff.StackExists,
+		"Added":       len(diff.Added),
+		"Removed":     len(diff.Removed),
+		"Modified":    len(diff.Modified),
+	}).Info("Template diff")
+	if diff.Empty() {
+		ctx.logger.Info("No resource changes detected")
+		return nil
+	}
+	changes := []struct {
+		operation string
+		resources []string
+	}{
+		{"+ Add", diff.Added},
+		{"- Remove", diff.Removed},
+		{"~ Modify", diff.Modified},
+	}
+	for _, eachChange := range changes {
+		for _, eachResource := range eachChange.resources {
+			resourceType := ""
+			if resource, resourceExists := ctx.context.cfTemplate.Resources[eachResource]; resourceExists {
+				resourceType = resource.Properties.CfnResourceType()
+			}
+			ctx.logger.WithFields(logrus.Fields{
+				"Resource": eachResource,
+				"Type":     resourceType,
+			}).Info(eachChange.operation)
+		}
+	}
+	return nil
+}
+
+// uploadReviewTemplate uploads the templateBody to a temporary key in the
+// artifact bucket so that a review change set can be created from a
+// template that exceeds the inline size limit. The returned function
+// deletes the temporary object.
+func uploadReviewTemplate(ctx *workflowContext, templateBody []byte) (string, func(), error) {
+	reviewKey := fmt.Sprintf("%s%s-review-%s-cftemplate.json",
+		artifactKeyPrefix(ctx),
+		sanitizedName(ctx.userdata.serviceName),
+		ctx.userdata.buildID)
+	putObjectOutput, putObjectErr := ctx.context.s3Svc.PutObjectWithContext(ctx.stepContext,
+		&s3.PutObjectInput{
+			Bucket:      aws.String(ctx.userdata.s3Bucket),
+			Key:         aws.String(reviewKey),
+			Body:        bytes.NewReader(templateBody),
+			ContentType: aws.String("application/json"),
+		},
+		ctx.context.s3RequestOptions...)
+	if nil != putObjectErr {
+		return "", nil, errors.Wrapf(putObjectErr, "Failed to upload review template")
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"Bucket": ctx.userdata.s3Bucket,
+		"Key":    reviewKey,
+		"Size":   humanize.Bytes(uint64(len(templateBody))),
+	}).Info("Uploaded temporary template for ChangeSet review")
+
+	templateURL := spartaS3.ObjectURL(s3BucketConfig(ctx), ctx.userdata.s3Bucket, reviewKey)
+	if putObjectOutput.VersionId != nil {
+		templateURL = fmt.Sprintf("%s?versionId=%s",
+			templateURL,
+			aws.StringValue(putObjectOutput.VersionId))
+	}
+	deleteTemplate := func() {
+		// Delete the specific version so that versioned buckets don't
+		// retain the temporary template
+		_, deleteErr := ctx.context.s3Svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket:    aws.String(ctx.userdata.s3Bucket),
+			Key:       aws.String(reviewKey),
+			VersionId: putObjectOutput.VersionId,
+		})
+		if nil != deleteErr {
+			ctx.logger.WithFields(logrus.Fields{
+				"Bucket": ctx.userdata.s3Bucket,
+				"Key":    reviewKey,
+				"Error":  deleteErr,
+			}).Warn("Failed to delete temporary review template")
+		}
+	}
+	return templateURL, deleteTemplate, nil
+}
+
+// logStackChangeSet creates a change set for the deployed stack from the
+// generated template, logs the resource changes, and then deletes it
+func logStackChangeSet(ctx *workflowContext) error {
+	exists, existsErr := spartaCF.StackExistsWithClient(ctx.userdata.serviceName,
+		ctx.context.cfSvc,
+		ctx.logger)
+	if nil != existsErr {
+		return existsErr
+	}
+	if !exists {
+		ctx.logger.WithFields(logrus.Fields{
+			"StackName": ctx.userdata.serviceName,
+		}).Info("Stack doesn't exist. ChangeSet review only applies to stack updates")
+		return nil
+	}
+	ctx.registerRollback(func(logger *logrus.Logger) error {
+		return spartaCF.DeleteAbandonedChangeSets(ctx.userdata.serviceName,
+			ctx.context.cfSvc,
+			logger)
+	})
+	// Templates that are too large to create the change set inline are
+	// uploaded to a temporary key that's deleted after the review
+	templateURL := ""
+	templateBody, templateBodyErr := json.Marshal(ctx.context.cfTemplate)
+	if nil != templateBodyErr {
+		return errors.Wrapf(templateBodyErr, "Failed to marshal review template")
+	}
+	if len(templateBody) > spartaCF.MaxTemplateBodySize {
+		reviewTemplateURL, deleteReviewTemplate, uploadErr := uploadReviewTemplate(ctx, templateBody)
+		if nil != uploadErr {
+			return uploadErr
+		}
+		defer deleteReviewTemplate()
+		templateURL = reviewTemplateURL
+	}
+	awsCloudFormation := ctx.context.cfSvc
+	changeSetRequestName := CloudFormationResourceName(fmt.Sprintf("%sReviewChangeSet", ctx.userdata.serviceName))
+	changes, changesErr := spartaCF.CreateStackChangeSet(changeSetRequestName,
+		ctx.userdata.serviceName,
+		ctx.context.cfTemplate,
+		templateURL,
+		nil,
+		awsCloudFormation,
+		ctx.logger)
+	if nil != changesErr {
+		return errors.Wrapf(changesErr, "Failed to create review ChangeSet")
+	}
+	// No changes means the change set was already deleted
+	if nil == changes {
+		return nil
+	}
+	actionCounts := make(map[string]int)
+	for _, eachChange := range changes.Changes {
+		if eachChange.ResourceChange != nil {
+			actionCounts[aws.StringValue(eachChange.ResourceChange.Action)]++
+		}
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"StackName":   ctx.userdata.serviceName,
+		"ChangeCount": len(changes.Changes),
+		"Added":       actionCounts[cloudformation.ChangeActionAdd],
+		"Modified":    actionCounts[cloudformation.ChangeActionModify],
+		"Removed":     actionCounts[cloudformation.ChangeActionRemove],
+	}).Info("ChangeSet review")
+	spartaCF.LogChangeSetChanges(changes, ctx.logger)
+	_, deleteErr := spartaCF.DeleteChangeSet(ctx.userdata.serviceName,
+		changeSetRequestName,
+		awsCloudFormation)
+	return deleteErr
+}
+
+// gitCommitEnvironmentVariables are the CI environment variables that
+// store the commit SHA being built, in priority order
+var gitCommitEnvironmentVariables = []string{
+	"GITHUB_SHA",
+	"CI_COMMIT_SHA",
+	"CODEBUILD_RESOLVED_SOURCE_VERSION",
+	"GIT_COMMIT",
+}
+
+// gitCommitSHA returns the commit SHA of the source being provisioned from
+// the CI environment or `git rev-parse HEAD`. An empty string is returned
+// if the SHA can't be determined.
+func gitCommitSHA(logger *logrus.Logger) string {
+	for _, eachVariable := range gitCommitEnvironmentVariables {
+		if envSHA := strings.TrimSpace(os.Getenv(eachVariable)); envSHA != "" {
+			return envSHA
+		}
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmdErr := cmd.Run()
+	if cmdErr != nil {
+		logger.WithFields(logrus.Fields{
+			"Error": cmdErr,
+		}).Debug("Failed to determine git commit SHA")
+		return ""
+	}
+	return strings.TrimSpace(stdout.String())
+}
+
+// addBuildOutputs adds the BuildID, git commit, and provision timestamp
+// to the template Outputs so that a deployed stack can be correlated with
+// the source that produced it
+func addBuildOutputs(ctx *workflowContext) {
+	ctx.context.cfTemplate.Outputs[OutputSpartaBuildID] = &gocf.Output{
+		Description: "Sparta BuildID of the provision",
+		Value:       gocf.String(ctx.userdata.buildID),
+	}
+	commitSHA := gitCommitSHA(ctx.logger)
+	if commitSHA != "" {
+		ctx.context.cfTemplate.Outputs[OutputSpartaGitCommit] = &gocf.Output{
+			Description: "git commit SHA of the provisioned source",
+			Value:       gocf.String(commitSHA),
+		}
+	}
+	buildTime := ctx.transaction.startTime.UTC().Format(time.RFC3339)
+	ctx.context.cfTemplate.Outputs[OutputSpartaBuildTime] = &gocf.Output{
+		Description: "UTC timestamp of the provision",
+		Value:       gocf.String(buildTime),
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"BuildID":   ctx.userdata.buildID,
+		"GitCommit": commitSHA,
+		"BuildTime": buildTime,
+	}).Debug("Added build Outputs")
+}
+
+// addResourceCountOutputs adds the total and per-type resource counts
+// of the template to its Outputs
+func addResourceCountOutputs(ctx *workflowContext) error {
+	typeCounts := make(map[string]int)
+	for _, eachResource := range ctx.context.cfTemplate.Resources {
+		typeCounts[eachResource.Properties.CfnResourceType()]++
+	}
+	resourceCount := len(ctx.context.cfTemplate.Resources)
+	typeCountsJSON, typeCountsJSONErr := json.Marshal(typeCounts)
+	if typeCountsJSONErr != nil {
+		return errors.Wrapf(typeCountsJSONErr, "Failed to marshal resource type counts")
+	}
+	ctx.context.cfTemplate.Outputs[OutputResourceCount] = &gocf.Output{
+		Description: "Number of resources in the CloudFormation template",
+		Value:       gocf.String(fmt.Sprintf("%d", resourceCount)),
+	}
+	ctx.context.cfTemplate.Outputs[OutputResourceTypeCounts] = &gocf.Output{
+		Description: "Number of resources in the CloudFormation template by type",
+		Value:       gocf.String(string(typeCountsJSON)),
+	}
+	logEntry := ctx.logger.WithFields(logrus.Fields{
+		"Count": resourceCount,
+		"Limit": maxStackResourceCount,
+		"Types": typeCounts,
+	})
+	if resourceCount >= (maxStackResourceCount*8)/10 {
+		logEntry.Warn("Template resource count is approaching the CloudFormation limit")
+	} else {
+		logEntry.Info("Template resource count")
+	}
+	return nil
+}
+
+// templateTooLargeError returns an error with guidance about which
+// resources to extract into a separate stack so that the template
+// fits within the CloudFormation template size limit
+func templateTooLargeError(cfTemplate []byte, logger *logrus.Logger) error {
+	clusters, clustersErr := spartaCF.TemplateResourceClusters(cfTemplate)
+	if clustersErr != nil {
+		return errors.Wrapf(clustersErr, "Failed to analyze oversized template")
+	}
+	const maxClusterCount = 5
+	guidance := []string{}
+	for eachIndex, eachCluster := range clusters {
+		if eachIndex >= maxClusterCount {
+			break
+		}
+		logger.WithFields(logrus.Fields{
+			"Size":      humanize.Bytes(uint64(eachCluster.Size)),
+			"Resources": eachCluster.Resources,
+		}).Warn("Template resource cluster")
+		guidance = append(guidance, fmt.Sprintf("%s (%s)",
+			strings.Join(eachCluster.Resources, ", "),
+			humanize.Bytes(uint64(eachCluster.Size))))
+	}
+	return errors.Errorf("CloudFormation template size (%s) exceeds the %s limit. "+
+		"Consider extracting one of the largest independent resource groups into a separate stack:\n\t%s",
+		humanize.Bytes(uint64(len(cfTemplate))),
+		humanize.Bytes(uint64(spartaCF.MaxTemplateSize)),
+		strings.Join(guidance, "\n\t"))
+}
+
+// mergeResourceTags returns the Properties with the tags merged into the
+// `Tags` TagList property, if the resource type defines one. Existing
+// resource tags take precedence over the service tags. The boolean
+// result is false if the resource isn't taggable.
+func mergeResourceTags(properties gocf.ResourceProperties,
+	tags map[string]string) (gocf.ResourceProperties, bool) {
+	propertiesValue := reflect.ValueOf(properties)
+	if !propertiesValue.IsValid() {
+		return properties, false
+	}
+	// Value types (eg, LambdaFunctionResource) are updated via an
+	// addressable copy
+	isPointer := propertiesValue.Kind() == reflect.Ptr
+	structValue := propertiesValue
+	if isPointer {
+		if propertiesValue.IsNil() {
+			return properties, false
+		}
+		structValue = propertiesValue.Elem()
+	} else {
+		structValue = reflect.New(propertiesValue.Type()).Elem()
+		structValue.Set(propertiesValue)
+	}
+	if structValue.Kind() != reflect.Struct {
+		return properties, false
+	}
+	tagsField := structValue.FieldByName("Tags")
+	if !tagsField.IsValid() ||
+		!tagsField.CanSet() ||
+		tagsField.Type() != reflect.TypeOf(&gocf.TagList{}) {
+		return properties, false
+	}
+	mergedTags := gocf.TagList{}
+	existingKeys := make(map[string]bool)
+	if !tagsField.IsNil() {
+		for _, eachTag := range *(tagsField.Interface().(*gocf.TagList)) {
+			if eachTag.Key != nil {
+				existingKeys[eachTag.Key.Literal] = true
+			}
+			mergedTags = append(mergedTags, eachTag)
+		}
+	}
+	tagKeys := []string{}
+	for eachKey := range tags {
+		tagKeys = append(tagKeys, eachKey)
+	}
+	sort.Strings(tagKeys)
+	for _, eachKey := range tagKeys {
+		if existingKeys[eachKey] {
+			continue
+		}
+		mergedTags = append(mergedTags, gocf.Tag{
+			Key:   gocf.String(eachKey),
+			Value: gocf.String(tags[eachKey]),
+		})
+	}
+	tagsField.Set(reflect.ValueOf(&mergedTags))
+	if isPointer {
+		return properties, true
+	}
+	return structValue.Interface().(gocf.ResourceProperties), true
+}
+
+// propagateResourceTags merges the service-wide tags into every taggable
+// template resource
+func propagateResourceTags(ctx *workflowContext) {
+	taggedResources := []string{}
+	for eachName, eachResource := range ctx.context.cfTemplate.Resources {
+		taggedProperties, tagged := mergeResourceTags(eachResource.Properties,
+			ctx.userdata.options.resourceTags)
+		if tagged {
+			eachResource.Properties = taggedProperties
+			taggedResources = append(taggedResources, eachName)
+		}
+	}
+	sort.Strings(taggedResources)
+	ctx.logger.WithFields(logrus.Fields{
+		"Tags":      ctx.userdata.options.resourceTags,
+		"Resources": taggedResources,
+	}).Debug("Propagated service tags to resources")
+}
+
+// applyIAMPermissionsBoundary sets the PermissionsBoundary property of
+// every IAM role in the template
+func applyIAMPermissionsBoundary(ctx *workflowContext) {
+	permissionsBoundary := gocf.String(ctx.userdata.options.iamPermissionsBoundary)
+	boundedRoles := []string{}
+	for eachName, eachResource := range ctx.context.cfTemplate.Resources {
+		var roleResource iamRoleResource
+		switch typedProperties := eachResource.Properties.(type) {
+		case iamRoleResource:
+			// IAMRoleDefinition.PermissionsBoundary takes precedence
+			if typedProperties.PermissionsBoundary != nil {
+				continue
+			}
+			roleResource = typedProperties
+		case gocf.IAMRole:
+			roleResource = iamRoleResource{IAMRole: typedProperties}
+		case *gocf.IAMRole:
+			roleResource = iamRoleResource{IAMRole: *typedProperties}
+		default:
+			continue
+		}
+		roleResource.PermissionsBoundary = permissionsBoundary
+		eachResource.Properties = roleResource
+		boundedRoles = append(boundedRoles, eachName)
+	}
+	sort.Strings(boundedRoles)
+	ctx.logger.WithFields(logrus.Fields{
+		"PermissionsBoundary": ctx.userdata.options.iamPermissionsBoundary,
+		"Roles":               boundedRoles,
+	}).Debug("Applied IAM permissions boundary")
+}
+
+// applyServiceBinaryFunctions calls applier with the properties of every
+// function whose code is the service binary archive
+func applyServiceBinaryFunctions(ctx *workflowContext,
+	applier func(functionResource *LambdaFunctionResource)) {
+	codeKey := ctx.context.s3CodeZipURL.keyName()
+	for _, eachResource := range ctx.context.cfTemplate.Resources {
+		var functionResource LambdaFunctionResource
+		switch typedProperties := eachResource.Properties.(type) {
+		case LambdaFunctionResource:
+			functionResource = typedProperties
+		case gocf.LambdaFunction:
+			functionResource = LambdaFunctionResource{
+				LambdaFunction: typedProperties,
+				Code:           typedProperties.Code,
+			}
+		case *gocf.LambdaFunction:
+			functionResource = LambdaFunctionResource{
+				LambdaFunction: *typedProperties,
+				Code:           typedProperties.Code,
+			}
+		default:
+			continue
+		}
+		functionCode, functionCodeOk := functionResource.Code.(*gocf.LambdaFunctionCode)
+		if !functionCodeOk ||
+			functionCode == nil ||
+			functionCode.S3Key == nil ||
+			functionCode.S3Key.Literal != codeKey {
+			continue
+		}
+		applier(&functionResource)
+		eachResource.Properties = functionResource
+	}
+}
+
+// applyLambdaArchitecture sets the Architectures property of every function
+// whose code is the service binary archive
+func applyLambdaArchitecture(ctx *workflowContext) {
+	architectures := []string{ctx.userdata.options.lambdaArchitecture}
+	applyServiceBinaryFunctions(ctx, func(functionResource *LambdaFunctionResource) {
+		functionResource.Architectures = architectures
+	})
+}
+
+// applyLambdaRuntime sets the Runtime and Handler properties of every
+// function whose code is the service binary archive so that they match
+// the packaged binary
+func applyLambdaRuntime(ctx *workflowContext) {
+	runtime := ctx.userdata.options.lambdaRuntime
+	handlerName := lambdaHandlerName(runtime, ctx.context.binaryName)
+	applyServiceBinaryFunctions(ctx, func(functionResource *LambdaFunctionResource) {
+		functionResource.Runtime = gocf.String(runtime)
+		functionResource.Handler = gocf.String(handlerName)
+	})
+}
+
+// checkReservedConcurrency warns if the total ReservedConcurrentExecutions
+// of the functions leaves fewer than minUnreservedConcurrency executions
+// of the accountLimit unreserved. Lambda rejects the reservations that
+// exceed the limit when the functions are created. The total is returned.
+func checkReservedConcurrency(lambdaAWSInfos []*LambdaAWSInfo,
+	accountLimit int64,
+	logger *logrus.Logger) int64 {
+	if accountLimit <= 0 {
+		accountLimit = defaultAccountConcurrencyLimit
+	}
+	reservedTotal := int64(0)
+	for _, eachLambda := range lambdaAWSInfos {
+		if eachLambda.Options != nil &&
+			eachLambda.Options.ReservedConcurrentExecutions != nil {
+			reservedTotal += *eachLambda.Options.ReservedConcurrentExecutions
+		}
+		for _, eachCustom := range eachLambda.customResources {
+			if eachCustom.options != nil &&
+				eachCustom.options.ReservedConcurrentExecutions != nil {
+				reservedTotal += *eachCustom.options.ReservedConcurrentExecutions
+			}
+		}
+	}
+	if reservedTotal > accountLimit-minUnreservedConcurrency {
+		logger.WithFields(logrus.Fields{
+			"ReservedConcurrentExecutions": reservedTotal,
+			"AccountLimit":                 accountLimit,
+			"MinimumUnreserved":            minUnreservedConcurrency,
+		}).Warn("Reserved concurrency exceeds the account concurrency limit. See WithConcurrencyLimitHint")
+	}
+	return reservedTotal
+}
+
+// lambdaAliasOutputName returns the name of the Output that stores the
+// alias ARN of the lambdaResourceName function
+func lambdaAliasOutputName(lambdaResourceName string) string {
+	return fmt.Sprintf("%sAliasArn", lambdaResourceName)
+}
+
+// functionCodeDigest returns the SHA256 digest of the Code property of the
+// lambdaResourceName function in the template. The Code property includes
+// the content addressed S3 key and object version of the service binary,
+// or the ImageURI, so the digest changes whenever the function code does.
+func functionCodeDigest(ctx *workflowContext, lambdaResourceName string) (string, error) {
+	functionResource, functionResourceExists := ctx.context.cfTemplate.Resources[lambdaResourceName]
+	if !functionResourceExists {
+		return "", errors.Errorf("Failed to find template resource for function: %s", lambdaResourceName)
+	}
+	propertiesJSON, propertiesJSONErr := json.Marshal(functionResource.Properties)
+	if propertiesJSONErr != nil {
+		return "", errors.Wrapf(propertiesJSONErr, "Failed to marshal function properties: %s", lambdaResourceName)
+	}
+	var functionProperties struct {
+		Code json.RawMessage
+	}
+	unmarshalErr := json.Unmarshal(propertiesJSON, &functionProperties)
+	if unmarshalErr != nil {
+		return "", errors.Wrapf(unmarshalErr, "Failed to unmarshal function properties: %s", lambdaResourceName)
+	}
+	codeDigest := sha256.Sum256(functionProperties.Code)
+	return hex.EncodeToString(codeDigest[:]), nil
+}
+
+// addLambdaAliases publishes a version of each service function and points
+// the WithLambdaAlias alias at it. The version resource name includes the
+// digest of the function code so that every provision of changed code
+// publishes a new version, even if the BuildID is reused. Superseded
+// versions are retained so that the alias can be rolled back.
+func addLambdaAliases(ctx *workflowContext) error {
+	aliasName := ctx.userdata.options.lambdaAlias
+	for _, eachLambdaInfo := range ctx.userdata.lambdaAWSInfos {
+		lambdaResourceName := eachLambdaInfo.LogicalResourceName()
+		codeDigest, codeDigestErr := functionCodeDigest(ctx, lambdaResourceName)
+		if codeDigestErr != nil {
+			return codeDigestErr
+		}
+		versionResourceName := CloudFormationResourceName(lambdaResourceName+"Version",
+			codeDigest)
+		versionEntry := ctx.context.cfTemplate.AddResource(versionResourceName,
+			&gocf.LambdaVersion{
+				FunctionName: gocf.Ref(lambdaResourceName).String(),
+			})
+		versionEntry.DeletionPolicy = "Retain"
+
+		aliasResourceName := CloudFormationResourceName(lambdaResourceName+"Alias",
+			aliasName)
+		ctx.context.cfTemplate.AddResource(aliasResourceName,
+			&gocf.LambdaAlias{
+				FunctionName:    gocf.Ref(lambdaResourceName).String(),
+				FunctionVersion: gocf.GetAtt(versionResourceName, "Version").String(),
+				Name:            gocf.String(aliasName),
+			})
+		// Ref of an AWS::Lambda::Alias is the alias ARN
+		ctx.context.cfTemplate.Outputs[lambdaAliasOutputName(lambdaResourceName)] = &gocf.Output{
+			Description: fmt.Sprintf("%s alias ARN of %s", aliasName, eachLambdaInfo.lambdaFunctionName()),
+			Value:       gocf.Ref(aliasResourceName).String(),
+		}
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"Alias":         aliasName,
+		"FunctionCount": len(ctx.userdata.lambdaAWSInfos),
+	}).Info("Publishing Lambda versions")
+	return nil
+}
+
+// stackOutputValues returns the stack Outputs keyed by OutputKey
+func stackOutputValues(stack *cloudformation.Stack) map[string]string {
+	stackOutputs := make(map[string]string)
+	for _, eachOutput := range stack.Outputs {
+		stackOutputs[aws.StringValue(eachOutput.OutputKey)] = aws.StringValue(eachOutput.OutputValue)
+	}
+	return stackOutputs
+}
+
+// populateProvisionResult copies the provisioned stack state into the
+// caller's WithProvisionResult value, if one was supplied
+func populateProvisionResult(ctx *workflowContext) {
+	result := ctx.userdata.options.provisionResult
+	stack := ctx.context.stack
+	if result == nil || stack == nil {
+		return
+	}
+	result.StackID = aws.StringValue(stack.StackId)
+	result.StackStatus = aws.StringValue(stack.StackStatus)
+	result.Outputs = stackOutputValues(stack)
+	result.BuildTime = ctx.transaction.startTime.UTC()
+	result.ColdStartInitMS = ctx.context.coldStartDurations
+	if len(ctx.context.artifactSignatures) != 0 {
+		result.Signatures = make(map[string]ArtifactSignature, len(ctx.context.artifactSignatures))
+		for eachKey, eachSignature := range ctx.context.artifactSignatures {
+			result.Signatures[eachKey] = *eachSignature
+		}
+	}
+}
+
+// logArtifactSignatures logs the WithArtifactSigning signature of each
+// uploaded artifact, ordered by S3 key
+func logArtifactSignatures(ctx *workflowContext) {
+	signatureKeys := make([]string, 0, len(ctx.context.artifactSignatures))
+	for eachKey := range ctx.context.artifactSignatures {
+		signatureKeys = append(signatureKeys, eachKey)
+	}
+	sort.Strings(signatureKeys)
+	for _, eachKey := range signatureKeys {
+		eachSignature := ctx.context.artifactSignatures[eachKey]
+		ctx.logger.WithFields(logrus.Fields{
+			"Key":       eachKey,
+			"SHA256":    eachSignature.SHA256,
+			"Signature": eachSignature.Signature,
+		}).Info("Artifact signature")
+	}
+}
+
+// logDeploySummary logs the stack identity, endpoints, and function
+// names of the provisioned service
+func logDeploySummary(ctx *workflowContext, elapsed time.Duration) {
+	stack := ctx.context.stack
+	if stack == nil {
+		return
+	}
+	stackOutputs := stackOutputValues(stack)
+	functionNames := []string{}
+	for _, eachLambda := range ctx.userdata.lambdaAWSInfos {
+		functionNames = append(functionNames, eachLambda.lambdaFunctionName())
+	}
+	sort.Strings(functionNames)
+
+	summaryFields := logrus.Fields{
+		"StackName":    aws.StringValue(stack.StackName),
+		"StackId":      aws.StringValue(stack.StackId),
+		"Functions":    functionNames,
+		"Duration (s)": fmt.Sprintf("%.f", elapsed.Seconds()),
+	}
+	if apiURL, apiURLExists := stackOutputs[OutputAPIGatewayURL]; apiURLExists {
+		summaryFields["APIGatewayURL"] = apiURL
+	}
+	if siteURL, siteURLExists := stackOutputs[OutputS3SiteURL]; siteURLExists {
+		summaryFields["S3SiteURL"] = siteURL
+	}
+	ctx.logger.Info(headerDivider)
+	ctx.logger.WithFields(summaryFields).Info("Deployed " + ctx.userdata.serviceName)
+	ctx.logger.Info(headerDivider)
+}
+
+// preserveDeployedFunctionCode replaces the Code property of every function
+// that isn't selected by WithSelectiveFunctionDeploy with the Code property
+// of the currently deployed function
+func preserveDeployedFunctionCode(ctx *workflowContext) error {
+	exists, existsErr := spartaCF.StackExistsWithClient(ctx.userdata.serviceName,
+		ctx.context.cfSvc,
+		ctx.logger)
+	if existsErr != nil {
+		return existsErr
+	}
+	if !exists {
+		return errors.Errorf("Selective function deploy requires an existing stack: %s",
+			ctx.userdata.serviceName)
+	}
+	deployedResources, deployedResourcesErr := spartaCF.StackTemplateResources(ctx.userdata.serviceName,
+		ctx.context.cfSvc)
+	if deployedResourcesErr != nil {
+		return deployedResourcesErr
+	}
+	selectedFunctions := make(map[string]bool)
+	for _, eachName := range ctx.userdata.options.selectedFunctions {
+		selectedFunctions[eachName] = true
+	}
+	for _, eachLambda := range ctx.userdata.lambdaAWSInfos {
+		if selectedFunctions[eachLambda.lambdaFunctionName()] {
+			continue
+		}
+		// Image functions reference user managed code
+		if eachLambda.Options != nil && eachLambda.Options.ImageURI != "" {
+			continue
+		}
+		logicalName := eachLambda.LogicalResourceName()
+		var deployedCode interface{}
+		deployedResource, _ := deployedResources[logicalName].(map[string]interface{})
+		if deployedResource != nil {
+			deployedProperties, _ := deployedResource["Properties"].(map[string]interface{})
+			if deployedProperties != nil {
+				deployedCode = deployedProperties["Code"]
+			}
+		}
+		if deployedCode == nil {
+			return errors.Errorf("Function %s isn't deployed in stack %s. Include it in the selective deploy functions",
+				eachLambda.lambdaFunctionName(),
+				ctx.userdata.serviceName)
+		}
+		templateResource, templateResourceExists := ctx.context.cfTemplate.Resources[logicalName]
+		if !templateResourceExists {
+			return errors.Errorf("Failed to find template resource for function: %s",
+				eachLambda.lambdaFunctionName())
+		}
+		functionResource, functionResourceOk := templateResource.Properties.(LambdaFunctionResource)
+		if !functionResourceOk {
+			return errors.Errorf("Unexpected resource type for function %s: %T",
+				eachLambda.lambdaFunctionName(),
+				templateResource.Properties)
+		}
+		functionResource.Code = deployedCode
+		templateResource.Properties = functionResource
+		ctx.logger.WithFields(logrus.Fields{
+			"Function": eachLambda.lambdaFunctionName(),
+			"Code":     deployedCode,
+		}).Info("Preserving deployed function code")
+	}
+	return nil
+}
+
+// addOutputExports marks the selected template Outputs as exports with
+// a service-namespaced name
+func addOutputExports(ctx *workflowContext) error {
+	outputKeys := ctx.userdata.options.outputExports
+	if len(outputKeys) == 0 {
+		for eachKey := range ctx.context.cfTemplate.Outputs {
+			outputKeys = append(outputKeys, eachKey)
+		}
+	}
+	sort.Strings(outputKeys)
+	for _, eachKey := range outputKeys {
+		output, outputExists := ctx.context.cfTemplate.Outputs[eachKey]
+		if !outputExists {
+			return errors.Errorf("Unable to export Output %s. Output does not exist in template", eachKey)
+		}
+		exportName := fmt.Sprintf("%s-%s", ctx.userdata.serviceName, eachKey)
+		output.Export = &gocf.OutputExport{
+			Name: gocf.String(exportName),
+		}
+		ctx.context.exportNames = append(ctx.context.exportNames, exportName)
+		ctx.logger.WithFields(logrus.Fields{
+			"Output": eachKey,
+			"Export": exportName,
+		}).Debug("Exporting Output")
+	}
+	return nil
+}
+
 func verifyLambdaPreconditions(lambdaAWSInfo *LambdaAWSInfo, logger *logrus.Logger) error {
 	// If this is a legacy Sparta lambda function, let the user know
 	if lambdaAWSInfo.lambdaFn != nil {
@@ -1423,7 +3797,7 @@ func validateSpartaPostconditions() workflowStep {
 		if len(validateErrs) != 0 {
 			return nil, errors.Errorf("Problems validating template contents: %v", validateErrs)
 		}
-		return ensureCloudFormationStack(), nil
+		return phaseTimeoutStep(ProvisionPhaseConverge, ensureCloudFormationStack()), nil
 	}
 }
 
@@ -1435,6 +3809,7 @@ func ensureCloudFormationStack() workflowStep {
 			msg = "Updating Lambda function code "
 		}
 		defer recordDuration(time.Now(), msg, ctx)
+		reportProgress(ctx, ProgressStepEnsureCloudFormationStack, 75, nil)
 
 		// PreMarshall Hook
 		if ctx.userdata.workflowHooks != nil {
@@ -1497,11 +3872,7 @@ func ensureCloudFormationStack() workflowStep {
 				ctx.userdata.noop,
 				ctx.logger)
 			if nil == err {
-				safeMergeErrs := gocc.SafeMerge(apiGatewayTemplate,
-					ctx.context.cfTemplate)
-				if len(safeMergeErrs) != 0 {
-					err = errors.Errorf("APIGateway template merge failed: %v", safeMergeErrs)
-				}
+				err = safeMergeTemplates(apiGatewayTemplate, ctx.context.cfTemplate)
 			}
 			if nil != err {
 				return nil, errors.Wrapf(err, "APIGateway template export failed")
@@ -1527,6 +3898,10 @@ func ensureCloudFormationStack() workflowStep {
 		if serviceDecoratorErr != nil {
 			return nil, serviceDecoratorErr
 		}
+		mergeErr := mergeAdditionalTemplates(ctx)
+		if mergeErr != nil {
+			return nil, mergeErr
+		}
 
 		// Discovery info on a per-function basis
 		for _, eachEntry := range ctx.userdata.lambdaAWSInfos {
@@ -1578,6 +3953,43 @@ func ensureCloudFormationStack() workflowStep {
 			return nil, errors.Wrapf(annotateErr,
 				"Failed to perform final template annotations")
 		}
+		if len(ctx.userdata.options.resourceTags) != 0 {
+			propagateResourceTags(ctx)
+		}
+		if ctx.userdata.options.iamPermissionsBoundary != "" {
+			applyIAMPermissionsBoundary(ctx)
+		}
+		if ctx.userdata.options.lambdaArchitecture != "" {
+			applyLambdaArchitecture(ctx)
+		}
+		if ctx.userdata.options.lambdaRuntime != "" {
+			applyLambdaRuntime(ctx)
+		}
+		if ctx.userdata.options.selectedFunctions != nil {
+			preserveErr := preserveDeployedFunctionCode(ctx)
+			if preserveErr != nil {
+				return nil, preserveErr
+			}
+		}
+		addBuildOutputs(ctx)
+		if ctx.userdata.options.lambdaAlias != "" {
+			aliasErr := addLambdaAliases(ctx)
+			if aliasErr != nil {
+				return nil, aliasErr
+			}
+		}
+		if ctx.userdata.options.resourceCountOutput {
+			resourceCountErr := addResourceCountOutputs(ctx)
+			if resourceCountErr != nil {
+				return nil, resourceCountErr
+			}
+		}
+		if ctx.userdata.options.outputExports != nil {
+			exportErr := addOutputExports(ctx)
+			if exportErr != nil {
+				return nil, exportErr
+			}
+		}
 		// Finally, anything we need to do here to patch up any template references
 		// across resources?
 
@@ -1590,14 +4002,15 @@ func ensureCloudFormationStack() workflowStep {
 // identify and is used to determine create vs update operations.  The compilation options/flags are:
 //
 // 	TAGS:         -tags lambdabinary
-// 	ENVIRONMENT:  GOOS=linux GOARCH=amd64
+// 	ENVIRONMENT:  GOOS=linux GOARCH=amd64 (or arm64, see WithLambdaArchitecture)
 //
 // The compiled binary is packaged with a NodeJS proxy shim to manage AWS Lambda setup & invocation per
 // http://docs.aws.amazon.com/lambda/latest/dg/authoring-function-in-nodejs.html
 //
 // The two files are ZIP'd, posted to S3 and used as an input to a dynamically generated CloudFormation
 // template (http://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/Welcome.html)
-// which creates or updates the service state.
+// which creates or updates the service state. The optional ProvisionOption values
+// customize the service-wide provisioning behavior.
 //
 func Provision(noop bool,
 	serviceName string,
@@ -1614,16 +4027,131 @@ func Provision(noop bool,
 	linkerFlags string,
 	templateWriter io.Writer,
 	workflowHooks *WorkflowHooks,
-	logger *logrus.Logger) error {
+	logger *logrus.Logger,
+	options ...ProvisionOption) error {
+
+	return ProvisionWithContext(context.Background(),
+		noop,
+		serviceName,
+		serviceDescription,
+		lambdaAWSInfos,
+		api,
+		site,
+		s3Bucket,
+		useCGO,
+		inPlaceUpdates,
+		buildID,
+		codePipelineTrigger,
+		buildTags,
+		linkerFlags,
+		templateWriter,
+		workflowHooks,
+		logger,
+		options...)
+}
+
+// ProvisionWithContext is the same as Provision, but accepts a context.Context
+// that scopes the provisioning workflow. The context is checked between
+// workflow steps and while waiting for the CloudFormation stack operation
+// to complete. If the context is canceled, the workflow stops waiting,
+// invokes the registered rollback functions, and returns the context error.
+// Note that canceling the context does not cancel a stack operation that
+// CloudFormation has already accepted.
+func ProvisionWithContext(provisionCtx context.Context,
+	noop bool,
+	serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api *API,
+	site *S3Site,
+	s3Bucket string,
+	useCGO bool,
+	inPlaceUpdates bool,
+	buildID string,
+	codePipelineTrigger string,
+	buildTags string,
+	linkerFlags string,
+	templateWriter io.Writer,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger,
+	options ...ProvisionOption) error {
+
+	provisionOpts, provisionOptsErr := newProvisionOptions(options...)
+	if nil != provisionOptsErr {
+		return errors.Wrapf(provisionOptsErr, "Failed to apply provision options")
+	}
+	logger = provisionLogger(logger, provisionOpts)
+	if provisionOpts.disableRollback && provisionOpts.stackOnFailure != "" {
+		return errors.New("WithDisableRollback and WithStackOnFailure can't be used together")
+	}
 
 	err := validateSpartaPreconditions(lambdaAWSInfos, logger)
 	if nil != err {
 		return errors.Wrapf(err, "Failed to validate preconditions")
 	}
+	err = validateResponseStreaming(lambdaAWSInfos, provisionOpts.lambdaRuntime)
+	if nil != err {
+		return errors.Wrapf(err, "Failed to validate preconditions")
+	}
+	checkReservedConcurrency(lambdaAWSInfos, provisionOpts.concurrencyLimitHint, logger)
 	startTime := time.Now()
 
+	awsConfig := &aws.Config{
+		CredentialsChainVerboseErrors: aws.Bool(true),
+	}
+	if provisionOpts.awsMaxRetries > 0 {
+		awsConfig.MaxRetries = aws.Int(provisionOpts.awsMaxRetries)
+	}
+	if provisionOpts.awsRegion != "" {
+		awsConfig.Region = aws.String(provisionOpts.awsRegion)
+	}
+	if provisionOpts.awsEndpoint != "" {
+		awsConfig.Endpoint = aws.String(provisionOpts.awsEndpoint)
+		// Custom endpoints (eg, LocalStack) don't support virtual hosted
+		// bucket names
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+	var awsSession *session.Session
+	if provisionOpts.awsSession != nil {
+		if provisionOpts.awsProfile != "" {
+			return errors.New("WithAWSSession and WithAWSProfile can't be used together")
+		}
+		awsSession = provisionOpts.awsSession.Copy(awsConfig)
+	} else if provisionOpts.awsProfile != "" {
+		profileSession, profileSessionErr := spartaAWS.NewSessionWithProfile(awsConfig,
+			provisionOpts.awsProfile,
+			logger)
+		if profileSessionErr != nil {
+			return errors.Wrapf(profileSessionErr,
+				"Failed to create AWS session for profile: %s",
+				provisionOpts.awsProfile)
+		}
+		awsSession = profileSession
+	} else {
+		awsSession = spartaAWS.NewSessionWithConfig(awsConfig, logger)
+	}
+	if provisionOpts.assumeRoleARN != "" {
+		logger.WithFields(logrus.Fields{
+			"RoleARN": provisionOpts.assumeRoleARN,
+		}).Info("Assuming IAM role for provisioning")
+
+		awsSession = awsSession.Copy(&aws.Config{
+			Credentials: stscreds.NewCredentials(awsSession,
+				provisionOpts.assumeRoleARN,
+				func(provider *stscreds.AssumeRoleProvider) {
+					if provisionOpts.assumeRoleExternalID != "" {
+						provider.ExternalID = aws.String(provisionOpts.assumeRoleExternalID)
+					}
+					if provisionOpts.assumeRoleSessionName != "" {
+						provider.RoleSessionName = provisionOpts.assumeRoleSessionName
+					}
+				}),
+		})
+	}
+
 	ctx := &workflowContext{
-		logger: logger,
+		logger:      logger,
+		stepContext: provisionCtx,
 		userdata: userdata{
 			noop:               noop,
 			useCGO:             useCGO,
@@ -1641,12 +4169,20 @@ func Provision(noop bool,
 			},
 			codePipelineTrigger: codePipelineTrigger,
 			workflowHooks:       workflowHooks,
+			options:             provisionOpts,
 		},
 		context: provisionContext{
 			cfTemplate:                gocf.NewTemplate(),
 			s3BucketVersioningEnabled: false,
-			awsSession:                spartaAWS.NewSession(logger),
+			s3BucketRegion:            aws.StringValue(awsSession.Config.Region),
+			awsSession:                awsSession,
+			cfSvc:                     cloudformation.New(awsSession),
+			iamSvc:                    iam.New(awsSession),
+			lambdaSvc:                 lambda.New(awsSession),
+			s3Svc:                     s3.New(awsSession),
+			stsSvc:                    sts.New(awsSession),
 			workflowHooksContext:      make(map[string]interface{}),
+			artifactSignatures:        make(map[string]*ArtifactSignature),
 			templateWriter:            templateWriter,
 			binaryName:                SpartaBinaryName,
 		},
@@ -1655,6 +4191,11 @@ func Provision(noop bool,
 		},
 	}
 	ctx.context.cfTemplate.Description = serviceDescription
+	if provisionOpts.artifactEncryption != "" {
+		ctx.context.s3RequestOptions = append(ctx.context.s3RequestOptions,
+			spartaS3.ServerSideEncryption(provisionOpts.artifactEncryption,
+				provisionOpts.artifactEncryptionKMSKeyID))
+	}
 
 	// Update the context iff it exists
 	if nil != workflowHooks && nil != workflowHooks.Context {
@@ -1674,14 +4215,54 @@ func Provision(noop bool,
 	if len(lambdaAWSInfos) <= 0 {
 		return errors.New("No lambda functions provided to Sparta.Provision()")
 	}
+	// Selective deploys must name known functions
+	if provisionOpts.selectedFunctions != nil {
+		knownFunctions := make(map[string]bool)
+		for _, eachLambda := range lambdaAWSInfos {
+			knownFunctions[eachLambda.lambdaFunctionName()] = true
+		}
+		for _, eachName := range provisionOpts.selectedFunctions {
+			if !knownFunctions[eachName] {
+				return errors.Errorf("Selective deploy function %s isn't defined by the service", eachName)
+			}
+		}
+	}
+	// In-place updates publish the ZIP archive, so they're not
+	// compatible with container image functions. They also bypass
+	// CloudFormation, so the alias wouldn't be moved to the new code.
+	if inPlaceUpdates {
+		if provisionOpts.lambdaAlias != "" {
+			return errors.Errorf("In-place updates can't be used with WithLambdaAlias: %s",
+				provisionOpts.lambdaAlias)
+		}
+		for _, eachLambda := range lambdaAWSInfos {
+			if eachLambda.Options != nil && eachLambda.Options.ImageURI != "" {
+				return errors.Errorf("In-place updates only support ZIP packaged functions. Function %s defines ImageURI: %s",
+					eachLambda.lambdaFunctionName(),
+					eachLambda.Options.ImageURI)
+			}
+		}
+	}
 
 	// Start the workflow
-	for step := verifyIAMRoles; step != nil; {
+	for step := verifyPreflightConditions; step != nil; {
+		if cancelErr := provisionCtx.Err(); cancelErr != nil {
+			ctx.logger.WithField("Error", cancelErr).Warn("Provisioning canceled")
+			return withRollbackError(cancelErr, ctx.rollback())
+		}
 		next, err := step(ctx)
 		if err != nil {
-			ctx.rollback()
+			rollbackErr := ctx.rollback()
+			if cancelErr := provisionCtx.Err(); cancelErr != nil {
+				ctx.logger.WithFields(logrus.Fields{
+					"Error":     err,
+					"StackName": ctx.userdata.serviceName,
+				}).Warn("Provisioning canceled. Any CloudFormation operation in progress will continue")
+				return withRollbackError(cancelErr, rollbackErr)
+			}
 			// Workflow step?
-			return errors.Wrapf(err, "Failed to verify IAM roles")
+			return withRollbackError(errors.Wrapf(err, "Failed to verify IAM roles"),
+				rollbackErr)
 		}
 
 		if next == nil {
@@ -1699,6 +4280,12 @@ func Provision(noop bool,
 			ctx.logger.WithFields(logrus.Fields{
 				"Duration (s)": fmt.Sprintf("%.f", elapsed.Seconds()),
 			}).Info("Total elapsed time")
+			logDeploySummary(ctx, elapsed)
+			populateProvisionResult(ctx)
+			reportProgress(ctx, ProgressStepComplete, 100, map[string]interface{}{
+				"Duration": elapsed,
+			})
+			logArtifactSignatures(ctx)
 			break
 		} else {
 			step = next
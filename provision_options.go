@@ -0,0 +1,1217 @@
+package sparta
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ProvisionPhase identifies a coarse grained stage of the Provision workflow
+type ProvisionPhase string
+
+const (
+	// ProvisionPhaseBuild is the module verification, compilation, and
+	// packaging phase
+	ProvisionPhaseBuild ProvisionPhase = "build"
+	// ProvisionPhaseUpload is the code artifact upload phase
+	ProvisionPhaseUpload ProvisionPhase = "upload"
+	// ProvisionPhaseConverge is the template generation, upload, and stack
+	// convergence phase
+	ProvisionPhaseConverge ProvisionPhase = "converge"
+)
+
+// ProgressFunc receives structured Provision progress notifications. step
+// is one of the ProgressStep* values, pct is the approximate percentage of
+// the workflow that's complete, and detail contains step-specific values
+// such as the StackName and StackStatus.
+type ProgressFunc func(step string, pct int, detail map[string]interface{})
+
+const (
+	// ProgressStepVerifyIAMRoles is reported when the IAM roles are verified
+	ProgressStepVerifyIAMRoles = "verifyIAMRoles"
+	// ProgressStepCreatePackage is reported when the code bundle is built
+	ProgressStepCreatePackage = "createPackageStep"
+	// ProgressStepCreateUpload is reported when the code bundle is uploaded
+	ProgressStepCreateUpload = "createUploadStep"
+	// ProgressStepEnsureCloudFormationStack is reported when the stack is
+	// converged and after each stack operation poll
+	ProgressStepEnsureCloudFormationStack = "ensureCloudFormationStack"
+	// ProgressStepComplete is reported when the workflow succeeds
+	ProgressStepComplete = "complete"
+)
+
+// ProvisionOption is a functional option that customizes the service-wide
+// behavior of the Provision workflow. ProvisionOption values are applied
+// in order and the first error aborts the provisioning operation.
+type ProvisionOption func(options *provisionOptions) error
+
+// provisionOptions are the settings mutated by the set of user-supplied
+// ProvisionOption values
+type provisionOptions struct {
+	// Should a -noop provision diff the template against the live stack?
+	dryRunDiff bool
+	// Should a -noop provision preview the stack update change set?
+	changeSetReview bool
+	// Should semantically identical IAMRoleDefinitions share a single role?
+	iamRoleContentDedup bool
+	// Optional signer for uploaded artifacts
+	artifactSigner crypto.Signer
+	// Should module dependencies be verified before building?
+	goModuleCheck bool
+	// Should the template resource counts be published as Outputs?
+	resourceCountOutput bool
+	// Should the template be canonicalized and uploaded to a content
+	// addressable key?
+	canonicalTemplate bool
+	// Should successful provisions be recorded in the artifact bucket?
+	deploymentHistory bool
+	// Template Output keys to export. An empty, non-nil slice exports
+	// all Outputs
+	outputExports []string
+	// Optional per-phase deadlines
+	phaseTimeouts map[ProvisionPhase]time.Duration
+	// Optional CloudFormation polling interval. Nil uses the default
+	stackPollInterval *spartaCF.StackPollInterval
+	// Maximum number of AWS request retries. Zero uses the default
+	awsMaxRetries int
+	// Optional AWS region and service endpoint overrides. Empty values use
+	// the environment and shared config resolution
+	awsRegion   string
+	awsEndpoint string
+	// Optional shared config profile name. Empty uses the default chain
+	awsProfile string
+	// Optional caller-owned session. Nil creates a new session
+	awsSession *session.Session
+	// Optional managed policy ARN set as the permissions boundary of every
+	// IAM role in the template
+	iamPermissionsBoundary string
+	// Optional role to assume for every provisioning request
+	assumeRoleARN         string
+	assumeRoleExternalID  string
+	assumeRoleSessionName string
+	// CreateStack OnFailure behavior. Empty deletes the failed stack
+	stackOnFailure string
+	// Should failed stack creates and updates be left in place rather than
+	// rolled back?
+	disableRollback bool
+	// Optional stack policy and during-update override policy documents
+	stackPolicyBody             string
+	stackPolicyDuringUpdateBody string
+	// Names of the functions whose code should be updated. A nil slice
+	// updates all functions
+	selectedFunctions []string
+	// Service artifact retention, in days
+	artifactExpirationDays int64
+	// Should the service's artifact expiration rule be created if needed?
+	artifactExpirationCreateRule bool
+	// Maximum build parallelism. Zero uses all cores
+	buildConcurrency int
+	// Optional additional `go build` settings
+	buildOptions *BuildOptions
+	// Should the build cache be bypassed?
+	forceBuild bool
+	// Should local build artifacts be kept for inspection?
+	preserveArtifacts bool
+	// Should the binary be compressed with upx?
+	upxCompression bool
+	// Lambda instruction set architecture. Empty uses x86_64
+	lambdaArchitecture string
+	// Lambda runtime for the service binary. Empty uses GoLambdaVersion
+	lambdaRuntime string
+	// Optional alias that targets the version published by each provision
+	lambdaAlias string
+	// Account concurrency limit used to check the reserved concurrency.
+	// Zero uses defaultAccountConcurrencyLimit
+	concurrencyLimitHint int64
+	// Optional templates merged into the service template
+	additionalTemplates []*gocf.Template
+	// Optional path for the pretty-printed CloudFormation template
+	templateOutputPath string
+	// Format of the template writer and template output path contents.
+	// Empty uses TemplateFormatJSON
+	templateFormat string
+	// Should the artifact bucket owner be verified?
+	bucketOwnershipCheck bool
+	// Should the function cold starts be measured after provisioning?
+	coldStartBenchmark bool
+	// Service-wide tags applied to every taggable resource
+	resourceTags map[string]string
+	// User-supplied tags applied to the CloudFormation stack
+	stackTags map[string]string
+	// SNS topics that receive the CloudFormation stack events
+	stackNotificationARNs []string
+	// Optional S3 key prefix for the service's artifacts
+	s3KeyPrefix string
+	// Optional multipart upload part size and concurrency for uploaded
+	// artifacts. Zero values use the s3manager defaults
+	s3UploadPartSize    int64
+	s3UploadConcurrency int
+	// Optional server-side encryption algorithm and SSE-KMS key for
+	// uploaded artifacts
+	artifactEncryption         string
+	artifactEncryptionKMSKeyID string
+	// Optional caller-owned result populated after a successful provision
+	provisionResult *ProvisionResult
+	// Optional structured progress callback
+	progressFunc ProgressFunc
+	// Optional log output format and level. Empty and nil values use the
+	// caller's logger settings
+	logFormat string
+	logLevel  *logrus.Level
+}
+
+// newProvisionOptions returns the provisionOptions that result from applying
+// each option
+func newProvisionOptions(options ...ProvisionOption) (*provisionOptions, error) {
+	opts := &provisionOptions{}
+	for _, eachOption := range options {
+		if eachOption == nil {
+			continue
+		}
+		optionErr := eachOption(opts)
+		if optionErr != nil {
+			return nil, optionErr
+		}
+	}
+	return opts, nil
+}
+
+// WithProvisionDryRunDiff enables a resource-level diff of the generated
+// template against the template of the currently deployed stack. The diff
+// is only produced for -noop provisions and reports the logical names
+// of the added, removed, and modified resources.
+func WithProvisionDryRunDiff() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.dryRunDiff = true
+		return nil
+	}
+}
+
+// WithChangeSetReview previews the CloudFormation change set for -noop
+// provisions of an existing stack. The change set is created from the
+// generated template, the counts of added, modified, and removed resources
+// and each resource change are logged, and then it's deleted. Templates
+// larger than the 51,200 byte inline limit are uploaded to a temporary key
+// in the artifact bucket, which is deleted after the review. Non -noop
+// updates always log the change set resource changes before executing it.
+func WithChangeSetReview() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.changeSetReview = true
+		return nil
+	}
+}
+
+// WithProvisionPlan reports the impact of a -noop provision on the deployed
+// stack. It's the same as supplying both WithProvisionDryRunDiff and
+// WithChangeSetReview: the generated template is diffed against the
+// deployed template, and the change set that CloudFormation would execute
+// is previewed and then deleted. Nothing is deployed.
+func WithProvisionPlan() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.dryRunDiff = true
+		options.changeSetReview = true
+		return nil
+	}
+}
+
+// WithIAMRoleContentDeduplication shares a single generated IAM::Role across
+// all functions whose IAMRoleDefinitions are semantically identical, rather
+// than provisioning one role per definition. Roles are compared by the
+// content of their policy documents and event source ARNs. Use this to
+// stay under the account's IAM role limits for services with many
+// similarly privileged functions.
+func WithIAMRoleContentDeduplication() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.iamRoleContentDedup = true
+		return nil
+	}
+}
+
+// WithArtifactSigning signs the SHA256 digest of every artifact uploaded
+// to S3 (code archive, template, S3 site) with the supplied signer. The
+// hex encoded digest and base64 encoded detached signature are attached to
+// each S3 object as the `x-amz-meta-sparta-sha256` and
+// `x-amz-meta-sparta-signature` metadata values. They're also returned in
+// the WithProvisionResult Signatures map, keyed by S3 key, so that CI
+// tooling can verify the deployed artifacts. To sign with an AWS KMS
+// asymmetric key, provide a crypto.Signer implementation that delegates
+// to KMS Sign.
+func WithArtifactSigning(signer crypto.Signer) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if signer == nil {
+			return errors.New("WithArtifactSigning requires a non-nil crypto.Signer")
+		}
+		options.artifactSigner = signer
+		return nil
+	}
+}
+
+// WithGoVendorConsistencyCheck verifies the module dependencies before the
+// binary is compiled. It runs `go mod verify`, `go mod tidy -diff` (if
+// supported by the installed go version), and, if there's a vendor
+// directory, confirms it's consistent with go.mod. Any inconsistency aborts
+// the provision so that the deployed binary reflects the declared
+// dependencies.
+func WithGoVendorConsistencyCheck() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.goModuleCheck = true
+		return nil
+	}
+}
+
+// WithStackResourceCountOutput publishes the number of resources in the
+// generated template as the OutputResourceCount stack Output, together
+// with the JSON encoded per-type breakdown in the OutputResourceTypeCounts
+// Output. A warning is logged as the count approaches the CloudFormation
+// per-stack resource limit.
+func WithStackResourceCountOutput() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.resourceCountOutput = true
+		return nil
+	}
+}
+
+// WithTemplateCanonicalization serializes the CloudFormation template
+// in canonical form (sorted keys, no insignificant whitespace) and uploads
+// it to an S3 key derived from the SHA256 digest of that content. Builds
+// that produce semantically identical templates therefore produce the
+// same template key, regardless of the go-cloudformation serialization order.
+func WithTemplateCanonicalization() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.canonicalTemplate = true
+		return nil
+	}
+}
+
+// WithDeploymentHistory appends a JSON record of each successful provision
+// to the `_sparta/<serviceName>/deployment-history.jsonl` object in the
+// artifact bucket. The object is outside of the service's artifact prefix,
+// so it's not expired by WithArtifactBucketKeyExpiry. Each record includes
+// the timestamp, the STS caller identity, the BuildID (the git SHA by
+// default), and the SHA256 digests of the template and compiled binary. S3
+// doesn't support appends, so concurrent provisions of the same service may
+// drop a record.
+func WithDeploymentHistory() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.deploymentHistory = true
+		return nil
+	}
+}
+
+// WithOutputExports marks the template Outputs identified by outputKeys as
+// CloudFormation exports for cross-stack references. If no keys are
+// provided, all Outputs (including the built-in Sparta Outputs such as
+// APIGatewayURL) are exported. Each export is named `<serviceName>-<OutputKey>`
+// and provisioning fails before the stack is updated if another stack in the
+// region already exports the same name.
+func WithOutputExports(outputKeys ...string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.outputExports = append([]string{}, outputKeys...)
+		return nil
+	}
+}
+
+// WithProvisionTimeoutPerPhase applies a deadline to each phase of the
+// Provision workflow. When a phase's deadline expires, any in-progress
+// command (eg, a `go build` stalled on the module proxy), S3 upload, or
+// stack convergence wait is canceled and the provision fails with a phase
+// timeout error. Phases without an entry, or with a non-positive duration,
+// aren't limited. Note that a canceled convergence wait doesn't cancel the
+// CloudFormation stack operation.
+func WithProvisionTimeoutPerPhase(timeouts map[ProvisionPhase]time.Duration) ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.phaseTimeouts = make(map[ProvisionPhase]time.Duration)
+		for eachPhase, eachTimeout := range timeouts {
+			switch eachPhase {
+			case ProvisionPhaseBuild,
+				ProvisionPhaseUpload,
+				ProvisionPhaseConverge:
+				options.phaseTimeouts[eachPhase] = eachTimeout
+			default:
+				return errors.Errorf("Unsupported provision phase for timeout: %s", eachPhase)
+			}
+		}
+		return nil
+	}
+}
+
+// WithStackPollInterval sets the delay between CloudFormation DescribeStacks
+// calls while waiting for the stack operation to complete. Each delay is
+// the interval plus a random duration in [0, jitter). The default is an
+// 11 second interval with 13 seconds of jitter. The interval must be at
+// least one second so that the CloudFormation API isn't throttled.
+func WithStackPollInterval(interval time.Duration, jitter time.Duration) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if interval < time.Second {
+			return errors.Errorf("Stack poll interval must be at least 1s (found: %s)", interval)
+		}
+		if jitter < 0 {
+			return errors.Errorf("Stack poll jitter must not be negative (found: %s)", jitter)
+		}
+		options.stackPollInterval = &spartaCF.StackPollInterval{
+			Interval: interval,
+			Jitter:   jitter,
+		}
+		return nil
+	}
+}
+
+// WithAWSMaxRetries sets the number of times that a retryable AWS request,
+// such as a throttled CloudFormation DescribeStacks call, is retried with
+// exponential backoff before the provision fails. The default is
+// spartaAWS.DefaultMaxRetries.
+func WithAWSMaxRetries(maxRetries int) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if maxRetries <= 0 {
+			return errors.Errorf("AWS max retries must be greater than 0 (found: %d)", maxRetries)
+		}
+		options.awsMaxRetries = maxRetries
+		return nil
+	}
+}
+
+// WithTemplateOutputPath writes the pretty-printed, fully resolved
+// CloudFormation template to the file at templatePath. The file and any
+// missing parent directories are created, and an existing file is
+// truncated. The template is written for both -noop and normal provisions.
+func WithTemplateOutputPath(templatePath string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if strings.TrimSpace(templatePath) == "" {
+			return errors.New("WithTemplateOutputPath requires a non-empty path")
+		}
+		options.templateOutputPath = templatePath
+		return nil
+	}
+}
+
+const (
+	// TemplateFormatJSON is the default, pretty-printed JSON template format
+	TemplateFormatJSON = "json"
+	// TemplateFormatYAML is the YAML template format, which uses the short
+	// form intrinsic functions (eg, `!Ref`)
+	TemplateFormatYAML = "yaml"
+)
+
+// WithTemplateFormat sets the format of the CloudFormation template that's
+// written to the Provision template writer and the WithTemplateOutputPath
+// file. The format must be one of TemplateFormatJSON (the default) or
+// TemplateFormatYAML. The template that's uploaded to S3 is always JSON.
+func WithTemplateFormat(format string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		switch format {
+		case TemplateFormatJSON, TemplateFormatYAML:
+			options.templateFormat = format
+			return nil
+		default:
+			return errors.Errorf("Unsupported template format: %q. Supported values: %s, %s",
+				format,
+				TemplateFormatJSON,
+				TemplateFormatYAML)
+		}
+	}
+}
+
+// WithAWSRegion provisions the service in the region, regardless of the
+// region resolved from the environment and shared config.
+func WithAWSRegion(region string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if region == "" {
+			return errors.New("WithAWSRegion requires a non-empty region")
+		}
+		options.awsRegion = region
+		return nil
+	}
+}
+
+// WithAWSProfile resolves the provisioning credentials and configuration
+// from the named shared config profile (eg, `[profile staging]` in
+// ~/.aws/config), rather than from the default credential chain.
+func WithAWSProfile(profile string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if strings.TrimSpace(profile) == "" {
+			return errors.New("WithAWSProfile requires a non-empty profile name")
+		}
+		options.awsProfile = profile
+		return nil
+	}
+}
+
+// WithAWSSession provisions the service with a copy of the caller's
+// session, rather than a session created from the environment and shared
+// config. The copy retains the session's credentials, handlers, and HTTP
+// client, which makes it possible to provision against stubbed AWS
+// services in tests. The WithAWSRegion, WithAWSEndpoint,
+// WithAWSMaxRetries, and WithAssumeRole options are applied to the copy.
+// WithAWSSession can't be combined with WithAWSProfile.
+func WithAWSSession(awsSession *session.Session) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if awsSession == nil {
+			return errors.New("WithAWSSession requires a non-nil session")
+		}
+		options.awsSession = awsSession
+		return nil
+	}
+}
+
+// WithAWSEndpoint sends every AWS request made during provisioning,
+// including the CloudFormation, S3, and IAM requests, to the endpoint URL
+// (eg, `http://localhost:4566` for LocalStack). S3 requests use path style
+// addressing.
+func WithAWSEndpoint(endpoint string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		endpointURL, endpointURLErr := url.Parse(endpoint)
+		if endpointURLErr != nil ||
+			(endpointURL.Scheme != "http" && endpointURL.Scheme != "https") ||
+			endpointURL.Host == "" {
+			return errors.Errorf("Invalid AWS endpoint: %q. The endpoint must be an http or https URL",
+				endpoint)
+		}
+		options.awsEndpoint = endpoint
+		return nil
+	}
+}
+
+// WithStackOnFailure sets the behavior when a new stack fails to create.
+// The onFailure value must be one of cloudformation.OnFailureDoNothing,
+// cloudformation.OnFailureRollback, or cloudformation.OnFailureDelete.
+// The default is OnFailureDelete, which deletes the failed stack. Use
+// OnFailureDoNothing to retain the failed resources for debugging. The
+// CloudFormation console URL of a retained stack is logged.
+func WithStackOnFailure(onFailure string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		switch onFailure {
+		case cloudformation.OnFailureDoNothing,
+			cloudformation.OnFailureRollback,
+			cloudformation.OnFailureDelete:
+			options.stackOnFailure = onFailure
+			return nil
+		default:
+			return errors.Errorf("Unsupported stack OnFailure value: %s", onFailure)
+		}
+	}
+}
+
+// WithDisableRollback disables the CloudFormation rollback of a failed stack
+// create or update, so that the failed resources can be inspected. A failed
+// update leaves the stack in the UPDATE_FAILED state, and the stack must be
+// rolled back or deleted before the next update. WithDisableRollback can't
+// be combined with WithStackOnFailure.
+func WithDisableRollback() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.disableRollback = true
+		return nil
+	}
+}
+
+// WithSelectiveFunctionDeploy limits the code update to the functions
+// named by functionNames (the LambdaAWSInfo function names). The service
+// binary is still compiled and uploaded once, but every other function
+// is provisioned with the code of its currently deployed version, so
+// only the selected functions are updated with the new archive. Unselected
+// functions remain in the template, so they aren't deleted, and their
+// non-code configuration is still converged. The service stack must
+// already exist.
+func WithSelectiveFunctionDeploy(functionNames ...string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if len(functionNames) == 0 {
+			return errors.New("WithSelectiveFunctionDeploy requires at least one function name")
+		}
+		options.selectedFunctions = append([]string{}, functionNames...)
+		return nil
+	}
+}
+
+// DefaultArtifactExpirationDays is the artifact retention used by
+// WithArtifactBucketKeyExpiry when expirationDays is zero
+const DefaultArtifactExpirationDays = 30
+
+// WithArtifactBucketKeyExpiry verifies that the service's artifacts in the
+// S3 bucket (the keys with the `<serviceName>/` prefix) are covered by an
+// enabled lifecycle expiration rule. Rules scoped to other prefixes in a
+// shared bucket don't satisfy the check. If createRule is true and the
+// prefix isn't covered, a service scoped rule is added that expires the
+// current and noncurrent versions of the service's artifacts after
+// expirationDays (DefaultArtifactExpirationDays if zero). Other lifecycle
+// rules are preserved, and an externally managed rule with a different
+// retention is logged rather than overridden. If createRule is false,
+// provisioning fails if the prefix isn't covered.
+func WithArtifactBucketKeyExpiry(expirationDays int, createRule bool) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if expirationDays < 0 {
+			return errors.Errorf("WithArtifactBucketKeyExpiry requires a non-negative number of days: %d",
+				expirationDays)
+		}
+		if expirationDays == 0 {
+			expirationDays = DefaultArtifactExpirationDays
+		}
+		options.artifactExpirationDays = int64(expirationDays)
+		options.artifactExpirationCreateRule = createRule
+		return nil
+	}
+}
+
+// WithS3KeyPrefix stores the service's artifacts (the code archive, S3 site
+// archive, CloudFormation template, and deployment history) under
+// `<keyPrefix>/<serviceName>/` rather than `<serviceName>/`. This
+// namespaces services that share a bucket. The artifact expiration check of
+// WithArtifactBucketKeyExpiry is scoped to the same prefix.
+func WithS3KeyPrefix(keyPrefix string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		keyPrefix = strings.Trim(keyPrefix, "/")
+		if keyPrefix == "" || strings.Contains(keyPrefix, "//") {
+			return errors.Errorf("Invalid S3 key prefix: %q", keyPrefix)
+		}
+		options.s3KeyPrefix = keyPrefix
+		return nil
+	}
+}
+
+// WithS3UploadTuning sets the multipart upload part size, in bytes, and the
+// number of parts uploaded concurrently for the code archive, S3 site, and
+// CloudFormation template uploads. Larger parts and more concurrency can
+// speed up large uploads on high latency networks. A zero partSize uses the
+// s3manager.DefaultUploadPartSize (5MB), and a nonzero partSize must be at
+// least the S3 minimum part size, s3manager.MinUploadPartSize (5MB). A zero
+// concurrency uses the s3manager.DefaultUploadConcurrency (5).
+func WithS3UploadTuning(partSize int64, concurrency int) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if partSize != 0 && partSize < s3manager.MinUploadPartSize {
+			return errors.Errorf("Invalid S3 upload part size: %d. Part size must be at least %d bytes",
+				partSize,
+				s3manager.MinUploadPartSize)
+		}
+		if concurrency < 0 {
+			return errors.Errorf("Invalid S3 upload concurrency: %d", concurrency)
+		}
+		options.s3UploadPartSize = partSize
+		options.s3UploadConcurrency = concurrency
+		return nil
+	}
+}
+
+// WithArtifactEncryption requests server-side encryption of every artifact
+// uploaded to the S3 bucket. The algorithm is either `AES256` (SSE-S3) or
+// `aws:kms` (SSE-KMS). The optional kmsKeyID is the SSE-KMS key ID or ARN.
+// If it's empty, the AWS managed `aws/s3` key is used.
+func WithArtifactEncryption(algorithm string, kmsKeyID string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		switch algorithm {
+		case s3.ServerSideEncryptionAes256:
+			if kmsKeyID != "" {
+				return errors.Errorf("WithArtifactEncryption KMS key requires the %s algorithm",
+					s3.ServerSideEncryptionAwsKms)
+			}
+		case s3.ServerSideEncryptionAwsKms:
+		default:
+			return errors.Errorf("Unsupported artifact encryption algorithm: %q. Supported values: %s, %s",
+				algorithm,
+				s3.ServerSideEncryptionAes256,
+				s3.ServerSideEncryptionAwsKms)
+		}
+		options.artifactEncryption = algorithm
+		options.artifactEncryptionKMSKeyID = kmsKeyID
+		return nil
+	}
+}
+
+// WithBuildConcurrency limits the CPU footprint of the service binary
+// compilation by setting both GOMAXPROCS and the `go build -p` parallelism
+// flag for the build subprocess to maxProcs. By default the build uses
+// all available cores.
+func WithBuildConcurrency(maxProcs int) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if maxProcs <= 0 {
+			return errors.Errorf("WithBuildConcurrency requires a positive value: %d", maxProcs)
+		}
+		options.buildConcurrency = maxProcs
+		return nil
+	}
+}
+
+// BuildOptions are additional settings for the `go build` command that
+// compiles the service binary
+type BuildOptions struct {
+	// Tags are additional build tags. The `lambdabinary` tag is always
+	// included
+	Tags []string
+	// LinkerFlags are additional -ldflags values (eg, `-X main.gitSHA=...`)
+	LinkerFlags string
+	// Env are additional environment variables for the build. GOOS and
+	// GOARCH values are ignored for safety, since the binary must target
+	// the AWS Lambda environment. Use WithLambdaArchitecture to target arm64.
+	Env map[string]string
+}
+
+// WithForceBuild always compiles the service binary. By default, the
+// binary built by a previous provision is reused from the scratch
+// directory's build cache if the Go toolchain, build flags, build
+// environment, and working directory files are unchanged.
+func WithForceBuild() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.forceBuild = true
+		return nil
+	}
+}
+
+// WithPreserveArtifacts keeps the local build artifacts, including the
+// service binary, the code archive, and the S3 site archive, rather than
+// deleting them after the upload or during rollback. The retained paths are
+// logged so that a failed provision can be inspected.
+func WithPreserveArtifacts() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.preserveArtifacts = true
+		return nil
+	}
+}
+
+// WithUPXCompression compresses the service binary with `upx`
+// (https://upx.github.io) before it's added to the code archive, which
+// reduces the archive size and upload time. Compression is skipped with a
+// warning if `upx` isn't on the PATH. Decompression adds a small amount of
+// cold start latency.
+func WithUPXCompression() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.upxCompression = true
+		return nil
+	}
+}
+
+// WithBuildOptions merges the BuildOptions into the `go build` command
+// that compiles the service binary. The options are added to the build
+// tags and linker flags supplied to Provision.
+func WithBuildOptions(buildOptions BuildOptions) ProvisionOption {
+	return func(options *provisionOptions) error {
+		for _, eachTag := range buildOptions.Tags {
+			if eachTag == "" || strings.ContainsAny(eachTag, " \t,") {
+				return errors.Errorf("Invalid build tag: %q", eachTag)
+			}
+		}
+		for eachKey := range buildOptions.Env {
+			if eachKey == "" || strings.Contains(eachKey, "=") {
+				return errors.Errorf("Invalid build environment variable name: %q", eachKey)
+			}
+		}
+		options.buildOptions = &buildOptions
+		return nil
+	}
+}
+
+const (
+	// LambdaArchitectureX8664 is the default x86_64 Lambda architecture
+	LambdaArchitectureX8664 = "x86_64"
+	// LambdaArchitectureArm64 is the arm64 (AWS Graviton) Lambda architecture
+	LambdaArchitectureArm64 = "arm64"
+)
+
+// lambdaGoArch returns the GOARCH value for the Lambda architecture
+func lambdaGoArch(lambdaArchitecture string) string {
+	if lambdaArchitecture == LambdaArchitectureArm64 {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// WithLambdaArchitecture sets the instruction set architecture of the
+// service's functions. The service binary is compiled for the matching
+// GOARCH and the Architectures property is set on every function that
+// runs the service binary. The architecture must be one of
+// LambdaArchitectureX8664 (the default) or LambdaArchitectureArm64.
+func WithLambdaArchitecture(architecture string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		switch architecture {
+		case LambdaArchitectureX8664, LambdaArchitectureArm64:
+			options.lambdaArchitecture = architecture
+			return nil
+		default:
+			return errors.Errorf("Unsupported Lambda architecture: %q. Supported values: %s, %s",
+				architecture,
+				LambdaArchitectureX8664,
+				LambdaArchitectureArm64)
+		}
+	}
+}
+
+const (
+	// LambdaRuntimeProvidedAL2 is the Amazon Linux 2 OS-only Lambda runtime
+	LambdaRuntimeProvidedAL2 = "provided.al2"
+	// LambdaRuntimeProvidedAL2023 is the Amazon Linux 2023 OS-only Lambda runtime
+	LambdaRuntimeProvidedAL2023 = "provided.al2023"
+	// providedRuntimeHandler is the executable name required by the
+	// OS-only runtimes
+	providedRuntimeHandler = "bootstrap"
+)
+
+// WithLambdaRuntime sets the Lambda runtime of every function that runs the
+// service binary. The runtime must be one of GoLambdaVersion (the default),
+// LambdaRuntimeProvidedAL2, or LambdaRuntimeProvidedAL2023. The OS-only
+// provided runtimes package the service binary as `bootstrap` and set each
+// function's Handler to match. The arm64 architecture requires a provided
+// runtime.
+func WithLambdaRuntime(runtime string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		switch runtime {
+		case GoLambdaVersion, LambdaRuntimeProvidedAL2, LambdaRuntimeProvidedAL2023:
+			options.lambdaRuntime = runtime
+			return nil
+		default:
+			return errors.Errorf("Unsupported Lambda runtime: %q. Supported values: %s, %s, %s",
+				runtime,
+				GoLambdaVersion,
+				LambdaRuntimeProvidedAL2,
+				LambdaRuntimeProvidedAL2023)
+		}
+	}
+}
+
+// lambdaHandlerName returns the name of the service binary in the code
+// archive, which is also the function Handler, for the runtime
+func lambdaHandlerName(lambdaRuntime string, binaryName string) string {
+	switch lambdaRuntime {
+	case LambdaRuntimeProvidedAL2, LambdaRuntimeProvidedAL2023:
+		return providedRuntimeHandler
+	default:
+		return binaryName
+	}
+}
+
+// WithS3BucketOwnershipCheck verifies that the artifact bucket is owned by
+// the caller's AWS account before any artifacts are uploaded. The account ID
+// is also sent as the `x-amz-expected-bucket-owner` header on the artifact
+// uploads so that S3 rejects uploads to a bucket owned by another account.
+func WithS3BucketOwnershipCheck() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.bucketOwnershipCheck = true
+		return nil
+	}
+}
+
+// WithColdStartBenchmark measures the cold start of each function after the
+// stack is provisioned. Each function is forced to cold start by temporarily
+// updating an environment variable, invoked with an empty JSON object, and
+// the `Init Duration` from the invocation log tail is logged and returned in
+// the WithProvisionResult ProvisionResult. If WithDeploymentHistory is
+// enabled, the durations are also included in the deployment history record
+// so that they can be trended across deploys.
+// The probe adds deploy time and invokes each function, so it's opt-in.
+func WithColdStartBenchmark() ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.coldStartBenchmark = true
+		return nil
+	}
+}
+
+// WithResourceTagPropagation adds the service-wide tags to the `Tags`
+// property of every taggable resource in the generated template, including
+// the resources created by decorators. Resource-specific tags, such as
+// LambdaFunctionOptions.Tags, take precedence over service tags with the
+// same key. Resource types whose CloudFormation definition doesn't include
+// a `Tags` list aren't modified.
+func WithResourceTagPropagation(tags map[string]string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if options.resourceTags == nil {
+			options.resourceTags = make(map[string]string)
+		}
+		for eachKey, eachValue := range tags {
+			options.resourceTags[eachKey] = eachValue
+		}
+		return nil
+	}
+}
+
+// WithAdditionalTemplate merges the template into the service's
+// CloudFormation template. Use it to provision supporting resources, such
+// as a DynamoDB table or an SQS queue, in the same stack as the functions
+// that use them. Provision fails if a logical name in the template is
+// already defined by the service template. The option may be supplied more
+// than once.
+func WithAdditionalTemplate(template *gocf.Template) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if template == nil {
+			return errors.New("Invalid additional template: template must not be nil")
+		}
+		options.additionalTemplates = append(options.additionalTemplates, template)
+		return nil
+	}
+}
+
+// WithStackTags applies the tags (eg, `Team`, `CostCenter`) to the service's
+// CloudFormation stack for both creates and updates. CloudFormation
+// propagates stack tags to the stack resources that support tagging. Keys
+// with the reserved `aws:` prefix are logged and ignored. Sparta's own stack
+// tags take precedence over tags with the same key.
+func WithStackTags(tags map[string]string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if options.stackTags == nil {
+			options.stackTags = make(map[string]string)
+		}
+		for eachKey, eachValue := range tags {
+			options.stackTags[eachKey] = eachValue
+		}
+		return nil
+	}
+}
+
+// maxStackNotificationARNs is the CloudFormation limit on the number of
+// NotificationARNs per stack
+const maxStackNotificationARNs = 5
+
+// reSNSTopicARN matches SNS topic ARNs in any partition
+var reSNSTopicARN = regexp.MustCompile(`^arn:aws[a-z-]*:sns:[a-z0-9-]+:\d{12}:[a-zA-Z0-9_-]{1,256}$`)
+
+// WithStackNotificationARNs publishes the CloudFormation stack events to the
+// SNS topics during stack creates and updates. Each value must be an SNS
+// topic ARN, and CloudFormation supports at most 5 topics per stack.
+func WithStackNotificationARNs(topicARNs ...string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if len(topicARNs) > maxStackNotificationARNs {
+			return errors.Errorf("Too many stack NotificationARNs: %d. CloudFormation supports at most %d",
+				len(topicARNs),
+				maxStackNotificationARNs)
+		}
+		for _, eachARN := range topicARNs {
+			if !reSNSTopicARN.MatchString(eachARN) {
+				return errors.Errorf("Invalid stack NotificationARN: %q is not an SNS topic ARN", eachARN)
+			}
+		}
+		options.stackNotificationARNs = topicARNs
+		return nil
+	}
+}
+
+// reIAMRoleARN matches IAM role ARNs in any partition
+var reIAMRoleARN = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]{1,512}$`)
+
+// reIAMPolicyARN matches AWS and customer managed policy ARNs in any
+// partition
+var reIAMPolicyARN = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(aws|\d{12}):policy/[\w+=,.@/-]{1,512}$`)
+
+// WithIAMPermissionsBoundary sets the PermissionsBoundary of every
+// AWS::IAM::Role resource in the template, including the roles created
+// for IAMRoleDefinition values, to the managed policy ARN. Roles with an
+// IAMRoleDefinition.PermissionsBoundary keep that boundary. The
+// policyARN must be a managed policy ARN (eg,
+// `arn:aws:iam::123412341234:policy/DeveloperBoundary`).
+func WithIAMPermissionsBoundary(policyARN string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if !reIAMPolicyARN.MatchString(policyARN) {
+			return errors.Errorf("Invalid permissions boundary: %q is not a managed policy ARN",
+				policyARN)
+		}
+		options.iamPermissionsBoundary = policyARN
+		return nil
+	}
+}
+
+// WithConcurrencyLimitHint sets the account concurrent executions limit
+// used to check the total ReservedConcurrentExecutions of the service
+// functions. Provision logs a warning if the total exceeds the limit less
+// the 100 executions that Lambda keeps unreserved. The default limit is
+// 1000, the Lambda account default.
+func WithConcurrencyLimitHint(accountLimit int64) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if accountLimit <= 0 {
+			return errors.Errorf("Invalid concurrency limit hint: %d. The limit must be positive",
+				accountLimit)
+		}
+		options.concurrencyLimitHint = accountLimit
+		return nil
+	}
+}
+
+// reLambdaAliasName matches valid Lambda alias names. Names that are
+// entirely numeric are reserved for versions.
+var reLambdaAliasName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,128}$`)
+var reLambdaVersionNumber = regexp.MustCompile(`^[0-9]+$`)
+
+// WithLambdaAlias publishes a new AWS::Lambda::Version of each function
+// whenever its code changes and points the aliasName AWS::Lambda::Alias (eg,
+// `live`) at it. Published versions are retained when they're superseded
+// so that the alias can be rolled back. The alias ARN of each function is
+// stored in the "<LogicalResourceName>AliasArn" stack Output so that API
+// Gateway integrations and event sources can target the alias rather than
+// $LATEST. It can't be used with in-place updates, which don't publish a
+// version.
+func WithLambdaAlias(aliasName string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if !reLambdaAliasName.MatchString(aliasName) ||
+			reLambdaVersionNumber.MatchString(aliasName) {
+			return errors.Errorf("Invalid Lambda alias name: %q. Alias names must be 1-128 alphanumeric, - or _ characters and not entirely numeric",
+				aliasName)
+		}
+		options.lambdaAlias = aliasName
+		return nil
+	}
+}
+
+// reRoleSessionName matches valid STS AssumeRole session names
+var reRoleSessionName = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// WithAssumeRole provisions the service with temporary credentials from
+// assuming the IAM role (eg, a deployment role in a member account). The
+// ambient credentials are only used to call STS AssumeRole. The externalID
+// and sessionName are optional; an empty sessionName uses the AWS SDK default.
+func WithAssumeRole(roleARN string, externalID string, sessionName string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if !reIAMRoleARN.MatchString(roleARN) {
+			return errors.Errorf("Invalid AssumeRole ARN: %q is not an IAM role ARN", roleARN)
+		}
+		if sessionName != "" && !reRoleSessionName.MatchString(sessionName) {
+			return errors.Errorf("Invalid AssumeRole session name: %q. Session names must be 2-64 alphanumeric or _+=,.@- characters",
+				sessionName)
+		}
+		options.assumeRoleARN = roleARN
+		options.assumeRoleExternalID = externalID
+		options.assumeRoleSessionName = sessionName
+		return nil
+	}
+}
+
+// ProvisionResult is the state of the service's CloudFormation stack after
+// a successful provision. See WithProvisionResult
+type ProvisionResult struct {
+	// StackID is the ARN of the service's CloudFormation stack
+	StackID string
+	// StackStatus is the final stack status (eg, UPDATE_COMPLETE)
+	StackStatus string
+	// Outputs are the stack Outputs, keyed by OutputKey
+	Outputs map[string]string
+	// BuildTime is the UTC time the provision started
+	BuildTime time.Time
+	// ColdStartInitMS is the cold start Init Duration (ms) of each function,
+	// keyed by function name. It's only populated if WithColdStartBenchmark
+	// is enabled.
+	ColdStartInitMS map[string]float64
+	// Signatures are the signatures of the uploaded artifacts, keyed by S3
+	// key. It's only populated if WithArtifactSigning is enabled.
+	Signatures map[string]ArtifactSignature
+}
+
+// ArtifactSignature is the detached signature of an artifact uploaded to
+// S3. See WithArtifactSigning.
+type ArtifactSignature struct {
+	// SHA256 is the hex encoded SHA256 digest of the artifact
+	SHA256 string
+	// Signature is the base64 encoded signature of the SHA256 digest
+	Signature string
+}
+
+// WithProvisionResult populates result with the stack ID, status, and
+// Outputs after a successful provision, so that callers can use values
+// like the API Gateway URL without describing the stack. The result is not
+// modified by -noop builds or CodePipeline packages, since neither
+// provisions a stack.
+func WithProvisionResult(result *ProvisionResult) ProvisionOption {
+	return func(options *provisionOptions) error {
+		if result == nil {
+			return errors.New("WithProvisionResult requires a non-nil ProvisionResult")
+		}
+		options.provisionResult = result
+		return nil
+	}
+}
+
+// WithProgressFunc reports the Provision workflow progress to progressFunc
+// as it transitions between steps and on each CloudFormation stack poll,
+// so that embedding tools can render their own UI independent of the
+// logger. progressFunc is called synchronously from the workflow. A nil
+// progressFunc disables progress reporting.
+func WithProgressFunc(progressFunc ProgressFunc) ProvisionOption {
+	return func(options *provisionOptions) error {
+		options.progressFunc = progressFunc
+		return nil
+	}
+}
+
+const (
+	// LogFormatText is the human readable log format
+	LogFormatText = "text"
+	// LogFormatJSON is the structured log format, suitable for log pipelines
+	// that index fields like StackName and Resource
+	LogFormatJSON = "json"
+)
+
+// WithLogFormat sets the format of the Provision workflow log output. The
+// format must be one of LogFormatText or LogFormatJSON. The caller's logger
+// isn't modified; the workflow logs through a derived logger that shares
+// its output and hooks.
+func WithLogFormat(format string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		switch format {
+		case LogFormatText, LogFormatJSON:
+			options.logFormat = format
+			return nil
+		default:
+			return errors.Errorf("Unsupported log format: %q. Supported values: %s, %s",
+				format,
+				LogFormatText,
+				LogFormatJSON)
+		}
+	}
+}
+
+// WithLogLevel sets the level (eg, "info", "debug") of the Provision
+// workflow log output. Like WithLogFormat, the caller's logger isn't
+// modified.
+func WithLogLevel(level string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		logLevel, logLevelErr := logrus.ParseLevel(level)
+		if logLevelErr != nil {
+			return errors.Wrapf(logLevelErr, "Invalid log level: %q", level)
+		}
+		options.logLevel = &logLevel
+		return nil
+	}
+}
+
+// provisionLogger returns the logger used by the Provision workflow. If
+// neither WithLogFormat nor WithLogLevel was supplied, this is the caller's
+// logger. Otherwise it's a new logger with the same output and hooks.
+func provisionLogger(logger *logrus.Logger, options *provisionOptions) *logrus.Logger {
+	if options.logFormat == "" && options.logLevel == nil {
+		return logger
+	}
+	derivedLogger := logrus.New()
+	derivedLogger.Out = logger.Out
+	derivedLogger.Hooks = logger.Hooks
+	derivedLogger.Formatter = logger.Formatter
+	derivedLogger.Level = logger.Level
+	switch options.logFormat {
+	case LogFormatText:
+		derivedLogger.Formatter = &logrus.TextFormatter{
+			DisableColors: (runtime.GOOS == "windows") || isRunningInAWS(),
+		}
+	case LogFormatJSON:
+		derivedLogger.Formatter = &logrus.JSONFormatter{}
+	}
+	if options.logLevel != nil {
+		derivedLogger.Level = *options.logLevel
+	}
+	return derivedLogger
+}
+
+// stackPolicyStatement is the subset of a stack policy statement
+// that's validated before the policy is applied
+type stackPolicyStatement struct {
+	Effect      string
+	Action      interface{}
+	NotAction   interface{}
+	Principal   interface{}
+	Resource    interface{}
+	NotResource interface{}
+}
+
+// jsonErrorPosition returns the 1-based line and column of the byte that
+// caused an encoding/json error. The error Offset is the number of bytes
+// read, including that byte.
+func jsonErrorPosition(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 1 {
+		return 1, 1
+	}
+	prefix := data[:offset-1]
+	line := bytes.Count(prefix, []byte("\n")) + 1
+	column := len(prefix) - bytes.LastIndexByte(prefix, '\n')
+	return line, column
+}
+
+// loadStackPolicyFile reads and validates the stack policy document
+// at policyPath
+func loadStackPolicyFile(policyPath string) (string, error) {
+	/* #nosec */
+	policyData, policyDataErr := ioutil.ReadFile(policyPath)
+	if policyDataErr != nil {
+		return "", errors.Wrapf(policyDataErr, "Failed to read stack policy file: %s", policyPath)
+	}
+	var policy struct {
+		Statement []stackPolicyStatement
+	}
+	unmarshalErr := json.Unmarshal(policyData, &policy)
+	if unmarshalErr != nil {
+		offset := int64(-1)
+		switch typedErr := unmarshalErr.(type) {
+		case *json.SyntaxError:
+			offset = typedErr.Offset
+		case *json.UnmarshalTypeError:
+			offset = typedErr.Offset
+		}
+		if offset >= 0 {
+			line, column := jsonErrorPosition(policyData, offset)
+			return "", errors.Errorf("Invalid stack policy file %s (line %d, column %d): %s",
+				policyPath,
+				line,
+				column,
+				unmarshalErr)
+		}
+		return "", errors.Wrapf(unmarshalErr, "Invalid stack policy file: %s", policyPath)
+	}
+	if len(policy.Statement) == 0 {
+		return "", errors.Errorf("Invalid stack policy file %s: at least one Statement is required",
+			policyPath)
+	}
+	for eachIndex, eachStatement := range policy.Statement {
+		statementErr := func(message string) error {
+			return errors.Errorf("Invalid stack policy file %s: Statement[%d] %s",
+				policyPath,
+				eachIndex,
+				message)
+		}
+		switch {
+		case eachStatement.Effect != "Allow" && eachStatement.Effect != "Deny":
+			return "", statementErr("Effect must be either Allow or Deny")
+		case eachStatement.Principal == nil:
+			return "", statementErr("requires a Principal")
+		case eachStatement.Action == nil && eachStatement.NotAction == nil:
+			return "", statementErr("requires either an Action or NotAction")
+		case eachStatement.Resource == nil && eachStatement.NotResource == nil:
+			return "", statementErr("requires either a Resource or NotResource")
+		}
+	}
+	return string(policyData), nil
+}
+
+// WithStackPolicyFromFile applies the stack policy document at policyPath
+// to the service's CloudFormation stack. The document is validated when
+// the option is applied so that a malformed policy fails the provision
+// before any deploy action is taken. See
+// https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/protect-stack-resources.html
+func WithStackPolicyFromFile(policyPath string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		policyBody, policyBodyErr := loadStackPolicyFile(policyPath)
+		if policyBodyErr != nil {
+			return policyBodyErr
+		}
+		options.stackPolicyBody = policyBody
+		return nil
+	}
+}
+
+// WithStackPolicyDuringUpdateFromFile temporarily overrides the stack policy
+// with the document at policyPath while an existing stack is updated. The
+// document is validated the same way as WithStackPolicyFromFile.
+func WithStackPolicyDuringUpdateFromFile(policyPath string) ProvisionOption {
+	return func(options *provisionOptions) error {
+		policyBody, policyBodyErr := loadStackPolicyFile(policyPath)
+		if policyBodyErr != nil {
+			return policyBodyErr
+		}
+		options.stackPolicyDuringUpdateBody = policyBody
+		return nil
+	}
+}
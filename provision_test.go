@@ -1,10 +1,38 @@
 package sparta
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
 	gocf "github.com/mweagle/go-cloudformation"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -111,3 +139,3047 @@ func TestDecorateProvision(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 }
+
+func TestResourceDecoratorProvision(t *testing.T) {
+	lambdas := testLambdaData()
+	lambdas[0].ResourceDecorators = []LambdaResourceDecorator{
+		func(function *gocf.LambdaFunction,
+			resource *gocf.Resource,
+			template *gocf.Template,
+			logger *logrus.Logger) error {
+			function.MemorySize = gocf.Integer(1536)
+			resource.DeletionPolicy = "Retain"
+			return nil
+		},
+	}
+	logger, _ := NewLogger("info")
+	var templateWriter bytes.Buffer
+	err := Provision(true,
+		"SampleProvision",
+		"",
+		lambdas,
+		nil,
+		nil,
+		os.Getenv("S3_BUCKET"),
+		false,
+		false,
+		"testBuildID",
+		"",
+		"",
+		"",
+		&templateWriter,
+		nil,
+		logger)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(templateWriter.String(), "1536") {
+		t.Fatal("Failed to apply resource decorator MemorySize to the template")
+	}
+}
+
+func TestResourceDecoratorError(t *testing.T) {
+	lambdaInfo := testLambdaData()[0]
+	lambdaInfo.ResourceDecorators = []LambdaResourceDecorator{
+		func(function *gocf.LambdaFunction,
+			resource *gocf.Resource,
+			template *gocf.Template,
+			logger *logrus.Logger) error {
+			return errors.New("decorator failed")
+		},
+	}
+	cfResource := &gocf.Resource{Properties: LambdaFunctionResource{}}
+	err := lambdaInfo.applyResourceDecorators(cfResource, gocf.NewTemplate(), logrus.New())
+	if err == nil || !strings.Contains(err.Error(), "decorator failed") {
+		t.Fatalf("Failed to propagate resource decorator error: %v", err)
+	}
+}
+
+func TestResourceDecoratorLambdaFunctionResource(t *testing.T) {
+	lambdas := testLambdaData()
+	lambdas[0].Options.LoggingConfig = &LambdaLoggingConfig{LogFormat: "JSON"}
+	// The template includes the previously exported function resources
+	lambdas[1].ResourceDecorators = []LambdaResourceDecorator{
+		func(function *gocf.LambdaFunction,
+			resource *gocf.Resource,
+			template *gocf.Template,
+			logger *logrus.Logger) error {
+			if _, resourceOk := resource.Properties.(LambdaFunctionResource); !resourceOk {
+				return errors.Errorf("Unexpected resource properties type: %T", resource.Properties)
+			}
+			cfResource := template.Resources[lambdas[0].LogicalResourceName()]
+			functionResource, functionResourceOk := cfResource.Properties.(LambdaFunctionResource)
+			if !functionResourceOk {
+				return errors.Errorf("Unexpected function properties type: %T", cfResource.Properties)
+			}
+			functionResource.MemorySize = gocf.Integer(1536)
+			cfResource.Properties = functionResource
+			return nil
+		},
+	}
+	logger, _ := NewLogger("info")
+	var templateWriter bytes.Buffer
+	err := Provision(true,
+		"SampleProvision",
+		"",
+		lambdas,
+		nil,
+		nil,
+		os.Getenv("S3_BUCKET"),
+		false,
+		false,
+		"testBuildID",
+		"",
+		"",
+		"",
+		&templateWriter,
+		nil,
+		logger)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	var template struct {
+		Resources map[string]struct {
+			Properties struct {
+				MemorySize    interface{}
+				LoggingConfig *LambdaLoggingConfig
+			}
+		}
+	}
+	unmarshalErr := json.Unmarshal(templateWriter.Bytes(), &template)
+	if unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	functionProperties := template.Resources[lambdas[0].LogicalResourceName()].Properties
+	if fmt.Sprintf("%v", functionProperties.MemorySize) != "1536" {
+		t.Errorf("Failed to apply resource decorator MemorySize: %v", functionProperties.MemorySize)
+	}
+	// The Sparta specific properties are preserved
+	if functionProperties.LoggingConfig == nil || functionProperties.LoggingConfig.LogFormat != "JSON" {
+		t.Errorf("Unexpected LoggingConfig after resource decorator: %#v", functionProperties.LoggingConfig)
+	}
+}
+
+// testProvisionTemplateBody returns the -noop provisioned template for the
+// lambdas
+func testProvisionTemplateBody(t *testing.T,
+	lambdas []*LambdaAWSInfo,
+	options ...ProvisionOption) string {
+	logger, _ := NewLogger("info")
+	var templateWriter bytes.Buffer
+	err := Provision(true,
+		"SampleProvision",
+		"",
+		lambdas,
+		nil,
+		nil,
+		os.Getenv("S3_BUCKET"),
+		false,
+		false,
+		"testBuildID",
+		"",
+		"",
+		"",
+		&templateWriter,
+		nil,
+		logger,
+		options...)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	return templateWriter.String()
+}
+
+// testFunctionProperties is the subset of the AWS::Lambda::Function
+// properties verified by the provision tests
+type testFunctionProperties struct {
+	Architectures []string
+	Code          map[string]interface{}
+	Handler       string
+	PackageType   string
+	Runtime       string
+	Environment   struct {
+		Variables map[string]interface{}
+	}
+	KmsKeyArn                    string
+	RecursiveLoop                string
+	ReservedConcurrentExecutions interface{}
+	TracingConfig                struct {
+		Mode string
+	}
+	Tags []struct {
+		Key   string
+		Value string
+	}
+}
+
+// testProvisionFunctionProperties returns the properties of the lambda's
+// AWS::Lambda::Function resource in the templateBody
+func testProvisionFunctionProperties(t *testing.T,
+	templateBody string,
+	lambda *LambdaAWSInfo) testFunctionProperties {
+	var template struct {
+		Resources map[string]struct {
+			Properties testFunctionProperties
+		}
+	}
+	unmarshalErr := json.Unmarshal([]byte(templateBody), &template)
+	if unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	functionResource, functionResourceExists := template.Resources[lambda.LogicalResourceName()]
+	if !functionResourceExists {
+		t.Fatalf("Failed to find function resource: %s", lambda.LogicalResourceName())
+	}
+	return functionResource.Properties
+}
+
+func TestEnvironmentProvision(t *testing.T) {
+	lambdas := testLambdaData()
+	lambdas[0].Options.Environment = map[string]*gocf.StringExpr{
+		"SERVICE_REGION": gocf.Ref("AWS::Region").String(),
+	}
+	lambdas[0].Options.KmsKeyArn = "arn:aws:kms:us-west-2:123456789012:key/sparta-test"
+
+	templateBody := testProvisionTemplateBody(t, lambdas)
+	functionProperties := testProvisionFunctionProperties(t, templateBody, lambdas[0])
+	regionRef, regionRefExists := functionProperties.Environment.Variables["SERVICE_REGION"]
+	if !regionRefExists {
+		t.Fatalf("Failed to find SERVICE_REGION environment variable: %#v",
+			functionProperties.Environment.Variables)
+	}
+	if regionRefJSON, _ := json.Marshal(regionRef); string(regionRefJSON) != `{"Ref":"AWS::Region"}` {
+		t.Fatalf("Unexpected SERVICE_REGION value: %s", regionRefJSON)
+	}
+	if functionProperties.KmsKeyArn != lambdas[0].Options.KmsKeyArn {
+		t.Fatalf("Unexpected KmsKeyArn: %s", functionProperties.KmsKeyArn)
+	}
+}
+
+func TestKmsEncryptionContextProvision(t *testing.T) {
+	kmsKeyArn := "arn:aws:kms:us-west-2:123456789012:key/sparta-test"
+	decryptStatements := func(encryptionContext map[string]string) []map[string]interface{} {
+		lambdaFn := HandleAWSLambda(LambdaName(mockLambda1),
+			mockLambda1,
+			IAMRoleDefinition{})
+		lambdaFn.Options.KmsKeyArn = kmsKeyArn
+		lambdaFn.Options.KmsEncryptionContext = encryptionContext
+		templateBody := testProvisionTemplateBody(t, []*LambdaAWSInfo{lambdaFn})
+		template := struct {
+			Resources map[string]struct {
+				Type       string
+				Properties struct {
+					Policies []struct {
+						PolicyDocument struct {
+							Statement []map[string]interface{}
+						}
+					}
+				}
+			}
+		}{}
+		if err := json.Unmarshal([]byte(templateBody), &template); err != nil {
+			t.Fatalf("Failed to unmarshal template: %s", err)
+		}
+		statements := []map[string]interface{}{}
+		for _, eachResource := range template.Resources {
+			if eachResource.Type != "AWS::IAM::Role" {
+				continue
+			}
+			for _, eachPolicy := range eachResource.Properties.Policies {
+				for _, eachStatement := range eachPolicy.PolicyDocument.Statement {
+					actionJSON, _ := json.Marshal(eachStatement["Action"])
+					if string(actionJSON) == `["kms:Decrypt"]` {
+						statements = append(statements, eachStatement)
+					}
+				}
+			}
+		}
+		return statements
+	}
+
+	statements := decryptStatements(map[string]string{
+		"Service": "payments",
+		"Stage":   "prod",
+	})
+	if len(statements) != 1 {
+		t.Fatalf("Unexpected kms:Decrypt statements: %v", statements)
+	}
+	if statements[0]["Resource"] != kmsKeyArn {
+		t.Errorf("Unexpected kms:Decrypt Resource: %v", statements[0]["Resource"])
+	}
+	conditionJSON, _ := json.Marshal(statements[0]["Condition"])
+	expectedCondition := `{"StringEquals":{"kms:EncryptionContext:Service":"payments","kms:EncryptionContext:Stage":"prod"}}`
+	if string(conditionJSON) != expectedCondition {
+		t.Errorf("Unexpected kms:Decrypt Condition. Expected: %s, Actual: %s",
+			expectedCondition,
+			conditionJSON)
+	}
+	// The statement is only added for an encryption context
+	if statements = decryptStatements(nil); len(statements) != 0 {
+		t.Errorf("Unexpected kms:Decrypt statements without an encryption context: %v", statements)
+	}
+}
+
+func TestTracingConfigProvision(t *testing.T) {
+	tracedLambda := HandleAWSLambda(LambdaName(mockLambda1),
+		mockLambda1,
+		IAMRoleDefinition{})
+	tracedLambda.Options.TracingConfig = &gocf.LambdaFunctionTracingConfig{
+		Mode: gocf.String("Active"),
+	}
+	templateBody := testProvisionTemplateBody(t, []*LambdaAWSInfo{tracedLambda})
+	functionProperties := testProvisionFunctionProperties(t, templateBody, tracedLambda)
+	if functionProperties.TracingConfig.Mode != "Active" {
+		t.Fatalf("Unexpected TracingConfig mode: %s", functionProperties.TracingConfig.Mode)
+	}
+	for _, eachAction := range []string{"xray:PutTraceSegments", "xray:PutTelemetryRecords"} {
+		if !strings.Contains(templateBody, eachAction) {
+			t.Fatalf("Failed to find %s in the function's IAM role", eachAction)
+		}
+	}
+}
+
+func TestServiceStackTags(t *testing.T) {
+	opts, optsErr := newProvisionOptions(WithStackTags(map[string]string{
+		"Team":               "Platform",
+		"aws:cloudformation": "reserved",
+		SpartaTagBuildIDKey:  "userBuildID",
+	}))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	ctx := &workflowContext{logger: logrus.New()}
+	ctx.userdata.options = opts
+	ctx.userdata.buildID = "testBuildID"
+	stackTags := serviceStackTags(ctx)
+	if stackTags["Team"] != "Platform" {
+		t.Errorf("Failed to include user stack tag: %v", stackTags)
+	}
+	if _, reservedExists := stackTags["aws:cloudformation"]; reservedExists {
+		t.Errorf("Failed to ignore reserved stack tag: %v", stackTags)
+	}
+	if stackTags[SpartaTagBuildIDKey] != "testBuildID" {
+		t.Errorf("Failed to preserve Sparta build ID tag: %v", stackTags)
+	}
+}
+
+func TestUploadErrorsError(t *testing.T) {
+	failingUpload := func(message string) *workTask {
+		return newWorkTask(func() workResult {
+			return newTaskResult(nil, errors.New(message))
+		})
+	}
+	p := newWorkerPool([]*workTask{
+		failingUpload("binary upload failed"),
+		failingUpload("S3 site upload failed"),
+	}, 2)
+	_, uploadErrors := p.Run()
+	if len(uploadErrors) != 2 {
+		t.Fatalf("Expected 2 upload errors, got %d", len(uploadErrors))
+	}
+	errorMessage := uploadErrorsError(uploadErrors).Error()
+	for _, eachMessage := range []string{"binary upload failed", "S3 site upload failed"} {
+		if strings.Count(errorMessage, eachMessage) != 1 {
+			t.Errorf("Expected %q exactly once in: %s", eachMessage, errorMessage)
+		}
+	}
+}
+
+func TestUserBuildEnvironment(t *testing.T) {
+	buildEnv := userBuildEnvironment(map[string]string{
+		"GOOS":        "darwin",
+		"GOARCH":      "arm64",
+		"GOFLAGS":     "-mod=vendor",
+		"CGO_ENABLED": "0",
+	}, logrus.New())
+	expected := "CGO_ENABLED=0,GOFLAGS=-mod=vendor"
+	if strings.Join(buildEnv, ",") != expected {
+		t.Fatalf("Unexpected build environment. Expected %s, got %v", expected, buildEnv)
+	}
+}
+
+func TestBuildConcurrency(t *testing.T) {
+	opts, optsErr := newProvisionOptions(WithBuildConcurrency(2))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	buildFlags, buildEnv := buildConcurrencySettings(opts.buildConcurrency)
+	if strings.Join(buildFlags, " ") != "-p 2" {
+		t.Errorf("Unexpected build flags: %v", buildFlags)
+	}
+	if strings.Join(buildEnv, ",") != "GOMAXPROCS=2" {
+		t.Errorf("Unexpected build environment: %v", buildEnv)
+	}
+	// The default build uses all cores
+	buildFlags, buildEnv = buildConcurrencySettings(0)
+	if len(buildFlags) != 0 || len(buildEnv) != 0 {
+		t.Errorf("Unexpected default build settings: %v %v", buildFlags, buildEnv)
+	}
+	if WithBuildConcurrency(0)(&provisionOptions{}) == nil {
+		t.Error("Failed to reject non-positive build concurrency")
+	}
+}
+
+func TestGoVersionSupported(t *testing.T) {
+	supported := []string{
+		"go version go1.10 linux/amd64",
+		"go version go1.22.3 darwin/arm64",
+		"go version go2.0 linux/amd64",
+		"go version devel +a1b2c3d Tue Jan 1 00:00:00 2019 +0000 linux/amd64",
+	}
+	for _, eachVersion := range supported {
+		if !goVersionSupported(eachVersion) {
+			t.Errorf("Failed to accept supported Go version: %s", eachVersion)
+		}
+	}
+	unsupported := []string{
+		"go version go1.9.7 linux/amd64",
+		"go version go0.99 linux/amd64",
+		"not a go version",
+	}
+	for _, eachVersion := range unsupported {
+		if goVersionSupported(eachVersion) {
+			t.Errorf("Failed to reject unsupported Go version: %s", eachVersion)
+		}
+	}
+}
+
+func TestLogArtifactSignatures(t *testing.T) {
+	var logOutput bytes.Buffer
+	ctx := &workflowContext{logger: logrus.New()}
+	ctx.logger.Out = &logOutput
+	ctx.context.artifactSignatures = map[string]*ArtifactSignature{}
+	signatureKeys := []string{"MyService/c.json", "MyService/a.zip", "MyService/b.zip"}
+	for _, eachKey := range signatureKeys {
+		ctx.context.artifactSignatures[eachKey] = &ArtifactSignature{SHA256: eachKey}
+	}
+	logArtifactSignatures(ctx)
+	logLines := strings.Split(strings.TrimSpace(logOutput.String()), "\n")
+	expectedKeys := []string{"MyService/a.zip", "MyService/b.zip", "MyService/c.json"}
+	if len(logLines) != len(expectedKeys) {
+		t.Fatalf("Unexpected artifact signature log: %s", logOutput.String())
+	}
+	for eachIndex, eachKey := range expectedKeys {
+		if !strings.Contains(logLines[eachIndex], "Key="+eachKey) {
+			t.Errorf("Unexpected artifact signature order. Expected %s, got: %s",
+				eachKey,
+				logLines[eachIndex])
+		}
+	}
+}
+
+func TestPopulateProvisionResult(t *testing.T) {
+	if WithProvisionResult(nil)(&provisionOptions{}) == nil {
+		t.Fatal("Failed to reject nil ProvisionResult")
+	}
+	result := &ProvisionResult{}
+	opts, optsErr := newProvisionOptions(WithProvisionResult(result))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	ctx := &workflowContext{}
+	ctx.userdata.options = opts
+	ctx.context.stack = &cloudformation.Stack{
+		StackId:     aws.String("arn:aws:cloudformation:us-west-2:123456789012:stack/MyService/1"),
+		StackStatus: aws.String(cloudformation.StackStatusUpdateComplete),
+		Outputs: []*cloudformation.Output{
+			{
+				OutputKey:   aws.String(OutputAPIGatewayURL),
+				OutputValue: aws.String("https://example.execute-api.us-west-2.amazonaws.com/v1"),
+			},
+		},
+	}
+	ctx.context.coldStartDurations = map[string]float64{"MyFunction": 87.31}
+	ctx.context.artifactSignatures = map[string]*ArtifactSignature{
+		"MyService/MyService-code-1111.zip": {
+			SHA256:    "1111",
+			Signature: "c2lnbmF0dXJl",
+		},
+	}
+	ctx.transaction.startTime = time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	populateProvisionResult(ctx)
+	if result.StackID != aws.StringValue(ctx.context.stack.StackId) ||
+		result.StackStatus != cloudformation.StackStatusUpdateComplete {
+		t.Fatalf("Unexpected stack identity: %#v", result)
+	}
+	if result.Outputs[OutputAPIGatewayURL] != "https://example.execute-api.us-west-2.amazonaws.com/v1" {
+		t.Fatalf("Unexpected stack outputs: %#v", result.Outputs)
+	}
+	if !result.BuildTime.Equal(ctx.transaction.startTime) {
+		t.Fatalf("Unexpected build time: %s", result.BuildTime)
+	}
+	if result.ColdStartInitMS["MyFunction"] != 87.31 {
+		t.Fatalf("Unexpected cold start durations: %#v", result.ColdStartInitMS)
+	}
+	codeSignature := result.Signatures["MyService/MyService-code-1111.zip"]
+	if len(result.Signatures) != 1 ||
+		codeSignature.SHA256 != "1111" ||
+		codeSignature.Signature != "c2lnbmF0dXJl" {
+		t.Fatalf("Unexpected artifact signatures: %#v", result.Signatures)
+	}
+}
+
+// fakeLambdaAPI implements the lambdaAPI requests made by the cold start
+// benchmark and records the order in which they're made
+type fakeLambdaAPI struct {
+	lambdaAPI
+	variables map[string]*string
+	revision  int
+	// Number of GetFunctionConfiguration requests that report the previous
+	// revision after an update
+	staleReads int
+	logTail    string
+	calls      []string
+}
+
+func (fake *fakeLambdaAPI) GetFunctionConfigurationWithContext(ctx aws.Context,
+	input *lambda.GetFunctionConfigurationInput,
+	opts ...request.Option) (*lambda.FunctionConfiguration, error) {
+	fake.calls = append(fake.calls, "GetFunctionConfiguration")
+	revision := fake.revision
+	if fake.staleReads > 0 {
+		fake.staleReads--
+		revision--
+	}
+	return &lambda.FunctionConfiguration{
+		Environment: &lambda.EnvironmentResponse{Variables: fake.variables},
+		RevisionId:  aws.String(strconv.Itoa(revision)),
+	}, nil
+}
+
+func (fake *fakeLambdaAPI) UpdateFunctionConfigurationWithContext(ctx aws.Context,
+	input *lambda.UpdateFunctionConfigurationInput,
+	opts ...request.Option) (*lambda.FunctionConfiguration, error) {
+	fake.calls = append(fake.calls, "UpdateFunctionConfiguration")
+	fake.variables = input.Environment.Variables
+	fake.revision++
+	return &lambda.FunctionConfiguration{
+		RevisionId: aws.String(strconv.Itoa(fake.revision)),
+	}, nil
+}
+
+func (fake *fakeLambdaAPI) InvokeWithContext(ctx aws.Context,
+	input *lambda.InvokeInput,
+	opts ...request.Option) (*lambda.InvokeOutput, error) {
+	fake.calls = append(fake.calls, "Invoke")
+	if _, probeExists := fake.variables[coldStartProbeEnvVar]; !probeExists {
+		return nil, errors.New("Function invoked without the cold start probe")
+	}
+	return &lambda.InvokeOutput{
+		LogResult: aws.String(base64.StdEncoding.EncodeToString([]byte(fake.logTail))),
+	}, nil
+}
+
+func TestBenchmarkColdStart(t *testing.T) {
+	fakeLambda := &fakeLambdaAPI{
+		variables: map[string]*string{"MY_VAR": aws.String("value")},
+		logTail: "START RequestId: 1f7a Version: $LATEST\n" +
+			"END RequestId: 1f7a\n" +
+			"REPORT RequestId: 1f7a\tDuration: 1.52 ms\tBilled Duration: 2 ms\t" +
+			"Memory Size: 128 MB\tMax Memory Used: 31 MB\tInit Duration: 87.31 ms\t\n",
+	}
+	logger, _ := NewLogger("warning")
+	initDuration, initDurationErr := benchmarkColdStart(context.Background(),
+		fakeLambda,
+		"MyFunction",
+		logger)
+	if initDurationErr != nil {
+		t.Fatalf("Failed to benchmark cold start: %s", initDurationErr)
+	}
+	if initDuration != 87.31 {
+		t.Errorf("Unexpected Init Duration: %f", initDuration)
+	}
+	expectedCalls := []string{"GetFunctionConfiguration",
+		"UpdateFunctionConfiguration",
+		"GetFunctionConfiguration",
+		"Invoke",
+		"UpdateFunctionConfiguration",
+		"GetFunctionConfiguration"}
+	if strings.Join(fakeLambda.calls, ",") != strings.Join(expectedCalls, ",") {
+		t.Errorf("Unexpected Lambda requests: %v", fakeLambda.calls)
+	}
+	if len(fakeLambda.variables) != 1 || aws.StringValue(fakeLambda.variables["MY_VAR"]) != "value" {
+		t.Errorf("Failed to restore function environment: %v", fakeLambda.variables)
+	}
+
+	// Warm invocations don't report an Init Duration
+	fakeLambda.logTail = "REPORT RequestId: 2b8c\tDuration: 1.01 ms\tBilled Duration: 2 ms\t\n"
+	_, initDurationErr = benchmarkColdStart(context.Background(),
+		fakeLambda,
+		"MyFunction",
+		logger)
+	if initDurationErr == nil {
+		t.Error("Failed to reject log tail without Init Duration")
+	}
+}
+
+func TestWaitForFunctionRevision(t *testing.T) {
+	fakeLambda := &fakeLambdaAPI{revision: 2, staleReads: 1}
+	waitErr := waitForFunctionRevision(context.Background(), fakeLambda, "MyFunction", "2")
+	if waitErr != nil {
+		t.Fatalf("Failed to wait for function revision: %s", waitErr)
+	}
+	if len(fakeLambda.calls) != 2 {
+		t.Errorf("Unexpected Lambda requests: %v", fakeLambda.calls)
+	}
+
+	// A canceled context stops waiting for a revision that never appears
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	waitErr = waitForFunctionRevision(canceledCtx, fakeLambda, "MyFunction", "3")
+	if waitErr == nil || !strings.Contains(waitErr.Error(), context.Canceled.Error()) {
+		t.Errorf("Failed to stop waiting for canceled context: %v", waitErr)
+	}
+}
+
+func TestWithStackNotificationARNs(t *testing.T) {
+	validARNs := []string{
+		"arn:aws:sns:us-west-2:123456789012:StackEvents",
+		"arn:aws-us-gov:sns:us-gov-west-1:123456789012:stack-events_1",
+	}
+	if err := WithStackNotificationARNs(validARNs...)(&provisionOptions{}); err != nil {
+		t.Fatalf("Failed to accept valid SNS topic ARNs: %s", err)
+	}
+	invalidARNs := []string{
+		"arn:aws:sqs:us-west-2:123456789012:StackEvents",
+		"arn:aws:sns:us-west-2:123456789012",
+		"StackEvents",
+	}
+	for _, eachARN := range invalidARNs {
+		if err := WithStackNotificationARNs(eachARN)(&provisionOptions{}); err == nil {
+			t.Errorf("Failed to reject invalid SNS topic ARN: %s", eachARN)
+		}
+	}
+	tooManyARNs := make([]string, maxStackNotificationARNs+1)
+	for eachIndex := range tooManyARNs {
+		tooManyARNs[eachIndex] = fmt.Sprintf("arn:aws:sns:us-west-2:123456789012:Topic%d", eachIndex)
+	}
+	if err := WithStackNotificationARNs(tooManyARNs...)(&provisionOptions{}); err == nil {
+		t.Error("Failed to reject too many NotificationARNs")
+	}
+}
+
+func TestLoadStackPolicyFile(t *testing.T) {
+	testCases := []struct {
+		name            string
+		policy          string
+		expectedMessage string
+	}{
+		{"Valid",
+			`{
+  "Statement": [
+    {
+      "Effect": "Deny",
+      "Principal": "*",
+      "Action": "Update:Replace",
+      "Resource": "*"
+    }
+  ]
+}`,
+			""},
+		{"MissingComma",
+			`{
+  "Statement": [
+    {
+      "Effect": "Deny"
+      "Principal": "*"
+    }
+  ]
+}`,
+			"(line 5, column 7)"},
+		{"InvalidType",
+			`{
+  "Statement": [
+    {
+      "Effect": ["Deny"],
+      "Principal": "*"
+    }
+  ]
+}`,
+			"(line 4, column 17)"},
+		{"MissingPrincipal",
+			`{"Statement": [{"Effect": "Allow", "Action": "Update:*", "Resource": "*"}]}`,
+			"Statement[0] requires a Principal"},
+	}
+	for _, eachTestCase := range testCases {
+		policyFile, policyFileErr := ioutil.TempFile("", "stack-policy")
+		if policyFileErr != nil {
+			t.Fatalf("Failed to create stack policy file: %s", policyFileErr)
+		}
+		defer os.Remove(policyFile.Name())
+		_, writeErr := policyFile.WriteString(eachTestCase.policy)
+		policyFile.Close()
+		if writeErr != nil {
+			t.Fatalf("Failed to write stack policy file: %s", writeErr)
+		}
+		policyBody, policyErr := loadStackPolicyFile(policyFile.Name())
+		if eachTestCase.expectedMessage == "" {
+			if policyErr != nil || policyBody != eachTestCase.policy {
+				t.Errorf("%s: Failed to load stack policy: %v", eachTestCase.name, policyErr)
+			}
+			continue
+		}
+		if policyErr == nil || !strings.Contains(policyErr.Error(), eachTestCase.expectedMessage) {
+			t.Errorf("%s: Unexpected stack policy error. Expected: %s, Actual: %v",
+				eachTestCase.name,
+				eachTestCase.expectedMessage,
+				policyErr)
+		}
+	}
+	// Offsets past the end of the data are clamped
+	if line, column := jsonErrorPosition([]byte("{\n  \"a\""), 100); line != 2 || column != 5 {
+		t.Errorf("Unexpected clamped position: line %d, column %d", line, column)
+	}
+}
+
+func TestWithArtifactBucketKeyExpiry(t *testing.T) {
+	opts, optsErr := newProvisionOptions(WithArtifactBucketKeyExpiry(0, true))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	if opts.artifactExpirationDays != DefaultArtifactExpirationDays {
+		t.Fatalf("Expected default expiration of %d days, got %d",
+			DefaultArtifactExpirationDays,
+			opts.artifactExpirationDays)
+	}
+	if WithArtifactBucketKeyExpiry(-1, true)(&provisionOptions{}) == nil {
+		t.Fatal("Failed to reject negative expiration days")
+	}
+}
+
+func TestArtifactKeyPrefix(t *testing.T) {
+	ctx := &workflowContext{}
+	ctx.userdata.serviceName = "MyService"
+	ctx.userdata.options = &provisionOptions{}
+	if keyPrefix := artifactKeyPrefix(ctx); keyPrefix != "MyService/" {
+		t.Fatalf("Unexpected default key prefix: %s", keyPrefix)
+	}
+	if historyKey := deploymentHistoryKey(ctx); historyKey != "_sparta/MyService/deployment-history.jsonl" {
+		t.Fatalf("Unexpected default deployment history key: %s", historyKey)
+	}
+	opts, optsErr := newProvisionOptions(WithS3KeyPrefix("/teams/platform/"))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	ctx.userdata.options = opts
+	if keyPrefix := artifactKeyPrefix(ctx); keyPrefix != "teams/platform/MyService/" {
+		t.Fatalf("Unexpected key prefix: %s", keyPrefix)
+	}
+	// The history must not be expired by the artifact prefix rule
+	if historyKey := deploymentHistoryKey(ctx); historyKey != "teams/platform/_sparta/MyService/deployment-history.jsonl" {
+		t.Fatalf("Unexpected deployment history key: %s", historyKey)
+	}
+	for _, eachPrefix := range []string{"", "/", "teams//platform"} {
+		if WithS3KeyPrefix(eachPrefix)(&provisionOptions{}) == nil {
+			t.Errorf("Failed to reject invalid S3 key prefix: %q", eachPrefix)
+		}
+	}
+}
+
+func TestWithArtifactEncryption(t *testing.T) {
+	validOptions := [][]string{
+		{"AES256", ""},
+		{"aws:kms", ""},
+		{"aws:kms", "arn:aws:kms:us-west-2:123456789012:key/sparta-test"},
+	}
+	for _, eachOption := range validOptions {
+		if err := WithArtifactEncryption(eachOption[0], eachOption[1])(&provisionOptions{}); err != nil {
+			t.Errorf("Failed to accept valid artifact encryption: %s", err)
+		}
+	}
+	invalidOptions := [][]string{
+		{"", ""},
+		{"aws:kms:dsse", ""},
+		{"AES256", "arn:aws:kms:us-west-2:123456789012:key/sparta-test"},
+	}
+	for _, eachOption := range invalidOptions {
+		if err := WithArtifactEncryption(eachOption[0], eachOption[1])(&provisionOptions{}); err == nil {
+			t.Errorf("Failed to reject invalid artifact encryption: %v", eachOption)
+		}
+	}
+}
+
+func TestWithAWSEndpoint(t *testing.T) {
+	validEndpoints := []string{
+		"http://localhost:4566",
+		"https://cloudformation.us-west-2.amazonaws.com",
+	}
+	for _, eachEndpoint := range validEndpoints {
+		options := &provisionOptions{}
+		if err := WithAWSEndpoint(eachEndpoint)(options); err != nil {
+			t.Errorf("Failed to accept valid endpoint: %s", err)
+		} else if options.awsEndpoint != eachEndpoint {
+			t.Errorf("Unexpected endpoint. Expected: %s, Actual: %s",
+				eachEndpoint,
+				options.awsEndpoint)
+		}
+	}
+	invalidEndpoints := []string{
+		"",
+		"localhost:4566",
+		"ftp://localhost",
+		"http://",
+	}
+	for _, eachEndpoint := range invalidEndpoints {
+		if err := WithAWSEndpoint(eachEndpoint)(&provisionOptions{}); err == nil {
+			t.Errorf("Failed to reject invalid endpoint: %q", eachEndpoint)
+		}
+	}
+	if err := WithAWSRegion("")(&provisionOptions{}); err == nil {
+		t.Errorf("Failed to reject empty region")
+	}
+}
+
+func TestWithAssumeRole(t *testing.T) {
+	options := &provisionOptions{}
+	err := WithAssumeRole("arn:aws:iam::123456789012:role/deploy/SpartaDeployer",
+		"external-id",
+		"sparta-deploy")(options)
+	if err != nil {
+		t.Fatalf("Failed to accept valid AssumeRole: %s", err)
+	}
+	if options.assumeRoleARN != "arn:aws:iam::123456789012:role/deploy/SpartaDeployer" ||
+		options.assumeRoleExternalID != "external-id" ||
+		options.assumeRoleSessionName != "sparta-deploy" {
+		t.Errorf("Unexpected AssumeRole options: %#v", options)
+	}
+	invalidOptions := [][]string{
+		{"", ""},
+		{"SpartaDeployer", ""},
+		{"arn:aws:iam::123456789012:user/SpartaDeployer", ""},
+		{"arn:aws:iam::123456789012:role/SpartaDeployer", "x"},
+		{"arn:aws:iam::123456789012:role/SpartaDeployer", "invalid session"},
+	}
+	for _, eachOption := range invalidOptions {
+		if err := WithAssumeRole(eachOption[0], "", eachOption[1])(&provisionOptions{}); err == nil {
+			t.Errorf("Failed to reject invalid AssumeRole: %v", eachOption)
+		}
+	}
+}
+
+func TestWithAWSProfile(t *testing.T) {
+	options := &provisionOptions{}
+	if err := WithAWSProfile("staging")(options); err != nil {
+		t.Fatalf("Failed to accept valid profile: %s", err)
+	}
+	if options.awsProfile != "staging" {
+		t.Errorf("Unexpected profile: %s", options.awsProfile)
+	}
+	if err := WithAWSProfile(" ")(&provisionOptions{}); err == nil {
+		t.Errorf("Failed to reject empty profile")
+	}
+}
+
+func TestWithAWSSession(t *testing.T) {
+	if err := WithAWSSession(nil)(&provisionOptions{}); err == nil {
+		t.Errorf("Failed to reject nil session")
+	}
+	awsSession, awsSessionErr := session.NewSession(&aws.Config{
+		Region: aws.String("us-west-2"),
+	})
+	if awsSessionErr != nil {
+		t.Fatalf("Failed to create session: %s", awsSessionErr)
+	}
+	options := &provisionOptions{}
+	if err := WithAWSSession(awsSession)(options); err != nil {
+		t.Fatalf("Failed to accept session: %s", err)
+	}
+	if options.awsSession != awsSession {
+		t.Errorf("Unexpected session: %#v", options.awsSession)
+	}
+
+	logger, _ := NewLogger("warning")
+	provisionErr := Provision(true,
+		"SampleProvision",
+		"",
+		testLambdaData(),
+		nil,
+		nil,
+		"testBucket",
+		false,
+		false,
+		"testBuildID",
+		"",
+		"",
+		"",
+		ioutil.Discard,
+		nil,
+		logger,
+		WithAWSSession(awsSession),
+		WithAWSProfile("staging"))
+	if provisionErr == nil {
+		t.Errorf("Failed to reject WithAWSSession combined with WithAWSProfile")
+	}
+}
+
+func TestApplyLambdaRuntime(t *testing.T) {
+	if WithLambdaRuntime("nodejs18.x")(&provisionOptions{}) == nil {
+		t.Fatal("Failed to reject unsupported Lambda runtime")
+	}
+	opts, optsErr := newProvisionOptions(WithLambdaRuntime(LambdaRuntimeProvidedAL2))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	ctx := &workflowContext{}
+	ctx.userdata.options = opts
+	ctx.context.binaryName = SpartaBinaryName
+	ctx.context.s3CodeZipURL = newS3UploadURL("https://bucket.s3.amazonaws.com/MyService-code.zip")
+	ctx.context.cfTemplate = gocf.NewTemplate()
+	ctx.context.cfTemplate.AddResource("ServiceFunction", LambdaFunctionResource{
+		LambdaFunction: gocf.LambdaFunction{
+			Handler: gocf.String(SpartaBinaryName),
+			Runtime: gocf.String(GoLambdaVersion),
+		},
+		Code: &gocf.LambdaFunctionCode{
+			S3Bucket: gocf.String("bucket"),
+			S3Key:    gocf.String("MyService-code.zip"),
+		},
+	})
+	ctx.context.cfTemplate.AddResource("ExternalFunction", LambdaFunctionResource{
+		LambdaFunction: gocf.LambdaFunction{
+			Handler: gocf.String("index.handler"),
+			Runtime: gocf.String("nodejs18.x"),
+		},
+		Code: &gocf.LambdaFunctionCode{
+			S3Bucket: gocf.String("bucket"),
+			S3Key:    gocf.String("external.zip"),
+		},
+	})
+	applyLambdaRuntime(ctx)
+
+	serviceFunction := ctx.context.cfTemplate.Resources["ServiceFunction"].Properties.(LambdaFunctionResource)
+	if serviceFunction.Runtime.Literal != LambdaRuntimeProvidedAL2 ||
+		serviceFunction.Handler.Literal != "bootstrap" {
+		t.Errorf("Unexpected service function runtime: %s, handler: %s",
+			serviceFunction.Runtime.Literal,
+			serviceFunction.Handler.Literal)
+	}
+	externalFunction := ctx.context.cfTemplate.Resources["ExternalFunction"].Properties.(LambdaFunctionResource)
+	if externalFunction.Runtime.Literal != "nodejs18.x" ||
+		externalFunction.Handler.Literal != "index.handler" {
+		t.Errorf("Unexpected external function update. Runtime: %s, handler: %s",
+			externalFunction.Runtime.Literal,
+			externalFunction.Handler.Literal)
+	}
+}
+
+func TestMatchStackFunction(t *testing.T) {
+	spartaLogicalName := (&LambdaAWSInfo{
+		userSuppliedFunctionName: "HelloWorld",
+	}).LogicalResourceName()
+	resources := []*cloudformation.StackResourceSummary{
+		{
+			LogicalResourceId:  aws.String("IAMRole"),
+			PhysicalResourceId: aws.String("MyService-IAMRole"),
+			ResourceType:       aws.String("AWS::IAM::Role"),
+		},
+		{
+			LogicalResourceId:  aws.String(spartaLogicalName),
+			PhysicalResourceId: aws.String("MyService_HelloWorld"),
+			ResourceType:       aws.String("AWS::Lambda::Function"),
+		},
+	}
+	for _, eachName := range []string{"HelloWorld", spartaLogicalName, "MyService_HelloWorld"} {
+		functionName, functionNameErr := matchStackFunction(resources, eachName)
+		if functionNameErr != nil {
+			t.Errorf("Failed to match function %s: %s", eachName, functionNameErr)
+		} else if functionName != "MyService_HelloWorld" {
+			t.Errorf("Unexpected function match for %s: %s", eachName, functionName)
+		}
+	}
+	for _, eachName := range []string{"Missing", "IAMRole"} {
+		if _, err := matchStackFunction(resources, eachName); err == nil {
+			t.Errorf("Failed to reject unknown function: %s", eachName)
+		}
+	}
+}
+
+func TestTemplateOutputPath(t *testing.T) {
+	outputDir, outputDirErr := ioutil.TempDir("", "sparta-template")
+	if outputDirErr != nil {
+		t.Fatal(outputDirErr)
+	}
+	defer os.RemoveAll(outputDir)
+	templatePath := filepath.Join(outputDir, "templates", "SampleProvision.json")
+
+	logger, _ := NewLogger("info")
+	err := Provision(true,
+		"SampleProvision",
+		"",
+		testLambdaData(),
+		nil,
+		nil,
+		os.Getenv("S3_BUCKET"),
+		false,
+		false,
+		"testBuildID",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		logger,
+		WithTemplateOutputPath(templatePath))
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	templateBody, templateBodyErr := ioutil.ReadFile(templatePath)
+	if templateBodyErr != nil {
+		t.Fatal(templateBodyErr)
+	}
+	var template map[string]interface{}
+	unmarshalErr := json.Unmarshal(templateBody, &template)
+	if unmarshalErr != nil {
+		t.Fatalf("Failed to unmarshal template output: %s", unmarshalErr)
+	}
+	if _, resourcesExist := template["Resources"]; !resourcesExist {
+		t.Fatalf("Failed to find Resources in template output: %s", templatePath)
+	}
+	if !bytes.Contains(templateBody, []byte("\n")) {
+		t.Fatalf("Template output isn't pretty-printed")
+	}
+}
+
+func TestTemplateWriterOutput(t *testing.T) {
+	templateBody := testProvisionTemplateBody(t, testLambdaData())
+	var template struct {
+		AWSTemplateFormatVersion string
+		Resources                map[string]struct {
+			Type string
+		}
+	}
+	unmarshalErr := json.Unmarshal([]byte(templateBody), &template)
+	if unmarshalErr != nil {
+		t.Fatalf("Failed to unmarshal template writer output: %s", unmarshalErr)
+	}
+	if template.AWSTemplateFormatVersion == "" || len(template.Resources) == 0 {
+		t.Fatalf("Unexpected template writer output: %s", templateBody)
+	}
+}
+
+func TestFunctionTagsProvision(t *testing.T) {
+	lambdas := testLambdaData()
+	lambdas[0].Options.Tags = map[string]string{
+		"Owner": "payments",
+		"Team":  "function-team",
+	}
+	templateBody := testProvisionTemplateBody(t,
+		lambdas,
+		WithResourceTagPropagation(map[string]string{
+			"CostCenter": "1234",
+			"Team":       "service-team",
+		}))
+	functionProperties := testProvisionFunctionProperties(t, templateBody, lambdas[0])
+	tags := []string{}
+	for _, eachTag := range functionProperties.Tags {
+		tags = append(tags, fmt.Sprintf("%s=%s", eachTag.Key, eachTag.Value))
+	}
+	expected := "Owner=payments,Team=function-team,CostCenter=1234"
+	if strings.Join(tags, ",") != expected {
+		t.Fatalf("Unexpected function tags. Expected: %s, Actual: %s",
+			expected,
+			strings.Join(tags, ","))
+	}
+}
+
+func TestMergeResourceTags(t *testing.T) {
+	serviceTags := map[string]string{
+		"CostCenter": "1234",
+		"Team":       "service-team",
+	}
+	resourceTags := func(properties gocf.ResourceProperties) string {
+		var tagList *gocf.TagList
+		switch typedProperties := properties.(type) {
+		case *gocf.SQSQueue:
+			tagList = typedProperties.Tags
+		case LambdaFunctionResource:
+			tagList = typedProperties.Tags
+		}
+		if tagList == nil {
+			return ""
+		}
+		tags := []string{}
+		for _, eachTag := range *tagList {
+			tags = append(tags, fmt.Sprintf("%s=%s", eachTag.Key.Literal, eachTag.Value.Literal))
+		}
+		return strings.Join(tags, ",")
+	}
+	functionResource := LambdaFunctionResource{}
+	functionResource.Tags = &gocf.TagList{
+		gocf.Tag{Key: gocf.String("Owner"), Value: gocf.String("payments")},
+	}
+
+	testCases := []struct {
+		name           string
+		properties     gocf.ResourceProperties
+		expectedTagged bool
+		expectedTags   string
+	}{
+		{"Queue",
+			&gocf.SQSQueue{},
+			true,
+			"CostCenter=1234,Team=service-team"},
+		{"QueueExistingTags",
+			&gocf.SQSQueue{
+				Tags: &gocf.TagList{
+					gocf.Tag{Key: gocf.String("Team"), Value: gocf.String("queue-team")},
+				},
+			},
+			true,
+			"Team=queue-team,CostCenter=1234"},
+		{"FunctionValue",
+			functionResource,
+			true,
+			"Owner=payments,CostCenter=1234,Team=service-team"},
+		{"NoTagsField",
+			&gocf.LambdaPermission{},
+			false,
+			""},
+		{"Nil",
+			nil,
+			false,
+			""},
+	}
+	for _, eachTestCase := range testCases {
+		merged, tagged := mergeResourceTags(eachTestCase.properties, serviceTags)
+		if tagged != eachTestCase.expectedTagged {
+			t.Errorf("%s: Unexpected tagged result: %t", eachTestCase.name, tagged)
+		}
+		if mergedTags := resourceTags(merged); mergedTags != eachTestCase.expectedTags {
+			t.Errorf("%s: Unexpected tags. Expected: %s, Actual: %s",
+				eachTestCase.name,
+				eachTestCase.expectedTags,
+				mergedTags)
+		}
+	}
+	// Value types are merged into a copy
+	if originalTags := resourceTags(functionResource); originalTags != "Owner=payments" {
+		t.Errorf("Unexpected update to the original function resource tags: %s", originalTags)
+	}
+}
+
+func TestVerifyLambdaBinary(t *testing.T) {
+	logger, _ := NewLogger("warning")
+	scriptFile, scriptFileErr := ioutil.TempFile("", "verify-binary")
+	if scriptFileErr != nil {
+		t.Fatalf("Failed to create non-ELF file: %s", scriptFileErr)
+	}
+	defer os.Remove(scriptFile.Name())
+	_, writeErr := scriptFile.WriteString("#!/bin/sh\necho hello\n")
+	scriptFile.Close()
+	if writeErr != nil {
+		t.Fatalf("Failed to write non-ELF file: %s", writeErr)
+	}
+	verifyErr := verifyLambdaBinary(scriptFile.Name(), "amd64", logger)
+	if verifyErr == nil ||
+		!strings.Contains(verifyErr.Error(), "built for an unknown platform but Lambda needs linux/amd64") {
+		t.Errorf("Unexpected non-ELF binary error: %v", verifyErr)
+	}
+
+	// The test binary is built for the host, so verify it against the
+	// other Lambda architecture
+	mismatchedArch := "arm64"
+	if runtime.GOARCH == "arm64" {
+		mismatchedArch = "amd64"
+	}
+	verifyErr = verifyLambdaBinary(os.Args[0], mismatchedArch, logger)
+	expectedMessage := fmt.Sprintf("but Lambda needs linux/%s", mismatchedArch)
+	if runtime.GOOS == "linux" && (runtime.GOARCH == "amd64" || runtime.GOARCH == "arm64") {
+		expectedMessage = fmt.Sprintf("built for linux/%s %s", runtime.GOARCH, expectedMessage)
+	}
+	if verifyErr == nil || !strings.Contains(verifyErr.Error(), expectedMessage) {
+		t.Errorf("Unexpected mismatched architecture error. Expected: %s, Actual: %v",
+			expectedMessage,
+			verifyErr)
+	}
+}
+
+func TestSignArtifact(t *testing.T) {
+	artifactContent := []byte("artifact contents")
+	artifactFile, artifactFileErr := ioutil.TempFile("", "signed-artifact")
+	if artifactFileErr != nil {
+		t.Fatalf("Failed to create artifact: %s", artifactFileErr)
+	}
+	defer os.Remove(artifactFile.Name())
+	_, writeErr := artifactFile.Write(artifactContent)
+	artifactFile.Close()
+	if writeErr != nil {
+		t.Fatalf("Failed to write artifact: %s", writeErr)
+	}
+	signer, signerErr := rsa.GenerateKey(rand.Reader, 2048)
+	if signerErr != nil {
+		t.Fatalf("Failed to create signing key: %s", signerErr)
+	}
+	signature, signatureErr := signArtifact(artifactFile.Name(), signer)
+	if signatureErr != nil {
+		t.Fatalf("Failed to sign artifact: %s", signatureErr)
+	}
+	expectedDigest := sha256.Sum256(artifactContent)
+	if signature.SHA256 != hex.EncodeToString(expectedDigest[:]) {
+		t.Errorf("Unexpected artifact digest: %s", signature.SHA256)
+	}
+	signatureBytes, decodeErr := base64.StdEncoding.DecodeString(signature.Signature)
+	if decodeErr != nil {
+		t.Fatalf("Failed to decode signature: %s", decodeErr)
+	}
+	verifyErr := rsa.VerifyPKCS1v15(&signer.PublicKey,
+		crypto.SHA256,
+		expectedDigest[:],
+		signatureBytes)
+	if verifyErr != nil {
+		t.Errorf("Failed to verify artifact signature: %s", verifyErr)
+	}
+	metadata := signature.metadata()
+	if len(metadata) != 2 ||
+		metadata["sparta-sha256"] != signature.SHA256 ||
+		metadata["sparta-signature"] != signature.Signature {
+		t.Errorf("Unexpected signature metadata: %v", metadata)
+	}
+	if _, missingErr := signArtifact(artifactFile.Name()+".missing", signer); missingErr == nil {
+		t.Error("Failed to report missing artifact")
+	}
+}
+
+func TestBuildInputsDigest(t *testing.T) {
+	buildFlags := []string{"-tags", "lambdabinary noop "}
+	digest, digestErr := buildInputsDigest(context.Background(),
+		SpartaBinaryName,
+		false,
+		"amd64",
+		buildFlags,
+		nil)
+	if digestErr != nil {
+		t.Fatal(digestErr)
+	}
+	repeatedDigest, _ := buildInputsDigest(context.Background(),
+		SpartaBinaryName,
+		false,
+		"amd64",
+		buildFlags,
+		nil)
+	if digest != repeatedDigest {
+		t.Fatalf("Build inputs digest isn't stable: %s != %s", digest, repeatedDigest)
+	}
+	archDigest, _ := buildInputsDigest(context.Background(),
+		SpartaBinaryName,
+		false,
+		"arm64",
+		buildFlags,
+		nil)
+	flagsDigest, _ := buildInputsDigest(context.Background(),
+		SpartaBinaryName,
+		false,
+		"amd64",
+		[]string{"-tags", "lambdabinary "},
+		nil)
+	if archDigest == digest || flagsDigest == digest {
+		t.Fatal("Failed to change build inputs digest for changed build inputs")
+	}
+}
+
+func TestCompressBinaryWithoutUPX(t *testing.T) {
+	binaryFile, binaryFileErr := ioutil.TempFile("", "sparta-upx")
+	if binaryFileErr != nil {
+		t.Fatal(binaryFileErr)
+	}
+	defer os.Remove(binaryFile.Name())
+	binaryContent := []byte("not a compressible binary")
+	binaryFile.Write(binaryContent)
+	binaryFile.Close()
+
+	emptyPath, emptyPathErr := ioutil.TempDir("", "sparta-path")
+	if emptyPathErr != nil {
+		t.Fatal(emptyPathErr)
+	}
+	defer os.RemoveAll(emptyPath)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", emptyPath)
+
+	logger, _ := NewLogger("info")
+	compressErr := compressBinary(context.Background(), binaryFile.Name(), logger)
+	if compressErr != nil {
+		t.Fatalf("Failed to skip compression without upx: %s", compressErr)
+	}
+	compressedContent, _ := ioutil.ReadFile(binaryFile.Name())
+	if !bytes.Equal(compressedContent, binaryContent) {
+		t.Fatal("Binary modified without upx")
+	}
+}
+
+type fakeIAMAPI struct {
+	roleARNs     map[string]string
+	requestCount int32
+}
+
+func (fake *fakeIAMAPI) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	atomic.AddInt32(&fake.requestCount, 1)
+	roleARN, roleARNExists := fake.roleARNs[aws.StringValue(input.RoleName)]
+	if !roleARNExists {
+		return nil, errors.Errorf("The role with name %s cannot be found",
+			aws.StringValue(input.RoleName))
+	}
+	rolePath := "/"
+	if pathSeparator := strings.LastIndex(roleARN, "/"); pathSeparator > strings.Index(roleARN, "/") {
+		rolePath = roleARN[strings.Index(roleARN, "/") : pathSeparator+1]
+	}
+	return &iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn:  aws.String(roleARN),
+			Path: aws.String(rolePath),
+		},
+	}, nil
+}
+
+func TestVerifyIAMRoles(t *testing.T) {
+	roleARN := "arn:aws:iam::123412341234:role/ExistingRole"
+	testCases := []struct {
+		roleName    string
+		expectError bool
+	}{
+		{"ExistingRole", false},
+		{"MissingRole", true},
+		// Role ARNs are used without a GetRole request
+		{roleARN, false},
+	}
+	for _, eachTestCase := range testCases {
+		logger, _ := NewLogger("warning")
+		ctx := &workflowContext{logger: logger}
+		ctx.userdata.serviceName = "MyService"
+		ctx.userdata.options = &provisionOptions{}
+		ctx.userdata.lambdaAWSInfos = []*LambdaAWSInfo{
+			HandleAWSLambda(LambdaName(mockLambda1), mockLambda1, eachTestCase.roleName),
+		}
+		ctx.context.cfTemplate = gocf.NewTemplate()
+		fakeIAM := &fakeIAMAPI{
+			roleARNs: map[string]string{"ExistingRole": roleARN},
+		}
+		ctx.context.iamSvc = fakeIAM
+		_, verifyErr := verifyIAMRoles(ctx)
+		if eachTestCase.expectError {
+			if verifyErr == nil {
+				t.Errorf("Failed to reject role: %s", eachTestCase.roleName)
+			}
+			continue
+		}
+		if verifyErr != nil {
+			t.Fatalf("Failed to verify role %s: %s", eachTestCase.roleName, verifyErr)
+		}
+		roleExpr, roleExprExists := ctx.context.lambdaIAMRoleNameMap[eachTestCase.roleName]
+		if !roleExprExists || roleExpr.Literal != roleARN {
+			t.Errorf("Unexpected role ARN for %s: %#v", eachTestCase.roleName, roleExpr)
+		}
+		if eachTestCase.roleName == roleARN && fakeIAM.requestCount != 0 {
+			t.Errorf("Unexpected GetRole request for role ARN: %d", fakeIAM.requestCount)
+		}
+	}
+}
+
+// slowIAMAPI delays each GetRole response and records the maximum number
+// of concurrent requests
+type slowIAMAPI struct {
+	fakeIAMAPI
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (fake *slowIAMAPI) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	inFlight := atomic.AddInt32(&fake.inFlight, 1)
+	defer atomic.AddInt32(&fake.inFlight, -1)
+	for {
+		maxInFlight := atomic.LoadInt32(&fake.maxInFlight)
+		if inFlight <= maxInFlight ||
+			atomic.CompareAndSwapInt32(&fake.maxInFlight, maxInFlight, inFlight) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return fake.fakeIAMAPI.GetRole(input)
+}
+
+func TestVerifyStaticRoleNamesConcurrency(t *testing.T) {
+	fakeIAM := &slowIAMAPI{
+		fakeIAMAPI: fakeIAMAPI{roleARNs: make(map[string]string)},
+	}
+	var roleNames []string
+	for i := 0; i != 3*iamRoleVerificationConcurrency; i++ {
+		roleName := fmt.Sprintf("Role%d", i)
+		fakeIAM.roleARNs[roleName] = fmt.Sprintf("arn:aws:iam::123412341234:role/%s", roleName)
+		roleNames = append(roleNames, roleName)
+	}
+	logger, _ := NewLogger("warning")
+	ctx := &workflowContext{logger: logger}
+	ctx.context.iamSvc = fakeIAM
+	ctx.context.lambdaIAMRoleNameMap = make(map[string]*gocf.StringExpr)
+	verifyErr := verifyStaticRoleNames(ctx, roleNames)
+	if verifyErr != nil {
+		t.Fatalf("Failed to verify roles: %s", verifyErr)
+	}
+	if int(fakeIAM.requestCount) != len(roleNames) {
+		t.Errorf("Unexpected GetRole request count: %d", fakeIAM.requestCount)
+	}
+	if fakeIAM.maxInFlight < 2 || fakeIAM.maxInFlight > iamRoleVerificationConcurrency {
+		t.Errorf("Unexpected GetRole concurrency: %d", fakeIAM.maxInFlight)
+	}
+	for _, eachRoleName := range roleNames {
+		if ctx.context.lambdaIAMRoleNameMap[eachRoleName].Literal != fakeIAM.roleARNs[eachRoleName] {
+			t.Errorf("Unexpected role ARN for %s: %#v",
+				eachRoleName,
+				ctx.context.lambdaIAMRoleNameMap[eachRoleName])
+		}
+	}
+}
+
+func TestIAMPermissionsBoundaryProvision(t *testing.T) {
+	for _, eachARN := range []string{"", "arn:aws:iam::123412341234:role/Boundary", "DeveloperBoundary"} {
+		if WithIAMPermissionsBoundary(eachARN)(&provisionOptions{}) == nil {
+			t.Errorf("Failed to reject invalid permissions boundary: %q", eachARN)
+		}
+	}
+	boundaryARN := "arn:aws:iam::123412341234:policy/DeveloperBoundary"
+	templateBody := testProvisionTemplateBody(t,
+		testLambdaData(),
+		WithIAMPermissionsBoundary(boundaryARN))
+	template := struct {
+		Resources map[string]struct {
+			Type       string
+			Properties struct {
+				PermissionsBoundary string
+			}
+		}
+	}{}
+	if err := json.Unmarshal([]byte(templateBody), &template); err != nil {
+		t.Fatalf("Failed to unmarshal template: %s", err)
+	}
+	roleCount := 0
+	for eachName, eachResource := range template.Resources {
+		if eachResource.Type != "AWS::IAM::Role" {
+			continue
+		}
+		roleCount++
+		if eachResource.Properties.PermissionsBoundary != boundaryARN {
+			t.Errorf("Unexpected PermissionsBoundary for %s: %q",
+				eachName,
+				eachResource.Properties.PermissionsBoundary)
+		}
+	}
+	if roleCount == 0 {
+		t.Fatal("Failed to find IAM roles in template")
+	}
+}
+
+func TestVerifyStaticRoleNames(t *testing.T) {
+	fakeIAM := &fakeIAMAPI{
+		roleARNs: map[string]string{
+			"SharedRole":  "arn:aws:iam::123412341234:role/SharedRole",
+			"ServiceRole": "arn:aws:iam::123412341234:role/service-role/ServiceRole",
+		},
+	}
+	logger, _ := NewLogger("warning")
+	ctx := &workflowContext{logger: logger}
+	ctx.context.iamSvc = fakeIAM
+	ctx.context.lambdaIAMRoleNameMap = make(map[string]*gocf.StringExpr)
+	roleARN := "arn:aws:iam::123412341234:role/team/OtherAccountRole"
+	verifyErr := verifyStaticRoleNames(ctx, []string{"SharedRole",
+		"SharedRole",
+		"service-role/ServiceRole",
+		roleARN})
+	if verifyErr != nil {
+		t.Fatalf("Failed to verify roles: %s", verifyErr)
+	}
+	if fakeIAM.requestCount != 2 {
+		t.Errorf("Unexpected GetRole request count: %d", fakeIAM.requestCount)
+	}
+	if ctx.context.lambdaIAMRoleNameMap[roleARN].Literal != roleARN {
+		t.Errorf("Unexpected role ARN: %#v", ctx.context.lambdaIAMRoleNameMap[roleARN])
+	}
+	if ctx.context.lambdaIAMRoleNameMap["service-role/ServiceRole"].Literal != fakeIAM.roleARNs["ServiceRole"] {
+		t.Errorf("Unexpected role ARN: %#v", ctx.context.lambdaIAMRoleNameMap["service-role/ServiceRole"])
+	}
+
+	// All missing roles are reported
+	verifyErr = verifyStaticRoleNames(ctx, []string{"MissingRole",
+		"OtherMissingRole",
+		"other-path/ServiceRole"})
+	if verifyErr == nil {
+		t.Fatal("Failed to reject missing roles")
+	}
+	for _, eachRoleName := range []string{"MissingRole", "OtherMissingRole", "other-path"} {
+		if !strings.Contains(verifyErr.Error(), eachRoleName) {
+			t.Errorf("Error doesn't report %s: %s", eachRoleName, verifyErr)
+		}
+	}
+}
+
+func TestIAMRoleDefinitionPathProvision(t *testing.T) {
+	for _, eachPath := range []string{"service-roles/", "/service-roles", "/service roles/"} {
+		invalidRole := IAMRoleDefinition{Path: eachPath}
+		if invalidRole.validate() == nil {
+			t.Errorf("Failed to reject invalid role path: %q", eachPath)
+		}
+	}
+	roleBoundaryARN := "arn:aws:iam::123412341234:policy/FunctionBoundary"
+	lambdaFn := HandleAWSLambda(LambdaName(mockLambda1),
+		mockLambda1,
+		IAMRoleDefinition{
+			Path:                "/service-roles/",
+			PermissionsBoundary: roleBoundaryARN,
+		})
+	templateBody := testProvisionTemplateBody(t,
+		[]*LambdaAWSInfo{lambdaFn},
+		WithIAMPermissionsBoundary("arn:aws:iam::123412341234:policy/DeveloperBoundary"))
+	template := struct {
+		Resources map[string]struct {
+			Type       string
+			Properties struct {
+				Path                string
+				PermissionsBoundary string
+			}
+		}
+	}{}
+	if err := json.Unmarshal([]byte(templateBody), &template); err != nil {
+		t.Fatalf("Failed to unmarshal template: %s", err)
+	}
+	roleName := lambdaFn.RoleDefinition.logicalName("SampleProvision", lambdaFn.lambdaFunctionName())
+	roleResource, roleResourceExists := template.Resources[roleName]
+	if !roleResourceExists {
+		t.Fatalf("Failed to find IAM role: %s", roleName)
+	}
+	if roleResource.Properties.Path != "/service-roles/" {
+		t.Errorf("Unexpected role Path: %q", roleResource.Properties.Path)
+	}
+	if roleResource.Properties.PermissionsBoundary != roleBoundaryARN {
+		t.Errorf("Unexpected role PermissionsBoundary: %q", roleResource.Properties.PermissionsBoundary)
+	}
+}
+
+func TestIAMRoleContentDeduplicationProvision(t *testing.T) {
+	roleDefinition := func(actions ...string) IAMRoleDefinition {
+		return IAMRoleDefinition{
+			Privileges: []IAMRolePrivilege{
+				{
+					Actions:  actions,
+					Resource: wildcardArn,
+				},
+			},
+		}
+	}
+	newLambdas := func() []*LambdaAWSInfo {
+		return []*LambdaAWSInfo{
+			HandleAWSLambda(LambdaName(mockLambda1),
+				mockLambda1,
+				roleDefinition("s3:GetObject")),
+			HandleAWSLambda(LambdaName(mockLambda2),
+				mockLambda2,
+				roleDefinition("s3:GetObject")),
+			HandleAWSLambda(LambdaName(mockLambda3),
+				mockLambda3,
+				roleDefinition("s3:PutObject")),
+		}
+	}
+	functionRoles := func(templateBody string) (map[string]string, int) {
+		template := struct {
+			Resources map[string]struct {
+				Type       string
+				Properties struct {
+					Role interface{}
+				}
+			}
+		}{}
+		if err := json.Unmarshal([]byte(templateBody), &template); err != nil {
+			t.Fatalf("Failed to unmarshal template: %s", err)
+		}
+		roles := make(map[string]string)
+		roleCount := 0
+		for eachName, eachResource := range template.Resources {
+			switch eachResource.Type {
+			case "AWS::IAM::Role":
+				roleCount++
+			case "AWS::Lambda::Function":
+				roleJSON, _ := json.Marshal(eachResource.Properties.Role)
+				roles[eachName] = string(roleJSON)
+			}
+		}
+		return roles, roleCount
+	}
+
+	lambdas := newLambdas()
+	roles, roleCount := functionRoles(testProvisionTemplateBody(t,
+		lambdas,
+		WithIAMRoleContentDeduplication()))
+	if roleCount != 2 {
+		t.Errorf("Unexpected deduplicated IAM role count: %d", roleCount)
+	}
+	firstRole := roles[lambdas[0].LogicalResourceName()]
+	if firstRole == "" || roles[lambdas[1].LogicalResourceName()] != firstRole {
+		t.Errorf("Identical role definitions don't share a role: %v", roles)
+	}
+	if roles[lambdas[2].LogicalResourceName()] == firstRole {
+		t.Errorf("Different role definitions share a role: %v", roles)
+	}
+
+	// Without deduplication every definition has its own role
+	lambdas = newLambdas()
+	_, roleCount = functionRoles(testProvisionTemplateBody(t, lambdas))
+	if roleCount != len(lambdas) {
+		t.Errorf("Unexpected IAM role count without deduplication: %d", roleCount)
+	}
+}
+
+func TestWithProgressFunc(t *testing.T) {
+	if err := WithProgressFunc(nil)(&provisionOptions{}); err != nil {
+		t.Errorf("Failed to accept nil ProgressFunc: %s", err)
+	}
+	var reportedSteps []string
+	var reportedPcts []int
+	testProvisionTemplateBody(t,
+		testLambdaData(),
+		WithProgressFunc(func(step string, pct int, detail map[string]interface{}) {
+			if detail["StackName"] != "SampleProvision" {
+				t.Errorf("Unexpected StackName for %s: %v", step, detail["StackName"])
+			}
+			reportedSteps = append(reportedSteps, step)
+			reportedPcts = append(reportedPcts, pct)
+		}))
+	if len(reportedSteps) == 0 {
+		t.Fatal("Failed to report progress")
+	}
+	if reportedSteps[0] != ProgressStepVerifyIAMRoles {
+		t.Errorf("Unexpected first progress step: %s", reportedSteps[0])
+	}
+	lastIndex := len(reportedSteps) - 1
+	if reportedSteps[lastIndex] != ProgressStepComplete || reportedPcts[lastIndex] != 100 {
+		t.Errorf("Unexpected final progress step: %s (%d%%)",
+			reportedSteps[lastIndex],
+			reportedPcts[lastIndex])
+	}
+	for i := 1; i < len(reportedPcts); i++ {
+		if reportedPcts[i] < reportedPcts[i-1] {
+			t.Errorf("Progress decreased from %d to %d at %s",
+				reportedPcts[i-1],
+				reportedPcts[i],
+				reportedSteps[i])
+		}
+	}
+}
+
+func TestWithLogFormat(t *testing.T) {
+	if WithLogFormat("xml")(&provisionOptions{}) == nil {
+		t.Errorf("Failed to reject invalid log format")
+	}
+	if WithLogLevel("chatty")(&provisionOptions{}) == nil {
+		t.Errorf("Failed to reject invalid log level")
+	}
+	logger, _ := NewLogger("warning")
+	callerFormatter := logger.Formatter
+	var logOutput bytes.Buffer
+	logger.Out = &logOutput
+	provisionErr := Provision(true,
+		"SampleProvision",
+		"",
+		testLambdaData(),
+		nil,
+		nil,
+		os.Getenv("S3_BUCKET"),
+		false,
+		false,
+		"testBuildID",
+		"",
+		"",
+		"",
+		ioutil.Discard,
+		nil,
+		logger,
+		WithLogFormat(LogFormatJSON),
+		WithLogLevel("info"))
+	if provisionErr != nil {
+		t.Fatal(provisionErr.Error())
+	}
+	if logger.Formatter != callerFormatter || logger.Level != logrus.WarnLevel {
+		t.Errorf("Unexpected modification of caller's logger")
+	}
+	logLines := strings.Split(strings.TrimSpace(logOutput.String()), "\n")
+	if len(logLines) == 0 || logLines[0] == "" {
+		t.Fatal("Failed to log provision output")
+	}
+	for _, eachLine := range logLines {
+		logEntry := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(eachLine), &logEntry); err != nil {
+			t.Errorf("Failed to parse JSON log line %q: %s", eachLine, err)
+		}
+	}
+}
+
+func TestCreatePackageStepFailureCleanup(t *testing.T) {
+	serviceName := "ArchiveFailureProvision"
+	workflowHooks := &WorkflowHooks{
+		Archives: []ArchiveHookHandler{
+			ArchiveHookFunc(func(context map[string]interface{},
+				serviceName string,
+				zipWriter *zip.Writer,
+				awsSession *session.Session,
+				noop bool,
+				logger *logrus.Logger) error {
+				return errors.New("Forced archive failure")
+			}),
+		},
+	}
+	logger, _ := NewLogger("warning")
+	provisionErr := Provision(true,
+		serviceName,
+		"",
+		testLambdaData(),
+		nil,
+		nil,
+		os.Getenv("S3_BUCKET"),
+		false,
+		false,
+		"testBuildID",
+		"",
+		"",
+		"",
+		ioutil.Discard,
+		workflowHooks,
+		logger)
+	if provisionErr == nil {
+		t.Fatal("Failed to report archive hook error")
+	}
+	for _, eachPattern := range []string{"*.lambda.amd64",
+		filepath.Join(ScratchDirectory, "*.lambda.amd64"),
+		filepath.Join(ScratchDirectory, serviceName+"-code.zip")} {
+		matches, matchesErr := filepath.Glob(eachPattern)
+		if matchesErr != nil {
+			t.Fatalf("Failed to glob %s: %s", eachPattern, matchesErr)
+		}
+		if len(matches) != 0 {
+			t.Errorf("Failed to cleanup build artifacts: %v", matches)
+		}
+	}
+}
+
+// fakeS3API implements the s3API HeadBucket, HeadObject, GetObject,
+// PutObject, and DeleteObject requests. Any other request panics via the nil embedded interface.
+type fakeS3API struct {
+	s3API
+	headObjectOutput *s3.HeadObjectOutput
+	headObjectErr    error
+	headObjectKeys   []string
+	objects          map[string][]byte
+	getObjectErr     error
+	deletedObjects   []*s3.DeleteObjectInput
+	bucketOwner      string
+	expectedOwners   []string
+}
+
+func (fake *fakeS3API) HeadBucketWithContext(ctx aws.Context,
+	input *s3.HeadBucketInput,
+	opts ...request.Option) (*s3.HeadBucketOutput, error) {
+	// Apply the options to a real request to read the owner header
+	s3Svc := s3.New(session.Must(session.NewSession(&aws.Config{
+		Region: aws.String("us-west-2"),
+	})))
+	headBucketRequest, _ := s3Svc.HeadBucketRequest(input)
+	headBucketRequest.ApplyOptions(opts...)
+	expectedOwner := headBucketRequest.HTTPRequest.Header.Get("x-amz-expected-bucket-owner")
+	fake.expectedOwners = append(fake.expectedOwners, expectedOwner)
+	if expectedOwner != fake.bucketOwner {
+		return nil, awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "")
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (fake *fakeS3API) HeadObjectWithContext(ctx aws.Context,
+	input *s3.HeadObjectInput,
+	opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	fake.headObjectKeys = append(fake.headObjectKeys, aws.StringValue(input.Key))
+	return fake.headObjectOutput, fake.headObjectErr
+}
+
+func (fake *fakeS3API) GetObjectWithContext(ctx aws.Context,
+	input *s3.GetObjectInput,
+	opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if fake.getObjectErr != nil {
+		return nil, fake.getObjectErr
+	}
+	object, objectExists := fake.objects[aws.StringValue(input.Key)]
+	if !objectExists {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(object)),
+	}, nil
+}
+
+func (fake *fakeS3API) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	fake.deletedObjects = append(fake.deletedObjects, input)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (fake *fakeS3API) PutObjectWithContext(ctx aws.Context,
+	input *s3.PutObjectInput,
+	opts ...request.Option) (*s3.PutObjectOutput, error) {
+	object, objectErr := ioutil.ReadAll(input.Body)
+	if objectErr != nil {
+		return nil, objectErr
+	}
+	if fake.objects == nil {
+		fake.objects = make(map[string][]byte)
+	}
+	fake.objects[aws.StringValue(input.Key)] = object
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestCreateUploadStep(t *testing.T) {
+	packageFile, packageFileErr := ioutil.TempFile("", "upload-step")
+	if packageFileErr != nil {
+		t.Fatalf("Failed to create package file: %s", packageFileErr)
+	}
+	defer os.Remove(packageFile.Name())
+	_, writeErr := packageFile.WriteString("package contents")
+	packageFile.Close()
+	if writeErr != nil {
+		t.Fatalf("Failed to write package file: %s", writeErr)
+	}
+
+	testCases := []struct {
+		name string
+		s3   *fakeS3API
+		// Expected code URL if the step succeeds
+		expectedURL string
+	}{
+		{
+			"ExistingObject",
+			&fakeS3API{
+				headObjectOutput: &s3.HeadObjectOutput{VersionId: aws.String("v1")},
+			},
+			"?versionId=v1",
+		},
+		{
+			"AccessDenied",
+			&fakeS3API{
+				headObjectErr: awserr.NewRequestFailure(awserr.New("Forbidden", "Forbidden", nil),
+					403,
+					"requestID"),
+			},
+			"",
+		},
+	}
+	for _, eachTestCase := range testCases {
+		logger, _ := NewLogger("warning")
+		ctx := &workflowContext{logger: logger}
+		ctx.stepContext = context.Background()
+		ctx.userdata.serviceName = "UploadService"
+		ctx.userdata.s3Bucket = "upload-bucket"
+		ctx.userdata.options = &provisionOptions{}
+		ctx.context.s3Svc = eachTestCase.s3
+		ctx.context.s3BucketRegion = "us-west-2"
+
+		nextStep, stepErr := createUploadStep(packageFile.Name())(ctx)
+		if len(eachTestCase.s3.headObjectKeys) != 1 ||
+			!strings.HasPrefix(eachTestCase.s3.headObjectKeys[0], "UploadService/UploadService-code-") {
+			t.Errorf("%s: Unexpected HeadObject keys: %v",
+				eachTestCase.name,
+				eachTestCase.s3.headObjectKeys)
+		}
+		if eachTestCase.expectedURL == "" {
+			if stepErr == nil {
+				t.Errorf("%s: Failed to report HeadObject error", eachTestCase.name)
+			}
+			continue
+		}
+		if stepErr != nil || nextStep == nil {
+			t.Fatalf("%s: Failed to run upload step: %v", eachTestCase.name, stepErr)
+		}
+		if ctx.context.s3CodeZipURL == nil ||
+			!strings.HasSuffix(ctx.context.s3CodeZipURL.location, eachTestCase.expectedURL) ||
+			ctx.context.s3CodeZipURL.version != "v1" {
+			t.Errorf("%s: Unexpected code URL: %#v", eachTestCase.name, ctx.context.s3CodeZipURL)
+		}
+	}
+}
+
+type fakeSTSAPI struct{}
+
+func (fake *fakeSTSAPI) GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String("123412341234"),
+		Arn:     aws.String("arn:aws:iam::123412341234:user/deployer"),
+	}, nil
+}
+
+func TestAppendDeploymentHistory(t *testing.T) {
+	logger, _ := NewLogger("warning")
+	ctx := &workflowContext{logger: logger}
+	ctx.stepContext = context.Background()
+	ctx.userdata.serviceName = "HistoryService"
+	ctx.userdata.s3Bucket = "history-bucket"
+	ctx.userdata.options = &provisionOptions{}
+	ctx.context.stsSvc = &fakeSTSAPI{}
+	fakeS3 := &fakeS3API{}
+	ctx.context.s3Svc = fakeS3
+	stack := &cloudformation.Stack{
+		StackId: aws.String("arn:aws:cloudformation:us-west-2:123412341234:stack/HistoryService/1"),
+	}
+
+	// The first provision creates the history, and later ones append to it
+	buildIDs := []string{"firstBuild", "secondBuild"}
+	for _, eachBuildID := range buildIDs {
+		ctx.userdata.buildID = eachBuildID
+		historyErr := appendDeploymentHistory(ctx, stack, []byte("{}"))
+		if historyErr != nil {
+			t.Fatalf("Failed to append deployment history for %s: %s", eachBuildID, historyErr)
+		}
+	}
+	history, historyExists := fakeS3.objects[deploymentHistoryKey(ctx)]
+	if !historyExists {
+		t.Fatalf("Failed to write deployment history: %s", deploymentHistoryKey(ctx))
+	}
+	historyLines := strings.Split(strings.TrimSpace(string(history)), "\n")
+	if len(historyLines) != len(buildIDs) {
+		t.Fatalf("Unexpected deployment history: %s", history)
+	}
+	for eachIndex, eachLine := range historyLines {
+		var record deploymentHistoryRecord
+		unmarshalErr := json.Unmarshal([]byte(eachLine), &record)
+		if unmarshalErr != nil {
+			t.Fatalf("Failed to unmarshal deployment history record: %s", unmarshalErr)
+		}
+		if record.BuildID != buildIDs[eachIndex] ||
+			record.CallerAccount != "123412341234" ||
+			record.StackID != aws.StringValue(stack.StackId) {
+			t.Errorf("Unexpected deployment history record: %#v", record)
+		}
+	}
+
+	// Other errors aren't mistaken for a missing history
+	fakeS3.getObjectErr = awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil),
+		403,
+		"requestID")
+	if appendDeploymentHistory(ctx, stack, []byte("{}")) == nil {
+		t.Error("Failed to report deployment history GetObject error")
+	}
+}
+
+func TestWithDisableRollback(t *testing.T) {
+	options := &provisionOptions{}
+	if err := WithDisableRollback()(options); err != nil || !options.disableRollback {
+		t.Fatalf("Failed to disable rollback: %v", err)
+	}
+	logger, _ := NewLogger("warning")
+	provisionErr := Provision(true,
+		"SampleProvision",
+		"",
+		testLambdaData(),
+		nil,
+		nil,
+		"testBucket",
+		false,
+		false,
+		"testBuildID",
+		"",
+		"",
+		"",
+		ioutil.Discard,
+		nil,
+		logger,
+		WithDisableRollback(),
+		WithStackOnFailure(cloudformation.OnFailureDoNothing))
+	if provisionErr == nil {
+		t.Errorf("Failed to reject WithDisableRollback combined with WithStackOnFailure")
+	}
+}
+
+// failingRollbackHook is a RollbackHookHandler that always fails
+type failingRollbackHook struct{}
+
+func (hook *failingRollbackHook) Rollback(context map[string]interface{},
+	serviceName string,
+	awsSession *session.Session,
+	noop bool,
+	logger *logrus.Logger) error {
+	return errors.New("Rollback hook failure")
+}
+
+func TestWorkflowRollbackErrors(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	ctx := &workflowContext{logger: logger}
+	ctx.userdata.workflowHooks = &WorkflowHooks{
+		Rollbacks: []RollbackHookHandler{&failingRollbackHook{}},
+	}
+	if rollbackErr := ctx.rollback(); rollbackErr == nil ||
+		!strings.Contains(rollbackErr.Error(), "Rollback hook failure") {
+		t.Fatalf("Unexpected rollback hook error: %v", rollbackErr)
+	}
+
+	ctx.userdata.workflowHooks = nil
+	ctx.registerRollback(func(logger *logrus.Logger) error {
+		return nil
+	})
+	for _, eachKey := range []string{"first", "second"} {
+		cleanupErr := errors.Errorf("Failed to delete s3://bucket/%s", eachKey)
+		ctx.registerRollback(func(logger *logrus.Logger) error {
+			return cleanupErr
+		})
+	}
+	rollbackErr := ctx.rollback()
+	if rollbackErr == nil {
+		t.Fatal("Failed to return rollback errors")
+	}
+	for _, eachKey := range []string{"first", "second"} {
+		if !strings.Contains(rollbackErr.Error(), "s3://bucket/"+eachKey) {
+			t.Errorf("Failed to include %s rollback error: %s", eachKey, rollbackErr)
+		}
+	}
+	workflowErr := errors.New("Workflow failure")
+	if withRollbackError(workflowErr, nil) != workflowErr {
+		t.Errorf("Unexpected error without rollback failure")
+	}
+	combinedErr := withRollbackError(workflowErr, rollbackErr)
+	if errors.Cause(combinedErr) != workflowErr {
+		t.Errorf("Unexpected cause: %v", errors.Cause(combinedErr))
+	}
+	if !strings.Contains(combinedErr.Error(), "s3://bucket/first") {
+		t.Errorf("Failed to include rollback error: %s", combinedErr)
+	}
+}
+
+func TestWithS3UploadTuning(t *testing.T) {
+	invalidTunings := [][2]int64{
+		{s3manager.MinUploadPartSize - 1, 0},
+		{0, -1},
+	}
+	for _, eachTuning := range invalidTunings {
+		if WithS3UploadTuning(eachTuning[0], int(eachTuning[1]))(&provisionOptions{}) == nil {
+			t.Errorf("Failed to reject invalid upload tuning: %v", eachTuning)
+		}
+	}
+	options := &provisionOptions{}
+	if len(s3UploaderOptions(options)) != 0 {
+		t.Errorf("Unexpected default uploader options")
+	}
+	partSize := 64 * int64(1024*1024)
+	if err := WithS3UploadTuning(partSize, 10)(options); err != nil {
+		t.Fatalf("Failed to accept upload tuning: %s", err)
+	}
+	uploader := &s3manager.Uploader{
+		PartSize:    s3manager.DefaultUploadPartSize,
+		Concurrency: s3manager.DefaultUploadConcurrency,
+	}
+	for _, eachOption := range s3UploaderOptions(options) {
+		eachOption(uploader)
+	}
+	if uploader.PartSize != partSize || uploader.Concurrency != 10 {
+		t.Errorf("Unexpected uploader tuning. PartSize: %d, Concurrency: %d",
+			uploader.PartSize,
+			uploader.Concurrency)
+	}
+}
+
+func TestWithProvisionPlan(t *testing.T) {
+	options := &provisionOptions{}
+	if err := WithProvisionPlan()(options); err != nil {
+		t.Fatalf("Failed to apply provision plan option: %s", err)
+	}
+	if !options.dryRunDiff || !options.changeSetReview {
+		t.Errorf("Provision plan option failed to enable both the template diff and change set review")
+	}
+}
+
+func TestAddBuildOutputs(t *testing.T) {
+	ctx := &workflowContext{logger: logrus.New()}
+	ctx.userdata.buildID = "testBuildID"
+	ctx.context.cfTemplate = gocf.NewTemplate()
+	ctx.transaction.startTime = time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	addBuildOutputs(ctx)
+
+	outputValue := func(outputName string) (string, bool) {
+		output, outputExists := ctx.context.cfTemplate.Outputs[outputName]
+		if !outputExists {
+			return "", false
+		}
+		return output.Value.(*gocf.StringExpr).Literal, true
+	}
+	if buildID, _ := outputValue(OutputSpartaBuildID); buildID != "testBuildID" {
+		t.Errorf("Unexpected BuildID Output: %s", buildID)
+	}
+	if buildTime, _ := outputValue(OutputSpartaBuildTime); buildTime != "2018-06-01T12:00:00Z" {
+		t.Errorf("Unexpected BuildTime Output: %s", buildTime)
+	}
+	// The git commit is best-effort, but must not be empty if it's included
+	if commitSHA, commitExists := outputValue(OutputSpartaGitCommit); commitExists && commitSHA == "" {
+		t.Errorf("Unexpected empty git commit Output")
+	}
+}
+
+// fakeCFAPI reports the deployed template of an existing stack, accepts
+// any template, and deletes the stack with the deleteStatus result. Any
+// other request panics via the nil embedded interface.
+type fakeCFAPI struct {
+	cfAPI
+	templateBody     string
+	deleteStatus     string
+	deleteStackCount int
+}
+
+func (fake *fakeCFAPI) DescribeStacks(input *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	if fake.templateBody == "" {
+		return nil, errors.Errorf("Stack with id %s does not exist", aws.StringValue(input.StackName))
+	}
+	return &cloudformation.DescribeStacksOutput{
+		Stacks: []*cloudformation.Stack{
+			{
+				StackName: input.StackName,
+				StackId:   aws.String(fmt.Sprintf("%s-id", aws.StringValue(input.StackName))),
+			},
+		},
+	}, nil
+}
+
+func (fake *fakeCFAPI) DescribeStacksWithContext(ctx aws.Context,
+	input *cloudformation.DescribeStacksInput,
+	opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+	return &cloudformation.DescribeStacksOutput{
+		Stacks: []*cloudformation.Stack{
+			{
+				StackId:     input.StackName,
+				StackStatus: aws.String(fake.deleteStatus),
+			},
+		},
+	}, nil
+}
+
+func (fake *fakeCFAPI) DescribeStackEventsWithContext(ctx aws.Context,
+	input *cloudformation.DescribeStackEventsInput,
+	opts ...request.Option) (*cloudformation.DescribeStackEventsOutput, error) {
+	return &cloudformation.DescribeStackEventsOutput{}, nil
+}
+
+func (fake *fakeCFAPI) DescribeStackEvents(input *cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error) {
+	return &cloudformation.DescribeStackEventsOutput{
+		StackEvents: []*cloudformation.StackEvent{
+			{
+				EventId:              aws.String("delete-failed"),
+				LogicalResourceId:    aws.String("ArtifactBucket"),
+				ResourceStatus:       aws.String(cloudformation.ResourceStatusDeleteFailed),
+				ResourceStatusReason: aws.String("The bucket you tried to delete is not empty"),
+				Timestamp:            aws.Time(time.Now()),
+			},
+		},
+	}, nil
+}
+
+func (fake *fakeCFAPI) DeleteStack(input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+	fake.deleteStackCount++
+	return &cloudformation.DeleteStackOutput{}, nil
+}
+
+func (fake *fakeCFAPI) GetTemplate(input *cloudformation.GetTemplateInput) (*cloudformation.GetTemplateOutput, error) {
+	return &cloudformation.GetTemplateOutput{
+		TemplateBody: aws.String(fake.templateBody),
+	}, nil
+}
+
+func (fake *fakeCFAPI) ValidateTemplate(input *cloudformation.ValidateTemplateInput) (*cloudformation.ValidateTemplateOutput, error) {
+	return &cloudformation.ValidateTemplateOutput{}, nil
+}
+
+func TestPreserveDeployedFunctionCode(t *testing.T) {
+	lambdas := testLambdaData()
+	lambdas[2].Options.ImageURI = "123412341234.dkr.ecr.us-west-2.amazonaws.com/service:latest"
+	deployedCode := map[string]interface{}{
+		"S3Bucket": "deployed-bucket",
+		"S3Key":    "deployed-code.zip",
+	}
+	deployedTemplate := func(deployedLambdas ...*LambdaAWSInfo) string {
+		resources := map[string]interface{}{}
+		for _, eachLambda := range deployedLambdas {
+			resources[eachLambda.LogicalResourceName()] = map[string]interface{}{
+				"Type": "AWS::Lambda::Function",
+				"Properties": map[string]interface{}{
+					"Code": deployedCode,
+				},
+			}
+		}
+		templateBody, templateBodyErr := json.Marshal(map[string]interface{}{
+			"Resources": resources,
+		})
+		if templateBodyErr != nil {
+			t.Fatal(templateBodyErr)
+		}
+		return string(templateBody)
+	}
+	newCodeContext := func(templateBody string) *workflowContext {
+		logger, _ := NewLogger("warning")
+		ctx := &workflowContext{logger: logger}
+		ctx.userdata.serviceName = "SelectiveService"
+		ctx.userdata.options = &provisionOptions{
+			selectedFunctions: []string{lambdas[0].lambdaFunctionName()},
+		}
+		ctx.userdata.lambdaAWSInfos = lambdas
+		ctx.context.cfSvc = &fakeCFAPI{templateBody: templateBody}
+		ctx.context.cfTemplate = gocf.NewTemplate()
+		for _, eachLambda := range lambdas {
+			functionResource := LambdaFunctionResource{
+				Code: &gocf.LambdaFunctionCode{
+					S3Bucket: gocf.String("new-bucket"),
+					S3Key:    gocf.String("new-code.zip"),
+				},
+			}
+			ctx.context.cfTemplate.AddResource(eachLambda.LogicalResourceName(), functionResource)
+		}
+		return ctx
+	}
+	functionCode := func(ctx *workflowContext, lambdaAWSInfo *LambdaAWSInfo) interface{} {
+		resource := ctx.context.cfTemplate.Resources[lambdaAWSInfo.LogicalResourceName()]
+		return resource.Properties.(LambdaFunctionResource).Code
+	}
+
+	// Functions that aren't selected keep the deployed code. The deployed
+	// template doesn't need to include the selected or image functions.
+	ctx := newCodeContext(deployedTemplate(lambdas[1]))
+	preserveErr := preserveDeployedFunctionCode(ctx)
+	if preserveErr != nil {
+		t.Fatalf("Failed to preserve deployed code: %s", preserveErr)
+	}
+	if _, isNewCode := functionCode(ctx, lambdas[0]).(*gocf.LambdaFunctionCode); !isNewCode {
+		t.Errorf("Unexpected code for selected function: %#v", functionCode(ctx, lambdas[0]))
+	}
+	preservedCode, _ := functionCode(ctx, lambdas[1]).(map[string]interface{})
+	if preservedCode["S3Bucket"] != "deployed-bucket" || preservedCode["S3Key"] != "deployed-code.zip" {
+		t.Errorf("Failed to preserve deployed code: %#v", functionCode(ctx, lambdas[1]))
+	}
+	if _, isNewCode := functionCode(ctx, lambdas[2]).(*gocf.LambdaFunctionCode); !isNewCode {
+		t.Errorf("Unexpected code for image function: %#v", functionCode(ctx, lambdas[2]))
+	}
+
+	// Unselected functions must already be deployed
+	ctx = newCodeContext(deployedTemplate(lambdas[0]))
+	preserveErr = preserveDeployedFunctionCode(ctx)
+	if preserveErr == nil || !strings.Contains(preserveErr.Error(), "isn't deployed in stack SelectiveService") {
+		t.Errorf("Failed to reject undeployed function: %v", preserveErr)
+	}
+
+	// As must the stack
+	ctx = newCodeContext("")
+	preserveErr = preserveDeployedFunctionCode(ctx)
+	if preserveErr == nil || !strings.Contains(preserveErr.Error(), "requires an existing stack") {
+		t.Errorf("Failed to reject missing stack: %v", preserveErr)
+	}
+}
+
+func TestOutputExportsProvision(t *testing.T) {
+	templateBody := testProvisionTemplateBody(t,
+		testLambdaData(),
+		WithOutputExports(OutputSpartaBuildID))
+	var template struct {
+		Outputs map[string]struct {
+			Export *struct {
+				Name interface{}
+			}
+		}
+	}
+	unmarshalErr := json.Unmarshal([]byte(templateBody), &template)
+	if unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	for eachKey, eachOutput := range template.Outputs {
+		if eachKey != OutputSpartaBuildID {
+			if eachOutput.Export != nil {
+				t.Errorf("Unexpected export of Output %s: %v", eachKey, eachOutput.Export.Name)
+			}
+			continue
+		}
+		if eachOutput.Export == nil || eachOutput.Export.Name != "SampleProvision-SpartaBuildID" {
+			t.Errorf("Unexpected %s export: %#v", eachKey, eachOutput.Export)
+		}
+	}
+	if _, buildIDExists := template.Outputs[OutputSpartaBuildID]; !buildIDExists {
+		t.Fatalf("Missing Output: %s", OutputSpartaBuildID)
+	}
+
+	// Unknown Outputs are rejected
+	opts, optsErr := newProvisionOptions(WithOutputExports("MissingOutput"))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	ctx := &workflowContext{logger: logrus.New()}
+	ctx.userdata.serviceName = "SampleProvision"
+	ctx.userdata.options = opts
+	ctx.context.cfTemplate = gocf.NewTemplate()
+	ctx.context.cfTemplate.Outputs = map[string]*gocf.Output{}
+	exportErr := addOutputExports(ctx)
+	if exportErr == nil || !strings.Contains(exportErr.Error(), "Unable to export Output MissingOutput") {
+		t.Errorf("Failed to reject missing Output: %v", exportErr)
+	}
+	if len(ctx.context.exportNames) != 0 {
+		t.Errorf("Unexpected export names: %v", ctx.context.exportNames)
+	}
+}
+
+func TestWithPreserveArtifacts(t *testing.T) {
+	opts, optsErr := newProvisionOptions(WithPreserveArtifacts())
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	ctx := &workflowContext{logger: logrus.New()}
+	ctx.userdata.options = opts
+
+	finalizerFile, finalizerFileErr := ioutil.TempFile("", "sparta-preserve")
+	if finalizerFileErr != nil {
+		t.Fatal(finalizerFileErr)
+	}
+	defer os.Remove(finalizerFile.Name())
+	finalizerFile.Close()
+	rollbackFile, rollbackFileErr := ioutil.TempFile("", "sparta-preserve")
+	if rollbackFileErr != nil {
+		t.Fatal(rollbackFileErr)
+	}
+	defer os.Remove(rollbackFile.Name())
+
+	ctx.registerFileCleanupFinalizer(finalizerFile.Name())
+	ctx.registerFileCleanupRollback(rollbackFile)
+	for _, eachFinalizer := range ctx.transaction.finalizerFunctions {
+		eachFinalizer(ctx.logger)
+	}
+	if rollbackErr := ctx.rollback(); rollbackErr != nil {
+		t.Fatalf("Unexpected rollback error: %s", rollbackErr)
+	}
+	for _, eachPath := range []string{finalizerFile.Name(), rollbackFile.Name()} {
+		if _, statErr := os.Stat(eachPath); statErr != nil {
+			t.Errorf("Failed to preserve build artifact %s: %s", eachPath, statErr)
+		}
+	}
+}
+
+func TestWithLambdaAlias(t *testing.T) {
+	for _, eachInvalidName := range []string{"", "123", "live!"} {
+		if WithLambdaAlias(eachInvalidName)(&provisionOptions{}) == nil {
+			t.Errorf("Failed to reject invalid alias name: %q", eachInvalidName)
+		}
+	}
+	opts, optsErr := newProvisionOptions(WithLambdaAlias("live"))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	// aliasContext returns the context of a provision whose functions
+	// reference the codeKey service binary
+	aliasContext := func(codeKey string) *workflowContext {
+		ctx := &workflowContext{logger: logrus.New()}
+		ctx.userdata.options = opts
+		ctx.userdata.buildID = "testBuildID"
+		ctx.userdata.lambdaAWSInfos = testLambdaData()
+		ctx.context.cfTemplate = gocf.NewTemplate()
+		for _, eachLambdaInfo := range ctx.userdata.lambdaAWSInfos {
+			ctx.context.cfTemplate.AddResource(eachLambdaInfo.LogicalResourceName(),
+				LambdaFunctionResource{
+					Code: &gocf.LambdaFunctionCode{
+						S3Bucket: gocf.String("code-bucket"),
+						S3Key:    gocf.String(codeKey),
+					},
+				})
+		}
+		if aliasErr := addLambdaAliases(ctx); aliasErr != nil {
+			t.Fatalf("Failed to add Lambda aliases: %s", aliasErr)
+		}
+		return ctx
+	}
+	versionResourceNames := func(ctx *workflowContext) map[string]bool {
+		names := make(map[string]bool)
+		for eachName, eachResource := range ctx.context.cfTemplate.Resources {
+			if eachResource.Properties.CfnResourceType() == "AWS::Lambda::Version" {
+				names[eachName] = true
+			}
+		}
+		return names
+	}
+	ctx := aliasContext("MyService/MyService-code-1111.zip")
+	typeCounts := make(map[string]int)
+	for _, eachResource := range ctx.context.cfTemplate.Resources {
+		typeCounts[eachResource.Properties.CfnResourceType()]++
+	}
+	functionCount := len(ctx.userdata.lambdaAWSInfos)
+	if typeCounts["AWS::Lambda::Version"] != functionCount ||
+		typeCounts["AWS::Lambda::Alias"] != functionCount {
+		t.Errorf("Unexpected version and alias resources: %v", typeCounts)
+	}
+	for _, eachLambdaInfo := range ctx.userdata.lambdaAWSInfos {
+		outputName := lambdaAliasOutputName(eachLambdaInfo.LogicalResourceName())
+		if _, outputExists := ctx.context.cfTemplate.Outputs[outputName]; !outputExists {
+			t.Errorf("Missing alias ARN Output: %s", outputName)
+		}
+	}
+
+	// Changed code with the same BuildID publishes new versions...
+	changedVersions := versionResourceNames(aliasContext("MyService/MyService-code-2222.zip"))
+	for eachName := range versionResourceNames(ctx) {
+		if changedVersions[eachName] {
+			t.Errorf("Version resource %s reused for changed code", eachName)
+		}
+	}
+	// ...and unchanged code doesn't
+	unchangedVersions := versionResourceNames(aliasContext("MyService/MyService-code-1111.zip"))
+	for eachName := range versionResourceNames(ctx) {
+		if !unchangedVersions[eachName] {
+			t.Errorf("Version resource %s not reused for unchanged code", eachName)
+		}
+	}
+
+	// In-place updates don't publish versions, so the alias can't follow
+	// the updated code
+	logger, _ := NewLogger("warning")
+	provisionErr := Provision(true,
+		"TestWithLambdaAlias",
+		"",
+		testLambdaData(),
+		nil,
+		nil,
+		os.Getenv("S3_BUCKET"),
+		false,
+		true,
+		"testBuildID",
+		"",
+		"",
+		"",
+		ioutil.Discard,
+		nil,
+		logger,
+		WithLambdaAlias("live"))
+	if provisionErr == nil || !strings.Contains(provisionErr.Error(), "WithLambdaAlias") {
+		t.Errorf("Failed to reject in-place updates with an alias: %v", provisionErr)
+	}
+}
+
+func TestReservedConcurrencyProvision(t *testing.T) {
+	lambdas := testLambdaData()
+	lambdas[0].Options.ReservedConcurrentExecutions = aws.Int64(10)
+	lambdas[1].Options.ReservedConcurrentExecutions = aws.Int64(0)
+
+	templateBody := testProvisionTemplateBody(t, lambdas)
+	reservedProperties := testProvisionFunctionProperties(t, templateBody, lambdas[0])
+	if fmt.Sprintf("%v", reservedProperties.ReservedConcurrentExecutions) != "10" {
+		t.Fatalf("Unexpected ReservedConcurrentExecutions: %v",
+			reservedProperties.ReservedConcurrentExecutions)
+	}
+	throttledProperties := testProvisionFunctionProperties(t, templateBody, lambdas[1])
+	if fmt.Sprintf("%v", throttledProperties.ReservedConcurrentExecutions) != "0" {
+		t.Fatalf("Unexpected ReservedConcurrentExecutions for throttled function: %v",
+			throttledProperties.ReservedConcurrentExecutions)
+	}
+	unreservedProperties := testProvisionFunctionProperties(t, templateBody, lambdas[2])
+	if unreservedProperties.ReservedConcurrentExecutions != nil {
+		t.Fatalf("Unexpected ReservedConcurrentExecutions for unreserved function: %v",
+			unreservedProperties.ReservedConcurrentExecutions)
+	}
+	if reservedTotal := checkReservedConcurrency(lambdas, 0, logrus.New()); reservedTotal != 10 {
+		t.Fatalf("Unexpected reserved concurrency total: %d", reservedTotal)
+	}
+}
+
+func TestWithAdditionalTemplate(t *testing.T) {
+	if WithAdditionalTemplate(nil)(&provisionOptions{}) == nil {
+		t.Fatal("Failed to reject nil additional template")
+	}
+	queueTemplate := gocf.NewTemplate()
+	queueTemplate.AddResource("WorkQueue", &gocf.SQSQueue{})
+	opts, optsErr := newProvisionOptions(WithAdditionalTemplate(queueTemplate))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	ctx := &workflowContext{logger: logrus.New()}
+	ctx.userdata.options = opts
+	ctx.context.cfTemplate = gocf.NewTemplate()
+	if mergeErr := mergeAdditionalTemplates(ctx); mergeErr != nil {
+		t.Fatalf("Failed to merge additional template: %s", mergeErr)
+	}
+	if _, queueExists := ctx.context.cfTemplate.Resources["WorkQueue"]; !queueExists {
+		t.Fatal("Failed to merge additional template resource")
+	}
+	// Merging the same logical name again is a collision
+	if mergeErr := mergeAdditionalTemplates(ctx); mergeErr == nil {
+		t.Fatal("Failed to report additional template logical name collision")
+	}
+}
+
+func TestSafeMergeTemplatesCollisions(t *testing.T) {
+	newDestTemplate := func() *gocf.Template {
+		destTemplate := gocf.NewTemplate()
+		destTemplate.AddResource("MyLambda", &gocf.LambdaFunction{})
+		destTemplate.Outputs = map[string]*gocf.Output{
+			"MyOutput": {Value: gocf.String("value")},
+		}
+		destTemplate.Parameters = map[string]*gocf.Parameter{
+			"MyParameter": {Type: "String"},
+		}
+		return destTemplate
+	}
+	collidingTemplates := map[string]*gocf.Template{
+		"Resource MyLambda":     gocf.NewTemplate(),
+		"Output MyOutput":       gocf.NewTemplate(),
+		"Parameter MyParameter": gocf.NewTemplate(),
+	}
+	collidingTemplates["Resource MyLambda"].AddResource("MyLambda", &gocf.SQSQueue{})
+	collidingTemplates["Output MyOutput"].Outputs = map[string]*gocf.Output{
+		"MyOutput": {Value: gocf.String("other")},
+	}
+	collidingTemplates["Parameter MyParameter"].Parameters = map[string]*gocf.Parameter{
+		"MyParameter": {Type: "Number"},
+	}
+
+	for eachCollision, eachTemplate := range collidingTemplates {
+		destTemplate := newDestTemplate()
+		mergeErr := safeMergeTemplates(eachTemplate, destTemplate)
+		if mergeErr == nil || !strings.Contains(mergeErr.Error(), eachCollision) {
+			t.Errorf("Failed to report %s collision: %v", eachCollision, mergeErr)
+		}
+		if _, isLambda := destTemplate.Resources["MyLambda"].Properties.(*gocf.LambdaFunction); !isLambda {
+			t.Errorf("Colliding merge replaced the existing MyLambda resource")
+		}
+	}
+	queueTemplate := gocf.NewTemplate()
+	queueTemplate.AddResource("MyQueue", &gocf.SQSQueue{})
+	destTemplate := newDestTemplate()
+	if mergeErr := safeMergeTemplates(queueTemplate, destTemplate); mergeErr != nil {
+		t.Fatalf("Failed to merge non-colliding template: %s", mergeErr)
+	}
+	if _, queueExists := destTemplate.Resources["MyQueue"]; !queueExists {
+		t.Fatal("Failed to merge MyQueue resource")
+	}
+}
+
+func TestRecursiveLoopProvision(t *testing.T) {
+	lambdas := testLambdaData()
+	lambdas[1].Options.RecursiveLoop = RecursiveLoopAllow
+
+	templateBody := testProvisionTemplateBody(t, lambdas)
+	defaultProperties := testProvisionFunctionProperties(t, templateBody, lambdas[0])
+	if defaultProperties.RecursiveLoop != RecursiveLoopTerminate {
+		t.Fatalf("Unexpected default RecursiveLoop: %s", defaultProperties.RecursiveLoop)
+	}
+	allowProperties := testProvisionFunctionProperties(t, templateBody, lambdas[1])
+	if allowProperties.RecursiveLoop != RecursiveLoopAllow {
+		t.Fatalf("Unexpected RecursiveLoop: %s", allowProperties.RecursiveLoop)
+	}
+
+	// Other values are rejected before the service is built
+	logger, _ := NewLogger("info")
+	lambdas = testLambdaData()
+	lambdas[0].Options.RecursiveLoop = "Continue"
+	preconditionsErr := validateSpartaPreconditions(lambdas, logger)
+	if preconditionsErr == nil || !strings.Contains(preconditionsErr.Error(), "Invalid RecursiveLoop") {
+		t.Fatalf("Failed to reject invalid RecursiveLoop: %v", preconditionsErr)
+	}
+}
+
+func TestFunctionURLProvision(t *testing.T) {
+	lambdas := testLambdaData()
+	lambdas[0].Options.FunctionURL = &LambdaFunctionURL{
+		AuthType: FunctionURLAuthTypeNone,
+	}
+	lambdas[1].Options.FunctionURL = &LambdaFunctionURL{}
+
+	templateBody := testProvisionTemplateBody(t, lambdas)
+	var template struct {
+		Resources map[string]struct {
+			Type       string
+			Properties map[string]interface{}
+		}
+		Outputs map[string]interface{}
+	}
+	unmarshalErr := json.Unmarshal([]byte(templateBody), &template)
+	if unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	for _, eachLambda := range lambdas[0:2] {
+		urlResource, urlResourceExists := template.Resources[eachLambda.LogicalResourceName()+"URL"]
+		if !urlResourceExists || urlResource.Type != "AWS::Lambda::Url" {
+			t.Fatalf("Missing AWS::Lambda::Url resource for %s", eachLambda.lambdaFunctionName())
+		}
+		if urlResource.Properties["InvokeMode"] != FunctionURLInvokeModeBuffered {
+			t.Errorf("Unexpected InvokeMode: %v", urlResource.Properties["InvokeMode"])
+		}
+		outputName := eachLambda.LogicalResourceName() + "FunctionURL"
+		if _, outputExists := template.Outputs[outputName]; !outputExists {
+			t.Errorf("Missing %s Output", outputName)
+		}
+	}
+	publicURL := template.Resources[lambdas[0].LogicalResourceName()+"URL"]
+	if publicURL.Properties["AuthType"] != FunctionURLAuthTypeNone {
+		t.Errorf("Unexpected public AuthType: %v", publicURL.Properties["AuthType"])
+	}
+	publicPermission, publicPermissionExists := template.Resources[lambdas[0].LogicalResourceName()+"URLPermission"]
+	if !publicPermissionExists ||
+		publicPermission.Type != "AWS::Lambda::Permission" ||
+		publicPermission.Properties["Action"] != "lambda:InvokeFunctionUrl" ||
+		publicPermission.Properties["Principal"] != "*" ||
+		publicPermission.Properties["FunctionUrlAuthType"] != FunctionURLAuthTypeNone {
+		t.Errorf("Unexpected public function URL permission: %#v", publicPermission)
+	}
+	iamURL := template.Resources[lambdas[1].LogicalResourceName()+"URL"]
+	if iamURL.Properties["AuthType"] != FunctionURLAuthTypeIAM {
+		t.Errorf("Unexpected default AuthType: %v", iamURL.Properties["AuthType"])
+	}
+	if _, iamPermissionExists := template.Resources[lambdas[1].LogicalResourceName()+"URLPermission"]; iamPermissionExists {
+		t.Error("Unexpected public permission for AWS_IAM function URL")
+	}
+
+	// Invalid values are rejected before the service is built
+	logger, _ := NewLogger("info")
+	lambdas = testLambdaData()
+	lambdas[0].Options.FunctionURL = &LambdaFunctionURL{AuthType: "PUBLIC"}
+	preconditionsErr := validateSpartaPreconditions(lambdas, logger)
+	if preconditionsErr == nil || !strings.Contains(preconditionsErr.Error(), "FunctionURL.AuthType") {
+		t.Fatalf("Failed to reject invalid FunctionURL: %v", preconditionsErr)
+	}
+}
+
+func TestPhaseTimeoutStep(t *testing.T) {
+	opts, optsErr := newProvisionOptions(WithProvisionTimeoutPerPhase(map[ProvisionPhase]time.Duration{
+		ProvisionPhaseUpload: 10 * time.Millisecond,
+	}))
+	if optsErr != nil {
+		t.Fatal(optsErr)
+	}
+	ctx := &workflowContext{
+		logger:      logrus.New(),
+		stepContext: context.Background(),
+	}
+	ctx.userdata.options = opts
+
+	var stepContext context.Context
+	blockingStep := func(ctx *workflowContext) (workflowStep, error) {
+		stepContext = ctx.stepContext
+		select {
+		case <-ctx.stepContext.Done():
+			return nil, ctx.stepContext.Err()
+		case <-time.After(5 * time.Second):
+			return nil, nil
+		}
+	}
+	_, stepErr := phaseTimeoutStep(ProvisionPhaseUpload, blockingStep)(ctx)
+	if stepErr == nil || !strings.Contains(stepErr.Error(), "upload phase exceeded timeout") {
+		t.Fatalf("Unexpected phase timeout error: %v", stepErr)
+	}
+	if stepContext == nil || stepContext.Err() != context.DeadlineExceeded {
+		t.Fatalf("Failed to cancel the step context: %v", stepContext)
+	}
+	if ctx.stepContext != context.Background() {
+		t.Fatal("Failed to restore the parent step context")
+	}
+
+	// Phases without a timeout aren't limited
+	_, stepErr = phaseTimeoutStep(ProvisionPhaseBuild, func(ctx *workflowContext) (workflowStep, error) {
+		if _, hasDeadline := ctx.stepContext.Deadline(); hasDeadline {
+			return nil, errors.New("Unexpected build phase deadline")
+		}
+		return nil, nil
+	})(ctx)
+	if stepErr != nil {
+		t.Fatal(stepErr)
+	}
+}
+
+func TestProvisionWithContextCanceled(t *testing.T) {
+	logger, _ := NewLogger("info")
+	provisionCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var rollbackCount int32
+	var postBuildCount int32
+	workflowHooks := &WorkflowHooks{
+		PreBuilds: []WorkflowHookHandler{
+			WorkflowHookFunc(func(context map[string]interface{},
+				serviceName string,
+				S3Bucket string,
+				buildID string,
+				awsSession *session.Session,
+				noop bool,
+				logger *logrus.Logger) error {
+				RegisterRollbackFunction(context, func(logger *logrus.Logger) error {
+					atomic.AddInt32(&rollbackCount, 1)
+					return nil
+				})
+				cancel()
+				return nil
+			}),
+		},
+		PostBuilds: []WorkflowHookHandler{
+			WorkflowHookFunc(func(context map[string]interface{},
+				serviceName string,
+				S3Bucket string,
+				buildID string,
+				awsSession *session.Session,
+				noop bool,
+				logger *logrus.Logger) error {
+				atomic.AddInt32(&postBuildCount, 1)
+				return nil
+			}),
+		},
+	}
+	var templateWriter bytes.Buffer
+	provisionErr := ProvisionWithContext(provisionCtx,
+		true,
+		"SampleProvision",
+		"",
+		testLambdaData(),
+		nil,
+		nil,
+		os.Getenv("S3_BUCKET"),
+		false,
+		false,
+		"testBuildID",
+		"",
+		"",
+		"",
+		&templateWriter,
+		workflowHooks,
+		logger)
+	if errors.Cause(provisionErr) != context.Canceled {
+		t.Fatalf("Unexpected canceled provision error: %v", provisionErr)
+	}
+	if atomic.LoadInt32(&rollbackCount) != 1 {
+		t.Errorf("Unexpected rollback count: %d", rollbackCount)
+	}
+	if atomic.LoadInt32(&postBuildCount) != 0 {
+		t.Error("Canceled provision continued to the PostBuild hooks")
+	}
+	if templateWriter.Len() != 0 {
+		t.Error("Canceled provision wrote a template")
+	}
+}
+
+func TestImageURIProvision(t *testing.T) {
+	imageURI := "123412341234.dkr.ecr.us-west-2.amazonaws.com/service:latest"
+	lambdas := testLambdaData()
+	lambdas[2].Options.ImageURI = imageURI
+
+	templateBody := testProvisionTemplateBody(t, lambdas)
+	imageProperties := testProvisionFunctionProperties(t, templateBody, lambdas[2])
+	if imageProperties.PackageType != "Image" {
+		t.Errorf("Unexpected PackageType: %s", imageProperties.PackageType)
+	}
+	if imageProperties.Code["ImageUri"] != imageURI {
+		t.Errorf("Unexpected image Code: %v", imageProperties.Code)
+	}
+	for _, eachZIPProperty := range []string{"S3Bucket", "S3Key", "S3ObjectVersion"} {
+		if _, exists := imageProperties.Code[eachZIPProperty]; exists {
+			t.Errorf("Unexpected ZIP Code property for image function: %s", eachZIPProperty)
+		}
+	}
+	if imageProperties.Handler != "" || imageProperties.Runtime != "" {
+		t.Errorf("Unexpected Handler (%s) or Runtime (%s) for image function",
+			imageProperties.Handler,
+			imageProperties.Runtime)
+	}
+	zipProperties := testProvisionFunctionProperties(t, templateBody, lambdas[0])
+	if zipProperties.PackageType != "" {
+		t.Errorf("Unexpected PackageType for ZIP function: %s", zipProperties.PackageType)
+	}
+	if _, exists := zipProperties.Code["S3Key"]; !exists {
+		t.Errorf("Missing ZIP Code S3Key: %v", zipProperties.Code)
+	}
+
+	// In-place updates only support ZIP packaged functions
+	logger, _ := NewLogger("info")
+	var templateWriter bytes.Buffer
+	inPlaceErr := Provision(true,
+		"SampleProvision",
+		"",
+		lambdas,
+		nil,
+		nil,
+		os.Getenv("S3_BUCKET"),
+		false,
+		true,
+		"testBuildID",
+		"",
+		"",
+		"",
+		&templateWriter,
+		nil,
+		logger)
+	if inPlaceErr == nil || !strings.Contains(inPlaceErr.Error(), imageURI) {
+		t.Fatalf("Failed to reject in-place update of image function: %v", inPlaceErr)
+	}
+}
+
+func TestAddResourceCountOutputs(t *testing.T) {
+	ctx := &workflowContext{logger: logrus.New()}
+	ctx.context.cfTemplate = gocf.NewTemplate()
+	ctx.context.cfTemplate.AddResource("FirstQueue", &gocf.SQSQueue{})
+	ctx.context.cfTemplate.AddResource("SecondQueue", &gocf.SQSQueue{})
+	ctx.context.cfTemplate.AddResource("Topic", &gocf.SNSTopic{})
+	if countErr := addResourceCountOutputs(ctx); countErr != nil {
+		t.Fatalf("Failed to add resource count Outputs: %s", countErr)
+	}
+	countOutput, countOutputExists := ctx.context.cfTemplate.Outputs[OutputResourceCount]
+	if !countOutputExists || countOutput.Value.(*gocf.StringExpr).Literal != "3" {
+		t.Fatalf("Unexpected %s Output: %#v", OutputResourceCount, countOutput)
+	}
+	typeCountsOutput, typeCountsOutputExists := ctx.context.cfTemplate.Outputs[OutputResourceTypeCounts]
+	if !typeCountsOutputExists {
+		t.Fatalf("Missing %s Output", OutputResourceTypeCounts)
+	}
+	typeCounts := map[string]int{}
+	unmarshalErr := json.Unmarshal([]byte(typeCountsOutput.Value.(*gocf.StringExpr).Literal), &typeCounts)
+	if unmarshalErr != nil {
+		t.Fatalf("Failed to unmarshal %s Output: %s", OutputResourceTypeCounts, unmarshalErr)
+	}
+	if len(typeCounts) != 2 ||
+		typeCounts["AWS::SQS::Queue"] != 2 ||
+		typeCounts["AWS::SNS::Topic"] != 1 {
+		t.Errorf("Unexpected resource type counts: %v", typeCounts)
+	}
+
+	// The Outputs are only added with WithStackResourceCountOutput
+	templateBody := testProvisionTemplateBody(t, testLambdaData(), WithStackResourceCountOutput())
+	var template struct {
+		Outputs map[string]interface{}
+	}
+	if unmarshalErr := json.Unmarshal([]byte(templateBody), &template); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	for _, eachOutput := range []string{OutputResourceCount, OutputResourceTypeCounts} {
+		if _, outputExists := template.Outputs[eachOutput]; !outputExists {
+			t.Errorf("Missing %s Output", eachOutput)
+		}
+	}
+}
+
+func TestEnsureBucketOwner(t *testing.T) {
+	newOwnerContext := func(bucketOwner string) (*workflowContext, *fakeS3API) {
+		logger, _ := NewLogger("warning")
+		ctx := &workflowContext{
+			logger:      logger,
+			stepContext: context.Background(),
+		}
+		ctx.userdata.s3Bucket = "weagle"
+		fakeS3 := &fakeS3API{bucketOwner: bucketOwner}
+		ctx.context.s3Svc = fakeS3
+		ctx.context.stsSvc = &fakeSTSAPI{}
+		return ctx, fakeS3
+	}
+
+	// A bucket owned by another account is rejected
+	ctx, fakeS3 := newOwnerContext("999999999999")
+	if ownerErr := ensureBucketOwner(ctx); ownerErr == nil {
+		t.Fatal("Failed to reject bucket owned by another account")
+	}
+	if len(ctx.context.s3RequestOptions) != 0 {
+		t.Errorf("Unexpected S3 request options for rejected bucket: %d", len(ctx.context.s3RequestOptions))
+	}
+
+	// The caller's account is the expected owner of the subsequent requests
+	ctx, fakeS3 = newOwnerContext("123412341234")
+	if ownerErr := ensureBucketOwner(ctx); ownerErr != nil {
+		t.Fatalf("Failed to verify bucket owner: %s", ownerErr)
+	}
+	if len(fakeS3.expectedOwners) != 1 || fakeS3.expectedOwners[0] != "123412341234" {
+		t.Errorf("Unexpected HeadBucket expected owners: %v", fakeS3.expectedOwners)
+	}
+	s3Svc := s3.New(session.Must(session.NewSession(&aws.Config{
+		Region: aws.String("us-west-2"),
+	})))
+	putObjectRequest, _ := s3Svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String("weagle"),
+		Key:    aws.String("MyService/code.zip"),
+	})
+	putObjectRequest.ApplyOptions(ctx.context.s3RequestOptions...)
+	if putObjectRequest.HTTPRequest.Header.Get("x-amz-expected-bucket-owner") != "123412341234" {
+		t.Errorf("Missing expected bucket owner on artifact request: %v", putObjectRequest.HTTPRequest.Header)
+	}
+}
+
+func TestVerifyGoModuleConsistency(t *testing.T) {
+	moduleDir, moduleDirErr := ioutil.TempDir("", "sparta-module")
+	if moduleDirErr != nil {
+		t.Fatal(moduleDirErr)
+	}
+	defer os.RemoveAll(moduleDir)
+	workingDir, workingDirErr := os.Getwd()
+	if workingDirErr != nil {
+		t.Fatal(workingDirErr)
+	}
+	defer os.Chdir(workingDir)
+	if chdirErr := os.Chdir(moduleDir); chdirErr != nil {
+		t.Fatal(chdirErr)
+	}
+	// Resolve modules offline with the local toolchain
+	for eachKey, eachValue := range map[string]string{
+		"GOFLAGS":     "",
+		"GOPROXY":     "off",
+		"GOTOOLCHAIN": "local",
+	} {
+		defer os.Setenv(eachKey, os.Getenv(eachKey))
+		os.Setenv(eachKey, eachValue)
+	}
+	logger, _ := NewLogger("warning")
+	writeModuleFile := func(name string, contents string) {
+		if mkdirErr := os.MkdirAll(filepath.Dir(name), 0755); mkdirErr != nil {
+			t.Fatal(mkdirErr)
+		}
+		if writeErr := ioutil.WriteFile(name, []byte(contents), 0644); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+
+	// Directories without a go.mod aren't checked
+	if checkErr := verifyGoModuleConsistency(context.Background(), logger); checkErr != nil {
+		t.Fatalf("Unexpected error without go.mod: %s", checkErr)
+	}
+
+	writeModuleFile("go.mod", "module example.com/service\n\ngo 1.13\n")
+	writeModuleFile("main.go", "package main\n\nfunc main() {}\n")
+	if checkErr := verifyGoModuleConsistency(context.Background(), logger); checkErr != nil {
+		t.Fatalf("Failed to verify consistent module: %s", checkErr)
+	}
+
+	// An inconsistent vendor directory is reported
+	writeModuleFile(filepath.Join("vendor", "modules.txt"),
+		"# example.com/dependency v1.0.0\n## explicit\nexample.com/dependency\n")
+	checkErr := verifyGoModuleConsistency(context.Background(), logger)
+	if checkErr == nil || !strings.Contains(checkErr.Error(), "`go list -mod=vendor ./...` failed") {
+		t.Fatalf("Unexpected vendor consistency error: %v", checkErr)
+	}
+	os.RemoveAll("vendor")
+
+	// An untidy go.mod is reported if `go mod tidy -diff` is supported
+	helpOutput, helpOutputErr := exec.Command("go", "help", "mod", "tidy").CombinedOutput()
+	if helpOutputErr != nil || !strings.Contains(string(helpOutput), "-diff") {
+		return
+	}
+	writeModuleFile("main.go",
+		"package main\n\nimport _ \"example.com/dependency\"\n\nfunc main() {}\n")
+	checkErr = verifyGoModuleConsistency(context.Background(), logger)
+	if checkErr == nil || !strings.Contains(checkErr.Error(), "`go mod tidy -diff` failed") {
+		t.Fatalf("Unexpected tidy consistency error: %v", checkErr)
+	}
+}
+
+func TestLogDeploySummary(t *testing.T) {
+	var logOutput bytes.Buffer
+	ctx := &workflowContext{logger: logrus.New()}
+	ctx.logger.Out = &logOutput
+	ctx.userdata.serviceName = "MyService"
+	ctx.userdata.lambdaAWSInfos = testLambdaData()
+
+	// Nothing is logged if the stack wasn't provisioned
+	logDeploySummary(ctx, time.Minute)
+	if logOutput.Len() != 0 {
+		t.Fatalf("Unexpected summary without a stack: %s", logOutput.String())
+	}
+
+	ctx.context.stack = &cloudformation.Stack{
+		StackName: aws.String("MyStack"),
+		StackId:   aws.String("arn:aws:cloudformation:us-west-2:123412341234:stack/MyStack/1"),
+		Outputs: []*cloudformation.Output{
+			{
+				OutputKey:   aws.String(OutputAPIGatewayURL),
+				OutputValue: aws.String("https://api.example.com/v1"),
+			},
+		},
+	}
+	logDeploySummary(ctx, 90*time.Second)
+	summary := logOutput.String()
+	expectedValues := []string{"Deployed MyService",
+		"StackName=MyStack",
+		"stack/MyStack/1",
+		"https://api.example.com/v1",
+		ctx.userdata.lambdaAWSInfos[0].lambdaFunctionName(),
+		"=90",
+	}
+	for _, eachValue := range expectedValues {
+		if !strings.Contains(summary, eachValue) {
+			t.Errorf("Failed to find %q in deploy summary: %s", eachValue, summary)
+		}
+	}
+	if strings.Contains(summary, "S3SiteURL") {
+		t.Errorf("Unexpected S3SiteURL in deploy summary: %s", summary)
+	}
+}
+
+func TestLambdaArchitecture(t *testing.T) {
+	lambdas := testLambdaData()
+	lambdas[2].Options.ImageURI = "123412341234.dkr.ecr.us-west-2.amazonaws.com/service:latest"
+	templateBody := testProvisionTemplateBody(t,
+		lambdas,
+		WithLambdaArchitecture(LambdaArchitectureArm64))
+	zipProperties := testProvisionFunctionProperties(t, templateBody, lambdas[0])
+	if strings.Join(zipProperties.Architectures, ",") != LambdaArchitectureArm64 {
+		t.Errorf("Unexpected service binary function Architectures: %v", zipProperties.Architectures)
+	}
+	imageProperties := testProvisionFunctionProperties(t, templateBody, lambdas[2])
+	if len(imageProperties.Architectures) != 0 {
+		t.Errorf("Unexpected image function Architectures: %v", imageProperties.Architectures)
+	}
+	// The default architecture doesn't set the property
+	defaultProperties := testProvisionFunctionProperties(t,
+		testProvisionTemplateBody(t, testLambdaData()),
+		lambdas[0])
+	if len(defaultProperties.Architectures) != 0 {
+		t.Errorf("Unexpected default Architectures: %v", defaultProperties.Architectures)
+	}
+	if lambdaGoArch(LambdaArchitectureArm64) != "arm64" || lambdaGoArch("") != "amd64" {
+		t.Error("Unexpected GOARCH for Lambda architecture")
+	}
+	if WithLambdaArchitecture("ppc64")(&provisionOptions{}) == nil {
+		t.Error("Failed to reject unsupported Lambda architecture")
+	}
+}
+
+func TestProvisionWorkflowHooks(t *testing.T) {
+	logger, _ := NewLogger("warning")
+	ctx := &workflowContext{logger: logger}
+	ctx.stepContext = context.Background()
+	ctx.userdata.serviceName = "HookService"
+	ctx.userdata.s3Bucket = "hook-bucket"
+	ctx.userdata.options = &provisionOptions{}
+	ctx.context.cfTemplate = gocf.NewTemplate()
+	ctx.context.cfSvc = &fakeCFAPI{}
+	fakeS3 := &fakeS3API{}
+	ctx.context.s3Svc = fakeS3
+
+	hookCalls := []string{}
+	testHook := func(phase string, hookErr error) WorkflowHookHandler {
+		return WorkflowHookFunc(func(context map[string]interface{},
+			serviceName string,
+			S3Bucket string,
+			buildID string,
+			awsSession *session.Session,
+			noop bool,
+			logger *logrus.Logger) error {
+			hookCalls = append(hookCalls, phase)
+			return hookErr
+		})
+	}
+	ctx.userdata.workflowHooks = &WorkflowHooks{
+		PreProvisions:  []WorkflowHookHandler{testHook("PreProvision", errors.New("pre-provision failed"))},
+		PostProvisions: []WorkflowHookHandler{testHook("PostProvision", nil)},
+	}
+	// The failing PreProvision hook aborts the workflow before the stack
+	// operation, which would otherwise panic on the fake CloudFormation client
+	_, applyErr := applyCloudFormationOperation(ctx)
+	if applyErr == nil || !strings.Contains(applyErr.Error(), "pre-provision failed") {
+		t.Fatalf("Failed to report PreProvision hook error: %v", applyErr)
+	}
+	if strings.Join(hookCalls, ",") != "PreProvision" {
+		t.Errorf("Unexpected hook calls: %v", hookCalls)
+	}
+	// The hook runs after the template is uploaded
+	templateUploaded := false
+	for eachKey := range fakeS3.objects {
+		templateUploaded = templateUploaded || strings.Contains(eachKey, "HookService-cftemplate")
+	}
+	if !templateUploaded {
+		t.Errorf("Failed to upload template before PreProvision hook: %v", fakeS3.objects)
+	}
+
+	// Noop provisions don't call the hooks
+	hookCalls = []string{}
+	ctx.userdata.noop = true
+	if _, noopErr := applyCloudFormationOperation(ctx); noopErr != nil {
+		t.Fatalf("Failed to apply noop CloudFormation operation: %s", noopErr)
+	}
+	if len(hookCalls) != 0 {
+		t.Errorf("Unexpected hook calls for noop provision: %v", hookCalls)
+	}
+}
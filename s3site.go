@@ -2,6 +2,7 @@ package sparta
 
 import (
 	"github.com/aws/aws-sdk-go/service/s3"
+	spartaZip "github.com/mweagle/Sparta/zip"
 )
 
 func stableCloudformationResourceName(prefix string) string {
@@ -17,6 +18,15 @@ type S3Site struct {
 	resources string
 	// If nil, defaults to ErrorDocument: error.html and IndexDocument: index.html
 	WebsiteConfiguration *s3.WebsiteConfiguration
+	// Optional path.Match patterns (eg, ".DS_Store", "*.map", ".git") of the
+	// files and directories to exclude from the site archive. Patterns are
+	// matched against both the slash separated path relative to the resources
+	// directory and its base name.
+	ExcludePatterns []string
+	// Symlinks is the handling policy for symlinks in the resources
+	// directory. The default follows symlinks that resolve within the
+	// resources directory and skips cycles.
+	Symlinks spartaZip.SymlinkPolicy
 }
 
 // CloudFormationS3ResourceName returns the stable CloudformationResource name that
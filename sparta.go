@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,7 +21,6 @@ import (
 	_ "github.com/aws/aws-lambda-go/lambdacontext" // Force dep to resolve
 	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
 	spartaIAM "github.com/mweagle/Sparta/aws/iam"
-	gocc "github.com/mweagle/go-cloudcondenser"
 	gocf "github.com/mweagle/go-cloudformation"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -56,6 +57,11 @@ const (
 	envVarDiscoveryInformation = "SPARTA_DISCOVERY_INFO"
 )
 
+// EnvVarKMSEncryptionContext is the name of the environment variable that
+// stores the JSON encoded LambdaFunctionOptions.KmsEncryptionContext value.
+// See KMSEncryptionContext.
+const EnvVarKMSEncryptionContext = "SPARTA_KMS_ENCRYPTION_CONTEXT"
+
 var (
 	// internal logging header
 	headerDivider = strings.Repeat("═", dividerLength)
@@ -80,6 +86,34 @@ const (
 	LambdaPrincipal = "lambda.amazonaws.com"
 )
 
+// Lambda recursive loop detection values. See
+// https://docs.aws.amazon.com/lambda/latest/dg/invocation-recursion.html
+// for more information.
+const (
+	// RecursiveLoopTerminate stops a function that's detected as being
+	// invoked in a recursive loop. This is the default value.
+	RecursiveLoopTerminate = "Terminate"
+	// RecursiveLoopAllow disables recursive loop detection for
+	// intentionally recursive workloads.
+	RecursiveLoopAllow = "Allow"
+)
+
+// Lambda function URL values. See
+// https://docs.aws.amazon.com/lambda/latest/dg/lambda-urls.html
+// for more information.
+const (
+	// FunctionURLAuthTypeIAM requires IAM authenticated requests
+	FunctionURLAuthTypeIAM = "AWS_IAM"
+	// FunctionURLAuthTypeNone allows public, unauthenticated requests
+	FunctionURLAuthTypeNone = "NONE"
+	// FunctionURLInvokeModeBuffered buffers the entire response before it's
+	// returned to the client. This is the default value.
+	FunctionURLInvokeModeBuffered = "BUFFERED"
+	// FunctionURLInvokeModeResponseStream streams the response payload to the
+	// client as it's produced
+	FunctionURLInvokeModeResponseStream = "RESPONSE_STREAM"
+)
+
 type cloudFormationLambdaCustomResource struct {
 	gocf.CloudFormationCustomResource
 	ServiceToken   *gocf.StringExpr
@@ -216,6 +250,20 @@ var CommonIAMStatements = struct {
 // RE for sanitizing names
 var reSanitize = regexp.MustCompile(`\W+`)
 
+// RE for valid CloudFormation stack names
+var reStackName = regexp.MustCompile(`^[a-zA-Z][-a-zA-Z0-9]*$`)
+
+// maxStackNameLength is the maximum length of a CloudFormation stack name
+const maxStackNameLength = 128
+
+// AWS Lambda function limits. Memory is configurable in 1 MB increments.
+// See https://docs.aws.amazon.com/lambda/latest/dg/gettingstarted-limits.html
+const (
+	minLambdaMemorySize = 128
+	maxLambdaMemorySize = 10240
+	maxLambdaTimeout    = 900
+)
+
 // Wildcard ARN for any AWS resource
 var wildcardArn = gocf.String("*")
 
@@ -289,17 +337,46 @@ type LambdaFunctionOptions struct {
 	Environment map[string]*gocf.StringExpr
 	// KMS Key Arn used to encrypt environment variables
 	KmsKeyArn string
-	// The maximum of concurrent executions you want reserved for the function
-	ReservedConcurrentExecutions int64
+	// KmsEncryptionContext is the optional encryption context that must be
+	// supplied to kms:Decrypt for values encrypted with KmsKeyArn. The
+	// function's IAM role is only granted kms:Decrypt for requests that
+	// include this context. The value is available at runtime via
+	// KMSEncryptionContext().
+	KmsEncryptionContext map[string]string
+	// The maximum of concurrent executions you want reserved for the function.
+	// A nil value doesn't reserve any concurrency and omits the property. Zero
+	// is a valid reservation that throttles every invocation of the function.
+	ReservedConcurrentExecutions *int64
 	// DeadLetterConfigArn is how Lambda handles events that it can't process.If
 	// you don't specify a Dead Letter Queue (DLQ) configuration, Lambda
 	// discards events after the maximum number of retries. For more information,
 	// see Dead Letter Queues in the AWS Lambda Developer Guide.
+	// The target may be an SQS queue or SNS topic ARN, including a reference to
+	// a resource in the same template. Roles created from an IAMRoleDefinition
+	// are granted permission to send to the target.
 	DeadLetterConfigArn gocf.Stringable
-	// Tags to associate with the Lambda function
+	// Tags to associate with the Lambda function. Service-wide tags from
+	// WithResourceTagPropagation are merged in, and these tags take
+	// precedence for duplicate keys.
 	Tags map[string]string
 	// Tracing options for XRay
 	TracingConfig *gocf.LambdaFunctionTracingConfig
+	// FunctionURL optionally exposes the function via a dedicated HTTPS endpoint
+	FunctionURL *LambdaFunctionURL
+	// ImageURI is the optional URI of a container image in Amazon ECR. If
+	// non-empty, the function is deployed with `PackageType: Image` from this
+	// image rather than from the Sparta ZIP archive. The image is built and
+	// pushed by the caller and must include the Sparta binary as its
+	// entrypoint. Image functions don't support in-place updates.
+	ImageURI string
+	// Advanced logging controls (log format, log levels, and log group)
+	LoggingConfig *LambdaLoggingConfig
+	// RecursiveLoop determines whether AWS terminates the function if it's
+	// detected as part of a recursive invocation loop (eg, a function
+	// writing to the S3 bucket that triggers it). One of
+	// RecursiveLoopTerminate or RecursiveLoopAllow. Defaults to
+	// RecursiveLoopTerminate.
+	RecursiveLoop string
 	// Additional params
 	SpartaOptions *SpartaOptions
 }
@@ -311,7 +388,7 @@ func defaultLambdaFunctionOptions() *LambdaFunctionOptions {
 		VpcConfig:                    nil,
 		Environment:                  nil,
 		KmsKeyArn:                    "",
-		ReservedConcurrentExecutions: 0,
+		ReservedConcurrentExecutions: nil,
 		SpartaOptions:                nil,
 	}
 }
@@ -325,6 +402,87 @@ type SpartaOptions struct {
 	Name string
 }
 
+// LambdaLoggingConfig defines the advanced logging controls for a Lambda
+// function. Setting LogFormat to JSON produces structured CloudWatch log
+// entries without any changes to the function's code. See
+// https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/aws-properties-lambda-function-loggingconfig.html
+// for more information.
+type LambdaLoggingConfig struct {
+	// LogFormat is either `Text` or `JSON`
+	LogFormat string `json:",omitempty"`
+	// ApplicationLogLevel filters the function's own log entries. One of
+	// TRACE, DEBUG, INFO, WARN, ERROR, FATAL. Requires the JSON LogFormat.
+	ApplicationLogLevel string `json:",omitempty"`
+	// SystemLogLevel filters the Lambda platform log entries. One of
+	// DEBUG, INFO, WARN. Requires the JSON LogFormat.
+	SystemLogLevel string `json:",omitempty"`
+	// LogGroup is the optional name of a custom CloudWatch Logs log group.
+	// Defaults to /aws/lambda/<functionName>
+	LogGroup *gocf.StringExpr `json:",omitempty"`
+}
+
+func (loggingConfig *LambdaLoggingConfig) validate() error {
+	switch loggingConfig.LogFormat {
+	case "", "Text", "JSON":
+		// NOP
+	default:
+		return errors.Errorf("Invalid LoggingConfig.LogFormat: %s", loggingConfig.LogFormat)
+	}
+	if loggingConfig.LogFormat != "JSON" &&
+		(loggingConfig.ApplicationLogLevel != "" || loggingConfig.SystemLogLevel != "") {
+		return errors.Errorf("LoggingConfig log levels require the JSON LogFormat")
+	}
+	switch loggingConfig.ApplicationLogLevel {
+	case "", "TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL":
+		// NOP
+	default:
+		return errors.Errorf("Invalid LoggingConfig.ApplicationLogLevel: %s",
+			loggingConfig.ApplicationLogLevel)
+	}
+	switch loggingConfig.SystemLogLevel {
+	case "", "DEBUG", "INFO", "WARN":
+		// NOP
+	default:
+		return errors.Errorf("Invalid LoggingConfig.SystemLogLevel: %s",
+			loggingConfig.SystemLogLevel)
+	}
+	return nil
+}
+
+// LambdaFunctionURL defines the function URL for a Lambda function. The URL
+// is published as the `<LambdaLogicalResourceName>FunctionURL` stack Output.
+// See https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/aws-resource-lambda-url.html
+// for more information.
+type LambdaFunctionURL struct {
+	// AuthType is either FunctionURLAuthTypeIAM or FunctionURLAuthTypeNone.
+	// Defaults to FunctionURLAuthTypeIAM. FunctionURLAuthTypeNone also adds
+	// the public lambda:InvokeFunctionUrl permission.
+	AuthType string
+	// InvokeMode is either FunctionURLInvokeModeBuffered or
+	// FunctionURLInvokeModeResponseStream. Defaults to
+	// FunctionURLInvokeModeBuffered. Response streaming isn't supported by
+	// the managed go1.x runtime, so it requires either a provided runtime
+	// (see WithLambdaRuntime) or a container image (ImageURI) whose handler
+	// writes a streaming compatible response.
+	InvokeMode string
+}
+
+func (functionURL *LambdaFunctionURL) validate() error {
+	switch functionURL.AuthType {
+	case "", FunctionURLAuthTypeIAM, FunctionURLAuthTypeNone:
+		// NOP
+	default:
+		return errors.Errorf("Invalid FunctionURL.AuthType: %s", functionURL.AuthType)
+	}
+	switch functionURL.InvokeMode {
+	case "", FunctionURLInvokeModeBuffered, FunctionURLInvokeModeResponseStream:
+		// NOP
+	default:
+		return errors.Errorf("Invalid FunctionURL.InvokeMode: %s", functionURL.InvokeMode)
+	}
+	return nil
+}
+
 // WorkflowHooks is a structure that allows callers to customize the Sparta provisioning
 // pipeline to add contents the Lambda archive or perform other workflow operations.
 // TODO: remove single-valued fields
@@ -361,6 +519,14 @@ type WorkflowHooks struct {
 	// PostMarshalls are called after Sparta marshalls the application contents to a CloudFormation
 	// template
 	PostMarshalls []WorkflowHookHandler
+	// PreProvisions are called after the CloudFormation template is uploaded
+	// and before the stack is created or updated. They are not called for
+	// -noop builds or CodePipeline packages
+	PreProvisions []WorkflowHookHandler
+	// PostProvisions are called after the stack is successfully created or
+	// updated. An error fails the provision, but the stack changes are not
+	// reverted
+	PostProvisions []WorkflowHookHandler
 
 	// Rollback is called if there is an error performing the requested operation
 	Rollback RollbackHook
@@ -408,13 +574,36 @@ func (rolePrivilege *IAMRolePrivilege) resourceExpr() *gocf.StringExpr {
 type IAMRoleDefinition struct {
 	// Slice of IAMRolePrivilege entries
 	Privileges []IAMRolePrivilege
+	// Optional role path (eg, `/service-roles/`). The path must begin and
+	// end with a forward slash. Empty uses the default `/` path.
+	Path string
+	// Optional managed policy ARN to use as the role's permissions
+	// boundary. It takes precedence over WithIAMPermissionsBoundary.
+	PermissionsBoundary string
 	// Cached logical resource name
 	cachedLogicalName string
 }
 
+// reIAMRolePath matches valid IAM role paths
+var reIAMRolePath = regexp.MustCompile(`^/([\x21-\x7E]{1,510}/)?$`)
+
+// validate returns an error if the Path or PermissionsBoundary is invalid
+func (roleDefinition *IAMRoleDefinition) validate() error {
+	if roleDefinition.Path != "" && !reIAMRolePath.MatchString(roleDefinition.Path) {
+		return errors.Errorf("Invalid IAMRoleDefinition.Path: %q. The path must begin and end with /",
+			roleDefinition.Path)
+	}
+	if roleDefinition.PermissionsBoundary != "" &&
+		!reIAMPolicyARN.MatchString(roleDefinition.PermissionsBoundary) {
+		return errors.Errorf("Invalid IAMRoleDefinition.PermissionsBoundary: %q is not a managed policy ARN",
+			roleDefinition.PermissionsBoundary)
+	}
+	return nil
+}
+
 func (roleDefinition *IAMRoleDefinition) toResource(eventSourceMappings []*EventSourceMapping,
 	options *LambdaFunctionOptions,
-	logger *logrus.Logger) gocf.IAMRole {
+	logger *logrus.Logger) iamRoleResource {
 
 	statements := CommonIAMStatements.Core
 	for _, eachPrivilege := range roleDefinition.Privileges {
@@ -430,6 +619,27 @@ func (roleDefinition *IAMRoleDefinition) toResource(eventSourceMappings []*Event
 	if options != nil && options.VpcConfig != nil {
 		statements = append(statements, CommonIAMStatements.VPC...)
 	}
+	// Allow delivery to the dead letter target iff needed
+	if options != nil && options.DeadLetterConfigArn != nil {
+		statements = append(statements, deadLetterTargetStatement(options.DeadLetterConfigArn))
+	}
+	// Scope kms:Decrypt to the encryption context iff needed
+	if options != nil &&
+		options.KmsKeyArn != "" &&
+		len(options.KmsEncryptionContext) != 0 {
+		contextConditions := make(map[string]string)
+		for eachKey, eachValue := range options.KmsEncryptionContext {
+			contextConditions[fmt.Sprintf("kms:EncryptionContext:%s", eachKey)] = eachValue
+		}
+		statements = append(statements, spartaIAM.PolicyStatement{
+			Effect:   "Allow",
+			Action:   []string{"kms:Decrypt"},
+			Resource: gocf.String(options.KmsKeyArn),
+			Condition: map[string]interface{}{
+				"StringEquals": contextConditions,
+			},
+		})
+	}
 	// In the past Sparta used to attach EventSourceMapping policies here.
 	// However, moving everything to dynamic references means that we can't
 	// fully populate the PolicyDocument statement slice until all of
@@ -447,10 +657,51 @@ func (roleDefinition *IAMRoleDefinition) toResource(eventSourceMappings []*Event
 		},
 		PolicyName: gocf.String("LambdaPolicy"),
 	})
-	return gocf.IAMRole{
-		AssumeRolePolicyDocument: AssumePolicyDocument,
-		Policies:                 &iamPolicies,
+	roleResource := iamRoleResource{
+		IAMRole: gocf.IAMRole{
+			AssumeRolePolicyDocument: AssumePolicyDocument,
+			Policies:                 &iamPolicies,
+		},
 	}
+	if roleDefinition.Path != "" {
+		roleResource.Path = gocf.String(roleDefinition.Path)
+	}
+	if roleDefinition.PermissionsBoundary != "" {
+		roleResource.PermissionsBoundary = gocf.String(roleDefinition.PermissionsBoundary)
+	}
+	return roleResource
+}
+
+// contentDigest returns a digest of the IAM::Role resource this definition
+// produces together with the event source ARNs whose permissions will
+// be attached to it. Definitions with the same digest are semantically
+// identical and can share a single IAM::Role resource.
+func (roleDefinition *IAMRoleDefinition) contentDigest(eventSourceMappings []*EventSourceMapping,
+	options *LambdaFunctionOptions,
+	logger *logrus.Logger) (string, error) {
+
+	eventSourceArns := []gocf.Stringable{}
+	for _, eachMapping := range eventSourceMappings {
+		eventSourceArns = append(eventSourceArns,
+			spartaCF.DynamicValueToStringExpr(eachMapping.EventSourceArn))
+	}
+	digestSource := struct {
+		Role            iamRoleResource
+		EventSourceArns []gocf.Stringable
+	}{
+		Role:            roleDefinition.toResource(eventSourceMappings, options, logger),
+		EventSourceArns: eventSourceArns,
+	}
+	jsonBytes, jsonBytesErr := json.Marshal(digestSource)
+	if jsonBytesErr != nil {
+		return "", errors.Wrapf(jsonBytesErr, "Failed to marshal IAMRoleDefinition")
+	}
+	hash := sha1.New()
+	_, writeErr := hash.Write(jsonBytes)
+	if writeErr != nil {
+		return "", writeErr
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 // Returns the stable logical name for this IAMRoleDefinition, which depends on the serviceName
@@ -607,10 +858,10 @@ func (resourceInfo *customResourceInfo) export(serviceName string,
 		FunctionName: lambdaFunctionName.String(),
 		Description:  gocf.String(lambdaDescription),
 		Handler:      gocf.String(binaryName),
-		MemorySize:   gocf.Integer(resourceInfo.options.MemorySize),
+		MemorySize:   optionalInteger(resourceInfo.options.MemorySize),
 		Role:         roleNameMap[iamRoleArnName],
 		Runtime:      gocf.String(GoLambdaVersion),
-		Timeout:      gocf.Integer(resourceInfo.options.Timeout),
+		Timeout:      optionalInteger(resourceInfo.options.Timeout),
 		VPCConfig:    resourceInfo.options.VpcConfig,
 		// DISPATCH INFORMATION
 		Environment: lambdaEnv,
@@ -638,6 +889,70 @@ func (resourceInfo *customResourceInfo) export(serviceName string,
 // END - customResourceInfo
 ////////////////////////////////////////////////////////////////////////////////
 
+////////////////////////////////////////////////////////////////////////////////
+// START - LambdaFunctionResource
+
+// LambdaFunctionResource is the AWS::Lambda::Function resource that Sparta
+// adds to the template. It embeds the generated gocf.LambdaFunction type and
+// extends it with properties that the vendored go-cloudformation definition
+// doesn't yet include. Code that reads a Sparta function resource from the
+// template, such as a LambdaResourceDecorator that inspects the template
+// param, should type assert the resource Properties to a
+// LambdaFunctionResource value and assign any modified value back to
+// Properties. The function code is the Code field, not LambdaFunction.Code.
+type LambdaFunctionResource struct {
+	gocf.LambdaFunction
+	// Code shadows the embedded LambdaFunction.Code value so that it can
+	// be either a *gocf.LambdaFunctionCode or *lambdaFunctionImageCode
+	Code          interface{}          `json:",omitempty"`
+	PackageType   string               `json:",omitempty"`
+	LoggingConfig *LambdaLoggingConfig `json:",omitempty"`
+	RecursiveLoop string               `json:",omitempty"`
+	Architectures []string             `json:",omitempty"`
+}
+
+// iamRoleResource is the AWS::IAM::Role resource extended with the
+// PermissionsBoundary property, which the vendored go-cloudformation
+// definition doesn't yet include
+type iamRoleResource struct {
+	gocf.IAMRole
+	PermissionsBoundary *gocf.StringExpr `json:",omitempty"`
+}
+
+// lambdaFunctionImageCode is the Code property for container image
+// packaged functions
+type lambdaFunctionImageCode struct {
+	ImageURI *gocf.StringExpr `json:"ImageUri"`
+}
+
+// lambdaURLResource is the AWS::Lambda::Url resource, which isn't
+// yet defined by the vendored go-cloudformation package
+type lambdaURLResource struct {
+	AuthType          string
+	InvokeMode        string
+	TargetFunctionArn *gocf.StringExpr
+}
+
+// CfnResourceType returns the CloudFormation resource type
+func (resource lambdaURLResource) CfnResourceType() string {
+	return "AWS::Lambda::Url"
+}
+
+// CfnResourceAttributes returns the resource attributes available to Fn::GetAtt
+func (resource lambdaURLResource) CfnResourceAttributes() []string {
+	return []string{"FunctionArn", "FunctionUrl"}
+}
+
+// lambdaURLPermissionResource extends the generated gocf.LambdaPermission
+// with the FunctionUrlAuthType property
+type lambdaURLPermissionResource struct {
+	gocf.LambdaPermission
+	FunctionURLAuthType string `json:"FunctionUrlAuthType,omitempty"`
+}
+
+// END - LambdaFunctionResource
+////////////////////////////////////////////////////////////////////////////////
+
 ////////////////////////////////////////////////////////////////////////////////
 // START - LambdaAWSInfo
 
@@ -649,9 +964,10 @@ type LambdaAWSInfo struct {
 	lambdaFn LambdaFunction
 	// The user supplied internal name
 	userSuppliedFunctionName string
-	// Role name (NOT ARN) to use during AWS Lambda Execution.  See
+	// Role name or ARN to use during AWS Lambda Execution.  See
 	// the FunctionConfiguration (http://docs.aws.amazon.com/lambda/latest/dg/API_FunctionConfiguration.html)
-	// docs for more info.
+	// docs for more info. Role names may include the role path
+	// (eg, `service-role/MyRole`). Role ARNs are used without verification.
 	// Note that either `RoleName` or `RoleDefinition` must be supplied
 	RoleName string
 	// IAM Role Definition if the stack should implicitly create an IAM role for
@@ -673,9 +989,17 @@ type LambdaAWSInfo struct {
 	// Template decorator. If defined, the decorator will be called to insert additional
 	// resources on behalf of this lambda function
 	Decorator TemplateDecorator
+	// Function resource decorators. If non empty, the decorators will be
+	// called, in order, to mutate the generated AWS::Lambda::Function
+	// resource after the template decorators
+	ResourceDecorators []LambdaResourceDecorator
 	// Optional array of infrastructure resource logical names, typically
 	// defined by a TemplateDecorator, that this lambda depends on
 	DependsOn []string
+	// Optional directory of JSON test event fixtures. Each `<name>.json`
+	// file defines a fixture named `<name>` that the explore command offers
+	// as an event input for this function
+	TestEventFixturesDirectory string
 	// Slice of customResourceInfo pointers for any associated CloudFormation
 	// CustomResources associated with this lambda
 	customResources []*customResourceInfo
@@ -683,6 +1007,35 @@ type LambdaAWSInfo struct {
 	cachedLambdaFunctionName string
 }
 
+// TestEventFixtures returns the named JSON test event payloads in the
+// TestEventFixturesDirectory, keyed by the fixture name. The fixtures can be
+// reused as regression test inputs for the function's handler.
+func (info *LambdaAWSInfo) TestEventFixtures() (map[string]json.RawMessage, error) {
+	fixtures := make(map[string]json.RawMessage)
+	if info.TestEventFixturesDirectory == "" {
+		return fixtures, nil
+	}
+	fixturePaths, fixturePathsErr := filepath.Glob(filepath.Join(info.TestEventFixturesDirectory, "*.json"))
+	if fixturePathsErr != nil {
+		return nil, errors.Wrapf(fixturePathsErr,
+			"Failed to find test event fixtures in directory: %s",
+			info.TestEventFixturesDirectory)
+	}
+	for _, eachPath := range fixturePaths {
+		/* #nosec */
+		fixtureData, fixtureDataErr := ioutil.ReadFile(eachPath)
+		if fixtureDataErr != nil {
+			return nil, errors.Wrapf(fixtureDataErr, "Failed to read test event fixture: %s", eachPath)
+		}
+		if !json.Valid(fixtureData) {
+			return nil, errors.Errorf("Test event fixture is not valid JSON: %s", eachPath)
+		}
+		fixtureName := strings.TrimSuffix(filepath.Base(eachPath), filepath.Ext(eachPath))
+		fixtures[fixtureName] = json.RawMessage(fixtureData)
+	}
+	return fixtures, nil
+}
+
 // lambdaFunctionName returns the internal
 // function name for lambda export binding
 func (info *LambdaAWSInfo) lambdaFunctionName() string {
@@ -837,17 +1190,53 @@ func (info *LambdaAWSInfo) applyDecorators(template *gocf.Template,
 			safeMetadataInsert(cfResource, info.LogicalResourceName(), metadataMap)
 		}
 		// Append the custom resources
-		safeMergeErrs := gocc.SafeMerge(decoratorProxyTemplate,
-			template)
-		if len(safeMergeErrs) != 0 {
-			return errors.Errorf("Lambda (%s) decorator created conflicting resources: %v",
-				info.lambdaFunctionName(),
-				safeMergeErrs)
+		mergeErr := safeMergeTemplates(decoratorProxyTemplate, template)
+		if mergeErr != nil {
+			return errors.Wrapf(mergeErr, "Lambda (%s) decorator created conflicting resources",
+				info.lambdaFunctionName())
 		}
 	}
 	return nil
 }
 
+// exportFunctionURL adds the AWS::Lambda::Url resource for the functionAttr
+// function and its `<LogicalResourceName>FunctionURL` Output to the template.
+// Public (FunctionURLAuthTypeNone) URLs also get the lambda:InvokeFunctionUrl
+// permission.
+func (info *LambdaAWSInfo) exportFunctionURL(functionAttr *gocf.StringExpr,
+	template *gocf.Template) {
+	functionURL := info.Options.FunctionURL
+	authType := functionURL.AuthType
+	if authType == "" {
+		authType = FunctionURLAuthTypeIAM
+	}
+	invokeMode := functionURL.InvokeMode
+	if invokeMode == "" {
+		invokeMode = FunctionURLInvokeModeBuffered
+	}
+	urlResourceName := fmt.Sprintf("%sURL", info.LogicalResourceName())
+	template.AddResource(urlResourceName, lambdaURLResource{
+		AuthType:          authType,
+		InvokeMode:        invokeMode,
+		TargetFunctionArn: functionAttr,
+	})
+	if authType == FunctionURLAuthTypeNone {
+		permissionResourceName := fmt.Sprintf("%sURLPermission", info.LogicalResourceName())
+		template.AddResource(permissionResourceName, lambdaURLPermissionResource{
+			LambdaPermission: gocf.LambdaPermission{
+				Action:       gocf.String("lambda:InvokeFunctionUrl"),
+				FunctionName: functionAttr,
+				Principal:    gocf.String("*"),
+			},
+			FunctionURLAuthType: FunctionURLAuthTypeNone,
+		})
+	}
+	template.Outputs[fmt.Sprintf("%sFunctionURL", info.LogicalResourceName())] = &gocf.Output{
+		Description: fmt.Sprintf("Function URL for %s", info.lambdaFunctionName()),
+		Value:       gocf.GetAtt(urlResourceName, "FunctionUrl"),
+	}
+}
+
 // Marshal this object into 1 or more CloudFormation resource definitions that are accumulated
 // in the resources map
 func (info *LambdaAWSInfo) export(serviceName string,
@@ -892,17 +1281,17 @@ func (info *LambdaAWSInfo) export(serviceName string,
 		},
 		Description: gocf.String(lambdaDescription),
 		Handler:     gocf.String(binaryName),
-		MemorySize:  gocf.Integer(info.Options.MemorySize),
+		MemorySize:  optionalInteger(info.Options.MemorySize),
 		Role:        roleNameMap[iamRoleArnName],
 		Runtime:     gocf.String(GoLambdaVersion),
-		Timeout:     gocf.Integer(info.Options.Timeout),
+		Timeout:     optionalInteger(info.Options.Timeout),
 		VPCConfig:   info.Options.VpcConfig,
 	}
 	if "" != S3Version {
 		lambdaResource.Code.S3ObjectVersion = gocf.String(S3Version)
 	}
-	if info.Options.ReservedConcurrentExecutions != 0 {
-		lambdaResource.ReservedConcurrentExecutions = gocf.Integer(info.Options.ReservedConcurrentExecutions)
+	if info.Options.ReservedConcurrentExecutions != nil {
+		lambdaResource.ReservedConcurrentExecutions = gocf.Integer(*info.Options.ReservedConcurrentExecutions)
 	}
 	if info.Options.DeadLetterConfigArn != nil {
 		lambdaResource.DeadLetterConfig = &gocf.LambdaFunctionDeadLetterConfig{
@@ -915,12 +1304,26 @@ func (info *LambdaAWSInfo) export(serviceName string,
 	if "" != info.Options.KmsKeyArn {
 		lambdaResource.KmsKeyArn = gocf.String(info.Options.KmsKeyArn)
 	}
+	if len(info.Options.KmsEncryptionContext) != 0 && "" == info.Options.KmsKeyArn {
+		return errors.Errorf("KmsEncryptionContext requires a KmsKeyArn for Lambda: %s",
+			info.lambdaFunctionName())
+	}
+	recursiveLoop := info.Options.RecursiveLoop
+	if recursiveLoop == "" {
+		recursiveLoop = RecursiveLoopTerminate
+	}
 	if nil != info.Options.Tags {
+		// Sorted so that the template is stable across provisions
+		tagKeys := make([]string, 0, len(info.Options.Tags))
+		for eachKey := range info.Options.Tags {
+			tagKeys = append(tagKeys, eachKey)
+		}
+		sort.Strings(tagKeys)
 		tagList := gocf.TagList{}
-		for eachKey, eachValue := range info.Options.Tags {
+		for _, eachKey := range tagKeys {
 			tagList = append(tagList, gocf.Tag{
 				Key:   gocf.String(eachKey),
-				Value: gocf.String(eachValue),
+				Value: gocf.String(info.Options.Tags[eachKey]),
 			})
 		}
 		lambdaResource.Tags = &tagList
@@ -935,6 +1338,14 @@ func (info *LambdaAWSInfo) export(serviceName string,
 	}
 	info.Options.Environment[envVarLogLevel] =
 		gocf.String(logger.Level.String())
+	if len(info.Options.KmsEncryptionContext) != 0 {
+		encryptionContext, encryptionContextErr := json.Marshal(info.Options.KmsEncryptionContext)
+		if encryptionContextErr != nil {
+			return errors.Wrapf(encryptionContextErr, "Failed to marshal KmsEncryptionContext")
+		}
+		info.Options.Environment[EnvVarKMSEncryptionContext] =
+			gocf.String(string(encryptionContext))
+	}
 
 	lambdaResource.Environment = &gocf.LambdaFunctionEnvironment{
 		Variables: info.Options.Environment,
@@ -947,13 +1358,36 @@ func (info *LambdaAWSInfo) export(serviceName string,
 	lambdaFunctionName := awsLambdaFunctionName(info.lambdaFunctionName())
 	lambdaResource.FunctionName = lambdaFunctionName.String()
 
-	cfResource := template.AddResource(info.LogicalResourceName(), lambdaResource)
+	// Container image or ZIP archive?
+	var functionCode interface{} = lambdaResource.Code
+	packageType := ""
+	if "" != info.Options.ImageURI {
+		functionCode = &lambdaFunctionImageCode{
+			ImageURI: gocf.String(info.Options.ImageURI),
+		}
+		packageType = "Image"
+		// Image functions define the runtime and entrypoint in the image
+		lambdaResource.Handler = nil
+		lambdaResource.Runtime = nil
+	}
+	cfResource := template.AddResource(info.LogicalResourceName(), LambdaFunctionResource{
+		LambdaFunction: lambdaResource,
+		Code:           functionCode,
+		PackageType:    packageType,
+		LoggingConfig:  info.Options.LoggingConfig,
+		RecursiveLoop:  recursiveLoop,
+	})
 	cfResource.DependsOn = append(cfResource.DependsOn, dependsOn...)
 	safeMetadataInsert(cfResource, "golangFunc", info.lambdaFunctionName())
 
 	// Create the lambda Ref in case we need a permission or event mapping
 	functionAttr := gocf.GetAtt(info.LogicalResourceName(), "Arn")
 
+	// Function URL
+	if nil != info.Options.FunctionURL {
+		info.exportFunctionURL(functionAttr, template)
+	}
+
 	// Permissions
 	for _, eachPermission := range info.Permissions {
 		_, err := eachPermission.export(serviceName,
@@ -1008,8 +1442,40 @@ func (info *LambdaAWSInfo) export(serviceName string,
 		buildID,
 		context,
 		logger)
+	if decoratorErr != nil {
+		return decoratorErr
+	}
+	return info.applyResourceDecorators(cfResource, template, logger)
+}
+
+// applyResourceDecorators calls the ResourceDecorators with the function
+// properties and writes the mutated properties back to the resource
+func (info *LambdaAWSInfo) applyResourceDecorators(cfResource *gocf.Resource,
+	template *gocf.Template,
+	logger *logrus.Logger) error {
 
-	return decoratorErr
+	if len(info.ResourceDecorators) == 0 {
+		return nil
+	}
+	functionResource, functionResourceOk := cfResource.Properties.(LambdaFunctionResource)
+	if !functionResourceOk {
+		return errors.Errorf("Lambda (%s) resource has unexpected properties type: %T",
+			info.lambdaFunctionName(),
+			cfResource.Properties)
+	}
+	for _, eachDecorator := range info.ResourceDecorators {
+		decoratorErr := eachDecorator(&functionResource.LambdaFunction,
+			cfResource,
+			template,
+			logger)
+		if decoratorErr != nil {
+			return errors.Wrapf(decoratorErr,
+				"Lambda (%s) resource decorator failed",
+				info.lambdaFunctionName())
+		}
+	}
+	cfResource.Properties = functionResource
+	return nil
 }
 
 //
@@ -1021,6 +1487,153 @@ func (info *LambdaAWSInfo) export(serviceName string,
 // BEGIN - Private
 //
 
+// deadLetterTargetStatement returns the policy statement that allows the
+// function to deliver failed events to the dead letter target. If the target
+// isn't a literal ARN, the service can't be determined until the stack is
+// created, so both SQS and SNS delivery are allowed to that target.
+func deadLetterTargetStatement(target gocf.Stringable) spartaIAM.PolicyStatement {
+	targetExpr := target.String()
+	actions := []string{"sqs:SendMessage", "sns:Publish"}
+	// arn:partition:service:region:account-id:resource
+	arnParts := strings.SplitN(targetExpr.Literal, ":", 4)
+	if len(arnParts) == 4 {
+		switch arnParts[2] {
+		case "sqs":
+			actions = []string{"sqs:SendMessage"}
+		case "sns":
+			actions = []string{"sns:Publish"}
+		}
+	}
+	return spartaIAM.PolicyStatement{
+		Effect:   "Allow",
+		Action:   actions,
+		Resource: targetExpr,
+	}
+}
+
+// optionalInteger returns nil for a zero value so that the property is
+// omitted and the AWS default is used
+func optionalInteger(value int64) *gocf.IntegerExpr {
+	if value == 0 {
+		return nil
+	}
+	return gocf.Integer(value)
+}
+
+// validateFunctionLimits ensures that the MemorySize and Timeout options
+// are within the AWS Lambda limits. Zero values use the AWS defaults.
+func validateFunctionLimits(functionName string, options *LambdaFunctionOptions) error {
+	if options == nil {
+		return nil
+	}
+	if options.MemorySize != 0 &&
+		(options.MemorySize < minLambdaMemorySize || options.MemorySize > maxLambdaMemorySize) {
+		return errors.Errorf("Invalid MemorySize for Lambda %s: %d MB. MemorySize must be between %d and %d MB",
+			functionName,
+			options.MemorySize,
+			minLambdaMemorySize,
+			maxLambdaMemorySize)
+	}
+	if options.Timeout < 0 || options.Timeout > maxLambdaTimeout {
+		return errors.Errorf("Invalid Timeout for Lambda %s: %d seconds. Timeout must be between 1 and %d seconds",
+			functionName,
+			options.Timeout,
+			maxLambdaTimeout)
+	}
+	if options.ReservedConcurrentExecutions != nil &&
+		*options.ReservedConcurrentExecutions < 0 {
+		return errors.Errorf("Invalid ReservedConcurrentExecutions for Lambda %s: %d. ReservedConcurrentExecutions must not be negative",
+			functionName,
+			*options.ReservedConcurrentExecutions)
+	}
+	return nil
+}
+
+// validateFunctionOptions ensures that the enumerated LambdaFunctionOptions
+// values are supported, so that they're rejected before the service binary
+// is built
+func validateFunctionOptions(functionName string, options *LambdaFunctionOptions) error {
+	if options == nil {
+		return nil
+	}
+	if nil != options.LoggingConfig {
+		loggingConfigErr := options.LoggingConfig.validate()
+		if loggingConfigErr != nil {
+			return errors.Wrapf(loggingConfigErr,
+				"Invalid LoggingConfig for Lambda: %s",
+				functionName)
+		}
+	}
+	if nil != options.FunctionURL {
+		functionURLErr := options.FunctionURL.validate()
+		if functionURLErr != nil {
+			return errors.Wrapf(functionURLErr,
+				"Invalid FunctionURL for Lambda: %s",
+				functionName)
+		}
+	}
+	switch options.RecursiveLoop {
+	case "", RecursiveLoopTerminate, RecursiveLoopAllow:
+		// NOP
+	default:
+		return errors.Errorf("Invalid RecursiveLoop value (%s) for Lambda: %s",
+			options.RecursiveLoop,
+			functionName)
+	}
+	return nil
+}
+
+// validateResponseStreaming ensures that functions with a
+// FunctionURLInvokeModeResponseStream function URL run in a runtime that can
+// stream responses. The lambdaRuntime is the WithLambdaRuntime value, which
+// defaults to GoLambdaVersion. Only the provided runtimes and container
+// images (ImageURI) support response streaming.
+func validateResponseStreaming(lambdaAWSInfos []*LambdaAWSInfo, lambdaRuntime string) error {
+	if lambdaRuntime == "" {
+		lambdaRuntime = GoLambdaVersion
+	}
+	var errorText []string
+	for _, eachLambda := range lambdaAWSInfos {
+		options := eachLambda.Options
+		if options == nil ||
+			options.FunctionURL == nil ||
+			options.FunctionURL.InvokeMode != FunctionURLInvokeModeResponseStream ||
+			options.ImageURI != "" {
+			continue
+		}
+		switch lambdaRuntime {
+		case LambdaRuntimeProvidedAL2, LambdaRuntimeProvidedAL2023:
+			// NOP
+		default:
+			errorText = append(errorText,
+				fmt.Sprintf("FunctionURL.InvokeMode %s for Lambda %s isn't supported by the %s runtime. Use WithLambdaRuntime with a provided runtime or an ImageURI",
+					FunctionURLInvokeModeResponseStream,
+					eachLambda.lambdaFunctionName(),
+					lambdaRuntime))
+		}
+	}
+	if len(errorText) != 0 {
+		return errors.New(strings.Join(errorText, "\n"))
+	}
+	return nil
+}
+
+// validateServiceName ensures that the serviceName is a valid
+// CloudFormation stack name
+func validateServiceName(serviceName string) error {
+	if len(serviceName) > maxStackNameLength {
+		return errors.Errorf("Invalid service name %q: stack names must be at most %d characters (found %d)",
+			serviceName,
+			maxStackNameLength,
+			len(serviceName))
+	}
+	if !reStackName.MatchString(serviceName) {
+		return errors.Errorf("Invalid service name %q: stack names must start with a letter and contain only alphanumeric characters and hyphens",
+			serviceName)
+	}
+	return nil
+}
+
 func validateSpartaPreconditions(lambdaAWSInfos []*LambdaAWSInfo,
 	logger *logrus.Logger) error {
 
@@ -1043,6 +1656,43 @@ func validateSpartaPreconditions(lambdaAWSInfos []*LambdaAWSInfo,
 			errorText = append(errorText, validationErr.Error())
 		}
 	}
+	// 0.5 - check the function memory and timeout limits and options
+	for _, eachLambda := range lambdaAWSInfos {
+		limitsErr := validateFunctionLimits(eachLambda.lambdaFunctionName(), eachLambda.Options)
+		if limitsErr != nil {
+			errorText = append(errorText, limitsErr.Error())
+		}
+		optionsErr := validateFunctionOptions(eachLambda.lambdaFunctionName(), eachLambda.Options)
+		if optionsErr != nil {
+			errorText = append(errorText, optionsErr.Error())
+		}
+		for _, eachCustom := range eachLambda.customResources {
+			limitsErr := validateFunctionLimits(eachCustom.userFunctionName, eachCustom.options)
+			if limitsErr != nil {
+				errorText = append(errorText, limitsErr.Error())
+			}
+		}
+	}
+
+	// 0.75 - check the IAM role definitions
+	for _, eachLambda := range lambdaAWSInfos {
+		if eachLambda.RoleDefinition != nil {
+			roleErr := eachLambda.RoleDefinition.validate()
+			if roleErr != nil {
+				errorText = append(errorText,
+					fmt.Sprintf("%s: %s", eachLambda.lambdaFunctionName(), roleErr))
+			}
+		}
+		for _, eachCustom := range eachLambda.customResources {
+			if eachCustom.roleDefinition != nil {
+				roleErr := eachCustom.roleDefinition.validate()
+				if roleErr != nil {
+					errorText = append(errorText,
+						fmt.Sprintf("%s: %s", eachCustom.userFunctionName, roleErr))
+				}
+			}
+		}
+	}
 
 	// 1 - check for duplicate golang function references.
 	for _, eachLambda := range lambdaAWSInfos {
@@ -1051,15 +1701,23 @@ func validateSpartaPreconditions(lambdaAWSInfos []*LambdaAWSInfo,
 			incrementCounter(eachCustom.userFunctionName)
 		}
 	}
-	// Duplicates?
-	for eachLambdaName, eachCount := range collisionMemo {
+	// Duplicates? Sort the names so that the error is stable across runs
+	collisionNames := make([]string, 0, len(collisionMemo))
+	for eachLambdaName := range collisionMemo {
+		collisionNames = append(collisionNames, eachLambdaName)
+	}
+	sort.Strings(collisionNames)
+	for _, eachLambdaName := range collisionNames {
+		eachCount := collisionMemo[eachLambdaName]
 		if eachCount > 1 {
 			logger.WithFields(logrus.Fields{
 				"CollisionCount": eachCount,
 				"Name":           eachLambdaName,
 			}).Error("HandleAWSLambda")
 			errorText = append(errorText,
-				fmt.Sprintf("Multiple definitions of lambda: %s", eachLambdaName))
+				fmt.Sprintf("Multiple definitions of lambda: %s (%d definitions). Use SpartaOptions.Name to provide a unique name",
+					eachLambdaName,
+					eachCount))
 		}
 	}
 	logger.WithFields(logrus.Fields{
@@ -1113,6 +1771,28 @@ func sanitizedName(input string) string {
 // Public
 ////////////////////////////////////////////////////////////////////////////////
 
+// KMSEncryptionContext returns the LambdaFunctionOptions.KmsEncryptionContext
+// value for the currently executing function, in the form expected by the
+// kms.DecryptInput EncryptionContext field. It returns an empty map if the
+// function doesn't define an encryption context.
+func KMSEncryptionContext() (map[string]*string, error) {
+	encryptionContext := make(map[string]*string)
+	encodedContext := os.Getenv(EnvVarKMSEncryptionContext)
+	if encodedContext == "" {
+		return encryptionContext, nil
+	}
+	decodedContext := make(map[string]string)
+	unmarshalErr := json.Unmarshal([]byte(encodedContext), &decodedContext)
+	if unmarshalErr != nil {
+		return nil, errors.Wrapf(unmarshalErr, "Failed to parse %s", EnvVarKMSEncryptionContext)
+	}
+	for eachKey, eachValue := range decodedContext {
+		value := eachValue
+		encryptionContext[eachKey] = &value
+	}
+	return encryptionContext, nil
+}
+
 // CloudFormationResourceName returns a name suitable as a logical
 // CloudFormation resource value.  See http://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/resources-section-structure.html
 // for more information.  The `prefix` value should provide a hint as to the
@@ -78,10 +78,13 @@ var CommandLineOptions = struct {
 // Provision options
 // Ref: http://docs.aws.amazon.com/AmazonS3/latest/dev/BucketRestrictions.html
 type optionsProvisionStruct struct {
-	S3Bucket        string `validate:"required"`
-	BuildID         string `validate:"-"` // non-whitespace
-	PipelineTrigger string `validate:"-"`
-	InPlace         bool   `validate:"-"`
+	S3Bucket          string `validate:"required"`
+	BuildID           string `validate:"-"` // non-whitespace
+	PipelineTrigger   string `validate:"-"`
+	InPlace           bool   `validate:"-"`
+	Diff              bool   `validate:"-"`
+	ForceBuild        bool   `validate:"-"`
+	PreserveArtifacts bool   `validate:"-"`
 }
 
 var optionsProvision optionsProvisionStruct
@@ -131,6 +134,7 @@ func provisionBuildID(userSuppliedValue string, logger *logrus.Logger) (string,
 type optionsDescribeStruct struct {
 	OutputFile string `validate:"required"`
 	S3Bucket   string `validate:"required"`
+	Graphviz   bool   `validate:"-"`
 }
 
 var optionsDescribe optionsDescribeStruct
@@ -227,6 +231,19 @@ func init() {
 		"c",
 		false,
 		"If the provision operation results in *only* function updates, bypass CloudFormation")
+	CommandLineOptions.Provision.Flags().BoolVarP(&optionsProvision.Diff,
+		"diff",
+		"d",
+		false,
+		"For -noop provisions, log the resource-level diff against the deployed stack template")
+	CommandLineOptions.Provision.Flags().BoolVar(&optionsProvision.ForceBuild,
+		"forceBuild",
+		false,
+		"Compile the service binary even if a cached binary with the same build inputs exists")
+	CommandLineOptions.Provision.Flags().BoolVar(&optionsProvision.PreserveArtifacts,
+		"preserveArtifacts",
+		false,
+		"Keep the local binary and archives rather than deleting them after the provision")
 
 	// Delete
 	CommandLineOptions.Delete = &cobra.Command{
@@ -258,6 +275,11 @@ func init() {
 		"s",
 		"",
 		"S3 Bucket to use for Lambda source")
+	CommandLineOptions.Describe.Flags().BoolVarP(&optionsDescribe.Graphviz,
+		"graphviz",
+		"g",
+		false,
+		"Produce a Graphviz DOT description of the service resources")
 
 	// Explore
 	CommandLineOptions.Explore = &cobra.Command{
@@ -6,6 +6,7 @@ package sparta
 // in the Lambda context
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -46,7 +47,8 @@ func MainEx(serviceName string,
 	api *API,
 	site *S3Site,
 	workflowHooks *WorkflowHooks,
-	useCGO bool) error {
+	useCGO bool,
+	options ...ProvisionOption) error {
 
 	// It's possible the user attached a custom command to the
 	// root command. If there is no command, then just run the
@@ -89,6 +91,21 @@ func Delete(serviceName string, logger *logrus.Logger) error {
 	return errors.New("Delete not supported for this binary")
 }
 
+// TailLogs is not available in the AWS Lambda binary
+func TailLogs(serviceName string, functionName string, logger *logrus.Logger) error {
+	logger.Error("TailLogs() not supported in AWS Lambda binary")
+	return errors.New("TailLogs not supported for this binary")
+}
+
+// TailLogsWithContext is not available in the AWS Lambda binary
+func TailLogsWithContext(ctx context.Context,
+	serviceName string,
+	functionName string,
+	logger *logrus.Logger) error {
+	logger.Error("TailLogsWithContext() not supported in AWS Lambda binary")
+	return errors.New("TailLogsWithContext not supported for this binary")
+}
+
 // Provision is not available in the AWS Lambda binary
 func Provision(noop bool,
 	serviceName string,
@@ -105,11 +122,35 @@ func Provision(noop bool,
 	linkerFlags string,
 	writer io.Writer,
 	workflowHooks *WorkflowHooks,
-	logger *logrus.Logger) error {
+	logger *logrus.Logger,
+	options ...ProvisionOption) error {
 	logger.Error("Provision() not supported in AWS Lambda binary")
 	return errors.New("Provision not supported for this binary")
 }
 
+// ProvisionWithContext is not available in the AWS Lambda binary
+func ProvisionWithContext(ctx context.Context,
+	noop bool,
+	serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api *API,
+	site *S3Site,
+	s3Bucket string,
+	useCGO bool,
+	inplace bool,
+	buildID string,
+	codePipelineTrigger string,
+	buildTags string,
+	linkerFlags string,
+	writer io.Writer,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger,
+	options ...ProvisionOption) error {
+	logger.Error("ProvisionWithContext() not supported in AWS Lambda binary")
+	return errors.New("ProvisionWithContext not supported for this binary")
+}
+
 // Describe is not available in the AWS Lambda binary
 func Describe(serviceName string,
 	serviceDescription string,
@@ -126,6 +167,22 @@ func Describe(serviceName string,
 	return errors.New("Describe not supported for this binary")
 }
 
+// DescribeGraphviz is not available in the AWS Lambda binary
+func DescribeGraphviz(serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api *API,
+	site *S3Site,
+	s3BucketName string,
+	buildTags string,
+	linkerFlags string,
+	outputWriter io.Writer,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) error {
+	logger.Error("DescribeGraphviz() not supported in AWS Lambda binary")
+	return errors.New("DescribeGraphviz not supported for this binary")
+}
+
 // Explore is an interactive command that brings up a GUI to test
 // lambda functions previously deployed into AWS lambda. It's not supported in the
 // AWS binary build
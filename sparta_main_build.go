@@ -3,9 +3,11 @@
 package sparta
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"time"
 
@@ -74,14 +76,16 @@ func Main(serviceName string, serviceDescription string, lambdaAWSInfos []*Lambd
 }
 
 // MainEx provides an "extended" Main that supports customizing the standard Sparta
-// workflow via the `workflowHooks` parameter.
+// workflow via the `workflowHooks` parameter. The optional ProvisionOption values
+// are applied to the `provision` command.
 func MainEx(serviceName string,
 	serviceDescription string,
 	lambdaAWSInfos []*LambdaAWSInfo,
 	api *API,
 	site *S3Site,
 	workflowHooks *WorkflowHooks,
-	useCGO bool) error {
+	useCGO bool,
+	options ...ProvisionOption) error {
 	//////////////////////////////////////////////////////////////////////////////
 	// cmdRoot defines the root, non-executable command
 	CommandLineOptions.Root.Short = fmt.Sprintf("%s - Sparta v.%s powered AWS Lambda Microservice",
@@ -159,7 +163,33 @@ func MainEx(serviceName string,
 			}
 			// Save the BuildID
 			StampedBuildID = buildID
-			return Provision(OptionsGlobal.Noop,
+			provisionOptions := append([]ProvisionOption{}, options...)
+			if optionsProvision.Diff {
+				provisionOptions = append(provisionOptions, WithProvisionDryRunDiff())
+			}
+			if optionsProvision.ForceBuild {
+				provisionOptions = append(provisionOptions, WithForceBuild())
+			}
+			if optionsProvision.PreserveArtifacts {
+				provisionOptions = append(provisionOptions, WithPreserveArtifacts())
+			}
+			// Cancel the workflow on interrupt so that the rollback
+			// functions are invoked
+			provisionCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			interruptChan := make(chan os.Signal, 1)
+			signal.Notify(interruptChan, os.Interrupt)
+			defer signal.Stop(interruptChan)
+			go func() {
+				select {
+				case <-interruptChan:
+					OptionsGlobal.Logger.Warn("Interrupt received. Canceling provision")
+					cancel()
+				case <-provisionCtx.Done():
+				}
+			}()
+			return ProvisionWithContext(provisionCtx,
+				OptionsGlobal.Noop,
 				serviceName,
 				serviceDescription,
 				lambdaAWSInfos,
@@ -174,7 +204,8 @@ func MainEx(serviceName string,
 				OptionsGlobal.LinkerFlags,
 				nil,
 				workflowHooks,
-				OptionsGlobal.Logger)
+				OptionsGlobal.Logger,
+				provisionOptions...)
 		}
 	}
 	CommandLineOptions.Root.AddCommand(CommandLineOptions.Provision)
@@ -217,7 +248,11 @@ func MainEx(serviceName string,
 				return fileWriterErr
 			}
 			defer fileWriter.Close()
-			describeErr := Describe(serviceName,
+			describer := Describe
+			if optionsDescribe.Graphviz {
+				describer = DescribeGraphviz
+			}
+			describeErr := describer(serviceName,
 				serviceDescription,
 				lambdaAWSInfos,
 				api,
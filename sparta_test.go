@@ -5,9 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	spartaCFResources "github.com/mweagle/Sparta/aws/cloudformation/resources"
 	gocf "github.com/mweagle/go-cloudformation"
 )
@@ -268,6 +272,28 @@ func TestUserDefinedOverlappingLambdaNames(t *testing.T) {
 	}
 }
 
+func TestValidateOverlappingLambdaNames(t *testing.T) {
+	logger, _ := NewLogger("info")
+
+	lambdaFunctions := testLambdaDoubleStructPtrData()
+	for _, eachLambda := range lambdaFunctions {
+		eachLambda.Options = &LambdaFunctionOptions{
+			SpartaOptions: &SpartaOptions{
+				Name: "HandlerX",
+			},
+		}
+	}
+	err := validateSpartaPreconditions(lambdaFunctions, logger)
+	if err == nil {
+		t.Fatal("Failed to reject duplicate lambda names")
+	}
+	expected := fmt.Sprintf("Multiple definitions of lambda: HandlerX (%d definitions)",
+		len(lambdaFunctions))
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("Expected collision error to contain %q, got: %s", expected, err)
+	}
+}
+
 func invalidFuncSignature(ctx context.Context) string {
 	return "Hello World!"
 }
@@ -306,6 +332,195 @@ func TestInvalidFunctionSignature(t *testing.T) {
 	}
 }
 
+func TestValidateServiceName(t *testing.T) {
+	validNames := []string{"MyService", "my-service-1", strings.Repeat("a", 128)}
+	for _, eachName := range validNames {
+		if err := validateServiceName(eachName); err != nil {
+			t.Errorf("Failed to accept valid service name %s: %s", eachName, err)
+		}
+	}
+	invalidNames := []string{"", "1Service", "my_service", "my.service", strings.Repeat("a", 129)}
+	for _, eachName := range invalidNames {
+		if err := validateServiceName(eachName); err == nil {
+			t.Errorf("Failed to reject invalid service name: %s", eachName)
+		}
+	}
+}
+
+func TestValidateFunctionLimits(t *testing.T) {
+	validOptions := []*LambdaFunctionOptions{
+		nil,
+		{},
+		{MemorySize: 128, Timeout: 1},
+		{MemorySize: 1769, Timeout: 900},
+		{MemorySize: 10240},
+		{ReservedConcurrentExecutions: aws.Int64(10)},
+		{ReservedConcurrentExecutions: aws.Int64(0)},
+	}
+	for _, eachOptions := range validOptions {
+		if err := validateFunctionLimits("ValidLimits", eachOptions); err != nil {
+			t.Errorf("Failed to accept valid function limits: %s", err)
+		}
+	}
+	invalidOptions := []*LambdaFunctionOptions{
+		{MemorySize: 64},
+		{MemorySize: 10241},
+		{Timeout: -1},
+		{Timeout: 901},
+		{ReservedConcurrentExecutions: aws.Int64(-1)},
+	}
+	for _, eachOptions := range invalidOptions {
+		if err := validateFunctionLimits("InvalidLimits", eachOptions); err == nil {
+			t.Errorf("Failed to reject invalid function limits: %#v", eachOptions)
+		}
+	}
+}
+
+func TestValidateLoggingConfig(t *testing.T) {
+	testCases := []struct {
+		loggingConfig *LambdaLoggingConfig
+		valid         bool
+	}{
+		{&LambdaLoggingConfig{}, true},
+		{&LambdaLoggingConfig{LogFormat: "Text"}, true},
+		{&LambdaLoggingConfig{LogFormat: "JSON"}, true},
+		{&LambdaLoggingConfig{LogFormat: "JSON", ApplicationLogLevel: "TRACE", SystemLogLevel: "WARN"}, true},
+		{&LambdaLoggingConfig{LogFormat: "XML"}, false},
+		{&LambdaLoggingConfig{LogFormat: "json"}, false},
+		{&LambdaLoggingConfig{LogFormat: "Text", ApplicationLogLevel: "INFO"}, false},
+		{&LambdaLoggingConfig{SystemLogLevel: "DEBUG"}, false},
+		{&LambdaLoggingConfig{LogFormat: "JSON", ApplicationLogLevel: "VERBOSE"}, false},
+		{&LambdaLoggingConfig{LogFormat: "JSON", SystemLogLevel: "ERROR"}, false},
+	}
+	for _, eachTestCase := range testCases {
+		validateErr := validateFunctionOptions("LoggingConfig",
+			&LambdaFunctionOptions{LoggingConfig: eachTestCase.loggingConfig})
+		if eachTestCase.valid && validateErr != nil {
+			t.Errorf("Failed to accept valid LoggingConfig %#v: %s", eachTestCase.loggingConfig, validateErr)
+		} else if !eachTestCase.valid && validateErr == nil {
+			t.Errorf("Failed to reject invalid LoggingConfig: %#v", eachTestCase.loggingConfig)
+		}
+	}
+
+	// Invalid values are rejected before the service is built
+	logger, _ := NewLogger("info")
+	lambdaFunctions := testLambdaData()
+	lambdaFunctions[0].Options.LoggingConfig = &LambdaLoggingConfig{LogFormat: "XML"}
+	preconditionsErr := validateSpartaPreconditions(lambdaFunctions, logger)
+	if preconditionsErr == nil || !strings.Contains(preconditionsErr.Error(), "LoggingConfig.LogFormat") {
+		t.Fatalf("Failed to reject invalid LoggingConfig in preconditions: %v", preconditionsErr)
+	}
+}
+
+func TestValidateResponseStreaming(t *testing.T) {
+	streamingLambdas := func() []*LambdaAWSInfo {
+		lambdaFunctions := testLambdaData()
+		lambdaFunctions[0].Options.FunctionURL = &LambdaFunctionURL{
+			InvokeMode: FunctionURLInvokeModeResponseStream,
+		}
+		return lambdaFunctions
+	}
+	validRuntimes := []string{LambdaRuntimeProvidedAL2, LambdaRuntimeProvidedAL2023}
+	for _, eachRuntime := range validRuntimes {
+		if err := validateResponseStreaming(streamingLambdas(), eachRuntime); err != nil {
+			t.Errorf("Failed to accept response streaming for %s runtime: %s", eachRuntime, err)
+		}
+	}
+	invalidRuntimes := []string{"", GoLambdaVersion}
+	for _, eachRuntime := range invalidRuntimes {
+		err := validateResponseStreaming(streamingLambdas(), eachRuntime)
+		if err == nil || !strings.Contains(err.Error(), GoLambdaVersion) {
+			t.Errorf("Failed to reject response streaming for %q runtime: %v", eachRuntime, err)
+		}
+	}
+	imageLambdas := streamingLambdas()
+	imageLambdas[0].Options.ImageURI = "123456789012.dkr.ecr.us-west-2.amazonaws.com/sparta:latest"
+	if err := validateResponseStreaming(imageLambdas, ""); err != nil {
+		t.Errorf("Failed to accept response streaming for ImageURI: %s", err)
+	}
+}
+
+func TestEventFixtures(t *testing.T) {
+	fixturesDir, fixturesDirErr := ioutil.TempDir("", "sparta-fixtures")
+	if fixturesDirErr != nil {
+		t.Fatalf("Failed to create fixtures directory: %s", fixturesDirErr)
+	}
+	defer os.RemoveAll(fixturesDir)
+
+	fixtureFiles := map[string]string{
+		"created.json": `{"Records":[{"eventName":"ObjectCreated:Put"}]}`,
+		"removed.json": `{"Records":[{"eventName":"ObjectRemoved:Delete"}]}`,
+		"notes.txt":    "not a fixture",
+	}
+	for eachName, eachContents := range fixtureFiles {
+		writeErr := ioutil.WriteFile(filepath.Join(fixturesDir, eachName), []byte(eachContents), 0644)
+		if writeErr != nil {
+			t.Fatalf("Failed to write fixture %s: %s", eachName, writeErr)
+		}
+	}
+	lambdaFunctions := testLambdaData()
+	lambdaFunctions[0].TestEventFixturesDirectory = fixturesDir
+	fixtures, fixturesErr := lambdaFunctions[0].TestEventFixtures()
+	if fixturesErr != nil {
+		t.Fatalf("Failed to load test event fixtures: %s", fixturesErr)
+	}
+	if len(fixtures) != 2 {
+		t.Fatalf("Unexpected fixture count. Expected 2, got %d: %v", len(fixtures), fixtures)
+	}
+	if string(fixtures["created"]) != fixtureFiles["created.json"] {
+		t.Errorf("Unexpected created fixture payload: %s", fixtures["created"])
+	}
+	if string(fixtures["removed"]) != fixtureFiles["removed.json"] {
+		t.Errorf("Unexpected removed fixture payload: %s", fixtures["removed"])
+	}
+
+	// Functions without a fixtures directory have no fixtures
+	emptyFixtures, emptyFixturesErr := lambdaFunctions[1].TestEventFixtures()
+	if emptyFixturesErr != nil || len(emptyFixtures) != 0 {
+		t.Errorf("Unexpected fixtures for function without a directory: %v (%v)",
+			emptyFixtures, emptyFixturesErr)
+	}
+
+	// Invalid JSON is rejected
+	invalidPath := filepath.Join(fixturesDir, "invalid.json")
+	if writeErr := ioutil.WriteFile(invalidPath, []byte("{"), 0644); writeErr != nil {
+		t.Fatalf("Failed to write invalid fixture: %s", writeErr)
+	}
+	_, invalidErr := lambdaFunctions[0].TestEventFixtures()
+	if invalidErr == nil || !strings.Contains(invalidErr.Error(), invalidPath) {
+		t.Errorf("Failed to reject invalid JSON fixture: %v", invalidErr)
+	}
+}
+
+func TestDeadLetterTargetStatement(t *testing.T) {
+	testTargets := map[string]struct {
+		target  gocf.Stringable
+		actions string
+	}{
+		"SQS": {
+			target:  gocf.String("arn:aws:sqs:us-west-2:123456789012:MyDLQ"),
+			actions: "sqs:SendMessage",
+		},
+		"SNS": {
+			target:  gocf.String("arn:aws:sns:us-west-2:123456789012:MyDLQ"),
+			actions: "sns:Publish",
+		},
+		"Reference": {
+			target:  gocf.GetAtt("MyDLQ", "Arn"),
+			actions: "sqs:SendMessage,sns:Publish",
+		},
+	}
+	for eachName, eachTest := range testTargets {
+		statement := deadLetterTargetStatement(eachTest.target)
+		if strings.Join(statement.Action, ",") != eachTest.actions {
+			t.Errorf("%s: expected actions %s, got %v", eachName, eachTest.actions, statement.Action)
+		}
+		if statement.Resource == nil {
+			t.Errorf("%s: missing statement Resource", eachName)
+		}
+	}
+}
+
 func TestNOP(t *testing.T) {
 	template := gocf.NewTemplate()
 	s3Resources := gocf.S3Bucket{
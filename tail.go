@@ -0,0 +1,195 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	spartaCWLogs "github.com/mweagle/Sparta/aws/cloudwatchlogs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// tailLogGroupPollInterval is the delay between checks for a log group
+// that doesn't exist yet
+const tailLogGroupPollInterval = 5 * time.Second
+
+// matchStackFunction returns the physical name of the function in the stack
+// resources that matches functionName. The functionName may be the Sparta
+// function name, the logical resource ID, or the physical function name.
+func matchStackFunction(resources []*cloudformation.StackResourceSummary,
+	functionName string) (string, error) {
+	spartaLogicalName := (&LambdaAWSInfo{
+		userSuppliedFunctionName: functionName,
+	}).LogicalResourceName()
+
+	var functionNames []string
+	for _, eachResource := range resources {
+		if aws.StringValue(eachResource.ResourceType) != "AWS::Lambda::Function" {
+			continue
+		}
+		logicalID := aws.StringValue(eachResource.LogicalResourceId)
+		physicalID := aws.StringValue(eachResource.PhysicalResourceId)
+		if logicalID == spartaLogicalName ||
+			logicalID == functionName ||
+			physicalID == functionName {
+			return physicalID, nil
+		}
+		functionNames = append(functionNames, physicalID)
+	}
+	return "", errors.Errorf("Failed to find function %s in stack. Available functions: %s",
+		functionName,
+		strings.Join(functionNames, ", "))
+}
+
+// functionLogGroupName returns the CloudWatch Logs log group for the
+// deployed function, which is either the LoggingConfig log group or the
+// Lambda default
+func functionLogGroupName(ctx context.Context,
+	awsSession *session.Session,
+	functionName string) (string, error) {
+	lambdaSvc := lambda.New(awsSession)
+	configOutput, configErr := lambdaSvc.GetFunctionConfigurationWithContext(ctx,
+		&lambda.GetFunctionConfigurationInput{
+			FunctionName: aws.String(functionName),
+		})
+	if configErr != nil {
+		return "", errors.Wrapf(configErr, "Failed to get configuration for function: %s", functionName)
+	}
+	if configOutput.LoggingConfig != nil &&
+		aws.StringValue(configOutput.LoggingConfig.LogGroup) != "" {
+		return aws.StringValue(configOutput.LoggingConfig.LogGroup), nil
+	}
+	return fmt.Sprintf("/aws/lambda/%s", functionName), nil
+}
+
+// waitForLogGroup polls until the log group exists. Lambda creates the
+// log group on the function's first invocation.
+func waitForLogGroup(ctx context.Context,
+	awsSession *session.Session,
+	logGroupName string,
+	logger *logrus.Logger) error {
+	cwlogsSvc := cloudwatchlogs.New(awsSession)
+	loggedWait := false
+	for {
+		describeOutput, describeErr := cwlogsSvc.DescribeLogGroupsWithContext(ctx,
+			&cloudwatchlogs.DescribeLogGroupsInput{
+				LogGroupNamePrefix: aws.String(logGroupName),
+			})
+		if describeErr != nil {
+			return errors.Wrapf(describeErr, "Failed to describe log group: %s", logGroupName)
+		}
+		for _, eachLogGroup := range describeOutput.LogGroups {
+			if aws.StringValue(eachLogGroup.LogGroupName) == logGroupName {
+				return nil
+			}
+		}
+		if !loggedWait {
+			logger.WithFields(logrus.Fields{
+				"LogGroupName": logGroupName,
+			}).Info("Waiting for log group. The log group is created when the function is first invoked")
+			loggedWait = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(tailLogGroupPollInterval):
+		}
+	}
+}
+
+// TailLogs prints the CloudWatch Logs events for the serviceName stack's
+// functionName function to the logger until the process is interrupted.
+// The functionName may be the Sparta function name (eg, the
+// SpartaOptions.Name value), the CloudFormation logical resource ID, or
+// the AWS Lambda function name.
+func TailLogs(serviceName string, functionName string, logger *logrus.Logger) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	defer signal.Stop(signalChan)
+	go func() {
+		select {
+		case <-signalChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return TailLogsWithContext(ctx, serviceName, functionName, logger)
+}
+
+// TailLogsWithContext prints the CloudWatch Logs events for the serviceName
+// stack's functionName function to the logger until the context is done.
+// See TailLogs for the supported functionName values.
+func TailLogsWithContext(ctx context.Context,
+	serviceName string,
+	functionName string,
+	logger *logrus.Logger) error {
+	awsSession := spartaAWS.NewSession(logger)
+	cfSvc := cloudformation.New(awsSession)
+
+	var stackResources []*cloudformation.StackResourceSummary
+	listErr := cfSvc.ListStackResourcesPagesWithContext(ctx,
+		&cloudformation.ListStackResourcesInput{
+			StackName: aws.String(serviceName),
+		},
+		func(page *cloudformation.ListStackResourcesOutput, lastPage bool) bool {
+			stackResources = append(stackResources, page.StackResourceSummaries...)
+			return true
+		})
+	if listErr != nil {
+		return errors.Wrapf(listErr, "Failed to list resources for stack: %s", serviceName)
+	}
+	lambdaFunctionName, lambdaFunctionNameErr := matchStackFunction(stackResources, functionName)
+	if lambdaFunctionNameErr != nil {
+		return lambdaFunctionNameErr
+	}
+	logGroupName, logGroupNameErr := functionLogGroupName(ctx, awsSession, lambdaFunctionName)
+	if logGroupNameErr != nil {
+		return logGroupNameErr
+	}
+	waitErr := waitForLogGroup(ctx, awsSession, logGroupName, logger)
+	if waitErr != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return waitErr
+	}
+	logger.WithFields(logrus.Fields{
+		"Function":     lambdaFunctionName,
+		"LogGroupName": logGroupName,
+	}).Info("Tailing CloudWatch Logs")
+
+	closeChan := make(chan bool)
+	defer close(closeChan)
+	logEvents := spartaCWLogs.TailWithContext(ctx,
+		closeChan,
+		awsSession,
+		logGroupName,
+		"",
+		logger)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case eachEvent := <-logEvents:
+			logger.WithFields(logrus.Fields{
+				"Timestamp": time.Unix(0, aws.Int64Value(eachEvent.Timestamp)*int64(time.Millisecond)).Format(time.RFC3339),
+				"LogStream": aws.StringValue(eachEvent.LogStreamName),
+			}).Info(strings.TrimSpace(aws.StringValue(eachEvent.Message)))
+		}
+	}
+}
@@ -4,18 +4,82 @@ import (
 	"archive/zip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// deterministicModTime is the modification time recorded for every
+// archive entry so that identical inputs produce byte-identical archives.
+// It's the earliest time representable in the MS-DOS date format.
+var deterministicModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// normalizeFileHeader removes the host specific metadata from the header.
+// The modification time is fixed and the permissions are normalized to
+// 0755 for directories and executables, and 0644 for all other files.
+func normalizeFileHeader(header *zip.FileHeader, info os.FileInfo) {
+	header.Modified = deterministicModTime
+	mode := os.FileMode(0644)
+	if info.IsDir() {
+		mode = os.ModeDir | 0755
+	} else if info.Mode()&0111 != 0 {
+		mode = 0755
+	}
+	header.SetMode(mode)
+}
+
+// SymlinkPolicy determines how symlinks in a source directory are archived
+type SymlinkPolicy int
+
+const (
+	// SymlinkFollow archives the target of each symlink under the symlink's
+	// name. Symlinks that resolve outside of the source directory, can't be
+	// resolved, or would create a cycle are skipped.
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkStore archives each symlink as a symlink entry whose contents
+	// are the link target
+	SymlinkStore
+	// SymlinkSkip omits symlinks from the archive
+	SymlinkSkip
+)
+
+// ArchiveOptions customize how a source directory is archived
+type ArchiveOptions struct {
+	// Optional path.Match patterns of the files and directories to exclude.
+	// Each pattern is matched against both the slash separated path relative
+	// to `rootSource` and its base name, so that `.DS_Store` and `*.map`
+	// exclude matching files in every directory. An excluded directory is
+	// skipped along with its contents.
+	ExcludePatterns []string
+	// Symlinks is the symlink handling policy. The default is SymlinkFollow.
+	Symlinks SymlinkPolicy
+}
+
 // FileHeaderAnnotator represents a callback function that accepts the current
 // file being added to allow it to customize the ZIP archive values
 type FileHeaderAnnotator func(header *zip.FileHeader) (*zip.FileHeader, error)
 
+// isExcluded returns true if the slash separated archive name, or its base
+// name, matches one of the excludePatterns. Patterns use the path.Match
+// syntax.
+func isExcluded(archiveName string, excludePatterns []string) bool {
+	for _, eachPattern := range excludePatterns {
+		if matched, _ := path.Match(eachPattern, archiveName); matched {
+			return true
+		}
+		if matched, _ := path.Match(eachPattern, path.Base(archiveName)); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // AnnotateAddToZip is an extended Zip writer that accepts an annotation function
 // to customize the FileHeader values written into the archive
 func AnnotateAddToZip(zipWriter *zip.Writer,
@@ -23,6 +87,34 @@ func AnnotateAddToZip(zipWriter *zip.Writer,
 	rootSource string,
 	annotator FileHeaderAnnotator,
 	logger *logrus.Logger) error {
+	return addToZip(zipWriter, source, rootSource, annotator, ArchiveOptions{}, logger)
+}
+
+// isWithinDirectory returns true if targetPath is directoryPath or one of its
+// descendants
+func isWithinDirectory(directoryPath string, targetPath string) bool {
+	relativePath, relativePathErr := filepath.Rel(directoryPath, targetPath)
+	if relativePathErr != nil {
+		return false
+	}
+	return relativePath != ".." &&
+		!strings.HasPrefix(relativePath, ".."+string(os.PathSeparator))
+}
+
+// addToZip adds the source to the zipWriter. Directory entries are filtered
+// and symlinks are handled according to the options.
+func addToZip(zipWriter *zip.Writer,
+	source string,
+	rootSource string,
+	annotator FileHeaderAnnotator,
+	options ArchiveOptions,
+	logger *logrus.Logger) error {
+
+	for _, eachPattern := range options.ExcludePatterns {
+		if _, matchErr := path.Match(eachPattern, ""); matchErr != nil {
+			return errors.Wrapf(matchErr, "Invalid exclude pattern: %s", eachPattern)
+		}
+	}
 
 	linuxZipName := func(platformValue string) string {
 		return strings.Replace(platformValue, "\\", "/", -1)
@@ -44,6 +136,7 @@ func AnnotateAddToZip(zipWriter *zip.Writer,
 		if fileHeaderErr != nil {
 			return fileHeaderErr
 		}
+		normalizeFileHeader(fileHeader, info)
 		// Update the name to the proper thing...
 		fileHeader.Name = zipEntryName
 		if annotator != nil {
@@ -79,34 +172,134 @@ func AnnotateAddToZip(zipWriter *zip.Writer,
 		return copyErr
 	}
 
-	directoryWalker := func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	storeSymlink := func(archiveName string, linkPath string) error {
+		linkTarget, linkTargetErr := os.Readlink(linkPath)
+		if linkTargetErr != nil {
+			return errors.Wrapf(linkTargetErr, "Failed to read symlink: %s", linkPath)
+		}
+		header := &zip.FileHeader{
+			Name:     archiveName,
+			Method:   zip.Store,
+			Modified: deterministicModTime,
+		}
+		header.SetMode(os.ModeSymlink | 0777)
+		writer, writerErr := zipWriter.CreateHeader(header)
+		if writerErr != nil {
+			return writerErr
+		}
+		logger.WithFields(logrus.Fields{
+			"Path":   archiveName,
+			"Target": linkTarget,
+		}).Debug("Storing symlink")
+		_, writeErr := writer.Write([]byte(filepath.ToSlash(linkTarget)))
+		return writeErr
+	}
+
+	// The resolved paths of the directories currently being walked, so that
+	// a followed symlink to an ancestor directory isn't walked again
+	activeDirectories := make(map[string]bool)
+	var resolvedRoot string
+
+	var walkEntry func(logicalPath string, filePath string, info os.FileInfo) error
+	walkEntry = func(logicalPath string, filePath string, info os.FileInfo) error {
+		// Normalize the Name
+		platformName := strings.TrimPrefix(strings.TrimPrefix(logicalPath, rootSource), string(os.PathSeparator))
+		archiveName := linuxZipName(platformName)
+		if archiveName != "" && isExcluded(archiveName, options.ExcludePatterns) {
+			logger.WithFields(logrus.Fields{
+				"Path": archiveName,
+			}).Debug("Excluding path from archive")
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch options.Symlinks {
+			case SymlinkSkip:
+				logger.WithFields(logrus.Fields{
+					"Path": archiveName,
+				}).Info("Skipping symlink")
+				return nil
+			case SymlinkStore:
+				return storeSymlink(archiveName, filePath)
+			default:
+				resolvedPath, resolvedPathErr := filepath.EvalSymlinks(filePath)
+				if resolvedPathErr != nil {
+					logger.WithFields(logrus.Fields{
+						"Path":  archiveName,
+						"Error": resolvedPathErr,
+					}).Warn("Skipping unresolvable symlink")
+					return nil
+				}
+				if !isWithinDirectory(resolvedRoot, resolvedPath) {
+					logger.WithFields(logrus.Fields{
+						"Path":   archiveName,
+						"Target": resolvedPath,
+					}).Warn("Skipping symlink that resolves outside of the archive source")
+					return nil
+				}
+				resolvedInfo, resolvedInfoErr := os.Stat(resolvedPath)
+				if resolvedInfoErr != nil {
+					return errors.Wrapf(resolvedInfoErr, "Failed to get symlink target information")
+				}
+				logger.WithFields(logrus.Fields{
+					"Path":   archiveName,
+					"Target": resolvedPath,
+				}).Debug("Following symlink")
+				filePath = resolvedPath
+				info = resolvedInfo
+			}
 		}
 		header, err := zip.FileInfoHeader(info)
 		if err != nil {
 			return errors.Wrapf(err, "Failed to create FileInfoHeader")
 		}
-		// Normalize the Name
-		platformName := strings.TrimPrefix(strings.TrimPrefix(path, rootSource), string(os.PathSeparator))
-		header.Name = linuxZipName(platformName)
+		normalizeFileHeader(header, info)
+		header.Name = archiveName
 
 		if info.IsDir() {
+			resolvedDirectory, resolvedDirectoryErr := filepath.EvalSymlinks(filePath)
+			if resolvedDirectoryErr != nil {
+				return errors.Wrapf(resolvedDirectoryErr, "Failed to resolve directory: %s", filePath)
+			}
+			if activeDirectories[resolvedDirectory] {
+				logger.WithFields(logrus.Fields{
+					"Path":   archiveName,
+					"Target": resolvedDirectory,
+				}).Warn("Skipping symlink cycle")
+				return nil
+			}
+			activeDirectories[resolvedDirectory] = true
+			defer delete(activeDirectories, resolvedDirectory)
+
 			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
+			_, err = zipWriter.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			// ReadDir returns the entries in lexical order, so the archive
+			// entry order is stable
+			entries, err := ioutil.ReadDir(filePath)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to read directory: %s", filePath)
+			}
+			for _, eachEntry := range entries {
+				err = walkEntry(filepath.Join(logicalPath, eachEntry.Name()),
+					filepath.Join(filePath, eachEntry.Name()),
+					eachEntry)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
 		}
+		header.Method = zip.Deflate
 		writer, err := zipWriter.CreateHeader(header)
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return nil
-		}
 		/* #nosec */
-		file, err := os.Open(path)
+		file, err := os.Open(filePath)
 		if err != nil {
-			return errors.Wrapf(err, "Failed to open file: %s", path)
+			return errors.Wrapf(err, "Failed to open file: %s", filePath)
 		}
 		defer file.Close()
 		_, err = io.Copy(writer, file)
@@ -122,7 +315,10 @@ func AnnotateAddToZip(zipWriter *zip.Writer,
 	}
 	switch mode := fileInfo.Mode(); {
 	case mode.IsDir():
-		err = filepath.Walk(fullPathSource, directoryWalker)
+		resolvedRoot, err = filepath.EvalSymlinks(fullPathSource)
+		if err == nil {
+			err = walkEntry(fullPathSource, fullPathSource, fileInfo)
+		}
 	case mode.IsRegular():
 		err = appendFile(fileInfo)
 	default:
@@ -136,7 +332,18 @@ func AnnotateAddToZip(zipWriter *zip.Writer,
 
 // AddToZip creates a source object (either a file, or a directory that will be recursively
 // added) to a previously opened zip.Writer.  The archive path of `source` is relative to the
-// `rootSource` parameter.
+// `rootSource` parameter. The caller owns the zipWriter, so AddToZip may be called
+// multiple times with different sources before the caller closes the writer.
 func AddToZip(zipWriter *zip.Writer, source string, rootSource string, logger *logrus.Logger) error {
 	return AnnotateAddToZip(zipWriter, source, rootSource, nil, logger)
 }
+
+// AddToZipWithOptions is the same as AddToZip, but filters the source
+// directory entries and handles symlinks according to the options.
+func AddToZipWithOptions(zipWriter *zip.Writer,
+	source string,
+	rootSource string,
+	options ArchiveOptions,
+	logger *logrus.Logger) error {
+	return addToZip(zipWriter, source, rootSource, nil, options, logger)
+}
@@ -0,0 +1,369 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAddToZipMultipleSources(t *testing.T) {
+	expectedContents := map[string]string{
+		"first.txt":        "first",
+		"nested/second.go": "second",
+		"third.json":       "third",
+	}
+	sourceFiles := [][]string{
+		{"first.txt", "nested/second.go"},
+		{"third.json"},
+	}
+	var zipBuffer bytes.Buffer
+	zipWriter := zip.NewWriter(&zipBuffer)
+	logger := logrus.New()
+	for _, eachSourceFiles := range sourceFiles {
+		sourceDir, sourceDirErr := ioutil.TempDir("", "sparta-zip")
+		if sourceDirErr != nil {
+			t.Fatal(sourceDirErr)
+		}
+		defer os.RemoveAll(sourceDir)
+		for _, eachFile := range eachSourceFiles {
+			filePath := filepath.Join(sourceDir, filepath.FromSlash(eachFile))
+			mkdirErr := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+			if mkdirErr != nil {
+				t.Fatal(mkdirErr)
+			}
+			writeErr := ioutil.WriteFile(filePath, []byte(expectedContents[eachFile]), 0644)
+			if writeErr != nil {
+				t.Fatal(writeErr)
+			}
+		}
+		addErr := AddToZip(zipWriter, sourceDir, sourceDir, logger)
+		if addErr != nil {
+			t.Fatal(addErr)
+		}
+	}
+	closeErr := zipWriter.Close()
+	if closeErr != nil {
+		t.Fatal(closeErr)
+	}
+
+	zipReader, zipReaderErr := zip.NewReader(bytes.NewReader(zipBuffer.Bytes()),
+		int64(zipBuffer.Len()))
+	if zipReaderErr != nil {
+		t.Fatal(zipReaderErr)
+	}
+	archivedContents := make(map[string]string)
+	for _, eachFile := range zipReader.File {
+		if eachFile.FileInfo().IsDir() {
+			continue
+		}
+		reader, readerErr := eachFile.Open()
+		if readerErr != nil {
+			t.Fatal(readerErr)
+		}
+		contents, contentsErr := ioutil.ReadAll(reader)
+		reader.Close()
+		if contentsErr != nil {
+			t.Fatalf("Failed to read %s: %s", eachFile.Name, contentsErr)
+		}
+		archivedContents[eachFile.Name] = string(contents)
+	}
+	if len(archivedContents) != len(expectedContents) {
+		t.Fatalf("Expected %d archived files, got %d: %v",
+			len(expectedContents),
+			len(archivedContents),
+			archivedContents)
+	}
+	for eachName, eachContents := range expectedContents {
+		if archivedContents[eachName] != eachContents {
+			t.Errorf("Unexpected contents for %s: %q", eachName, archivedContents[eachName])
+		}
+	}
+}
+
+func TestAnnotateAddToZipExecutableMode(t *testing.T) {
+	sourceDir, sourceDirErr := ioutil.TempDir("", "sparta-zip")
+	if sourceDirErr != nil {
+		t.Fatal(sourceDirErr)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	executableAnnotator := func(header *zip.FileHeader) (*zip.FileHeader, error) {
+		header.SetMode(0755)
+		return header, nil
+	}
+	testCases := []struct {
+		name      string
+		mode      os.FileMode
+		annotator FileHeaderAnnotator
+		expected  os.FileMode
+	}{
+		{"executable", 0755, nil, 0755},
+		{"regular", 0644, nil, 0644},
+		{"annotated", 0644, executableAnnotator, 0755},
+	}
+	var zipBuffer bytes.Buffer
+	zipWriter := zip.NewWriter(&zipBuffer)
+	for _, eachTestCase := range testCases {
+		filePath := filepath.Join(sourceDir, eachTestCase.name)
+		writeErr := ioutil.WriteFile(filePath, []byte(eachTestCase.name), eachTestCase.mode)
+		if writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		// Ensure the mode isn't masked by the umask
+		chmodErr := os.Chmod(filePath, eachTestCase.mode)
+		if chmodErr != nil {
+			t.Fatal(chmodErr)
+		}
+		addErr := AnnotateAddToZip(zipWriter,
+			filePath,
+			"",
+			eachTestCase.annotator,
+			logrus.New())
+		if addErr != nil {
+			t.Fatal(addErr)
+		}
+	}
+	closeErr := zipWriter.Close()
+	if closeErr != nil {
+		t.Fatal(closeErr)
+	}
+	zipReader, zipReaderErr := zip.NewReader(bytes.NewReader(zipBuffer.Bytes()),
+		int64(zipBuffer.Len()))
+	if zipReaderErr != nil {
+		t.Fatal(zipReaderErr)
+	}
+	if len(zipReader.File) != len(testCases) {
+		t.Fatalf("Expected %d archived files, got %d", len(testCases), len(zipReader.File))
+	}
+	for eachIndex, eachFile := range zipReader.File {
+		expectedMode := testCases[eachIndex].expected
+		if eachFile.Mode().Perm() != expectedMode {
+			t.Errorf("Unexpected mode for %s. Expected %s, got %s",
+				testCases[eachIndex].name,
+				expectedMode,
+				eachFile.Mode().Perm())
+		}
+	}
+}
+
+func TestAddToZipReproducible(t *testing.T) {
+	sourceDir, sourceDirErr := ioutil.TempDir("", "sparta-zip")
+	if sourceDirErr != nil {
+		t.Fatal(sourceDirErr)
+	}
+	defer os.RemoveAll(sourceDir)
+	for _, eachFile := range []string{"b.txt", "a/c.txt", "a.txt"} {
+		filePath := filepath.Join(sourceDir, filepath.FromSlash(eachFile))
+		mkdirErr := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+		if mkdirErr != nil {
+			t.Fatal(mkdirErr)
+		}
+		writeErr := ioutil.WriteFile(filePath, []byte(eachFile), 0644)
+		if writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	buildArchive := func(modTime time.Time) []byte {
+		walkErr := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Chtimes(path, modTime, modTime)
+		})
+		if walkErr != nil {
+			t.Fatal(walkErr)
+		}
+		var zipBuffer bytes.Buffer
+		zipWriter := zip.NewWriter(&zipBuffer)
+		addErr := AddToZip(zipWriter, sourceDir, sourceDir, logrus.New())
+		if addErr != nil {
+			t.Fatal(addErr)
+		}
+		closeErr := zipWriter.Close()
+		if closeErr != nil {
+			t.Fatal(closeErr)
+		}
+		return zipBuffer.Bytes()
+	}
+	firstArchive := buildArchive(time.Now().Add(-time.Hour))
+	secondArchive := buildArchive(time.Now())
+	if !bytes.Equal(firstArchive, secondArchive) {
+		t.Fatalf("Expected identical archives for identical inputs")
+	}
+}
+
+func TestAddToZipWithExcludes(t *testing.T) {
+	sourceFiles := map[string]bool{
+		"index.html":             true,
+		"error.html":             true,
+		".DS_Store":              false,
+		"js/app.js":              true,
+		"js/app.js.map":          false,
+		"js/vendor/lib.js":       true,
+		"js/vendor/.DS_Store":    false,
+		".git/HEAD":              false,
+		".git/objects/ab/cdef01": false,
+		"drafts/notes.txt":       false,
+		"img/drafts/logo.png":    true,
+	}
+	sourceDir, sourceDirErr := ioutil.TempDir("", "sparta-zip")
+	if sourceDirErr != nil {
+		t.Fatal(sourceDirErr)
+	}
+	defer os.RemoveAll(sourceDir)
+	for eachFile := range sourceFiles {
+		filePath := filepath.Join(sourceDir, filepath.FromSlash(eachFile))
+		mkdirErr := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+		if mkdirErr != nil {
+			t.Fatal(mkdirErr)
+		}
+		writeErr := ioutil.WriteFile(filePath, []byte(eachFile), 0644)
+		if writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	var zipBuffer bytes.Buffer
+	zipWriter := zip.NewWriter(&zipBuffer)
+	logger := logrus.New()
+	invalidErr := AddToZipWithOptions(zipWriter,
+		sourceDir,
+		sourceDir,
+		ArchiveOptions{ExcludePatterns: []string{"["}},
+		logger)
+	if invalidErr == nil {
+		t.Fatal("Failed to reject invalid exclude pattern")
+	}
+	addErr := AddToZipWithOptions(zipWriter,
+		sourceDir,
+		sourceDir,
+		ArchiveOptions{ExcludePatterns: []string{".DS_Store", "*.map", ".git", "drafts/*"}},
+		logger)
+	if addErr != nil {
+		t.Fatal(addErr)
+	}
+	closeErr := zipWriter.Close()
+	if closeErr != nil {
+		t.Fatal(closeErr)
+	}
+	zipReader, zipReaderErr := zip.NewReader(bytes.NewReader(zipBuffer.Bytes()),
+		int64(zipBuffer.Len()))
+	if zipReaderErr != nil {
+		t.Fatal(zipReaderErr)
+	}
+	archivedFiles := make(map[string]bool)
+	for _, eachFile := range zipReader.File {
+		if !eachFile.FileInfo().IsDir() {
+			archivedFiles[eachFile.Name] = true
+		}
+	}
+	for eachFile, expectArchived := range sourceFiles {
+		if archivedFiles[eachFile] != expectArchived {
+			t.Errorf("Unexpected archive state for %s. Expected: %t", eachFile, expectArchived)
+		}
+	}
+}
+
+func TestAddToZipSymlinks(t *testing.T) {
+	sourceDir, sourceDirErr := ioutil.TempDir("", "sparta-zip")
+	if sourceDirErr != nil {
+		t.Fatal(sourceDirErr)
+	}
+	defer os.RemoveAll(sourceDir)
+	outsideDir, outsideDirErr := ioutil.TempDir("", "sparta-zip-outside")
+	if outsideDirErr != nil {
+		t.Fatal(outsideDirErr)
+	}
+	defer os.RemoveAll(outsideDir)
+
+	siteDir := filepath.Join(sourceDir, "site")
+	mkdirErr := os.MkdirAll(filepath.Join(siteDir, "assets"), os.ModePerm)
+	if mkdirErr != nil {
+		t.Fatal(mkdirErr)
+	}
+	writeErr := ioutil.WriteFile(filepath.Join(siteDir, "assets", "app.js"), []byte("app"), 0644)
+	if writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	writeErr = ioutil.WriteFile(filepath.Join(outsideDir, "secret.txt"), []byte("secret"), 0644)
+	if writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	symlinks := map[string]string{
+		// Symlinked subdirectory within the tree
+		filepath.Join(siteDir, "static"): filepath.Join(siteDir, "assets"),
+		// Cycle back to an ancestor directory
+		filepath.Join(siteDir, "assets", "loop"): siteDir,
+		// Escapes the tree
+		filepath.Join(siteDir, "secret.txt"): filepath.Join(outsideDir, "secret.txt"),
+	}
+	for eachLink, eachTarget := range symlinks {
+		if symlinkErr := os.Symlink(eachTarget, eachLink); symlinkErr != nil {
+			t.Skipf("Symlinks not supported: %s", symlinkErr)
+		}
+	}
+
+	archivedEntries := func(policy SymlinkPolicy) map[string]*zip.File {
+		var zipBuffer bytes.Buffer
+		zipWriter := zip.NewWriter(&zipBuffer)
+		addErr := AddToZipWithOptions(zipWriter,
+			siteDir,
+			siteDir,
+			ArchiveOptions{Symlinks: policy},
+			logrus.New())
+		if addErr != nil {
+			t.Fatal(addErr)
+		}
+		if closeErr := zipWriter.Close(); closeErr != nil {
+			t.Fatal(closeErr)
+		}
+		zipReader, zipReaderErr := zip.NewReader(bytes.NewReader(zipBuffer.Bytes()),
+			int64(zipBuffer.Len()))
+		if zipReaderErr != nil {
+			t.Fatal(zipReaderErr)
+		}
+		entries := make(map[string]*zip.File)
+		for _, eachFile := range zipReader.File {
+			if !eachFile.FileInfo().IsDir() {
+				entries[eachFile.Name] = eachFile
+			}
+		}
+		return entries
+	}
+	entryNames := func(entries map[string]*zip.File) []string {
+		names := make([]string, 0, len(entries))
+		for eachName := range entries {
+			names = append(names, eachName)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	testCases := []struct {
+		policy   SymlinkPolicy
+		expected []string
+	}{
+		{SymlinkFollow, []string{"assets/app.js", "static/app.js"}},
+		{SymlinkStore, []string{"assets/app.js", "assets/loop", "secret.txt", "static"}},
+		{SymlinkSkip, []string{"assets/app.js"}},
+	}
+	for _, eachTestCase := range testCases {
+		entries := archivedEntries(eachTestCase.policy)
+		names := entryNames(entries)
+		if strings.Join(names, ",") != strings.Join(eachTestCase.expected, ",") {
+			t.Errorf("Unexpected entries for policy %d: %v", eachTestCase.policy, names)
+		}
+		if eachTestCase.policy == SymlinkStore {
+			if entries["static"].Mode()&os.ModeSymlink == 0 {
+				t.Errorf("Failed to store symlink entry: %s", entries["static"].Mode())
+			}
+		}
+	}
+}